@@ -0,0 +1,136 @@
+// ABOUTME: The agg command: counts and sums over a document stream in one pass, optionally grouped.
+// ABOUTME: Answers simple questions about a log stream without exporting it to an analytics tool.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"slices"
+)
+
+// aggGroup accumulates the aggregates of the documents with one combination
+// of --group-by values.
+type aggGroup struct {
+	values []any
+	count  int64
+	sums   []*big.Rat
+	whole  []bool
+}
+
+// runAgg reads the document stream at inputPath, JSON or BONJSON by file
+// extension (stdin is JSON), and computes the --count and --sum aggregates,
+// per combination of --group-by values if any are given. The summary goes
+// to opts.outFile, or to stdout as JSON if it is not set. Without --sum,
+// --count is implied.
+//
+// Documents without a value at a group-by path are grouped under null
+// there. A document without a number at a sum path adds nothing to that
+// sum; any other value there is an error. Sums are exact, and integers if
+// every number summed was a whole number.
+func runAgg(inputPath string, opts *options) error {
+	var r io.Reader = os.Stdin
+	if inputPath != "-" {
+		f, err := os.Open(inputPath)
+		if err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	progress.begin(inputPath)
+
+	groups := make(map[string]*aggGroup)
+	var aggErr error
+	decodeErr := decodeStream(bufio.NewReader(progressReader{r}), !isBONJSONPath(inputPath), opts, func(seq int, doc any) bool {
+		progress.documents.Add(1)
+		values := make([]any, len(opts.groupBy))
+		for i, p := range opts.groupBy {
+			values[i], _ = lookupPath(doc, p)
+		}
+		key, err := json.Marshal(values)
+		if err != nil {
+			aggErr = fmt.Errorf("document %d: %w", seq, err)
+			return false
+		}
+		g := groups[string(key)]
+		if g == nil {
+			g = &aggGroup{values: values, sums: make([]*big.Rat, len(opts.aggSums)), whole: make([]bool, len(opts.aggSums))}
+			for i := range g.sums {
+				g.sums[i] = new(big.Rat)
+				g.whole[i] = true
+			}
+			groups[string(key)] = g
+		}
+		g.count++
+		for i, p := range opts.aggSums {
+			value, ok := lookupPath(doc, p)
+			if !ok || value == nil {
+				continue
+			}
+			n, whole, ok := exactNumber(value)
+			if !ok {
+				aggErr = fmt.Errorf("document %d: value at %s is not a finite number", seq, p)
+				return false
+			}
+			g.sums[i].Add(g.sums[i], n)
+			g.whole[i] = g.whole[i] && whole
+		}
+		return true
+	})
+	if aggErr != nil {
+		return aggErr
+	}
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	summaries := make([]any, len(keys))
+	for i, key := range keys {
+		summaries[i] = groups[key].summary(opts)
+	}
+	if len(opts.groupBy) == 0 {
+		if len(summaries) == 0 {
+			summaries = append(summaries, (&aggGroup{}).summary(opts))
+		}
+		return writeDocument(summaries[0], opts.outFile, opts)
+	}
+	return writeDocument(map[string]any{"groups": summaries}, opts.outFile, opts)
+}
+
+// summary returns the aggregates of g as a document: the group-by values
+// under "group", keyed by path, then "count" and "sum" as requested.
+func (g *aggGroup) summary(opts *options) map[string]any {
+	summary := make(map[string]any)
+	if len(opts.groupBy) > 0 {
+		group := make(map[string]any, len(opts.groupBy))
+		for i, p := range opts.groupBy {
+			group[p.String()] = g.values[i]
+		}
+		summary["group"] = group
+	}
+	if opts.aggCount || len(opts.aggSums) == 0 {
+		summary["count"] = g.count
+	}
+	if len(opts.aggSums) > 0 {
+		sums := make(map[string]any, len(opts.aggSums))
+		for i, p := range opts.aggSums {
+			if g.sums == nil {
+				sums[p.String()] = int64(0)
+				continue
+			}
+			sums[p.String()] = decodedNumber(g.sums[i], g.whole[i])
+		}
+		summary["sum"] = sums
+	}
+	return summary
+}
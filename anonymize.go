@@ -0,0 +1,148 @@
+// ABOUTME: Deterministic pseudonymization of selected fields for the anonymize command.
+// ABOUTME: Values are replaced with HMAC-SHA256 tokens, so equal inputs map to equal tokens.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// anonymizeField selects the values to pseudonymize: every value of the key
+// name at any depth, or, if at is non-nil, only the value at that path.
+type anonymizeField struct {
+	name string
+	at   path
+}
+
+// parseAnonymizeField parses a --field value: a key name, or a path such as
+// $.user.email.
+func parseAnonymizeField(spec string) (anonymizeField, error) {
+	if !strings.HasPrefix(spec, "$") {
+		if spec == "" {
+			return anonymizeField{}, fmt.Errorf("empty field name")
+		}
+		return anonymizeField{name: spec}, nil
+	}
+	p, err := parsePath(spec)
+	if err != nil {
+		return anonymizeField{}, err
+	}
+	if len(p) == 0 {
+		return anonymizeField{}, fmt.Errorf("invalid field %q: cannot anonymize the whole document", spec)
+	}
+	return anonymizeField{at: p}, nil
+}
+
+// loadHMACKey reads the HMAC key from filename, ignoring surrounding
+// whitespace so that a key written with echo works.
+func loadHMACKey(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < 16 {
+		return nil, fmt.Errorf("key file %s: key must be at least 16 bytes", filename)
+	}
+	return key, nil
+}
+
+// anonymize replaces the selected fields in v with pseudonyms and returns the
+// number of values replaced.
+func anonymize(v any, fields []anonymizeField, key []byte) (int, error) {
+	count := 0
+	for _, field := range fields {
+		if field.at == nil {
+			n, err := anonymizeEverywhere(v, field.name, key)
+			if err != nil {
+				return count, err
+			}
+			count += n
+			continue
+		}
+		parent, ok := lookupPath(v, field.at[:len(field.at)-1])
+		if !ok {
+			continue
+		}
+		last := field.at[len(field.at)-1]
+		switch parent := parent.(type) {
+		case map[string]any:
+			if value, ok := parent[last.key]; ok && !last.isIndex {
+				token, err := pseudonym(value, key)
+				if err != nil {
+					return count, err
+				}
+				parent[last.key] = token
+				count++
+			}
+		case []any:
+			if last.isIndex && last.index < len(parent) {
+				token, err := pseudonym(parent[last.index], key)
+				if err != nil {
+					return count, err
+				}
+				parent[last.index] = token
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// anonymizeEverywhere replaces the value of every key called name, at any
+// depth, with its pseudonym.
+func anonymizeEverywhere(v any, name string, key []byte) (int, error) {
+	count := 0
+	switch v := v.(type) {
+	case map[string]any:
+		for k, elem := range v {
+			if k == name {
+				token, err := pseudonym(elem, key)
+				if err != nil {
+					return count, err
+				}
+				v[k] = token
+				count++
+				continue
+			}
+			n, err := anonymizeEverywhere(elem, name, key)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+	case []any:
+		for _, elem := range v {
+			n, err := anonymizeEverywhere(elem, name, key)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, nil
+}
+
+// pseudonym returns the token for value: the first 16 bytes of the
+// HMAC-SHA256 of its canonical JSON encoding, in hex. Encoding the value as
+// JSON keeps 1 and "1" distinct while making the token independent of the
+// input format. Null stays null, since it carries no information.
+func pseudonym(value any, key []byte) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("anonymizing value: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(canonical)
+	return "anon:" + hex.EncodeToString(mac.Sum(nil)[:16]), nil
+}
@@ -0,0 +1,111 @@
+// ABOUTME: The append command: converts documents and appends them to a multi-document BONJSON file.
+// ABOUTME: Keeps a container's index up to date and locks the target against concurrent writers.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runAppend converts the documents in inputPath (JSON, or BONJSON if named
+// *.boj or *.bonjson; several in stream mode) and appends them to the BONJSON
+// stream at targetPath, creating it if needed. If the target is a container,
+// the documents and a new index that includes them go after its footer, and
+// a new footer after those; nothing the old footer points at is overwritten.
+// An append cut short before the new footer is written leaves a container
+// that no longer ends with a footer; truncating it to its size before the
+// append restores it.
+//
+// The target is locked for the duration, so concurrent appends never
+// interleave their bytes. Since each append adds whole documents, a valid
+// stream stays valid.
+func runAppend(targetPath, inputPath string, opts *options) error {
+	if targetPath == "-" || isJSONPath(targetPath) {
+		return fmt.Errorf("append target must be a BONJSON file")
+	}
+
+	var data []byte
+	var err error
+	if inputPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(inputPath)
+	}
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+	var docs []any
+	if isBONJSONPath(inputPath) {
+		docs, _, err = decodeBONJSON(data, opts)
+		if err != nil {
+			return fmt.Errorf("invalid BONJSON: %w", err)
+		}
+	} else {
+		if docs, err = decodeJSON(data, opts); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+	if len(docs) == 0 {
+		return fmt.Errorf("input is empty")
+	}
+	if docs, err = transformDocuments(docs, inputPath, opts); err != nil {
+		return err
+	}
+	encoded, err := encodeDocuments(docs, false, opts)
+	if err != nil {
+		return err
+	}
+
+	f, unlock, err := openLocked(targetPath, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return fmt.Errorf("opening target: %w", err)
+	}
+	defer f.Close()
+	defer unlock()
+
+	index, _, err := readContainerIndex(f, targetPath)
+	if errors.Is(err, errNotContainer) {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return fmt.Errorf("appending to %s: %w", targetPath, err)
+		}
+		for _, doc := range encoded {
+			if _, err := f.Write(doc); err != nil {
+				return fmt.Errorf("appending to %s: %w", targetPath, err)
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The documents and the new index go after the old footer, which stays
+	// the last thing in the file until the new footer is written over
+	// everything it points at; the old index becomes space that compact
+	// reclaims.
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("appending to %s: %w", targetPath, err)
+	}
+	tail, err := appendContainerDocuments(nil, &index, info.Size(), encoded)
+	if err != nil {
+		return err
+	}
+	footerAt := len(tail) - containerFooterSize
+	if _, err := f.WriteAt(tail[:footerAt], info.Size()); err != nil {
+		return fmt.Errorf("appending to %s: %w", targetPath, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("appending to %s: %w", targetPath, err)
+	}
+	if _, err := f.WriteAt(tail[footerAt:], info.Size()+int64(footerAt)); err != nil {
+		return fmt.Errorf("appending to %s: %w", targetPath, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("appending to %s: %w", targetPath, err)
+	}
+	return nil
+}
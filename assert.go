@@ -0,0 +1,226 @@
+// ABOUTME: Per-path type assertions (--assert PATH:TYPE) checked on each decoded input document.
+// ABOUTME: A lightweight alternative to a JSON Schema: the first mismatch fails the conversion with its path and offset.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// assertTypeNames are the types an assertion may name, besides array<TYPE>.
+var assertTypeNames = []string{"null", "bool", "int", "number", "string", "array", "object"}
+
+// pathAssertion asserts that the value at a path is of a type.
+type pathAssertion struct {
+	spec string
+	at   path
+	typ  *assertType
+}
+
+// assertType is a type an assertion expects: one of assertTypeNames, or,
+// with elem, an array whose elements are all of type elem.
+type assertType struct {
+	name string
+	elem *assertType
+}
+
+func (t *assertType) String() string {
+	if t.elem != nil {
+		return "array<" + t.elem.String() + ">"
+	}
+	return t.name
+}
+
+// parseAssertion parses an --assert value, PATH:TYPE, such as $.id:int or
+// $.tags:array<string>.
+func parseAssertion(spec string) (pathAssertion, error) {
+	i := strings.LastIndex(spec, ":")
+	if i < 0 {
+		return pathAssertion{}, fmt.Errorf("invalid assertion %q: expected PATH:TYPE", spec)
+	}
+	p, err := parsePath(spec[:i])
+	if err != nil {
+		return pathAssertion{}, err
+	}
+	typ, err := parseAssertType(spec[i+1:])
+	if err != nil {
+		return pathAssertion{}, fmt.Errorf("invalid assertion %q: %w", spec, err)
+	}
+	return pathAssertion{spec: spec, at: p, typ: typ}, nil
+}
+
+func parseAssertType(s string) (*assertType, error) {
+	if inner, ok := strings.CutPrefix(s, "array<"); ok && strings.HasSuffix(inner, ">") {
+		elem, err := parseAssertType(strings.TrimSuffix(inner, ">"))
+		if err != nil {
+			return nil, err
+		}
+		return &assertType{name: "array", elem: elem}, nil
+	}
+	if !slices.Contains(assertTypeNames, s) {
+		return nil, fmt.Errorf("unknown type %q (expected %s, or array<TYPE>)", s, strings.Join(assertTypeNames, ", "))
+	}
+	return &assertType{name: s}, nil
+}
+
+// check returns the path of the first value within v, which is at path at,
+// that is not of the type t expects, with that type, or false if v is of
+// type t throughout.
+func (t *assertType) check(v any, at path) (path, *assertType, bool) {
+	if !t.matches(v) {
+		return at, t, true
+	}
+	if t.elem != nil {
+		for i, elem := range v.([]any) {
+			if bad, want, ok := t.elem.check(elem, append(at, pathSegment{index: i, isIndex: true})); ok {
+				return bad, want, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// matches reports whether v is of type t, not counting its elements. An int
+// is a whole number, however it was written, and also a number.
+func (t *assertType) matches(v any) bool {
+	if t.name == "int" || t.name == "number" {
+		if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return t.name == "number"
+		}
+		_, whole, ok := exactNumber(v)
+		return ok && (whole || t.name == "number")
+	}
+	return assertTypeOf(v) == t.name
+}
+
+// assertTypeOf returns the assertion type name of the decoded value v.
+func assertTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	if _, whole, ok := exactNumber(v); ok && whole {
+		return "int"
+	}
+	return "number"
+}
+
+// checkAssertions checks opts.asserts against docs, the documents decoded
+// from payload, which starts at offset start of the input, and fails at the
+// first mismatch. Its offset is that of the mismatched value, or of the
+// innermost value enclosing where a missing one should be.
+func checkAssertions(docs []any, payload []byte, start int, inputJSON bool, opts *options) error {
+	for i, doc := range docs {
+		for _, a := range opts.asserts {
+			v, ok := lookupPath(doc, a.at)
+			var bad path
+			var want *assertType
+			found := "nothing"
+			if !ok {
+				bad, want = a.at, a.typ
+			} else if bad, want, ok = a.typ.check(v, a.at); ok {
+				value, _ := lookupPath(doc, bad)
+				found = assertTypeOf(value)
+			} else {
+				continue
+			}
+			offset := int64(start) + locateValue(payload, inputJSON, i, bad)
+			return fmt.Errorf("assertion %s failed: document %d at offset %d (%s): expected %s, found %s", a.spec, i, offset, bad, want, found)
+		}
+	}
+	return nil
+}
+
+// locateValue returns the offset in payload of the value at p within
+// document n, or, if there is none, of the innermost value enclosing p.
+func locateValue(payload []byte, inputJSON bool, n int, p path) int64 {
+	if !inputJSON {
+		offsets := make(map[string]int64)
+		document := 0
+		scanner := newWireScanner(bytes.NewReader(payload), 0, func(v scannedValue) {
+			if v.kind == kindKey || v.kind == kindRecordDef {
+				return
+			}
+			if document == n {
+				offsets[v.path.String()] = v.offset
+			}
+			if v.depth == 0 {
+				document++
+			}
+		})
+		for document <= n {
+			if err := scanner.scanDocument(); err != nil {
+				break
+			}
+		}
+		for i := len(p); i >= 0; i-- {
+			if offset, ok := offsets[p[:i].String()]; ok {
+				return offset
+			}
+		}
+		return 0
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	for range n {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return 0
+		}
+	}
+	// The decoder stops before the separators that precede the next value.
+	next := func() int64 {
+		from := dec.InputOffset()
+		return from + int64(len(payload[from:])-len(bytes.TrimLeft(payload[from:], " \t\r\n:,")))
+	}
+	offset := next()
+	for _, seg := range p {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		found := false
+		switch {
+		case tok == json.Delim('{') && !seg.isIndex:
+			for !found && dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					break
+				}
+				if found = key == seg.key; !found {
+					var raw json.RawMessage
+					if err := dec.Decode(&raw); err != nil {
+						break
+					}
+				}
+			}
+		case tok == json.Delim('[') && seg.isIndex:
+			for i := 0; !found && dec.More(); i++ {
+				if found = i == seg.index; !found {
+					var raw json.RawMessage
+					if err := dec.Decode(&raw); err != nil {
+						break
+					}
+				}
+			}
+		}
+		if !found {
+			break
+		}
+		offset = next()
+	}
+	return offset
+}
@@ -0,0 +1,274 @@
+// ABOUTME: Batch conversion of directory trees and manifest generation.
+// ABOUTME: The manifest records each input, its output, sizes, checksums, and status.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifest describes the outcome of a (batch) conversion run. Options is a
+// fingerprint of the command and options, so incremental runs can tell
+// whether earlier outputs were produced the same way.
+type manifest struct {
+	Command string          `json:"command"`
+	Options string          `json:"options"`
+	Files   []manifestEntry `json:"files"`
+}
+
+// manifestEntry records the outcome of converting one input file.
+type manifestEntry struct {
+	Input        string `json:"input"`
+	Output       string `json:"output,omitempty"`
+	InputSize    int64  `json:"input_size"`
+	OutputSize   int64  `json:"output_size,omitempty"`
+	InputSHA256  string `json:"input_sha256,omitempty"`
+	OutputSHA256 string `json:"output_sha256,omitempty"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+// runBatch converts inputPath to outputPath, recording each conversion in a
+// manifest written to opts.manifestPath if set. If inputPath is a directory,
+// every file in it with an input format extension is converted into the
+// same relative location under the output directory, with its extension
+// replaced by the output format's, or to the path opts.outTemplate lays out
+// under it; a single file takes its path from opts.outTemplate too. Symlinks
+// in the tree are followed only with opts.followSymlinks, and sockets,
+// devices, and FIFOs are skipped; the skipped entries are summarized on
+// stderr. Failed files are reported and skipped;
+// the returned error summarizes how many failed. With opts.incremental, files
+// whose input, output, and options are unchanged since the previous manifest
+// are not converted again.
+func runBatch(command, inputPath, outputPath string, inputJSON, outputJSON bool, opts *options) error {
+	m := manifest{Command: command, Options: optionsFingerprint(command, opts)}
+	previous, err := previousEntries(&m, opts)
+	if err != nil {
+		return err
+	}
+	convertOrSkip := func(input, output string) (manifestEntry, error) {
+		if entry, ok := unchangedEntry(previous, input, output); ok {
+			return entry, nil
+		}
+		return convertEntry(input, output, inputJSON, outputJSON, opts)
+	}
+
+	info, err := os.Stat(inputPath)
+	if inputPath == "-" || err != nil || !info.IsDir() {
+		target := outputPath
+		if opts.outTemplate != "" {
+			if target, err = templatedOutputPath(inputPath, filepath.Dir(inputPath), outputPath, inputJSON, outputJSON, opts); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return fmt.Errorf("creating output directory: %w", err)
+			}
+		}
+		entry, convertErr := convertOrSkip(inputPath, target)
+		m.Files = append(m.Files, entry)
+		if err := writeManifest(&m, opts); err != nil {
+			return err
+		}
+		return convertErr
+	}
+
+	if outputPath == "-" {
+		return fmt.Errorf("directory input requires an output directory")
+	}
+	if opts.provenanceFile != "" {
+		return fmt.Errorf("--provenance cannot be used with directory input")
+	}
+	if opts.offsetMapFile != "" {
+		return fmt.Errorf("--offset-map cannot be used with directory input")
+	}
+	if opts.expectSHA256 != "" {
+		return fmt.Errorf("--expect-sha256 cannot be used with directory input")
+	}
+	failed := 0
+	var skips walkSkips
+	wanted := func(filename string) bool {
+		return hasInputExtension(filename, inputJSON, opts)
+	}
+	err = walkInputs(inputPath, opts.followSymlinks, wanted, &skips, func(filename string) error {
+		target := ""
+		if outputPath != "" {
+			rel, err := filepath.Rel(inputPath, filename)
+			if err != nil {
+				return err
+			}
+			target = filepath.Join(outputPath, replaceExtension(rel, outputExtension(outputJSON, opts)))
+			if opts.outTemplate != "" {
+				if target, err = templatedOutputPath(filename, inputPath, outputPath, inputJSON, outputJSON, opts); err != nil {
+					return err
+				}
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return fmt.Errorf("creating output directory: %w", err)
+			}
+		}
+		entry, err := convertOrSkip(filename, target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", filename, err)
+			failed++
+		}
+		m.Files = append(m.Files, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	skips.report()
+	if err := writeManifest(&m, opts); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(m.Files))
+	}
+	return nil
+}
+
+// convertEntry converts a single file, describing the outcome in the
+// returned entry as well as returning any conversion error.
+func convertEntry(inputPath, outputPath string, inputJSON, outputJSON bool, opts *options) (manifestEntry, error) {
+	entry := manifestEntry{Input: inputPath, Output: outputPath, Status: "ok"}
+	if outputPath == "-" {
+		entry.Output = ""
+	}
+	started := time.Now()
+	err := convert(inputPath, outputPath, inputJSON, outputJSON, opts)
+	if err == nil {
+		err = preserveMetadata(inputPath, outputPath, opts)
+	}
+	if opts.summary {
+		printSummary(os.Stderr, inputPath, outputPath, inputJSON, outputJSON, time.Since(started), err, opts)
+	}
+	if err != nil {
+		entry.Status = "error"
+		entry.Error = err.Error()
+	}
+	if opts.manifestPath != "" {
+		entry.InputSize, entry.InputSHA256 = fileDigest(inputPath)
+		if entry.Output != "" {
+			entry.OutputSize, entry.OutputSHA256 = fileDigest(outputPath)
+		}
+	}
+	return entry, err
+}
+
+// previousEntries loads the manifest of the previous run for an incremental
+// run, returning its entries by input path. Entries are only usable if that
+// run used the same command and options as m.
+func previousEntries(m *manifest, opts *options) (map[string]manifestEntry, error) {
+	if !opts.incremental {
+		return nil, nil
+	}
+	if opts.manifestPath == "" {
+		return nil, fmt.Errorf("--incremental requires --manifest")
+	}
+	var prev manifest
+	if err := loadDocumentInto(opts.manifestPath, &prev); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading previous manifest: %w", err)
+	}
+	if prev.Options != m.Options {
+		return nil, nil
+	}
+	entries := make(map[string]manifestEntry, len(prev.Files))
+	for _, entry := range prev.Files {
+		entries[entry.Input] = entry
+	}
+	return entries, nil
+}
+
+// unchangedEntry reports whether the previous run converted inputPath to
+// outputPath successfully and neither file has changed since. If so, it
+// returns the entry to record, marked "unchanged".
+func unchangedEntry(previous map[string]manifestEntry, inputPath, outputPath string) (manifestEntry, bool) {
+	entry, ok := previous[inputPath]
+	if !ok || entry.Output != outputPath || (entry.Status != "ok" && entry.Status != "unchanged") {
+		return manifestEntry{}, false
+	}
+	if _, sum := fileDigest(inputPath); sum == "" || sum != entry.InputSHA256 {
+		return manifestEntry{}, false
+	}
+	if outputPath != "" {
+		if _, sum := fileDigest(outputPath); sum == "" || sum != entry.OutputSHA256 {
+			return manifestEntry{}, false
+		}
+	}
+	entry.Status = "unchanged"
+	return entry, true
+}
+
+// optionsFingerprint returns a digest of the command and every option that
+// can influence the output, as given, and of the files they name, like the
+// conversion cache key.
+func optionsFingerprint(command string, opts *options) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "command %s\n", command)
+	writeOptionsDigest(h, opts)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fileDigest returns the size and hex SHA-256 digest of a regular file, or
+// zero values if it is not one (such as stdin or a FIFO, which reading again
+// would block on) or cannot be read.
+func fileDigest(filename string) (int64, string) {
+	if info, err := os.Stat(filename); filename == "-" || err != nil || !info.Mode().IsRegular() {
+		return 0, ""
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, ""
+	}
+	sum := sha256.Sum256(data)
+	return int64(len(data)), hex.EncodeToString(sum[:])
+}
+
+// writeManifest writes m to opts.manifestPath, as BONJSON if the file is
+// named *.boj or *.bonjson, and as JSON otherwise.
+func writeManifest(m *manifest, opts *options) error {
+	if opts.manifestPath == "" {
+		return nil
+	}
+	if err := saveDocument(opts.manifestPath, m); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	return nil
+}
+
+// hasInputExtension reports whether filename has an extension of the input
+// format.
+func hasInputExtension(filename string, inputJSON bool, opts *options) bool {
+	f := formatForPath(filename)
+	if f == nil {
+		return false
+	}
+	if opts.inputFormat != "" {
+		return f.Name == opts.inputFormat
+	}
+	if inputJSON {
+		return f.Name == "json"
+	}
+	return f.Name == "bonjson"
+}
+
+// outputExtension returns the file extension for the output format.
+func outputExtension(outputJSON bool, opts *options) string {
+	return outputFormat(outputJSON, opts).Extensions[0]
+}
+
+// replaceExtension replaces the extension of filename with ext.
+func replaceExtension(filename, ext string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+}
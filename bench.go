@@ -0,0 +1,189 @@
+// ABOUTME: The bench command: measures conversion throughput and allocations on an input.
+// ABOUTME: Results can be saved as a baseline and later runs fail if they regress against it.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// benchResult is the measurement of one conversion step.
+type benchResult struct {
+	Name        string  `json:"name"`
+	NsPerOp     int64   `json:"ns_per_op"`
+	MBPerSec    float64 `json:"mb_per_sec"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+}
+
+// benchReport is the result of a bench run, as saved with --save-baseline.
+type benchReport struct {
+	Input      string        `json:"input"`
+	Benchmarks []benchResult `json:"benchmarks"`
+}
+
+// runBench benchmarks decoding and encoding the input in both formats and
+// prints the results. With opts.benchBaseline, each result is compared to the
+// baseline; if opts.benchFailPercent is set, a throughput drop or allocation
+// increase beyond that percentage is returned as an error.
+func runBench(inputPath string, inputJSON bool, opts *options) error {
+	var data []byte
+	var err error
+	if inputPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(inputPath)
+	}
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	// Prepare the input in both formats, so every step can be measured. The
+	// encode steps use the documents as decoded from BONJSON, so results do
+	// not depend on which format the input was in.
+	var docs []any
+	var decodeErr error
+	if inputJSON {
+		docs, decodeErr = decodeJSON(data, opts)
+	} else {
+		docs, _, decodeErr = decodeBONJSON(data, opts)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("decoding input: %w", decodeErr)
+	}
+	bonjsonDocs, err := encodeDocuments(docs, false, opts)
+	if err != nil {
+		return err
+	}
+	bonjsonData := bytes.Join(bonjsonDocs, nil)
+	if docs, _, err = decodeBONJSON(bonjsonData, opts); err != nil {
+		return fmt.Errorf("decoding input: %w", err)
+	}
+	jsonDocs, err := encodeDocuments(docs, true, opts)
+	if err != nil {
+		return err
+	}
+	jsonData := bytes.Join(jsonDocs, nil)
+
+	steps := []struct {
+		name  string
+		bytes int
+		run   func() error
+	}{
+		{"json-decode", len(jsonData), func() error {
+			_, err := decodeJSON(jsonData, opts)
+			return err
+		}},
+		{"bonjson-encode", len(bonjsonData), func() error {
+			_, err := encodeDocuments(docs, false, opts)
+			return err
+		}},
+		{"bonjson-decode", len(bonjsonData), func() error {
+			_, _, err := decodeBONJSON(bonjsonData, opts)
+			return err
+		}},
+		{"json-encode", len(jsonData), func() error {
+			_, err := encodeDocuments(docs, true, opts)
+			return err
+		}},
+	}
+
+	report := benchReport{Input: inputPath}
+	for _, step := range steps {
+		var stepErr error
+		r := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(step.bytes))
+			for b.Loop() {
+				if err := step.run(); err != nil {
+					stepErr = err
+					b.FailNow()
+				}
+			}
+		})
+		if stepErr != nil {
+			return fmt.Errorf("%s: %w", step.name, stepErr)
+		}
+		mbPerSec := 0.0
+		if r.T > 0 {
+			mbPerSec = float64(r.Bytes) * float64(r.N) / 1e6 / r.T.Seconds()
+		}
+		report.Benchmarks = append(report.Benchmarks, benchResult{
+			Name:        step.name,
+			NsPerOp:     r.NsPerOp(),
+			MBPerSec:    mbPerSec,
+			AllocsPerOp: r.AllocsPerOp(),
+			BytesPerOp:  r.AllocedBytesPerOp(),
+		})
+	}
+
+	var baseline map[string]benchResult
+	if opts.benchBaseline != "" {
+		var saved benchReport
+		if err := loadDocumentInto(opts.benchBaseline, &saved); err != nil {
+			return fmt.Errorf("reading baseline: %w", err)
+		}
+		baseline = make(map[string]benchResult, len(saved.Benchmarks))
+		for _, result := range saved.Benchmarks {
+			baseline[result.Name] = result
+		}
+	}
+
+	var regressions []string
+	fmt.Printf("%-16s %12s %12s %14s", "benchmark", "MB/s", "allocs/op", "bytes/op")
+	if baseline != nil {
+		fmt.Printf(" %11s %11s", "MB/s diff", "allocs diff")
+	}
+	fmt.Println()
+	for _, result := range report.Benchmarks {
+		fmt.Printf("%-16s %12.2f %12d %14d", result.Name, result.MBPerSec, result.AllocsPerOp, result.BytesPerOp)
+		if base, ok := baseline[result.Name]; ok {
+			speed := percentChange(base.MBPerSec, result.MBPerSec)
+			allocs := percentChange(float64(base.AllocsPerOp), float64(result.AllocsPerOp))
+			fmt.Printf(" %+10.1f%% %+10.1f%%", speed, allocs)
+			if opts.benchFailPercent > 0 {
+				if -speed > opts.benchFailPercent {
+					regressions = append(regressions, fmt.Sprintf("%s throughput down %.1f%%", result.Name, -speed))
+				}
+				if allocs > opts.benchFailPercent {
+					regressions = append(regressions, fmt.Sprintf("%s allocations up %.1f%%", result.Name, allocs))
+				}
+			}
+		}
+		fmt.Println()
+	}
+
+	if opts.benchSaveBaseline != "" {
+		if err := saveDocument(opts.benchSaveBaseline, report); err != nil {
+			return fmt.Errorf("writing baseline: %w", err)
+		}
+	}
+	if len(regressions) > 0 {
+		return fmt.Errorf("performance regressed beyond %g%%: %s", opts.benchFailPercent, strings.Join(regressions, "; "))
+	}
+	return nil
+}
+
+// parsePercent parses a percentage such as "10%" or "2.5".
+func parsePercent(s string) (float64, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || pct < 0 {
+		return 0, fmt.Errorf("invalid percentage: %s", s)
+	}
+	return pct, nil
+}
+
+// percentChange returns the change from base to current as a percentage of
+// base, or 0 if base is 0.
+func percentChange(base, current float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return (current - base) / base * 100
+}
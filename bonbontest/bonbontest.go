@@ -0,0 +1,256 @@
+// ABOUTME: Test helpers for projects that keep JSON and BONJSON golden fixtures side by side.
+// ABOUTME: Provides round-trip assertions and golden files that go test -update rewrites.
+
+// Package bonbontest helps tests check data against golden fixtures kept in
+// both JSON and BONJSON. A golden fixture NAME is a pair of files in a
+// directory: NAME.json, indented for review, and NAME.boj, its BONJSON
+// encoding. Run the tests with -update to rewrite the fixtures from the
+// current results:
+//
+//	go test ./... -update
+//
+// The package defines the -update flag unless another package imported by
+// the test binary already has, in which case it reads that one. A test
+// package that wants to know whether it is updating should call Updating
+// rather than define the flag again.
+package bonbontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kstenerud/go-bonjson"
+)
+
+func init() {
+	if flag.Lookup("update") == nil {
+		flag.Bool("update", false, "rewrite bonbontest golden files")
+	}
+}
+
+// Updating reports whether the tests were run with -update.
+func Updating() bool {
+	f := flag.Lookup("update")
+	return f != nil && f.Value.String() == "true"
+}
+
+// AssertRoundTrip checks that the JSON document data survives conversion to
+// BONJSON and back unchanged.
+func AssertRoundTrip(t testing.TB, data []byte) {
+	t.Helper()
+	want, err := decode(data)
+	if err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	encoded, err := bonjson.Marshal(want)
+	if err != nil {
+		t.Fatalf("encoding BONJSON: %v", err)
+	}
+	var got any
+	if err := bonjson.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("decoding BONJSON: %v", err)
+	}
+	if !equal(want, got) {
+		t.Errorf("round trip through BONJSON changed the document:\nbefore: %s\nafter:  %s", canonical(want), canonical(got))
+	}
+}
+
+// AssertGolden checks the JSON document got against the golden fixture name
+// in dir: it must equal the document in NAME.json, and its BONJSON encoding
+// must match NAME.boj byte for byte. With -update, the fixture is rewritten
+// from got instead.
+func AssertGolden(t testing.TB, dir, name string, got []byte) {
+	t.Helper()
+	if Updating() {
+		UpdateGolden(t, dir, name, got)
+		return
+	}
+	gotValue, err := decode(got)
+	if err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	jsonPath := filepath.Join(dir, name+".json")
+	goldenJSON, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	want, err := decode(goldenJSON)
+	if err != nil {
+		t.Fatalf("%s: %v", jsonPath, err)
+	}
+	if !equal(want, gotValue) {
+		t.Errorf("%s: got %s, want %s", jsonPath, canonical(gotValue), canonical(want))
+	}
+
+	bonjsonPath := filepath.Join(dir, name+".boj")
+	goldenBONJSON, err := os.ReadFile(bonjsonPath)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	encoded, err := bonjson.Marshal(gotValue)
+	if err != nil {
+		t.Fatalf("encoding BONJSON: %v", err)
+	}
+	if !bytes.Equal(encoded, goldenBONJSON) {
+		t.Errorf("%s: BONJSON encoding differs from the golden file (%d bytes, want %d)", bonjsonPath, len(encoded), len(goldenBONJSON))
+	}
+}
+
+// UpdateGolden writes the JSON document data as the golden fixture name in
+// dir, creating dir if needed.
+func UpdateGolden(t testing.TB, dir, name string, data []byte) {
+	t.Helper()
+	value, err := decode(data)
+	if err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	indented, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		t.Fatalf("encoding JSON: %v", err)
+	}
+	encoded, err := bonjson.Marshal(value)
+	if err != nil {
+		t.Fatalf("encoding BONJSON: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("creating golden directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".json"), append(indented, '\n'), 0o644); err != nil {
+		t.Fatalf("writing golden file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".boj"), encoded, 0o644); err != nil {
+		t.Fatalf("writing golden file: %v", err)
+	}
+}
+
+// decode decodes the JSON document data with its integers kept exact, as
+// int64, uint64, or *big.Int, as BONJSON decodes them, so that integers
+// beyond 2^53 survive to be compared. Other numbers decode as float64.
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("invalid data after top-level value")
+	}
+	return exactNumbers(value)
+}
+
+// exactNumbers replaces each json.Number within v with the number it holds.
+func exactNumbers(v any) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return v.Float64()
+		}
+		n, ok := new(big.Int).SetString(v.String(), 10)
+		switch {
+		case !ok:
+			return nil, errors.New("invalid number " + v.String())
+		case n.IsInt64():
+			return n.Int64(), nil
+		case n.IsUint64():
+			return n.Uint64(), nil
+		}
+		return n, nil
+	case map[string]any:
+		for key, member := range v {
+			exact, err := exactNumbers(member)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = exact
+		}
+	case []any:
+		for i, elem := range v {
+			exact, err := exactNumbers(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = exact
+		}
+	}
+	return v, nil
+}
+
+// equal reports whether a and b are the same document. Numbers are equal by
+// exact value, whatever type they were decoded as: a whole float64 from
+// JSON equals the int64 BONJSON gives for it.
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, member := range a {
+			other, ok := b[key]
+			if !ok || !equal(member, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if x, ok := exactValue(a); ok {
+		y, ok := exactValue(b)
+		return ok && x.Cmp(y) == 0
+	}
+	return a == b
+}
+
+// exactValue returns the exact value of the finite number n, or false if n
+// is not one.
+func exactValue(n any) (*big.Rat, bool) {
+	switch n := n.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case uint64:
+		return new(big.Rat).SetUint64(n), true
+	case *big.Int:
+		return new(big.Rat).SetInt(n), true
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(n), true
+	case *big.Float:
+		if n.IsInf() {
+			return nil, false
+		}
+		exact, _ := n.Rat(nil)
+		return exact, true
+	}
+	return nil, false
+}
+
+func canonical(v any) []byte {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return []byte(err.Error())
+	}
+	return encoded
+}
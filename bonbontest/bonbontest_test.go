@@ -0,0 +1,91 @@
+// ABOUTME: Tests for the bonbontest helpers: exact round trips, golden files, and the -update flag.
+// ABOUTME: Failures of the assertions themselves are caught with a recording testing.TB.
+
+package bonbontest
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// recorder is a testing.TB that records failures instead of reporting
+// them, so that the assertions' failures can be tested.
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failed = true
+}
+
+func TestAssertRoundTrip(t *testing.T) {
+	AssertRoundTrip(t, []byte(`{"id": 9007199254740993, "big": 18446744073709551616, "ratio": 0.5, "tags": ["a", null, true]}`))
+}
+
+func TestEqualComparesIntegersExactly(t *testing.T) {
+	a, err := decode([]byte(`{"id": 9007199254740993}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := decode([]byte(`{"id": 9007199254740992}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if equal(a, b) {
+		t.Error("integers beyond 2^53 that differ by one compared equal")
+	}
+	whole, err := decode([]byte(`[1.0, 1e2]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(whole, []any{int64(1), uint64(100)}) {
+		t.Error("whole floats did not equal the same integers")
+	}
+}
+
+func TestDecodeRejectsTrailingData(t *testing.T) {
+	if _, err := decode([]byte(`{} {}`)); err == nil {
+		t.Error("decoded two documents as one")
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "golden")
+	doc := []byte(`{"id": 9007199254740993, "name": "x"}`)
+	UpdateGolden(t, dir, "doc", doc)
+	AssertGolden(t, dir, "doc", doc)
+
+	r := &recorder{TB: t}
+	AssertGolden(r, dir, "doc", []byte(`{"id": 9007199254740992, "name": "x"}`))
+	if !r.failed {
+		t.Error("a document differing from the golden file passed")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "doc.boj"), []byte{0}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r = &recorder{TB: t}
+	AssertGolden(r, dir, "doc", doc)
+	if !r.failed {
+		t.Error("a BONJSON golden file that differs passed")
+	}
+}
+
+func TestUpdating(t *testing.T) {
+	if flag.Lookup("update") == nil {
+		t.Fatal("-update is not defined")
+	}
+	if Updating() {
+		t.Fatal("updating without -update")
+	}
+	flag.Set("update", "true")
+	defer flag.Set("update", "false")
+	if !Updating() {
+		t.Error("not updating with -update")
+	}
+}
@@ -0,0 +1,618 @@
+// ABOUTME: bonjson-text: a reversible textual rendering of BONJSON, one token per line with its offset.
+// ABOUTME: Written with --to bontext and read with --from bontext, so binary documents can be reviewed and patched by hand.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// bontextHeader is the first line of bonjson-text.
+const bontextHeader = "# bonjson-text 1"
+
+// bontextPreview is how many bytes of a string, and an eighth as many
+// elements of a typed array, a decode trace shows.
+const bontextPreview = 64
+
+// bontextIntTypes name the fixed-size integer type codes 0xA8-0xAF.
+var bontextIntTypes = []string{"uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64"}
+
+// bontextArrayTypes name the element types of the typed array type codes
+// 0xF5-0xFE.
+var bontextArrayTypes = []string{"float64", "float32", "int64", "int32", "int16", "int8", "uint64", "uint32", "uint16", "uint8"}
+
+// renderBontext renders the BONJSON documents in data as bonjson-text. Each
+// line holds the offset of a token and the token, indented by its depth:
+//
+//	small N, uint8 N ... int64 N   an integer in the given encoding
+//	float32 X, float64 X           a float, or nan:0xBITS for a NaN
+//	bignumber SeE                  a big number, significand S times 10^E
+//	string "..."                   a string, in Go quoting
+//	long-string "..."              a string in the long form it need not take
+//	key "...", long-key "..."      an object key
+//	null, true, false
+//	array, object, end             container start and end
+//	record-def "k" ...             a record definition, with its keys
+//	record N                       a record instance of definition N
+//	typed-array T X ...            a typed array of element type T
+//
+// Every encoding choice is written out, so parseBontext rebuilds the input
+// byte for byte.
+func renderBontext(data []byte) ([]byte, error) {
+	r := &bontextRenderer{data: data}
+	r.out.WriteString(bontextHeader + "\n")
+	if err := r.documents(); err != nil {
+		return nil, err
+	}
+	return r.out.Bytes(), nil
+}
+
+// bontextRenderer renders BONJSON from data, starting at pos. With trace
+// set, it renders a decode trace instead: container starts and ends become
+// push and pop events, and long strings and typed arrays are cut short.
+type bontextRenderer struct {
+	data  []byte
+	pos   int
+	out   bytes.Buffer
+	trace bool
+}
+
+// documents renders the documents from pos to the end of data, each with
+// the record definitions before it.
+func (r *bontextRenderer) documents() error {
+	for document := 0; r.pos < len(r.data); document++ {
+		for r.pos < len(r.data) && r.data[r.pos] == 0xB9 {
+			if err := r.recordDef(); err != nil {
+				return err
+			}
+		}
+		if r.pos == len(r.data) {
+			break
+		}
+		fmt.Fprintf(&r.out, "# document %d\n", document)
+		if err := r.value(0, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// line writes one token, which starts at offset start.
+func (r *bontextRenderer) line(start, depth int, format string, args ...any) {
+	fmt.Fprintf(&r.out, "%-8d %s", start, strings.Repeat("  ", depth))
+	fmt.Fprintf(&r.out, format, args...)
+	r.out.WriteByte('\n')
+}
+
+// take consumes the next n bytes.
+func (r *bontextRenderer) take(n int) ([]byte, error) {
+	if n > len(r.data)-r.pos {
+		return nil, fmt.Errorf("offset %d: %w", r.pos, io.ErrUnexpectedEOF)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// leb128 consumes an unsigned LEB128 number, which may exceed 64 bits in a
+// big number's exponent.
+func (r *bontextRenderer) leb128() (*big.Int, error) {
+	n := new(big.Int)
+	for shift := uint(0); ; shift += 7 {
+		b, err := r.take(1)
+		if err != nil {
+			return nil, err
+		}
+		n.Or(n, new(big.Int).Lsh(big.NewInt(int64(b[0]&0x7F)), shift))
+		if b[0]&0x80 == 0 {
+			return n, nil
+		}
+	}
+}
+
+// count consumes an LEB128 count or index, which cannot exceed the input.
+func (r *bontextRenderer) count() (int, error) {
+	start := r.pos
+	n, err := r.leb128()
+	if err != nil {
+		return 0, err
+	}
+	if !n.IsInt64() || n.Int64() > int64(len(r.data)) {
+		return 0, fmt.Errorf("offset %d: count %s exceeds the input", start, n)
+	}
+	return int(n.Int64()), nil
+}
+
+// recordDef renders a record definition.
+func (r *bontextRenderer) recordDef() error {
+	start := r.pos
+	r.pos++
+	var keys []string
+	for {
+		if r.pos == len(r.data) {
+			return fmt.Errorf("offset %d: %w", r.pos, io.ErrUnexpectedEOF)
+		}
+		if r.data[r.pos] == 0xB6 {
+			r.pos++
+			break
+		}
+		keyStart := r.pos
+		key, long, err := r.string()
+		if err != nil {
+			return err
+		}
+		if long {
+			return fmt.Errorf("offset %d: record key %q has a long form bonjson-text cannot keep", keyStart, key)
+		}
+		keys = append(keys, strconv.Quote(key))
+	}
+	r.line(start, 0, "record-def %s", strings.Join(keys, " "))
+	return nil
+}
+
+// string consumes a string, reporting whether it takes the long form
+// although it need not.
+func (r *bontextRenderer) string() (string, bool, error) {
+	start := r.pos
+	b, err := r.take(1)
+	if err != nil {
+		return "", false, err
+	}
+	switch tc := b[0]; {
+	case tc >= 0x65 && tc <= 0xA7:
+		s, err := r.take(int(tc - 0x65))
+		return string(s), false, err
+	case tc == 0xFF:
+		end := bytes.IndexByte(r.data[r.pos:], 0xFF)
+		if end < 0 {
+			return "", false, fmt.Errorf("offset %d: unterminated long string: %w", start, io.ErrUnexpectedEOF)
+		}
+		s, _ := r.take(end)
+		r.pos++
+		return string(s), len(s) <= 66, nil
+	default:
+		return "", false, fmt.Errorf("offset %d: expected a string, got type code 0x%02x", start, tc)
+	}
+}
+
+// value renders one value and, for a container, its members. A key is a
+// string in the key position of an object.
+func (r *bontextRenderer) value(depth int, key bool) error {
+	start := r.pos
+	if start == len(r.data) {
+		return fmt.Errorf("offset %d: %w", start, io.ErrUnexpectedEOF)
+	}
+	tc := r.data[start]
+	if key || isStringTypeCode(tc) {
+		s, long, err := r.string()
+		if err != nil {
+			return err
+		}
+		token := "string"
+		if key {
+			token = "key"
+		}
+		if long {
+			token = "long-" + token
+		}
+		if r.trace && len(s) > bontextPreview {
+			r.line(start, depth, "%s %s... (%d bytes)", token, strconv.Quote(s[:bontextPreview]), len(s))
+		} else {
+			r.line(start, depth, "%s %s", token, strconv.Quote(s))
+		}
+		return nil
+	}
+
+	r.pos++
+	switch {
+	case tc <= 0x64:
+		r.line(start, depth, "small %d", tc)
+	case tc >= 0xA8 && tc <= 0xAF:
+		size := 1 << (tc & 0x03)
+		b, err := r.take(size)
+		if err != nil {
+			return err
+		}
+		var u uint64
+		for i := size - 1; i >= 0; i-- {
+			u = u<<8 | uint64(b[i])
+		}
+		if tc >= 0xAC {
+			shift := 64 - 8*size
+			r.line(start, depth, "%s %d", bontextIntTypes[tc-0xA8], int64(u<<shift)>>shift)
+		} else {
+			r.line(start, depth, "%s %d", bontextIntTypes[tc-0xA8], u)
+		}
+	case tc == 0xB0:
+		b, err := r.take(4)
+		if err != nil {
+			return err
+		}
+		r.line(start, depth, "float32 %s", formatBontextFloat(uint64(binary.LittleEndian.Uint32(b)), 32))
+	case tc == 0xB1:
+		b, err := r.take(8)
+		if err != nil {
+			return err
+		}
+		r.line(start, depth, "float64 %s", formatBontextFloat(binary.LittleEndian.Uint64(b), 64))
+	case tc == 0xB2:
+		exponent, err := r.leb128()
+		if err != nil {
+			return err
+		}
+		length, err := r.leb128()
+		if err != nil {
+			return err
+		}
+		signedLength := zigzagDecodeBig(length)
+		if !signedLength.IsInt64() || new(big.Int).Abs(signedLength).Int64() > int64(len(r.data)) {
+			return fmt.Errorf("offset %d: big number length %s exceeds the input", start, signedLength)
+		}
+		magnitude, err := r.take(int(new(big.Int).Abs(signedLength).Int64()))
+		if err != nil {
+			return err
+		}
+		be := make([]byte, len(magnitude))
+		for i, b := range magnitude {
+			be[len(be)-1-i] = b
+		}
+		significand := new(big.Int).SetBytes(be)
+		if signedLength.Sign() < 0 {
+			significand.Neg(significand)
+		}
+		r.line(start, depth, "bignumber %se%s", significand, zigzagDecodeBig(exponent))
+	case tc == 0xB3:
+		r.line(start, depth, "null")
+	case tc == 0xB4:
+		r.line(start, depth, "false")
+	case tc == 0xB5:
+		r.line(start, depth, "true")
+	case tc == 0xB7:
+		r.open(start, depth, "array")
+		return r.members(depth, false, "array")
+	case tc == 0xB8:
+		r.open(start, depth, "object")
+		return r.members(depth, true, "object")
+	case tc == 0xBA:
+		index, err := r.count()
+		if err != nil {
+			return err
+		}
+		r.open(start, depth, fmt.Sprintf("record %d", index))
+		return r.members(depth, false, "record")
+	case tc >= 0xF5 && tc <= 0xFE:
+		count, err := r.count()
+		if err != nil {
+			return err
+		}
+		size := int(typedArrayElementSize(tc))
+		if count > (len(r.data)-r.pos)/size {
+			return fmt.Errorf("offset %d: %w", r.pos, io.ErrUnexpectedEOF)
+		}
+		elemType := bontextArrayTypes[tc-0xF5]
+		elems := make([]string, count)
+		for i := range elems {
+			b, _ := r.take(size)
+			var u uint64
+			for j := size - 1; j >= 0; j-- {
+				u = u<<8 | uint64(b[j])
+			}
+			switch elemType[0] {
+			case 'f':
+				elems[i] = formatBontextFloat(u, 8*size)
+			case 'i':
+				shift := 64 - 8*size
+				elems[i] = strconv.FormatInt(int64(u<<shift)>>shift, 10)
+			default:
+				elems[i] = strconv.FormatUint(u, 10)
+			}
+		}
+		if r.trace && len(elems) > bontextPreview/8 {
+			elems = append(elems[:bontextPreview/8], fmt.Sprintf("... (%d elements)", count))
+		}
+		r.line(start, depth, "typed-array %s", strings.Join(append([]string{elemType}, elems...), " "))
+	default:
+		return &typeCodeError{offset: int64(start), code: tc}
+	}
+	return nil
+}
+
+// open renders the start of a container.
+func (r *bontextRenderer) open(start, depth int, container string) {
+	if r.trace {
+		r.line(start, depth, "push %s", container)
+	} else {
+		r.line(start, depth, "%s", container)
+	}
+}
+
+// members renders the members of a container up to and including its end.
+func (r *bontextRenderer) members(depth int, isObject bool, container string) error {
+	for {
+		if r.pos == len(r.data) {
+			return fmt.Errorf("offset %d: %w", r.pos, io.ErrUnexpectedEOF)
+		}
+		if r.data[r.pos] == 0xB6 {
+			if r.trace {
+				r.line(r.pos, depth, "pop %s", container)
+			} else {
+				r.line(r.pos, depth, "end")
+			}
+			r.pos++
+			return nil
+		}
+		if isObject {
+			if err := r.value(depth+1, true); err != nil {
+				return err
+			}
+		}
+		if err := r.value(depth+1, false); err != nil {
+			return err
+		}
+	}
+}
+
+// formatBontextFloat formats the float of bitSize with the given bits as the
+// shortest decimal that reads back to it, or nan:0xBITS for a NaN, whose
+// payload a decimal cannot carry.
+func formatBontextFloat(bits uint64, bitSize int) string {
+	f := math.Float64frombits(bits)
+	if bitSize == 32 {
+		f = float64(math.Float32frombits(uint32(bits)))
+	}
+	if math.IsNaN(f) {
+		return fmt.Sprintf("nan:0x%0*x", bitSize/4, bits)
+	}
+	return strconv.FormatFloat(f, 'g', -1, bitSize)
+}
+
+// zigzagDecodeBig decodes a zigzag-encoded signed number.
+func zigzagDecodeBig(z *big.Int) *big.Int {
+	n := new(big.Int).Rsh(z, 1)
+	if z.Bit(0) == 1 {
+		n.Not(n)
+	}
+	return n
+}
+
+// zigzagEncodeBig zigzag-encodes a signed number.
+func zigzagEncodeBig(n *big.Int) *big.Int {
+	z := new(big.Int).Lsh(n, 1)
+	if n.Sign() < 0 {
+		z.Not(z)
+	}
+	return z
+}
+
+// parseBontext parses bonjson-text back into BONJSON. Blank lines and lines
+// starting with # are ignored, as are the offsets that start lines, so a
+// hand-edited rendering need not renumber them. Structure is not checked
+// here; decoding the result does that.
+func parseBontext(text []byte) ([]byte, error) {
+	var out []byte
+	for i, line := range strings.Split(string(text), "\n") {
+		fields, err := bontextFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if len(fields) > 0 && strings.Trim(fields[0], "0123456789") == "" {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if out, err = appendBontextToken(out, fields[0], fields[1:]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return out, nil
+}
+
+// bontextFields splits a line into space-separated fields, a quoted string
+// being one field. A comment line has no fields.
+func bontextFields(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" || (len(fields) == 0 && line[0] == '#') {
+			return fields, nil
+		}
+		end := strings.IndexAny(line, " \t\r")
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", line)
+			}
+			end = len(quoted)
+		} else if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+}
+
+// appendBontextToken appends the encoding of the token name with arguments
+// args to out.
+func appendBontextToken(out []byte, name string, args []string) ([]byte, error) {
+	want := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s takes %d arguments, got %d", name, n, len(args))
+		}
+		return nil
+	}
+	switch name {
+	case "null", "false", "true", "array", "object", "end":
+		if err := want(0); err != nil {
+			return nil, err
+		}
+		code := map[string]byte{"null": 0xB3, "false": 0xB4, "true": 0xB5, "array": 0xB7, "object": 0xB8, "end": 0xB6}[name]
+		return append(out, code), nil
+	case "small":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseUint(args[0], 10, 8)
+		if err != nil || n > 0x64 {
+			return nil, fmt.Errorf("invalid small integer %s: must be 0 to 100", args[0])
+		}
+		return append(out, byte(n)), nil
+	case "uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		i := slices.Index(bontextIntTypes, name)
+		out = append(out, 0xA8+byte(i))
+		return appendBontextNumber(out, name, args[0])
+	case "float32", "float64":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		if name == "float32" {
+			out = append(out, 0xB0)
+		} else {
+			out = append(out, 0xB1)
+		}
+		return appendBontextNumber(out, name, args[0])
+	case "bignumber":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		s, e, _ := strings.Cut(strings.ToLower(args[0]), "e")
+		significand, ok1 := new(big.Int).SetString(s, 10)
+		exponent, ok2 := new(big.Int).SetString(e, 10)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("invalid big number %s: want SIGNIFICANDeEXPONENT", args[0])
+		}
+		out = appendLEB128Big(append(out, 0xB2), zigzagEncodeBig(exponent))
+		be := new(big.Int).Abs(significand).Bytes()
+		out = appendLEB128Big(out, zigzagEncodeBig(big.NewInt(int64(significand.Sign()*len(be)))))
+		for i := len(be) - 1; i >= 0; i-- {
+			out = append(out, be[i])
+		}
+		return out, nil
+	case "string", "key", "long-string", "long-key":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		s, err := unquoteBontext(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return appendBontextString(out, s, strings.HasPrefix(name, "long-"))
+	case "record-def":
+		out = append(out, 0xB9)
+		for _, arg := range args {
+			s, err := unquoteBontext(arg)
+			if err != nil {
+				return nil, err
+			}
+			if out, err = appendBontextString(out, s, false); err != nil {
+				return nil, err
+			}
+		}
+		return append(out, 0xB6), nil
+	case "record":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		index, ok := new(big.Int).SetString(args[0], 10)
+		if !ok || index.Sign() < 0 {
+			return nil, fmt.Errorf("invalid record index %s", args[0])
+		}
+		return appendLEB128Big(append(out, 0xBA), index), nil
+	case "typed-array":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("typed-array needs an element type")
+		}
+		i := slices.Index(bontextArrayTypes, args[0])
+		if i < 0 {
+			return nil, fmt.Errorf("invalid typed array element type %s", args[0])
+		}
+		out = appendLEB128Big(append(out, 0xF5+byte(i)), big.NewInt(int64(len(args)-1)))
+		for _, arg := range args[1:] {
+			var err error
+			if out, err = appendBontextNumber(out, args[0], arg); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown token %s", name)
+}
+
+// appendBontextNumber appends the little-endian bytes of the number s, of
+// the integer or float type typ.
+func appendBontextNumber(out []byte, typ, s string) ([]byte, error) {
+	var bits uint64
+	var err error
+	size, _ := strconv.Atoi(strings.TrimLeft(typ, "abcdefghijklmnopqrstuvwxyz"))
+	switch {
+	case strings.HasPrefix(typ, "float") && strings.HasPrefix(s, "nan:0x"):
+		bits, err = strconv.ParseUint(s[len("nan:0x"):], 16, size)
+	case typ == "float32":
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		bits = uint64(math.Float32bits(float32(f)))
+	case typ == "float64":
+		var f float64
+		f, err = strconv.ParseFloat(s, 64)
+		bits = math.Float64bits(f)
+	case strings.HasPrefix(typ, "uint"):
+		bits, err = strconv.ParseUint(s, 10, size)
+	default:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, size)
+		bits = uint64(n)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %s", typ, s)
+	}
+	for i := 0; i < size/8; i++ {
+		out = append(out, byte(bits>>(8*i)))
+	}
+	return out, nil
+}
+
+// appendBontextString appends s as a short string if it fits one and long is
+// not set, and as a long string otherwise.
+func appendBontextString(out []byte, s string, long bool) ([]byte, error) {
+	if !long && len(s) <= 66 {
+		return append(append(out, 0x65+byte(len(s))), s...), nil
+	}
+	if strings.IndexByte(s, 0xFF) >= 0 {
+		return nil, fmt.Errorf("string %q holds a 0xff byte, which ends a long string", s)
+	}
+	out = append(append(out, 0xFF), s...)
+	return append(out, 0xFF), nil
+}
+
+// appendLEB128Big appends the unsigned LEB128 encoding of n.
+func appendLEB128Big(out []byte, n *big.Int) []byte {
+	n = new(big.Int).Set(n)
+	for {
+		b := byte(n.Uint64() & 0x7F)
+		n.Rsh(n, 7)
+		if n.Sign() == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// unquoteBontext unquotes a quoted string argument.
+func unquoteBontext(arg string) (string, error) {
+	s, err := strconv.Unquote(arg)
+	if err != nil || !strings.HasPrefix(arg, `"`) {
+		return "", fmt.Errorf("invalid quoted string %s", arg)
+	}
+	return s, nil
+}
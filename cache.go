@@ -0,0 +1,94 @@
+// ABOUTME: The conversion cache (--cache-dir): output stored under a digest of the input, options, and bonbon build.
+// ABOUTME: Converting the same bytes the same way again, as CI jobs often do, reads the output back instead.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// cacheIgnoredOptions are the options that do not change the bytes of a
+// conversion's output, and so are left out of its cache key.
+var cacheIgnoredOptions = []string{
+	"--cache-dir", "--manifest", "--incremental", "--keep-temp", "--workers", "--queue-depth",
+	"--cpu-profile", "--mem-profile", "--trace-file", "--preserve-mode", "--preserve-times",
+	"--expect-sha256", "--in-fd", "--out-fd", "--summary",
+}
+
+// cacheable reports whether the conversion to outputPath can be served from
+// and stored in opts.cacheDir. Conversions with output beside the converted
+// documents (shards, sidecars, snapshots, reports on stderr) are not, nor are
+// those that read the environment or files that the input refers to.
+func cacheable(outputPath string, opts *options) bool {
+	return opts.cacheDir != "" && outputPath != "" &&
+		opts.splitSize == 0 && opts.splitDocs == 0 && opts.provenanceFile == "" && opts.offsetMapFile == "" && opts.decodeTraceFile == "" && opts.snapshotDir == "" &&
+		!opts.lossinessReport && !opts.envelope && !opts.printEndOffset && opts.trailingOut == "" &&
+		!opts.expandEnv && !opts.resolveRefs && !opts.omitNulls && !opts.nullsAsAbsent &&
+		len(opts.anonymizeFields) == 0 && opts.redactRules == nil && opts.fieldKey == nil && opts.uint64Mode != "clamp"
+}
+
+// conversionCacheKey returns the cache key of converting data: a digest of
+// data, the input and output formats, the options as given along with the
+// contents of the files they name, and the size and time of the bonbon
+// executable, so that a rebuilt bonbon does not reuse an older one's output.
+func conversionCacheKey(data []byte, inputJSON, outputJSON bool, opts *options) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "bonbon conversion cache 1\n")
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			fmt.Fprintf(h, "executable %d %d\n", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	fmt.Fprintf(h, "json %t %t\n", inputJSON, outputJSON)
+	writeOptionsDigest(h, opts)
+	fmt.Fprintf(h, "input %x\n", sha256.Sum256(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeOptionsDigest writes to h the options that can change a conversion's
+// output, as given, along with the digests of the files they name.
+func writeOptionsDigest(h io.Writer, opts *options) {
+	for _, option := range opts.optionArgs {
+		if slices.Contains(cacheIgnoredOptions, option[0]) {
+			continue
+		}
+		fmt.Fprintf(h, "option %q\n", option)
+		for _, value := range option[1:] {
+			if _, digest := fileDigest(value); digest != "" {
+				fmt.Fprintf(h, "file %s\n", digest)
+			}
+		}
+	}
+}
+
+// cachePath returns where the output of the conversion with key is cached.
+func cachePath(key string, opts *options) string {
+	return filepath.Join(opts.cacheDir, key[:2], key)
+}
+
+// cachedOutput returns the cached output of the conversion with key, if the
+// cache has it.
+func cachedOutput(key string, opts *options) ([]byte, bool) {
+	output, err := os.ReadFile(cachePath(key, opts))
+	return output, err == nil
+}
+
+// storeCachedOutput caches output as the output of the conversion with key.
+// A cache that cannot be written only warns, since the conversion itself
+// succeeded.
+func storeCachedOutput(key string, output []byte, opts *options) {
+	filename := cachePath(key, opts)
+	err := os.MkdirAll(filepath.Dir(filename), 0o755)
+	if err == nil {
+		err = writeFileAtomic(filename, output)
+	}
+	if err != nil {
+		warnf("conversion cache: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+// ABOUTME: The --expect-sha256 option: the input must have the given SHA-256 digest to be converted.
+// ABOUTME: The digest is computed as the input is read, and a mismatch fails the conversion before output is written.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// parseSHA256 parses the value of --expect-sha256, 64 hex digits in either
+// case, returning it in lower case.
+func parseSHA256(s string) (string, error) {
+	digest := strings.ToLower(s)
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 digest: %s (expected 64 hex digits)", s)
+	}
+	return digest, nil
+}
+
+// checkSHA256 reports an error if data does not have the SHA-256 digest
+// expected, in lower-case hex.
+func checkSHA256(data []byte, expected string) error {
+	sum := sha256.Sum256(data)
+	return compareSHA256(hex.EncodeToString(sum[:]), expected)
+}
+
+// compareSHA256 reports an error if the digest actual is not expected.
+func compareSHA256(actual, expected string) error {
+	if actual != expected {
+		return fmt.Errorf("input SHA-256 is %s, expected %s", actual, expected)
+	}
+	return nil
+}
+
+// digestReader computes the SHA-256 digest of everything read through it.
+type digestReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{r: r, h: sha256.New()}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.h.Write(p[:n])
+	return n, err
+}
+
+// verify reads what is left of the input, so that the digest covers all of
+// it even if decoding stopped early, and reports an error if the digest is
+// not expected.
+func (d *digestReader) verify(expected string) error {
+	if _, err := io.Copy(io.Discard, d); err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+	return compareSHA256(hex.EncodeToString(d.h.Sum(nil)), expected)
+}
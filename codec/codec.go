@@ -0,0 +1,27 @@
+// ABOUTME: Package codec is bonbon's library for programs that read JSON and BONJSON themselves.
+// ABOUTME: This file holds the package documentation and the Format type the helpers share.
+
+// Package codec gives Go programs the format handling the bonbon command
+// uses, so that they need not reimplement it: telling JSON from BONJSON,
+// and decoding streams of documents.
+package codec
+
+// Format is a data format. The zero Format is no format.
+type Format int
+
+// The formats codec handles.
+const (
+	JSON Format = iota + 1
+	BONJSON
+)
+
+// String returns the format's name, as bonbon's format registry has it.
+func (f Format) String() string {
+	switch f {
+	case JSON:
+		return "json"
+	case BONJSON:
+		return "bonjson"
+	}
+	return "unknown"
+}
@@ -0,0 +1,84 @@
+// ABOUTME: Decoding of BONJSON document streams: every concatenated document in a buffer or a reader.
+// ABOUTME: Wraps go-bonjson's Decoder loop the bonbon command uses, including its end-of-stream handling.
+
+package codec
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/kstenerud/go-bonjson"
+)
+
+// UnmarshalAll decodes every BONJSON document concatenated in data. On
+// error it returns the documents before the failing one along with the
+// error.
+func UnmarshalAll(data []byte) ([]any, error) {
+	var docs []any
+	err := UnmarshalEach(bytes.NewReader(data), func(doc any) error {
+		docs = append(docs, doc)
+		return nil
+	})
+	return docs, err
+}
+
+// UnmarshalEach decodes the BONJSON documents read from r one at a time,
+// passing each to fn, so a stream need not fit in memory. Record
+// definitions stay in effect for the rest of the stream. It stops at the end
+// of r, at the first document that fails to decode, or when fn returns an
+// error, which it returns. A document that fails to decode is returned as a
+// *DecodeError.
+func UnmarshalEach(r io.Reader, fn func(doc any) error) error {
+	rec := &recorder{r: r}
+	dec := bonjson.NewDecoder(rec)
+	for document := 0; ; document++ {
+		start := dec.InputOffset()
+		rec.forget(start)
+		var doc any
+		if err := dec.Decode(&doc); err != nil {
+			if err == io.EOF && dec.InputOffset() == start {
+				return nil
+			}
+			return rec.decodeError(document, start, err)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
+
+// recorder keeps the bytes read through it from an offset on, so that the
+// document that failed to decode can be replayed to find the path of the
+// failure.
+type recorder struct {
+	r    io.Reader
+	base int64 // offset of buf[0] in the stream
+	buf  []byte
+}
+
+func (rec *recorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	rec.buf = append(rec.buf, p[:n]...)
+	return n, err
+}
+
+// forget drops the bytes before offset.
+func (rec *recorder) forget(offset int64) {
+	rec.buf = append(rec.buf[:0], rec.buf[offset-rec.base:]...)
+	rec.base = offset
+}
+
+// decodeError returns the *DecodeError for err, from decoding the document
+// that starts at offset start, the offset forgotten up to.
+func (rec *recorder) decodeError(document int, start int64, err error) *DecodeError {
+	e := &DecodeError{Format: BONJSON, Document: document, Offset: start, Err: classify(err)}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		e.Err = io.ErrUnexpectedEOF
+		e.Offset = rec.base + int64(len(rec.buf))
+		e.Path = pathAt(rec.buf, -1)
+	} else if offset, ok := errorOffset(err); ok {
+		e.Offset = start + offset
+		e.Path = pathAt(rec.buf, offset)
+	}
+	return e
+}
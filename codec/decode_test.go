@@ -0,0 +1,139 @@
+// ABOUTME: Tests for decoding BONJSON document streams from buffers and readers.
+// ABOUTME: Covers whole streams, truncated documents, limits, and callbacks that stop the decoding.
+
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+
+	"github.com/kstenerud/go-bonjson"
+)
+
+// stream returns the BONJSON encodings of docs concatenated, and each
+// document as the decoder gives it back.
+func stream(t *testing.T, docs ...any) ([]byte, []any) {
+	t.Helper()
+	var data []byte
+	var decoded []any
+	for _, doc := range docs {
+		encoded := encodeBONJSON(t, doc)
+		var value any
+		if err := bonjson.Unmarshal(encoded, &value); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, encoded...)
+		decoded = append(decoded, value)
+	}
+	return data, decoded
+}
+
+func nested(depth int) any {
+	if depth == 0 {
+		return 1
+	}
+	return []any{nested(depth - 1)}
+}
+
+func TestUnmarshalAll(t *testing.T) {
+	data, want := stream(t, map[string]any{"a": 1}, []any{"x", true, nil}, "last")
+	docs, err := UnmarshalAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("got %v, want %v", docs, want)
+	}
+}
+
+func TestUnmarshalAllEmpty(t *testing.T) {
+	docs, err := UnmarshalAll(nil)
+	if err != nil || len(docs) != 0 {
+		t.Errorf("got %v, %v, want no documents and no error", docs, err)
+	}
+}
+
+func TestUnmarshalAllTruncated(t *testing.T) {
+	data, want := stream(t, map[string]any{"a": 1}, map[string]any{"a": []any{1, 2, 3}})
+	data = data[:len(data)-3]
+	docs, err := UnmarshalAll(data)
+	if !reflect.DeepEqual(docs, want[:1]) {
+		t.Errorf("got documents %v, want those before the truncated one, %v", docs, want[:1])
+	}
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("got %v, want a *DecodeError", err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", decodeErr.Err)
+	}
+	if decodeErr.Format != BONJSON || decodeErr.Document != 1 || decodeErr.Offset != int64(len(data)) || decodeErr.Path != "$.a[2]" {
+		t.Errorf("got %+v, want BONJSON document 1 at offset %d ($.a[2])", *decodeErr, len(data))
+	}
+}
+
+func TestUnmarshalAllDepthLimit(t *testing.T) {
+	first := encodeBONJSON(t, "first")
+	data := append(first, encodeBONJSON(t, nested(1000))...)
+	_, err := UnmarshalAll(data)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Document != 1 {
+		t.Fatalf("got %v, want a *DecodeError for document 1", err)
+	}
+	var limit *LimitExceededError
+	if !errors.As(err, &limit) || limit.Limit != "depth" {
+		t.Errorf("got %v, want a depth *LimitExceededError", decodeErr.Err)
+	}
+	if decodeErr.Offset <= int64(len(first)) {
+		t.Errorf("offset %d is not inside document 1", decodeErr.Offset)
+	}
+}
+
+func TestUnmarshalEach(t *testing.T) {
+	data, want := stream(t, map[string]any{"a": 1}, []any{1.5, "x"}, map[string]any{"b": map[string]any{"c": false}})
+	var docs []any
+	err := UnmarshalEach(iotest.OneByteReader(bytes.NewReader(data)), func(doc any) error {
+		docs = append(docs, doc)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("got %v, want %v", docs, want)
+	}
+}
+
+func TestUnmarshalEachStops(t *testing.T) {
+	data, _ := stream(t, 1, 2, 3)
+	stop := errors.New("stop")
+	calls := 0
+	err := UnmarshalEach(bytes.NewReader(data), func(doc any) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("got %v, want the callback's error", err)
+	}
+	if calls != 2 {
+		t.Errorf("called %d times, want 2", calls)
+	}
+}
+
+func TestUnmarshalEachReadError(t *testing.T) {
+	failure := errors.New("read failed")
+	err := UnmarshalEach(iotest.ErrReader(failure), func(doc any) error {
+		t.Error("called for a document from a failing reader")
+		return nil
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("got %v, want the reader's error", err)
+	}
+}
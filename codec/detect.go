@@ -0,0 +1,87 @@
+// ABOUTME: Format detection over a reader: peeks at the first bytes of a stream to tell JSON from BONJSON.
+// ABOUTME: The bytes peeked at are handed back with the rest, so nothing needs to buffer a whole body.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// DetectPeekSize is the most bytes DetectReader reads before deciding.
+const DetectPeekSize = 4096
+
+// ErrEmpty is returned by DetectReader for input with no data.
+var ErrEmpty = errors.New("codec: input is empty")
+
+// DetectReader decides the format of r's data from its first bytes, reading
+// at most DetectPeekSize of them, and returns a reader of all of r's data,
+// the bytes it read included.
+//
+// As with bonbon's detection, JSON is tried first: JSON text is often valid
+// BONJSON as well (small integers and short strings are single bytes), so
+// data whose first bytes are well-formed JSON is JSON, and anything else is
+// BONJSON. If all of r's data fits in the peek, it must be whole JSON
+// documents; otherwise it must be the start of them.
+func DetectReader(r io.Reader) (Format, io.Reader, error) {
+	br := bufio.NewReaderSize(r, DetectPeekSize)
+	prefix, err := br.Peek(DetectPeekSize)
+	complete := err == io.EOF
+	if err != nil && !complete {
+		return 0, nil, err
+	}
+	if len(prefix) == 0 {
+		return 0, nil, ErrEmpty
+	}
+	if isJSON(prefix, complete) {
+		return JSON, br, nil
+	}
+	return BONJSON, br, nil
+}
+
+// DetectReaderStrict is DetectReader, except that it does not guess: input
+// that fits in the peek and is whole documents of both formats returns a
+// *DetectionAmbiguousError, for callers that would rather ask than convert
+// the wrong way.
+func DetectReaderStrict(r io.Reader) (Format, io.Reader, error) {
+	br := bufio.NewReaderSize(r, DetectPeekSize)
+	format, _, err := DetectReader(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	if format != JSON {
+		return format, br, nil
+	}
+	prefix, err := br.Peek(DetectPeekSize)
+	if err == io.EOF {
+		if _, err := UnmarshalAll(prefix); err == nil {
+			return 0, nil, &DetectionAmbiguousError{Formats: []Format{JSON, BONJSON}}
+		}
+	}
+	return JSON, br, nil
+}
+
+// isJSON reports whether data is one or more whole JSON documents, or if it
+// is not complete, whether it is the start of them.
+func isJSON(data []byte, complete bool) bool {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if complete {
+		documents := 0
+		for ; ; documents++ {
+			var value any
+			if err := dec.Decode(&value); err == io.EOF {
+				return documents > 0
+			} else if err != nil {
+				return false
+			}
+		}
+	}
+	for {
+		if _, err := dec.Token(); err != nil {
+			return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// ABOUTME: Tests for format detection over a reader: the formats found, the bytes handed back, and the errors.
+// ABOUTME: Covers input that fits the peek and input that runs past it, for DetectReader and DetectReaderStrict.
+
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/kstenerud/go-bonjson"
+)
+
+// longJSON is a JSON document longer than the peek.
+var longJSON = "[" + strings.Repeat("1,", DetectPeekSize) + "1]"
+
+func encodeBONJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	encoded, err := bonjson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+// checkRewound fails unless r reads want, the whole input detected.
+func checkRewound(t *testing.T, r io.Reader, want []byte) {
+	t.Helper()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("reader gave %d bytes, want the %d of the input", len(got), len(want))
+	}
+}
+
+func TestDetectReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  Format
+	}{
+		{"object", []byte(`{"a": 1}`), JSON},
+		{"stream", []byte("{\"a\": 1}\n{\"b\": 2}\n"), JSON},
+		{"longer than the peek", []byte(longJSON), JSON},
+		{"malformed JSON", []byte(`{"a": ]`), BONJSON},
+		{"BONJSON", encodeBONJSON(t, map[string]any{"a": []any{1, "x"}}), BONJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, r, err := DetectReader(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.want {
+				t.Errorf("detected %v, want %v", format, tt.want)
+			}
+			checkRewound(t, r, tt.input)
+		})
+	}
+}
+
+func TestDetectReaderSmallReads(t *testing.T) {
+	format, r, err := DetectReader(iotest.OneByteReader(strings.NewReader(longJSON)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != JSON {
+		t.Errorf("detected %v, want json", format)
+	}
+	checkRewound(t, r, []byte(longJSON))
+}
+
+func TestDetectReaderErrors(t *testing.T) {
+	if _, _, err := DetectReader(strings.NewReader("")); !errors.Is(err, ErrEmpty) {
+		t.Errorf("empty input: got %v, want ErrEmpty", err)
+	}
+	failure := errors.New("read failed")
+	if _, _, err := DetectReader(iotest.ErrReader(failure)); !errors.Is(err, failure) {
+		t.Errorf("failing reader: got %v, want its error", err)
+	}
+	if _, _, err := DetectReaderStrict(strings.NewReader("")); !errors.Is(err, ErrEmpty) {
+		t.Errorf("strict, empty input: got %v, want ErrEmpty", err)
+	}
+	if _, _, err := DetectReaderStrict(iotest.ErrReader(failure)); !errors.Is(err, failure) {
+		t.Errorf("strict, failing reader: got %v, want its error", err)
+	}
+}
+
+func TestDetectReaderStrict(t *testing.T) {
+	bonjsonInput := encodeBONJSON(t, map[string]any{"a": 1})
+	tests := []struct {
+		name  string
+		input []byte
+		want  Format
+	}{
+		{"object", []byte(`{"a": 1}`), JSON},
+		{"longer than the peek", []byte(longJSON), JSON},
+		{"BONJSON", bonjsonInput, BONJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, r, err := DetectReaderStrict(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.want {
+				t.Errorf("detected %v, want %v", format, tt.want)
+			}
+			checkRewound(t, r, tt.input)
+		})
+	}
+}
+
+func TestDetectReaderStrictAmbiguous(t *testing.T) {
+	// A small integer is one byte in BONJSON, as it is in JSON.
+	format, r, err := DetectReaderStrict(strings.NewReader("1"))
+	var ambiguous *DetectionAmbiguousError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("got %v, %v, want a *DetectionAmbiguousError", format, err)
+	}
+	if r != nil {
+		t.Error("returned a reader with the error")
+	}
+	if len(ambiguous.Formats) != 2 || ambiguous.Formats[0] != JSON || ambiguous.Formats[1] != BONJSON {
+		t.Errorf("formats %v, want [json bonjson]", ambiguous.Formats)
+	}
+	if got, want := err.Error(), "codec: input is valid as json and bonjson"; got != want {
+		t.Errorf("message %q, want %q", got, want)
+	}
+
+	// DetectReader guesses JSON for the same input.
+	if format, _, err := DetectReader(strings.NewReader("1")); err != nil || format != JSON {
+		t.Errorf("DetectReader gave %v, %v, want json", format, err)
+	}
+}
+
+func TestFormatString(t *testing.T) {
+	for format, want := range map[Format]string{JSON: "json", BONJSON: "bonjson", 0: "unknown"} {
+		if got := format.String(); got != want {
+			t.Errorf("Format(%d).String() = %q, want %q", format, got, want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// ABOUTME: The error types codec returns, so that callers can branch on them with errors.As.
+// ABOUTME: Decode failures carry the document, offset, and path where they happened, wrapping the decoder's cause.
+
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/kstenerud/go-bonjson"
+)
+
+// DecodeError is a document that failed to decode. Err is the cause: a
+// *LimitExceededError, a *LossyConversionError, io.ErrUnexpectedEOF for
+// data that ends inside the document, or the decoder's own error.
+type DecodeError struct {
+	Format   Format
+	Document int    // index of the document in the stream
+	Offset   int64  // byte offset in the stream where decoding failed
+	Path     string // path of the value being decoded, such as $.a[1], or "" if not known
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	at := fmt.Sprintf("codec: document %d at offset %d", e.Document, e.Offset)
+	if e.Path != "" {
+		at += " (" + e.Path + ")"
+	}
+	return at + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// LimitExceededError is input beyond one of the decoder's limits, which
+// guard against hostile input: Limit names it, such as "depth" or "string
+// length".
+type LimitExceededError struct {
+	Limit string
+	Err   error
+}
+
+func (e *LimitExceededError) Error() string {
+	return e.Limit + " limit exceeded: " + e.Err.Error()
+}
+
+func (e *LimitExceededError) Unwrap() error { return e.Err }
+
+// LossyConversionError is a value that cannot be converted to a Go value
+// without loss, such as a big number beyond float64's range, and so is
+// refused rather than changed. Value is its text.
+type LossyConversionError struct {
+	Value string
+	Err   error
+}
+
+func (e *LossyConversionError) Error() string {
+	return "cannot convert " + e.Value + " without loss: " + e.Err.Error()
+}
+
+func (e *LossyConversionError) Unwrap() error { return e.Err }
+
+// DetectionAmbiguousError is input that is valid in more than one of
+// Formats, returned where a detector may not guess.
+type DetectionAmbiguousError struct {
+	Formats []Format
+}
+
+func (e *DetectionAmbiguousError) Error() string {
+	names := make([]string, len(e.Formats))
+	for i, f := range e.Formats {
+		names[i] = f.String()
+	}
+	return "codec: input is valid as " + strings.Join(names, " and ")
+}
+
+// classify wraps go-bonjson's limit and range errors in the codec error
+// types for them, and returns other errors as they are.
+func classify(err error) error {
+	var (
+		depth      *bonjson.MaxDepthError
+		container  *bonjson.MaxContainerSizeError
+		document   *bonjson.MaxDocumentSizeError
+		str        *bonjson.MaxStringLengthError
+		magnitude  *bonjson.MaxBigNumberMagnitudeError
+		exponent   *bonjson.MaxBigNumberExponentError
+		valueRange *bonjson.ValueRangeError
+	)
+	switch {
+	case errors.As(err, &depth):
+		return &LimitExceededError{Limit: "depth", Err: err}
+	case errors.As(err, &container):
+		return &LimitExceededError{Limit: "container size", Err: err}
+	case errors.As(err, &document):
+		return &LimitExceededError{Limit: "document size", Err: err}
+	case errors.As(err, &str):
+		return &LimitExceededError{Limit: "string length", Err: err}
+	case errors.As(err, &magnitude):
+		return &LimitExceededError{Limit: "big number magnitude", Err: err}
+	case errors.As(err, &exponent):
+		return &LimitExceededError{Limit: "big number exponent", Err: err}
+	case errors.As(err, &valueRange) && valueRange.Value != "LEB128 overflow": // a malformed length, not a value
+		return &LossyConversionError{Value: valueRange.Value, Err: err}
+	}
+	return err
+}
+
+// errorOffset returns the offset, from the start of the document, that
+// go-bonjson recorded in err. Its error types each have an Offset field,
+// with no method in common.
+func errorOffset(err error) (int64, bool) {
+	v := reflect.ValueOf(err)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return 0, false
+	}
+	if f := v.Elem().FieldByName("Offset"); f.IsValid() && f.Kind() == reflect.Int64 {
+		return f.Int(), true
+	}
+	return 0, false
+}
+
+// pathFrame is an open container while replaying a document's tokens.
+type pathFrame struct {
+	path      string
+	isObject  bool
+	expectKey bool
+	key       string
+	count     int
+}
+
+// pathAt returns the path of the value of the BONJSON document doc that
+// holds byte offset, or that is open when doc ends if offset is negative,
+// by replaying doc's tokens. It returns "" if the tokens cannot be
+// replayed as far as offset, as for a record defined in an earlier
+// document.
+func pathAt(doc []byte, offset int64) string {
+	dec := bonjson.NewDecoder(strings.NewReader(string(doc)))
+	var stack []*pathFrame
+	slot := func() string {
+		if len(stack) == 0 {
+			return "$"
+		}
+		top := stack[len(stack)-1]
+		switch {
+		case !top.isObject:
+			return fmt.Sprintf("%s[%d]", top.path, top.count)
+		case top.expectKey:
+			return top.path
+		}
+		return top.path + keySegment(top.key)
+	}
+	advance := func() {
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+			top.count++
+			top.expectKey = top.isObject
+		}
+	}
+	for {
+		at := slot()
+		tok, err := dec.Token()
+		if err != nil {
+			if offset < 0 || dec.InputOffset() > offset {
+				return at
+			}
+			return ""
+		}
+		if offset >= 0 && dec.InputOffset() > offset {
+			return at
+		}
+		switch tok := tok.(type) {
+		case bonjson.Delim:
+			switch tok {
+			case '[', '{':
+				stack = append(stack, &pathFrame{path: at, isObject: tok == '{', expectKey: tok == '{'})
+				continue
+			}
+			stack = stack[:len(stack)-1]
+		case string:
+			if len(stack) > 0 && stack[len(stack)-1].expectKey {
+				top := stack[len(stack)-1]
+				top.key, top.expectKey = tok, false
+				continue
+			}
+		}
+		if len(stack) == 0 {
+			return ""
+		}
+		advance()
+	}
+}
+
+// keySegment returns the path segment of an object member: .key, or
+// ["key"] for a key that would be ambiguous after a dot.
+func keySegment(key string) string {
+	if key == "" || strings.ContainsAny(key, ".[]\"") {
+		quoted, _ := json.Marshal(key)
+		return "[" + string(quoted) + "]"
+	}
+	return "." + key
+}
@@ -0,0 +1,126 @@
+// ABOUTME: Tests for codec's error types and the paths decode errors carry.
+// ABOUTME: Replays encoded documents through pathAt at known offsets, including documents cut short.
+
+package codec
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// pathDocument is {"a": [1, 2, {"b": "xyz"}]}, which encodes as:
+//
+//	0 {   1-2 "a"   3 [   4 1   5 2   6 {   7-8 "b"   9-12 "xyz"   13 }   14 ]   15 }
+var pathDocument = map[string]any{"a": []any{1, 2, map[string]any{"b": "xyz"}}}
+
+func TestPathAt(t *testing.T) {
+	doc := encodeBONJSON(t, pathDocument)
+	if len(doc) != 16 {
+		t.Fatalf("document encodes as %d bytes, want the 16 the offsets below assume", len(doc))
+	}
+	for offset, want := range map[int64]string{
+		0:  "$",
+		1:  "$",
+		3:  "$.a",
+		4:  "$.a[0]",
+		5:  "$.a[1]",
+		6:  "$.a[2]",
+		7:  "$.a[2]",
+		9:  "$.a[2].b",
+		12: "$.a[2].b",
+		13: "$.a[2]",
+		15: "$",
+	} {
+		if got := pathAt(doc, offset); got != want {
+			t.Errorf("offset %d: got %q, want %q", offset, got, want)
+		}
+	}
+}
+
+func TestPathAtEnd(t *testing.T) {
+	doc := encodeBONJSON(t, pathDocument)
+	for length, want := range map[int]string{
+		1:  "$",
+		3:  "$.a",
+		5:  "$.a[1]",
+		6:  "$.a[2]",
+		10: "$.a[2].b",
+		13: "$.a[2]",
+	} {
+		if got := pathAt(doc[:length], -1); got != want {
+			t.Errorf("cut to %d bytes: got %q, want %q", length, got, want)
+		}
+	}
+}
+
+func TestPathAtPastEnd(t *testing.T) {
+	doc := encodeBONJSON(t, pathDocument)
+	if got := pathAt(doc[:5], 10); got != "" {
+		t.Errorf("got %q for an offset past the end, want \"\"", got)
+	}
+}
+
+func TestKeySegment(t *testing.T) {
+	for key, want := range map[string]string{
+		"a":     ".a",
+		"snake": ".snake",
+		"a.b":   `["a.b"]`,
+		"a[0]":  `["a[0]"]`,
+		`q"`:    `["q\""]`,
+		"":      `[""]`,
+	} {
+		if got := keySegment(key); got != want {
+			t.Errorf("keySegment(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	err := &DecodeError{Format: BONJSON, Document: 2, Offset: 40, Path: "$.a[1]", Err: io.ErrUnexpectedEOF}
+	if got, want := err.Error(), "codec: document 2 at offset 40 ($.a[1]): unexpected EOF"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error("does not unwrap to its cause")
+	}
+	err.Path = ""
+	if got, want := err.Error(), "codec: document 2 at offset 40: unexpected EOF"; got != want {
+		t.Errorf("without a path: got %q, want %q", got, want)
+	}
+}
+
+func TestErrorTypes(t *testing.T) {
+	cause := errors.New("cause")
+	limit := &LimitExceededError{Limit: "depth", Err: cause}
+	if got, want := limit.Error(), "depth limit exceeded: cause"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	lossy := &LossyConversionError{Value: "1e999", Err: cause}
+	if got, want := lossy.Error(), "cannot convert 1e999 without loss: cause"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, err := range []error{limit, lossy} {
+		if !errors.Is(err, cause) {
+			t.Errorf("%T does not unwrap to its cause", err)
+		}
+	}
+	if classify(cause) != cause {
+		t.Error("classify changed an error that is not go-bonjson's")
+	}
+}
+
+func TestErrorOffset(t *testing.T) {
+	if _, ok := errorOffset(errors.New("no offset")); ok {
+		t.Error("found an offset in an error without one")
+	}
+	if _, ok := errorOffset(io.EOF); ok {
+		t.Error("found an offset in io.EOF")
+	}
+	if offset, ok := errorOffset(&struct {
+		error
+		Offset int64
+	}{Offset: 7}); !ok || offset != 7 {
+		t.Errorf("got %d, %v, want 7", offset, ok)
+	}
+}
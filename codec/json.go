@@ -0,0 +1,367 @@
+// ABOUTME: Decoding of JSON document streams, reporting what encoding/json would silently change as warnings.
+// ABOUTME: Rounded numbers, dropped duplicate keys, and text replaced with U+FFFD go to Options.OnWarning.
+
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxJSONDepth is the deepest nesting of JSON containers decoded, as with
+// encoding/json.
+const maxJSONDepth = 10000
+
+// Options configures decoding. The zero Options decodes as the package's
+// functions do.
+type Options struct {
+	// OnWarning, if not nil, is called for each fidelity issue found while
+	// decoding: a value that was decoded, but not exactly as written.
+	OnWarning func(Warning)
+	// UseNumber, if true, decodes JSON numbers as json.Number, exactly as
+	// written, rather than as float64, as json.Decoder.UseNumber does. No
+	// number is then rounded or refused.
+	UseNumber bool
+}
+
+// Warning is a fidelity issue: a value that decoding changed rather than
+// rejected.
+type Warning struct {
+	Document int    // index of the document in the stream
+	Offset   int64  // byte offset in the stream just past the value
+	Path     string // path of the value, such as $.a[1]
+	Message  string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("document %d at offset %d (%s): %s", w.Document, w.Offset, w.Path, w.Message)
+}
+
+// UnmarshalJSONEach decodes the whitespace-separated JSON documents (such as
+// NDJSON) read from r one at a time, passing each to fn, as encoding/json
+// decodes into an any. It stops at the end of r, at the first document that
+// fails to decode, which it returns as a *DecodeError, or when fn returns an
+// error, which it returns.
+func UnmarshalJSONEach(r io.Reader, fn func(doc any) error) error {
+	return (&Options{}).UnmarshalJSONEach(r, fn)
+}
+
+// UnmarshalJSONEach is the package's UnmarshalJSONEach, calling o.OnWarning
+// for each number rounded to a float64, each duplicate key whose earlier
+// value is dropped, and each string in which invalid UTF-8 or an unpaired
+// surrogate is replaced with U+FFFD. A number beyond float64's range is
+// refused with a *LossyConversionError.
+//
+// BONJSON decoding has no such warnings: it represents every value exactly,
+// holding numbers too large or precise for float64 in *big.Int or
+// *big.Float.
+func (o *Options) UnmarshalJSONEach(r io.Reader, fn func(doc any) error) error {
+	rec := &recorder{r: r}
+	dec := json.NewDecoder(rec)
+	if o.UseNumber {
+		dec.UseNumber()
+	}
+	for document := 0; ; document++ {
+		start := dec.InputOffset()
+		rec.forget(start)
+		var doc any
+		if err := dec.Decode(&doc); err == io.EOF {
+			return nil
+		} else if err != nil {
+			// Replay the document token by token, for the path and offset
+			// of the failure.
+			replay := io.MultiReader(bytes.NewReader(rec.buf), rec.r)
+			if _, replayErr := o.decodeTokens(replay, start, document); replayErr != nil {
+				return replayErr
+			}
+			return &DecodeError{Format: JSON, Document: document, Offset: start, Err: err}
+		}
+		if o.OnWarning != nil && !quiet(doc, rec.buf[:dec.InputOffset()-start], !o.UseNumber) {
+			// Decode it again token by token, to warn of what it changed.
+			var err error
+			if doc, err = o.decodeTokens(bytes.NewReader(rec.buf), start, document); err != nil {
+				return err
+			}
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
+
+// quiet reports whether decoding the JSON text raw into doc cannot have
+// needed a warning: raw is valid UTF-8 without surrogate escapes, none of
+// its numbers, if converted, was rounded, and none of its keys was a
+// duplicate, which encoding/json drops without a word. Token-by-token
+// decoding, which tracks paths and checks each value, is left to the
+// documents that are not quiet.
+func quiet(doc any, raw []byte, numbers bool) bool {
+	if !utf8.Valid(raw) {
+		return false
+	}
+	members := 0
+	for i := 0; i < len(raw); i++ {
+		switch c := raw[i]; {
+		case c == '"':
+			for i++; raw[i] != '"'; i++ {
+				if raw[i] != '\\' {
+					continue
+				}
+				i++
+				if raw[i] == 'u' && (raw[i+1] == 'd' || raw[i+1] == 'D') && !strings.ContainsRune("01234567", rune(raw[i+2])) {
+					return false
+				}
+			}
+		case c == ':':
+			members++
+		case numbers && (c == '-' || c >= '0' && c <= '9'):
+			end := i
+			for end < len(raw) && strings.IndexByte("+-.0123456789eE", raw[end]) >= 0 {
+				end++
+			}
+			if !quietNumber(raw[i:end]) {
+				return false
+			}
+			i = end - 1
+		}
+	}
+	return countMembers(doc) == members
+}
+
+// quietNumber reports whether the JSON number literal converts to a float64
+// of the same value. Most have at most the 15 significant digits that
+// always survive, well inside float64's range; the rest are converted.
+func quietNumber(literal []byte) bool {
+	digits, magnitude, ok := decimal(string(literal))
+	if ok && (len(digits) == 0 || len(digits) <= 15 && magnitude > -300 && magnitude < 300) {
+		return true
+	}
+	f, err := strconv.ParseFloat(string(literal), 64)
+	return err == nil && exactFloat(string(literal), f)
+}
+
+// exactFloat reports whether f, converted from the number literal, has the
+// same value. Decimal fractions like 0.1 are never exact in binary, so f
+// only differs if its shortest form reads as a different number.
+func exactFloat(literal string, f float64) bool {
+	digits, magnitude, ok := decimal(literal)
+	if !ok {
+		// An exponent too long to parse underflowed to zero, which is
+		// only exact if the literal was zero.
+		return len(digits) == 0
+	}
+	shortest, shortestMagnitude, _ := decimal(strconv.FormatFloat(f, 'e', -1, 64))
+	return digits == shortest && (len(digits) == 0 || magnitude == shortestMagnitude)
+}
+
+// decimal returns the significant digits of the number literal, without
+// leading or trailing zeros, and the power of ten that scales 0.digits to
+// its magnitude. ok is false if the exponent is too long to parse.
+func decimal(literal string) (digits string, magnitude int, ok bool) {
+	mantissa, exponent, _ := strings.Cut(strings.ToLower(literal), "e")
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(mantissa, "-"), ".")
+	digits = strings.TrimLeft(whole+fraction, "0")
+	magnitude = len(whole) - (len(whole+fraction) - len(digits))
+	digits = strings.TrimRight(digits, "0")
+	if exponent == "" {
+		return digits, magnitude, true
+	}
+	exp, err := strconv.Atoi(exponent)
+	if err != nil || exp > 1<<30 || exp < -1<<30 {
+		return digits, 0, false
+	}
+	return digits, magnitude + exp, true
+}
+
+// countMembers returns the number of object members within v.
+func countMembers(v any) int {
+	n := 0
+	switch v := v.(type) {
+	case map[string]any:
+		n += len(v)
+		for _, member := range v {
+			n += countMembers(member)
+		}
+	case []any:
+		for _, elem := range v {
+			n += countMembers(elem)
+		}
+	}
+	return n
+}
+
+// decodeTokens decodes one document read from r, which starts at offset
+// start of the stream, token by token, warning of each value it changes.
+func (o *Options) decodeTokens(r io.Reader, start int64, document int) (any, error) {
+	rec := &recorder{r: r}
+	d := &jsonDecoder{opts: o, rec: rec, dec: json.NewDecoder(rec), document: document, start: start}
+	d.dec.UseNumber()
+	return d.value(0)
+}
+
+// jsonDecoder builds documents from the tokens of a JSON stream.
+type jsonDecoder struct {
+	opts     *Options
+	rec      *recorder
+	dec      *json.Decoder
+	document int
+	start    int64         // offset in the stream of the decoder's input
+	path     []jsonSegment // of the value being decoded
+}
+
+// jsonSegment is a step of a path: an object member's key, or an array
+// index. Paths are only formatted for warnings and errors.
+type jsonSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// pathString returns the path of the value being decoded, such as $.a[1].
+func (d *jsonDecoder) pathString() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, seg := range d.path {
+		if seg.isIndex {
+			fmt.Fprintf(&b, "[%d]", seg.index)
+		} else {
+			b.WriteString(keySegment(seg.key))
+		}
+	}
+	return b.String()
+}
+
+// token returns the next token and the input bytes it was read from.
+func (d *jsonDecoder) token() (json.Token, []byte, error) {
+	from := d.dec.InputOffset()
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	return tok, d.rec.buf[from-d.rec.base : d.dec.InputOffset()-d.rec.base], nil
+}
+
+// decodeError returns the *DecodeError for err, from reading the value
+// being decoded.
+func (d *jsonDecoder) decodeError(err error) *DecodeError {
+	e := &DecodeError{Format: JSON, Document: d.document, Offset: d.start + d.dec.InputOffset(), Path: d.pathString(), Err: err}
+	var syntax *json.SyntaxError
+	switch {
+	case errors.As(err, &syntax):
+		e.Offset = d.start + syntax.Offset
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		e.Offset = d.start + d.rec.base + int64(len(d.rec.buf))
+		e.Err = io.ErrUnexpectedEOF
+	}
+	return e
+}
+
+func (d *jsonDecoder) warn(format string, args ...any) {
+	if d.opts.OnWarning != nil {
+		d.opts.OnWarning(Warning{Document: d.document, Offset: d.start + d.dec.InputOffset(), Path: d.pathString(), Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// value decodes the value at d.path, inside depth containers.
+func (d *jsonDecoder) value(depth int) (any, error) {
+	tok, raw, err := d.token()
+	if err != nil {
+		return nil, d.decodeError(err)
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if depth == maxJSONDepth {
+			return nil, d.decodeError(&LimitExceededError{Limit: "depth", Err: fmt.Errorf("more than %d nested containers", maxJSONDepth)})
+		}
+		if tok == '[' {
+			return d.array(depth + 1)
+		}
+		return d.object(depth + 1)
+	case json.Number:
+		return d.number(tok.String())
+	case string:
+		d.checkReplaced(tok, raw)
+		return tok, nil
+	}
+	return tok, nil
+}
+
+func (d *jsonDecoder) array(depth int) (any, error) {
+	values := []any{}
+	for d.dec.More() {
+		d.path = append(d.path, jsonSegment{index: len(values), isIndex: true})
+		value, err := d.value(depth)
+		if err != nil {
+			return nil, err
+		}
+		d.path = d.path[:len(d.path)-1]
+		values = append(values, value)
+	}
+	if _, _, err := d.token(); err != nil {
+		return nil, d.decodeError(err)
+	}
+	return values, nil
+}
+
+func (d *jsonDecoder) object(depth int) (any, error) {
+	members := map[string]any{}
+	for d.dec.More() {
+		tok, raw, err := d.token()
+		if err != nil {
+			return nil, d.decodeError(err)
+		}
+		key := tok.(string)
+		d.checkReplaced(key, raw)
+		d.path = append(d.path, jsonSegment{key: key})
+		value, err := d.value(depth)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := members[key]; ok {
+			d.warn("duplicate key %q: earlier value dropped", key)
+		}
+		d.path = d.path[:len(d.path)-1]
+		members[key] = value
+	}
+	if _, _, err := d.token(); err != nil {
+		return nil, d.decodeError(err)
+	}
+	return members, nil
+}
+
+// number converts the number literal at d.path to a float64, warning if
+// that changes it. With UseNumber, the literal is kept as it is.
+func (d *jsonDecoder) number(literal string) (any, error) {
+	if d.opts.UseNumber {
+		return json.Number(literal), nil
+	}
+	f, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return nil, d.decodeError(&LossyConversionError{Value: literal, Err: err})
+	}
+	if !exactFloat(literal, f) {
+		d.warn("number %s rounded to %s", literal, strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return f, nil
+}
+
+// checkReplaced warns if the string s, read from raw, has more U+FFFD
+// characters than raw writes, literally or escaped: the rest are
+// encoding/json's replacements for invalid UTF-8 and unpaired surrogate
+// escapes.
+func (d *jsonDecoder) checkReplaced(s string, raw []byte) {
+	replaced := strings.Count(s, "\uFFFD")
+	if replaced == 0 {
+		return
+	}
+	written := bytes.Count(raw, []byte("\uFFFD")) + bytes.Count(bytes.ToLower(raw), []byte(`\ufffd`))
+	if replaced > written {
+		d.warn("invalid UTF-8 or unpaired surrogate replaced with U+FFFD")
+	}
+}
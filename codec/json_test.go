@@ -0,0 +1,97 @@
+// ABOUTME: Tests for decoding JSON document streams, and the warnings of values that decoding changes.
+// ABOUTME: Covers documents that need no warning, each kind of warning, and the errors of invalid documents.
+
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeJSON decodes the JSON stream input, returning its documents and the
+// messages of its warnings, formatted.
+func decodeJSON(t *testing.T, input string, opts Options) ([]any, []string, error) {
+	t.Helper()
+	var docs []any
+	var warnings []string
+	opts.OnWarning = func(w Warning) { warnings = append(warnings, w.String()) }
+	err := opts.UnmarshalJSONEach(strings.NewReader(input), func(doc any) error {
+		docs = append(docs, doc)
+		return nil
+	})
+	return docs, warnings, err
+}
+
+func TestUnmarshalJSONEachQuiet(t *testing.T) {
+	input := `{"a": [1, 0.1, -2.5e-3, 134.36424411240122, 1e300, 0e99999999999999999999], "b": "é😀"} "x"`
+	docs, warnings, err := decodeJSON(t, input, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("got warnings %q, want none", warnings)
+	}
+	want := []any{map[string]any{"a": []any{1.0, 0.1, -2.5e-3, 134.36424411240122, 1e300, 0.0}, "b": "é😀"}, "x"}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("got %v, want %v", docs, want)
+	}
+}
+
+func TestUnmarshalJSONEachWarnings(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{"a": 1, "a": 2}`, `document 0 at offset 15 ($.a): duplicate key "a": earlier value dropped`},
+		{`[1, {"x": "\ud800"}]`, `document 0 at offset 18 ($[1].x): invalid UTF-8 or unpaired surrogate replaced with U+FFFD`},
+		{"{\"a\": \"\xff\"}", `document 0 at offset 9 ($.a): invalid UTF-8 or unpaired surrogate replaced with U+FFFD`},
+		{`{"n": 0.1000000000000000055511151231257827}`, `document 0 at offset 42 ($.n): number 0.1000000000000000055511151231257827 rounded to 0.1`},
+		{`[12345678901234567890]`, `document 0 at offset 21 ($[0]): number 12345678901234567890 rounded to 1.2345678901234567e+19`},
+		{`[1e-99999999999999999999]`, `document 0 at offset 24 ($[0]): number 1e-99999999999999999999 rounded to 0`},
+	}
+	for _, test := range tests {
+		_, warnings, err := decodeJSON(t, test.input, Options{})
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+		} else if len(warnings) != 1 || warnings[0] != test.want {
+			t.Errorf("%s: got warnings %q, want %q", test.input, warnings, test.want)
+		}
+	}
+}
+
+func TestUnmarshalJSONEachUseNumber(t *testing.T) {
+	docs, warnings, err := decodeJSON(t, `[12345678901234567890, 1e400]`, Options{UseNumber: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("got warnings %q, want none", warnings)
+	}
+	want := []any{[]any{json.Number("12345678901234567890"), json.Number("1e400")}}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("got %v, want %v", docs, want)
+	}
+}
+
+func TestUnmarshalJSONEachErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{"a": [1, {"b": tru}]}`, `codec: document 0 at offset 20 ($.a[1].b): invalid character '}' in literal true (expecting 'e')`},
+		{`{"a": 1} {"b": [1e400]}`, `codec: document 1 at offset 21 ($.b[0]): cannot convert 1e400 without loss: strconv.ParseFloat: parsing "1e400": value out of range`},
+		{`{"a": [1, 2`, `codec: document 0 at offset 11 ($.a[2]): unexpected end of JSON input`},
+	}
+	for _, test := range tests {
+		for _, opts := range []Options{{}, {OnWarning: func(Warning) {}}} {
+			err := opts.UnmarshalJSONEach(strings.NewReader(test.input), func(any) error { return nil })
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) || err.Error() != test.want {
+				t.Errorf("%s: got %v, want %s", test.input, err, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// ABOUTME: The combine command: merges several JSON or BONJSON documents into one.
+// ABOUTME: Used to consolidate per-environment configuration fragments into a single artifact.
+
+package main
+
+import "fmt"
+
+// runCombine merges the documents in inputPaths, each JSON or BONJSON by file
+// extension, in order using opts.mergeStrategy, and writes the result to
+// opts.outFile, or to stdout as JSON if it is not set.
+func runCombine(inputPaths []string, opts *options) error {
+	docs := make([]any, len(inputPaths))
+	for i, inputPath := range inputPaths {
+		doc, err := loadDocument(inputPath)
+		if err != nil {
+			return fmt.Errorf("reading input: %w", err)
+		}
+		docs[i] = doc
+	}
+	combined, err := combineDocuments(docs, opts.mergeStrategy, opts.nullsAsAbsent)
+	if err != nil {
+		return err
+	}
+	return writeDocument(combined, opts.outFile, opts)
+}
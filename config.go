@@ -0,0 +1,116 @@
+// ABOUTME: The config file: default options read before those on the command line.
+// ABOUTME: Lets a user settle choices such as --prefer once instead of on every invocation.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// configOptions are the options a config file may set: defaults that take
+// one value, which the command line's replaces. Options that change what a
+// conversion writes are left to the command line, so that no config file
+// can silently change, say, a git filter's output.
+var configOptions = []string{"--prefer", "--detect-budget"}
+
+// configPath returns where the config file is: $BONBON_CONFIG if set, and
+// otherwise bonbon/config in the user's configuration directory (such as
+// ~/.config on Linux). It returns "" if there is nowhere to look.
+func configPath() string {
+	if path, ok := os.LookupEnv("BONBON_CONFIG"); ok {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bonbon", "config")
+}
+
+// configArgs returns the options of the config file, as arguments to parse
+// before the command line's, so that those on the command line win. Each
+// line holds one of configOptions and its argument, after the first run of
+// spaces; blank lines and lines starting with # are ignored. A missing
+// config file has no options.
+func configArgs() ([]string, error) {
+	path := configPath()
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	defer f.Close()
+
+	var args []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		option, value, hasValue := strings.Cut(text, " ")
+		if !strings.HasPrefix(option, "-") || option == "-" || option == "--" {
+			return nil, fmt.Errorf("config %s line %d: expected an option, such as --prefer bonjson", path, line)
+		}
+		if !slices.Contains(configOptions, option) {
+			return nil, fmt.Errorf("config %s line %d: %s cannot be set in the config file (only %s)", path, line, option, strings.Join(configOptions, ", "))
+		}
+		if !hasValue || strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("config %s line %d: %s requires an argument", path, line, option)
+		}
+		args = append(args, option, strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	return args, nil
+}
+
+// reloadConfig reads the config file again and sets its options on opts,
+// leaving alone those the command line gives. An option no longer in the
+// config file goes back to its default.
+func (opts *options) reloadConfig() error {
+	args, err := configArgs()
+	if err != nil {
+		return err
+	}
+	given := opts.optionArgs[opts.configOptionCount:]
+	values := make(map[string]string)
+	for i := 0; i < len(args); i += 2 {
+		values[args[i]] = args[i+1]
+	}
+	for _, option := range configOptions {
+		if slices.ContainsFunc(given, func(o []string) bool { return o[0] == option }) {
+			continue
+		}
+		value := values[option]
+		switch option {
+		case "--prefer":
+			if value != "" && !slices.Contains(preferFormats, value) {
+				return fmt.Errorf("config: invalid preferred format: %s (expected %s)", value, strings.Join(preferFormats, " or "))
+			}
+			opts.prefer = value
+		case "--detect-budget":
+			budget := int64(0)
+			if value != "" {
+				if budget, err = parseSize(value); err != nil || budget <= 0 {
+					return fmt.Errorf("config: invalid size: %s", value)
+				}
+			}
+			opts.detectBudget = budget
+		}
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+// ABOUTME: The bonbon container format: many BONJSON documents plus a trailing index.
+// ABOUTME: Implements the container command (build, list, get, compact) with random access by document number.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/kstenerud/go-bonjson"
+)
+
+// A container file is laid out as:
+//
+//	document 0 .. document N-1   BONJSON documents, back to back
+//	index                        a BONJSON-encoded containerIndex
+//	footer                       containerMagic, then the index offset as a
+//	                             little-endian uint64
+//
+// The footer has a fixed size, so a reader finds the index with two reads
+// and can then read any document without scanning the others.
+const containerMagic = "BONBONIX"
+
+const containerFooterSize = len(containerMagic) + 8
+
+// containerIndex locates the documents in a container. SHA256 holds the hex
+// digest of each document when the container was built with --hashes.
+type containerIndex struct {
+	Version int      `json:"version"`
+	Offsets []int64  `json:"offsets"`
+	Lengths []int64  `json:"lengths"`
+	SHA256  []string `json:"sha256,omitempty"`
+}
+
+// runContainer implements the container subcommands:
+//
+//	container build INPUT OUTPUT   pack a document stream into a container
+//	container list CONTAINER       list the documents in a container
+//	container get CONTAINER N [OUTPUT]
+//	                               extract document N
+//	container update CONTAINER INPUT
+//	                               re-encode only what changed (reconvert.go)
+//	container compact CONTAINER    reclaim bytes of replaced documents
+func runContainer(args []string, opts *options) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: container build|list|get|update|compact CONTAINER ...")
+	}
+	switch args[0] {
+	case "build":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: container build INPUT OUTPUT")
+		}
+		return buildContainer(args[1], args[2], opts)
+	case "list":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: container list CONTAINER")
+		}
+		return listContainer(args[1])
+	case "get":
+		if len(args) < 3 || len(args) > 4 {
+			return fmt.Errorf("usage: container get CONTAINER N [OUTPUT]")
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid document number: %s", args[2])
+		}
+		outputPath := ""
+		if len(args) == 4 {
+			outputPath = args[3]
+		}
+		return getContainerDocument(args[1], n, outputPath, opts)
+	case "update":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: container update CONTAINER INPUT")
+		}
+		return updateContainer(args[1], args[2], opts)
+	case "compact":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: container compact CONTAINER")
+		}
+		return compactContainer(args[1])
+	default:
+		return fmt.Errorf("unknown container command: %s (expected build, list, get, update, or compact)", args[0])
+	}
+}
+
+// buildContainer packs the documents of inputPath into a container at
+// outputPath. BONJSON input (*.boj, *.bonjson) is copied byte for byte; any
+// other input is read as a JSON document stream and encoded.
+func buildContainer(inputPath, outputPath string, opts *options) error {
+	var data []byte
+	var err error
+	if inputPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(inputPath)
+	}
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	var docs [][]byte
+	if isBONJSONPath(inputPath) {
+		if docs, err = splitBONJSONDocuments(data, opts); err != nil {
+			return err
+		}
+	} else {
+		streamed := *opts
+		streamed.stream = true
+		values, err := decodeJSON(data, &streamed)
+		if err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+		if docs, err = encodeDocuments(values, false, &streamed); err != nil {
+			return err
+		}
+	}
+
+	index := containerIndex{Version: 1}
+	if opts.containerHashes {
+		index.SHA256 = []string{}
+	}
+	output, err := appendContainerDocuments(nil, &index, 0, docs)
+	if err != nil {
+		return err
+	}
+	return writeOutput(output, outputPath, false)
+}
+
+// appendContainerDocuments appends docs to buf, which is to be written at
+// offset dataEnd of a container whose documents end there, and records them
+// in index. It then appends the updated index and the footer. Digests are
+// recorded if the index already holds them (a non-nil SHA256).
+func appendContainerDocuments(buf []byte, index *containerIndex, dataEnd int64, docs [][]byte) ([]byte, error) {
+	offset := dataEnd
+	for _, doc := range docs {
+		index.Offsets = append(index.Offsets, offset)
+		index.Lengths = append(index.Lengths, int64(len(doc)))
+		if index.SHA256 != nil {
+			index.SHA256 = append(index.SHA256, documentDigest(doc))
+		}
+		buf = append(buf, doc...)
+		offset += int64(len(doc))
+	}
+	return appendContainerIndex(buf, index, offset)
+}
+
+// appendContainerIndex appends the encoded index, which starts at
+// indexOffset, and the footer to buf.
+func appendContainerIndex(buf []byte, index *containerIndex, indexOffset int64) ([]byte, error) {
+	encodedIndex, err := bonjson.Marshal(index)
+	if err != nil {
+		return nil, fmt.Errorf("encoding container index: %w", err)
+	}
+	buf = append(buf, encodedIndex...)
+	buf = append(buf, containerMagic...)
+	return binary.LittleEndian.AppendUint64(buf, uint64(indexOffset)), nil
+}
+
+// documentDigest returns the hex SHA-256 digest of an encoded document.
+func documentDigest(doc []byte) string {
+	sum := sha256.Sum256(doc)
+	return hex.EncodeToString(sum[:])
+}
+
+// splitBONJSONDocuments returns the encoded bytes of each document in a
+// BONJSON stream, validating them as it goes.
+func splitBONJSONDocuments(data []byte, opts *options) ([][]byte, error) {
+	var docs [][]byte
+	dec := newBONJSONDecoder(bytes.NewReader(data), opts)
+	start := int64(0)
+	for start < int64(len(data)) {
+		var value any
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("invalid BONJSON: document %d: %w", len(docs), err)
+		}
+		end := dec.InputOffset()
+		docs = append(docs, data[start:end])
+		start = end
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("input is empty")
+	}
+	return docs, nil
+}
+
+// openContainer opens a container and reads its index.
+func openContainer(filename string) (*os.File, containerIndex, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, containerIndex{}, fmt.Errorf("opening container: %w", err)
+	}
+	index, _, err := readContainerIndex(f, filename)
+	if err != nil {
+		f.Close()
+		return nil, containerIndex{}, err
+	}
+	return f, index, nil
+}
+
+// errNotContainer reports a file without a container footer.
+var errNotContainer = errors.New("not a bonbon container")
+
+// readContainerIndex reads the index of the container in f, and returns it
+// along with its offset, which is where the documents end. A file without a
+// container footer gives an error wrapping errNotContainer.
+func readContainerIndex(f *os.File, filename string) (containerIndex, int64, error) {
+	var index containerIndex
+	info, err := f.Stat()
+	if err != nil {
+		return index, 0, fmt.Errorf("opening container: %w", err)
+	}
+
+	footer := make([]byte, containerFooterSize)
+	if info.Size() < int64(containerFooterSize) {
+		return index, 0, fmt.Errorf("%s: %w (too short)", filename, errNotContainer)
+	}
+	if _, err := f.ReadAt(footer, info.Size()-int64(containerFooterSize)); err != nil {
+		return index, 0, fmt.Errorf("reading container footer: %w", err)
+	}
+	if string(footer[:len(containerMagic)]) != containerMagic {
+		return index, 0, fmt.Errorf("%s: %w (missing footer)", filename, errNotContainer)
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(footer[len(containerMagic):]))
+	indexEnd := info.Size() - int64(containerFooterSize)
+	if indexOffset < 0 || indexOffset > indexEnd {
+		return index, 0, fmt.Errorf("%s: corrupt container footer", filename)
+	}
+
+	encoded := make([]byte, indexEnd-indexOffset)
+	if _, err := f.ReadAt(encoded, indexOffset); err != nil {
+		return index, 0, fmt.Errorf("reading container index: %w", err)
+	}
+	if err := bonjson.Unmarshal(encoded, &index); err != nil {
+		return index, 0, fmt.Errorf("%s: corrupt container index: %w", filename, err)
+	}
+	if len(index.Lengths) != len(index.Offsets) || (index.SHA256 != nil && len(index.SHA256) != len(index.Offsets)) {
+		return index, 0, fmt.Errorf("%s: corrupt container index", filename)
+	}
+	for i, offset := range index.Offsets {
+		if offset < 0 || index.Lengths[i] < 0 || offset+index.Lengths[i] > indexOffset {
+			return index, 0, fmt.Errorf("%s: corrupt container index entry %d", filename, i)
+		}
+	}
+	return index, indexOffset, nil
+}
+
+// listContainer prints the number, offset, length, and digest (if recorded)
+// of every document in a container.
+func listContainer(filename string) error {
+	f, index, err := openContainer(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Printf("documents: %d\n\n", len(index.Offsets))
+	fmt.Printf("%8s %14s %10s", "document", "offset", "length")
+	if index.SHA256 != nil {
+		fmt.Printf("  %s", "sha256")
+	}
+	fmt.Println()
+	for i, offset := range index.Offsets {
+		fmt.Printf("%8d %14d %10d", i, offset, index.Lengths[i])
+		if index.SHA256 != nil {
+			fmt.Printf("  %s", index.SHA256[i])
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// getContainerDocument reads document n of a container, verifying its digest
+// if one was recorded, and writes it to outputPath: as BONJSON if the name is
+// *.boj or *.bonjson, and as JSON otherwise. An empty outputPath is stdout.
+func getContainerDocument(filename string, n int, outputPath string, opts *options) error {
+	f, index, err := openContainer(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if n >= len(index.Offsets) {
+		return fmt.Errorf("document %d out of range (container has %d)", n, len(index.Offsets))
+	}
+	doc, err := readContainerDocument(f, index, n)
+	if err != nil {
+		return err
+	}
+
+	if isBONJSONPath(outputPath) {
+		return writeOutput(doc, outputPath, false)
+	}
+	docs, _, err := decodeBONJSON(doc, opts)
+	if err != nil {
+		return fmt.Errorf("document %d: invalid BONJSON: %w", n, err)
+	}
+	output, err := encodeDocument(docs[0], true, opts)
+	if err != nil {
+		return err
+	}
+	return writeOutput(output, outputPath, true)
+}
+
+// readContainerDocument reads the encoded bytes of document n.
+func readContainerDocument(f *os.File, index containerIndex, n int) ([]byte, error) {
+	doc := make([]byte, index.Lengths[n])
+	if _, err := f.ReadAt(doc, index.Offsets[n]); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading document %d: %w", n, err)
+	}
+	if index.SHA256 != nil {
+		if documentDigest(doc) != index.SHA256[n] {
+			return nil, fmt.Errorf("document %d: checksum mismatch", n)
+		}
+	}
+	return doc, nil
+}
+
+// compactContainer rewrites a container with its documents back to back, in
+// order, dropping the bytes that updates left behind. It prints the number of
+// bytes reclaimed to stderr. The compacted container replaces the old one
+// through a temporary file, so an interrupted compaction leaves it untouched.
+func compactContainer(filename string) error {
+	f, unlock, err := openLocked(filename, os.O_RDONLY)
+	if err != nil {
+		return fmt.Errorf("opening container: %w", err)
+	}
+	defer f.Close()
+	defer unlock()
+	index, indexOffset, err := readContainerIndex(f, filename)
+	if err != nil {
+		return err
+	}
+
+	docs := make([][]byte, len(index.Offsets))
+	for i := range docs {
+		if docs[i], err = readContainerDocument(f, index, i); err != nil {
+			return err
+		}
+	}
+	compacted := containerIndex{Version: index.Version}
+	if index.SHA256 != nil {
+		compacted.SHA256 = []string{}
+	}
+	output, err := appendContainerDocuments(nil, &compacted, 0, docs)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filename, output); err != nil {
+		return fmt.Errorf("compacting %s: %w", filename, err)
+	}
+	used := int64(0)
+	for _, length := range index.Lengths {
+		used += length
+	}
+	fmt.Fprintf(os.Stderr, "reclaimed %d bytes\n", indexOffset-used)
+	return nil
+}
+
+// openLocked opens filename with flag and locks it. A compaction replaces
+// the file while holding the lock on the old one, so a writer that was
+// waiting for that lock would go on to write to a file no longer in place;
+// openLocked reopens the file until the one it locked is the one at
+// filename.
+func openLocked(filename string, flag int) (*os.File, func(), error) {
+	for {
+		f, err := os.OpenFile(filename, flag, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		unlock, err := lockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		locked, err := f.Stat()
+		if err == nil {
+			var current os.FileInfo
+			if current, err = os.Stat(filename); err == nil && os.SameFile(locked, current) {
+				return f, unlock, nil
+			}
+		}
+		unlock()
+		f.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+}
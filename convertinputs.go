@@ -0,0 +1,77 @@
+// ABOUTME: The convert command: converts any number of inputs, files and stdin mixed, into one output.
+// ABOUTME: Documents keep the order of the arguments, and of each input, for ad-hoc aggregation from scripts.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// runConvertInputs decodes every document of each input in inputPaths, in
+// argument order, and writes them all to opts.outFile (stdout, as JSON, if
+// it is not set): as a stream of documents, or with opts.wrapArray as one
+// array of them. An input's format is given by its extension, or detected
+// from its content for stdin ("-", which may appear once) and files without
+// a known one.
+func runConvertInputs(inputPaths []string, opts *options) error {
+	if len(inputPaths) == 0 {
+		return fmt.Errorf("convert requires at least one input")
+	}
+	if option := opts.bufferUnsupported(); option != "" {
+		return fmt.Errorf("convert cannot be combined with %s", option)
+	}
+	streamed := *opts
+	streamed.stream = true
+	readStdin := false
+	var docs []any
+	for _, inputPath := range inputPaths {
+		var data []byte
+		var err error
+		if inputPath == "-" {
+			if readStdin {
+				return fmt.Errorf("stdin (-) can be an input only once")
+			}
+			readStdin = true
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = readFile(inputPath)
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", inputPath, err)
+		}
+
+		f := formatForPath(inputPath)
+		if inputPath == "-" || f == nil || !f.Input {
+			if f, err = detectFormat(data, &streamed); err != nil {
+				return fmt.Errorf("%s: unknown format: %w", inputPath, err)
+			}
+		}
+		if f.Name == "bontext" {
+			if data, err = parseBontext(data); err != nil {
+				return fmt.Errorf("%s: invalid bonjson-text: %w", inputPath, err)
+			}
+		}
+		inputDocs, err := decodeBuffer(data, f.Name == "json", &streamed)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inputPath, err)
+		}
+		docs = append(docs, inputDocs...)
+	}
+
+	outputJSON := !isBONJSONPath(opts.outFile)
+	target := opts.outFile
+	if opts.wrapArray {
+		docs = []any{docs}
+		streamed.stream = false
+	} else if target == "" {
+		// Streamed JSON documents already end in newlines.
+		target = "-"
+	}
+	output, err := encodeBuffer(docs, outputJSON, &streamed)
+	if err != nil {
+		return err
+	}
+	return writeOutput(output, target, outputJSON)
+}
@@ -0,0 +1,184 @@
+// ABOUTME: The cross-check command: decodes BONJSON input with bonbon and with another decoder, and compares the results.
+// ABOUTME: Reports the first place the two decodings differ, to catch implementations reading the spec differently.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// runCrossCheck decodes the BONJSON input at inputPath, and has the command
+// other decode it too: other is split into words, and runs with inputPath
+// as its last argument, or with the input on stdin if inputPath is "-". It
+// must print what it decodes as JSON, a document or a stream of them. The
+// first difference between the two decodings is printed to stdout, and
+// makes the check fail. Input that both decoders reject passes.
+func runCrossCheck(inputPath, other string, opts *options) error {
+	var data []byte
+	var err error
+	if inputPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(inputPath)
+	}
+	if err != nil {
+		return fmt.Errorf("reading input file: %w", err)
+	}
+	streamed := *opts
+	streamed.stream = true
+	ours, _, oursErr := decodeBONJSON(data, &streamed)
+
+	words := strings.Fields(other)
+	if len(words) == 0 {
+		return fmt.Errorf("--other names no command")
+	}
+	cmd := exec.Command(words[0], words[1:]...)
+	if inputPath == "-" {
+		cmd.Stdin = bytes.NewReader(data)
+	} else {
+		cmd.Args = append(cmd.Args, inputPath)
+	}
+	cmd.Stderr = os.Stderr
+	output, theirsErr := cmd.Output()
+	var exitErr *exec.ExitError
+	if theirsErr != nil && !errors.As(theirsErr, &exitErr) {
+		return fmt.Errorf("running %s: %w", words[0], theirsErr)
+	}
+	var theirs []any
+	if theirsErr == nil {
+		dec := json.NewDecoder(bytes.NewReader(output))
+		dec.UseNumber()
+		for {
+			var doc any
+			if err := dec.Decode(&doc); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("reading the output of %s: invalid JSON: %w", words[0], err)
+			}
+			theirs = append(theirs, doc)
+		}
+	}
+
+	switch {
+	case oursErr != nil && theirsErr != nil:
+		return nil
+	case oursErr != nil:
+		fmt.Printf("document %d: bonbon rejects the input (%v), %s accepts it\n", len(ours), oursErr, words[0])
+		return fmt.Errorf("the decoders disagree")
+	case theirsErr != nil:
+		fmt.Printf("%s rejects the input (%v), bonbon accepts it\n", words[0], theirsErr)
+		return fmt.Errorf("the decoders disagree")
+	}
+	for i := range max(len(ours), len(theirs)) {
+		switch {
+		case i >= len(theirs):
+			fmt.Printf("document %d: only bonbon decodes it\n", i)
+		case i >= len(ours):
+			fmt.Printf("document %d: only %s decodes it\n", i, words[0])
+		default:
+			at, difference := firstDivergence(ours[i], theirs[i], nil, words[0])
+			if difference == "" {
+				continue
+			}
+			fmt.Printf("document %d: %s: %s\n", i, at, difference)
+		}
+		return fmt.Errorf("the decoders disagree")
+	}
+	return nil
+}
+
+// firstDivergence returns the path of the first place, in key order, where
+// ours, as bonbon decodes it, and theirs, as the decoder named other prints
+// it with numbers kept as json.Number, differ, and what differs there; or
+// an empty description if they agree. at is their path.
+func firstDivergence(ours, theirs any, at path, other string) (path, string) {
+	switch o := ours.(type) {
+	case map[string]any:
+		t, ok := theirs.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := slices.Collect(maps.Keys(o))
+		for key := range t {
+			if _, ok := o[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			member := append(slices.Clip(at), pathSegment{key: key})
+			ov, inOurs := o[key]
+			tv, inTheirs := t[key]
+			switch {
+			case !inTheirs:
+				return member, "only bonbon decodes this key"
+			case !inOurs:
+				return member, "only " + other + " decodes this key"
+			}
+			if p, difference := firstDivergence(ov, tv, member, other); difference != "" {
+				return p, difference
+			}
+		}
+		return nil, ""
+	case []any:
+		t, ok := theirs.([]any)
+		if !ok {
+			break
+		}
+		for i := range max(len(o), len(t)) {
+			elem := append(slices.Clip(at), pathSegment{index: i, isIndex: true})
+			switch {
+			case i >= len(t):
+				return elem, "only bonbon decodes this element"
+			case i >= len(o):
+				return elem, "only " + other + " decodes this element"
+			}
+			if p, difference := firstDivergence(o[i], t[i], elem, other); difference != "" {
+				return p, difference
+			}
+		}
+		return nil, ""
+	}
+	if sameDecodedValue(ours, theirs) {
+		return nil, ""
+	}
+	oursJSON, _ := json.Marshal(ours)
+	theirsJSON, _ := json.Marshal(theirs)
+	return at, fmt.Sprintf("bonbon decodes %s, %s decodes %s", oursJSON, other, theirsJSON)
+}
+
+// sameDecodedValue reports whether the scalars ours and theirs agree.
+// Numbers compare by exact value; a float also matches digits whose nearest
+// float64 or float32 it is, since decoders print floats with as few digits
+// as their precision needs.
+func sameDecodedValue(ours, theirs any) bool {
+	n, ok := theirs.(json.Number)
+	if !ok {
+		return jsonEqual(ours, theirs)
+	}
+	value, _, ok := exactNumber(ours)
+	if !ok {
+		return false
+	}
+	if digits, ok := new(big.Rat).SetString(string(n)); ok && digits.Cmp(value) == 0 {
+		return true
+	}
+	f, ok := ours.(float64)
+	if !ok {
+		return false
+	}
+	f64, err64 := strconv.ParseFloat(string(n), 64)
+	f32, err32 := strconv.ParseFloat(string(n), 32)
+	return (err64 == nil && f64 == f) || (err32 == nil && float64(float32(f32)) == f)
+}
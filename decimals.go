@@ -0,0 +1,78 @@
+// ABOUTME: The --decimals option: how decimals beyond float64's precision cross into and out of JSON.
+// ABOUTME: With "tag", they are written as {"$decimal": "..."} objects and read back from them, so no digit is lost.
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// decimalModes are the values of --decimals. With "string", the default, a
+// decimal that float64 cannot hold is written to JSON as a plain string.
+var decimalModes = []string{"string", "tag"}
+
+// decimalTag is the key of the object that stands for a decimal with
+// --decimals tag.
+const decimalTag = "$decimal"
+
+// tagDecimals replaces each high-precision decimal within v, which decodes
+// from BONJSON as a *big.Float, with a {"$decimal": "..."} object holding
+// its digits, for JSON output.
+func tagDecimals(v any) any {
+	switch v := v.(type) {
+	case *big.Float:
+		if !v.IsInf() {
+			return map[string]any{decimalTag: v.Text('g', -1)}
+		}
+	case map[string]any:
+		for key, member := range v {
+			v[key] = tagDecimals(member)
+		}
+	case []any:
+		for i, elem := range v {
+			v[i] = tagDecimals(elem)
+		}
+	}
+	return v
+}
+
+// untagDecimals replaces each {"$decimal": "..."} object within v with the
+// decimal it holds, as a *big.Float precise enough to keep every digit, so
+// that it encodes as a BONJSON big number. An object with other keys beside
+// "$decimal" is left alone; one whose "$decimal" is not a decimal number is
+// an error. at is v's path, for errors.
+func untagDecimals(v any, at path) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		if digits, ok := v[decimalTag]; ok && len(v) == 1 {
+			s, ok := digits.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s must be a string", at, decimalTag)
+			}
+			// Four bits per digit, and a margin, keep every digit through
+			// the shortest decimal that round-trips.
+			f, _, err := big.ParseFloat(s, 10, uint(len(s))*4+64, big.ToNearestEven)
+			if err != nil || f.IsInf() {
+				return nil, fmt.Errorf("%s: invalid %s %q", at, decimalTag, s)
+			}
+			return f, nil
+		}
+		for key, member := range v {
+			untagged, err := untagDecimals(member, append(at, pathSegment{key: key}))
+			if err != nil {
+				return nil, err
+			}
+			v[key] = untagged
+		}
+	case []any:
+		for i, elem := range v {
+			untagged, err := untagDecimals(elem, append(at, pathSegment{index: i, isIndex: true}))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = untagged
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,117 @@
+// ABOUTME: The describe command: identifies the value that encloses a byte offset of a BONJSON file.
+// ABOUTME: Reports its path, type, extent, and decoded value, as a backend for editor hover info.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// valueDescription is the result of describe, and its --json form.
+type valueDescription struct {
+	Document int64  `json:"document"`
+	Path     string `json:"path"`
+	Type     string `json:"type"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+	Value    any    `json:"value"`
+}
+
+// runDescribe prints the innermost value of the BONJSON stream at inputPath
+// whose encoding includes the byte at offset. An offset within an object key
+// describes the key. Record definitions have a null value.
+func runDescribe(inputPath string, offset int64, opts *options) error {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("reading input file: %w", err)
+	}
+	defer f.Close()
+
+	var found *scannedValue
+	var foundPath path
+	scanner := newWireScanner(f, 0, func(v scannedValue) {
+		// Values are visited innermost first, so the first match is the
+		// innermost one.
+		if found == nil && offset >= v.offset && offset < v.offset+v.size {
+			found = &v
+			foundPath = slices.Clone(v.path)
+		}
+	})
+	document := int64(0)
+	for ; found == nil; document++ {
+		if err := scanner.scanDocument(); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("offset %d is beyond the end of the input (%d bytes)", offset, scanner.offset)
+			}
+			return fmt.Errorf("invalid BONJSON: document %d: %w", document, err)
+		}
+	}
+	document--
+
+	desc := valueDescription{
+		Document: document,
+		Path:     foundPath.String(),
+		Type:     found.kind.String(),
+		Offset:   found.offset,
+		Size:     found.size,
+	}
+	switch found.kind {
+	case kindRecordDef:
+	case kindKey:
+		// The scanner reports a key with the path of its object; describe
+		// it with the path of its member, and the key itself as its value.
+		raw := make([]byte, found.size)
+		if _, err := f.ReadAt(raw, found.offset); err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+		docs, _, err := decodeBONJSON(raw, opts)
+		if err != nil {
+			return fmt.Errorf("invalid BONJSON: key at offset %d: %w", found.offset, err)
+		}
+		desc.Value = docs[0]
+		if key, ok := docs[0].(string); ok {
+			desc.Path = append(foundPath, pathSegment{key: key}).String()
+		}
+	default:
+		// Decode the document rather than the value alone, since it may
+		// depend on record definitions earlier in the stream.
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+		dec := newBONJSONDecoder(bufio.NewReader(f), opts)
+		var doc any
+		for i := int64(0); i <= document; i++ {
+			doc = nil
+			if err := dec.Decode(&doc); err != nil {
+				return fmt.Errorf("invalid BONJSON: document %d: %w", i, err)
+			}
+		}
+		desc.Value, _ = lookupPath(doc, foundPath)
+	}
+
+	if opts.printJSON {
+		output, err := json.Marshal(desc)
+		if err != nil {
+			return fmt.Errorf("encoding JSON: %w", err)
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+	fmt.Printf("document: %d\n", desc.Document)
+	fmt.Printf("path:     %s\n", desc.Path)
+	fmt.Printf("type:     %s\n", desc.Type)
+	fmt.Printf("offset:   %d (size %d)\n", desc.Offset, desc.Size)
+	if found.kind != kindRecordDef {
+		value, err := json.Marshal(desc.Value)
+		if err != nil {
+			return fmt.Errorf("encoding JSON: %w", err)
+		}
+		fmt.Printf("value:    %s\n", value)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+// ABOUTME: The diff command: compares two documents, or two directory trees file by file.
+// ABOUTME: Files pair up by relative path regardless of format, so a JSON tree can be checked against its BONJSON conversion.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Exit codes of the diff command, as for diff(1).
+const (
+	diffExitSame      = 0
+	diffExitDifferent = 1
+	diffExitTrouble   = 2
+)
+
+// Statuses of a pair of files in a directory diff.
+const (
+	diffSame      = "same"
+	diffDifferent = "different"
+	diffOnlyA     = "only_a"
+	diffOnlyB     = "only_b"
+	diffError     = "error"
+)
+
+// diffEntry is the comparison of the files at one relative path, and its
+// --json form. Path has no extension; A and B are the files found there.
+type diffEntry struct {
+	Path        string `json:"path"`
+	A           string `json:"a,omitempty"`
+	B           string `json:"b,omitempty"`
+	Status      string `json:"status"`
+	Differences int    `json:"differences,omitempty"`
+	First       string `json:"first,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// runDiff compares the two files or directories in args and returns the exit
+// code: diffExitSame if they hold the same documents, diffExitDifferent if
+// not, and diffExitTrouble if they could not be compared.
+func runDiff(args []string, opts *options) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Error: diff requires two files or two directories")
+		return diffExitTrouble
+	}
+	a, b := args[0], args[1]
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA == nil && errB == nil && infoA.IsDir() != infoB.IsDir() {
+		fmt.Fprintln(os.Stderr, "Error: diff compares two files or two directories, not one of each")
+		return diffExitTrouble
+	}
+	if errA == nil && infoA.IsDir() && errB == nil {
+		return diffTrees(a, b, opts)
+	}
+
+	differences, err := diffFiles(a, b, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return diffExitTrouble
+	}
+	for _, d := range differences {
+		fmt.Println(d)
+	}
+	if len(differences) > 0 {
+		return diffExitDifferent
+	}
+	return diffExitSame
+}
+
+// diffFiles decodes two files, each as BONJSON if named *.boj or *.bonjson
+// and as JSON otherwise, and describes how their documents differ, as the
+// JSON Patch operations (op and path) that would turn one into the other.
+// Numbers compare by value, so a JSON file and its BONJSON conversion are
+// the same.
+func diffFiles(a, b string, opts *options) ([]string, error) {
+	docsA, err := loadDiffDocuments(a, opts)
+	if err != nil {
+		return nil, err
+	}
+	docsB, err := loadDiffDocuments(b, opts)
+	if err != nil {
+		return nil, err
+	}
+	var differences []string
+	prefix := func(i int) string {
+		if len(docsA) == 1 && len(docsB) == 1 {
+			return ""
+		}
+		return fmt.Sprintf("document %d: ", i)
+	}
+	for i := range max(len(docsA), len(docsB)) {
+		switch {
+		case i >= len(docsA):
+			differences = append(differences, prefix(i)+"only in "+b)
+		case i >= len(docsB):
+			differences = append(differences, prefix(i)+"only in "+a)
+		default:
+			for _, op := range diffValues(docsA[i], docsB[i], "", nil) {
+				op := op.(map[string]any)
+				differences = append(differences, fmt.Sprintf("%s%s %s", prefix(i), op["op"], op["path"]))
+			}
+		}
+	}
+	return differences, nil
+}
+
+// loadDiffDocuments decodes every document in filename, which may also be
+// bonjson-text if named *.bontext.
+func loadDiffDocuments(filename string, opts *options) ([]any, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	inputJSON := !isBONJSONPath(filename)
+	if f := formatForPath(filename); f != nil && f.Name == "bontext" {
+		if data, err = parseBontext(data); err != nil {
+			return nil, fmt.Errorf("%s: invalid bonjson-text: %w", filename, err)
+		}
+		inputJSON = false
+	}
+	streamed := *opts
+	streamed.stream = true
+	docs, err := decodeBuffer(data, inputJSON, &streamed)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return docs, nil
+}
+
+// diffTrees compares every file with an input format extension under dirA
+// with the file at the same relative path, whatever its extension, under
+// dirB, on opts' workers. It prints one line per path and a summary, or a
+// JSON report with --json.
+func diffTrees(dirA, dirB string, opts *options) int {
+	filesA, err := diffTreeFiles(dirA)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return diffExitTrouble
+	}
+	filesB, err := diffTreeFiles(dirB)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return diffExitTrouble
+	}
+
+	keys := slices.Collect(maps.Keys(filesA))
+	for key := range filesB {
+		if _, ok := filesA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	entries := make([]diffEntry, len(keys))
+	for i, key := range keys {
+		e := &entries[i]
+		e.Path = key
+		as, bs := filesA[key], filesB[key]
+		if len(as) > 1 || len(bs) > 1 {
+			e.Status = diffError
+			e.Error = "several files share this path: " + strings.Join(append(as, bs...), ", ")
+			continue
+		}
+		if len(as) == 1 {
+			e.A = as[0]
+		}
+		if len(bs) == 1 {
+			e.B = bs[0]
+		}
+	}
+
+	work := make(chan *diffEntry)
+	var wg sync.WaitGroup
+	for range workerCount(opts.transformWorkers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range work {
+				compareDiffEntry(e, dirA, dirB, opts)
+			}
+		}()
+	}
+	for i := range entries {
+		work <- &entries[i]
+	}
+	close(work)
+	wg.Wait()
+
+	counts := map[string]int{}
+	for _, e := range entries {
+		counts[e.Status]++
+	}
+	if opts.printJSON {
+		output, err := json.MarshalIndent(map[string]any{"files": entries, "summary": counts}, "", "    ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: encoding JSON: %v\n", err)
+			return diffExitTrouble
+		}
+		fmt.Println(string(output))
+	} else {
+		for _, e := range entries {
+			switch e.Status {
+			case diffSame:
+				fmt.Printf("same       %s\n", e.Path)
+			case diffDifferent:
+				fmt.Printf("different  %s: %d differences, first %s\n", e.Path, e.Differences, e.First)
+			case diffOnlyA:
+				fmt.Printf("only in A  %s\n", e.A)
+			case diffOnlyB:
+				fmt.Printf("only in B  %s\n", e.B)
+			case diffError:
+				fmt.Printf("error      %s: %s\n", e.Path, e.Error)
+			}
+		}
+		fmt.Printf("%d paths: %d same, %d different, %d only in A, %d only in B, %d errors\n",
+			len(entries), counts[diffSame], counts[diffDifferent], counts[diffOnlyA], counts[diffOnlyB], counts[diffError])
+	}
+
+	switch {
+	case counts[diffError] > 0:
+		return diffExitTrouble
+	case counts[diffSame] < len(entries):
+		return diffExitDifferent
+	}
+	return diffExitSame
+}
+
+// compareDiffEntry compares the files of e, unless it already has a status,
+// and records the outcome in it.
+func compareDiffEntry(e *diffEntry, dirA, dirB string, opts *options) {
+	switch {
+	case e.Status != "":
+		return
+	case e.B == "":
+		e.Status = diffOnlyA
+		return
+	case e.A == "":
+		e.Status = diffOnlyB
+		return
+	}
+	differences, err := diffFiles(filepath.Join(dirA, e.A), filepath.Join(dirB, e.B), opts)
+	switch {
+	case err != nil:
+		e.Status = diffError
+		e.Error = err.Error()
+	case len(differences) > 0:
+		e.Status = diffDifferent
+		e.Differences = len(differences)
+		e.First = differences[0]
+	default:
+		e.Status = diffSame
+	}
+}
+
+// diffTreeFiles returns the files under dir with an input format extension,
+// relative to dir, keyed by their relative path without the extension. A key
+// that several files share (such as a.json and a.boj) lists them all, so that
+// the clash is reported rather than one of them silently winning.
+func diffTreeFiles(dir string) (map[string][]string, error) {
+	files := make(map[string][]string)
+	err := filepath.WalkDir(dir, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if f := formatForPath(filename); f == nil || !f.Input {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(replaceExtension(rel, ""))
+		files[key] = append(files[key], rel)
+		return nil
+	})
+	return files, err
+}
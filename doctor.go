@@ -0,0 +1,311 @@
+// ABOUTME: The doctor command: checks the environment and probes an input for likely problems.
+// ABOUTME: Prints each finding with advice, or with --json a report to attach to bug reports.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// largeInput is the size beyond which doctor suggests --stream, since a
+// conversion without it holds the whole input and its decoded documents in
+// memory.
+const largeInput = 256 << 20
+
+// minTempSpace is the free space below which doctor warns about the
+// temporary directory.
+const minTempSpace = 64 << 20
+
+// Statuses of a doctor finding.
+const (
+	checkOK   = "ok"
+	checkInfo = "info"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// finding is the outcome of one doctor check.
+type finding struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Advice string `json:"advice,omitempty"`
+}
+
+// doctorReport is everything doctor found, and its --json form.
+type doctorReport struct {
+	Go          string    `json:"go"`
+	Platform    string    `json:"platform"`
+	BONJSON     string    `json:"bonjson_library"`
+	Environment []finding `json:"environment"`
+	Input       string    `json:"input,omitempty"`
+	InputSize   int64     `json:"input_size,omitempty"`
+	InputChecks []finding `json:"input_checks,omitempty"`
+}
+
+// runDoctor checks the environment and, if inputPath is not empty, probes the
+// input, printing what it finds. It fails if any check failed.
+func runDoctor(inputPath string, opts *options) error {
+	report := doctorReport{
+		Go:       runtime.Version(),
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		BONJSON:  bonjsonLibraryVersion(),
+	}
+	report.Environment = append(report.Environment, checkTerminal(), checkLocale())
+	report.Environment = append(report.Environment, checkTempDir()...)
+	if inputPath != "" {
+		var data []byte
+		var err error
+		if inputPath == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(inputPath)
+		}
+		if err != nil {
+			return fmt.Errorf("reading input: %w", err)
+		}
+		report.Input = inputPath
+		report.InputSize = int64(len(data))
+		report.InputChecks = probeInput(data, inputPath, opts)
+	}
+
+	if opts.printJSON {
+		output, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return fmt.Errorf("encoding JSON: %w", err)
+		}
+		fmt.Println(string(output))
+	} else {
+		fmt.Printf("bonbon doctor (%s, %s, go-bonjson %s)\n", report.Go, report.Platform, report.BONJSON)
+		fmt.Println("environment:")
+		printFindings(report.Environment)
+		if inputPath != "" {
+			fmt.Printf("input %s (%d bytes):\n", inputPath, report.InputSize)
+			printFindings(report.InputChecks)
+		}
+	}
+
+	failed := 0
+	for _, f := range append(report.Environment, report.InputChecks...) {
+		if f.Status == checkFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// printFindings prints findings one per line, each followed by its advice.
+func printFindings(findings []finding) {
+	for _, f := range findings {
+		fmt.Printf("  %-5s %-10s %s\n", f.Status, f.Check, f.Detail)
+		if f.Advice != "" {
+			fmt.Printf("  %-5s %-10s -> %s\n", "", "", f.Advice)
+		}
+	}
+}
+
+// bonjsonLibraryVersion returns the version of go-bonjson built in, which
+// decides what the decoder accepts.
+func bonjsonLibraryVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/kstenerud/go-bonjson" {
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+}
+
+// checkTerminal reports whether stdout is a terminal, where BONJSON written to
+// "-" would arrive as raw binary.
+func checkTerminal() finding {
+	f := finding{Check: "terminal", Status: checkOK, Detail: "stdout is not a terminal"}
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		f.Status = checkInfo
+		f.Detail = "stdout is a terminal"
+		f.Advice = "BONJSON output to - would print binary here; write it to a file or pipe it, or convert with b2j to read it"
+	}
+	return f
+}
+
+// checkLocale reports whether the locale selects UTF-8, which JSON output
+// needs to display non-ASCII strings.
+func checkLocale() finding {
+	f := finding{Check: "locale", Status: checkOK}
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		f.Detail = name + "=" + value
+		normalized := strings.ReplaceAll(strings.ToLower(value), "-", "")
+		if !strings.Contains(normalized, "utf8") {
+			f.Status = checkWarn
+			f.Detail += " does not select UTF-8"
+			f.Advice = "set LANG to a UTF-8 locale, such as C.UTF-8, so non-ASCII strings in JSON output display correctly"
+		}
+		return f
+	}
+	if runtime.GOOS == "windows" {
+		f.Detail = "no locale variables; Windows consoles choose their own code page"
+		return f
+	}
+	f.Status = checkWarn
+	f.Detail = "none of LC_ALL, LC_CTYPE, and LANG is set"
+	f.Advice = "set LANG to a UTF-8 locale, such as C.UTF-8, so non-ASCII strings in JSON output display correctly"
+	return f
+}
+
+// checkTempDir reports whether the temporary directory is writable and has
+// room to spare.
+func checkTempDir() []finding {
+	dir := os.TempDir()
+	writable := finding{Check: "tmp", Status: checkOK, Detail: dir + " is writable"}
+	if f, err := os.CreateTemp(dir, "bonbon-doctor-*"); err != nil {
+		writable.Status = checkFail
+		writable.Detail = fmt.Sprintf("cannot create files in %s: %v", dir, err)
+		writable.Advice = "point TMPDIR at a writable directory"
+		return []finding{writable}
+	} else {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	space := finding{Check: "tmp space", Status: checkOK}
+	free, err := freeSpace(dir)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		space.Status = checkInfo
+		space.Detail = "free space cannot be measured on " + runtime.GOOS
+	case err != nil:
+		space.Status = checkWarn
+		space.Detail = fmt.Sprintf("cannot measure free space in %s: %v", dir, err)
+	default:
+		space.Detail = fmt.Sprintf("%d bytes free in %s", free, dir)
+		if free < minTempSpace {
+			space.Status = checkWarn
+			space.Advice = "free up space there, or point TMPDIR at a roomier file system"
+		}
+	}
+	return []finding{writable, space}
+}
+
+// probeInput checks what the content of an input is, whether that agrees
+// with its name, whether it decodes under the default (strict) settings and
+// which option would let it decode if not, and whether its size or document
+// count calls for --stream.
+func probeInput(data []byte, inputPath string, opts *options) []finding {
+	if len(data) == 0 {
+		return []finding{{Check: "size", Status: checkFail, Detail: "input is empty",
+			Advice: "check that the file was written completely"}}
+	}
+	var findings []finding
+
+	found, detectErr := detectFormats(data, opts)
+	var detected *format
+	if len(found) > 0 {
+		detected = found[0].format
+	}
+
+	named := formatForPath(inputPath)
+	format := finding{Check: "format", Status: checkOK}
+	switch {
+	case detected == nil:
+		format.Status = checkFail
+		format.Detail = "content is " + detectErr.Error()
+		format.Advice = "check that the file is not truncated, compressed, or in another format"
+		return append(findings, format)
+	case inputPath == "-" || named == nil:
+		format.Detail = "content is " + detected.Name
+	case named != detected:
+		format.Status = checkWarn
+		format.Detail = fmt.Sprintf("content is %s, but the name says %s", detected.Name, named.Name)
+		format.Advice = fmt.Sprintf("rename the file with a %s extension; commands that go by the extension will misread it",
+			detected.Extensions[0])
+	default:
+		format.Detail = "content is " + detected.Name + ", as the name says"
+	}
+	if len(found) > 1 {
+		// Ambiguous content: list the other candidates, most likely first.
+		var others []string
+		for _, d := range found[1:] {
+			others = append(others, fmt.Sprintf("%s (by %s)", d.format.Name, d.by))
+		}
+		format.Detail += "; also valid as " + strings.Join(others, ", ")
+	}
+	findings = append(findings, format)
+
+	var count int
+	if detected.Name == "json" {
+		docs, _ := decodeJSON(data, &options{stream: true})
+		count = len(docs)
+		findings = append(findings, probeJSON(data))
+	} else {
+		var spec finding
+		count, spec = probeBONJSON(data)
+		findings = append(findings, spec)
+	}
+
+	size := finding{Check: "size", Status: checkOK, Detail: "1 document"}
+	switch {
+	case count > 1:
+		size.Status = checkInfo
+		size.Detail = fmt.Sprintf("%d documents", count)
+		size.Advice = "convert it with --stream; without it, only a single document is accepted"
+	case len(data) > largeInput:
+		size.Status = checkWarn
+		size.Detail = fmt.Sprintf("one document of %d bytes", len(data))
+		size.Advice = "it is decoded in memory in full; split it into a stream of documents and convert with --stream"
+	}
+	return append(findings, size)
+}
+
+// probeJSON checks JSON input against RFC 8259, reporting what encoding/json
+// would silently change.
+func probeJSON(data []byte) finding {
+	if err := validateStrictJSON(data, true); err != nil {
+		return finding{Check: "spec", Status: checkWarn, Detail: err.Error(),
+			Advice: "conversion accepts this but changes the data; fix the input, or reject such input with --strict-json"}
+	}
+	return finding{Check: "spec", Status: checkOK, Detail: "strictly valid JSON (RFC 8259)"}
+}
+
+// probeBONJSON decodes BONJSON input with the default settings and, if that
+// fails, finds the option that lets it decode. It returns the number of
+// documents and the finding.
+func probeBONJSON(data []byte) (int, finding) {
+	docs, _, err := decodeBONJSON(data, &options{stream: true})
+	if err == nil {
+		return len(docs), finding{Check: "spec", Status: checkOK, Detail: "valid BONJSON under the default settings"}
+	}
+	relaxations := []struct {
+		flag string
+		opts options
+	}{
+		{"-f allow (or -f stringify)", options{nanInfMode: "allow"}},
+		{"-u replace", options{utf8Mode: "replace"}},
+		{"-n", options{allowNUL: true}},
+		{"-d keeplast (or -d keepfirst)", options{dupKeyMode: "keeplast"}},
+	}
+	for _, r := range relaxations {
+		relaxed := r.opts
+		relaxed.stream = true
+		if docs, _, relaxedErr := decodeBONJSON(data, &relaxed); relaxedErr == nil {
+			return len(docs), finding{Check: "spec", Status: checkWarn, Detail: err.Error(),
+				Advice: "the input decodes with " + r.flag}
+		}
+	}
+	return len(docs), finding{Check: "spec", Status: checkFail, Detail: err.Error(),
+		Advice: "no single decoding option accepts it; the input is damaged or needs several of -d, -f, -n, and -u"}
+}
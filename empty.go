@@ -0,0 +1,27 @@
+// ABOUTME: The --empty-as option: what a conversion makes of input with no bytes at all.
+// ABOUTME: Empty input can stand for a null or an empty object instead of failing, for pipelines whose producers may emit nothing.
+
+package main
+
+import "fmt"
+
+// emptyModes are the values of --empty-as. With "error", the default, empty
+// input fails the conversion.
+var emptyModes = []string{"null", "empty-object", "error"}
+
+// emptyInput returns the input that empty input stands for with --empty-as:
+// the encoding, in the input format, of a null or an empty object. It fails
+// if empty input is an error.
+func emptyInput(inputJSON bool, opts *options) ([]byte, error) {
+	switch {
+	case opts.emptyAs == "null" && inputJSON:
+		return []byte("null"), nil
+	case opts.emptyAs == "null":
+		return []byte{0xB3}, nil
+	case opts.emptyAs == "empty-object" && inputJSON:
+		return []byte("{}"), nil
+	case opts.emptyAs == "empty-object":
+		return []byte{0xB8, 0xB6}, nil
+	}
+	return nil, fmt.Errorf("input is empty")
+}
@@ -0,0 +1,78 @@
+// ABOUTME: Environment variable substitution in string values.
+// ABOUTME: Expands ${VAR} placeholders so JSON templates can become deployable artifacts.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// expandEnv replaces ${VAR} placeholders in every string value within v
+// (object keys are left alone) and returns the result. "$${" produces a
+// literal "${". Undefined variables expand to the empty string, or cause an
+// error if strict is true.
+func expandEnv(v any, at path, strict bool) (any, error) {
+	switch v := v.(type) {
+	case string:
+		expanded, err := expandEnvString(v, strict)
+		if err != nil {
+			return nil, fmt.Errorf("at %s: %w", at, err)
+		}
+		return expanded, nil
+	case map[string]any:
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			elem := v[key]
+			expanded, err := expandEnv(elem, append(at, pathSegment{key: key}), strict)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = expanded
+		}
+	case []any:
+		for i, elem := range v {
+			expanded, err := expandEnv(elem, append(at, pathSegment{index: i, isIndex: true}), strict)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+	}
+	return v, nil
+}
+
+// expandEnvString expands the ${VAR} placeholders in s.
+func expandEnvString(s string, strict bool) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var sb strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			sb.WriteString(s)
+			return sb.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			sb.WriteString(s[:i-1])
+			sb.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in %q", s)
+		}
+		name := s[i+2 : i+end]
+		value, ok := os.LookupEnv(name)
+		if !ok && strict {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		sb.WriteString(s[:i])
+		sb.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
@@ -0,0 +1,83 @@
+// ABOUTME: The --envelope option: wraps each converted document with where and when it came from.
+// ABOUTME: Records the source file, byte range, conversion time, and checksum, so a consolidated stream stays auditable.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// documentSource is the byte range of an input document, and its bytes.
+type documentSource struct {
+	offset int64
+	data   []byte
+}
+
+// scanDocumentSources returns the source of each of the first count
+// documents of a payload that starts at input offset start. A BONJSON
+// document's range includes the record definitions just before it, which it
+// needs to decode; a JSON document's excludes the whitespace around it.
+// Documents beyond where the payload stops scanning, such as one partially
+// decoded before an error, get a nil source.
+func scanDocumentSources(payload []byte, start int64, count int, inputJSON bool) []*documentSource {
+	sources := make([]*documentSource, 0, count)
+	add := func(from, to int64) {
+		if len(sources) < count {
+			sources = append(sources, &documentSource{offset: start + from, data: payload[from:to]})
+		}
+	}
+	if inputJSON {
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		for len(sources) < count {
+			from := dec.InputOffset()
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				break
+			}
+			from += int64(len(payload[from:]) - len(bytes.TrimLeft(payload[from:], " \t\r\n")))
+			add(from, dec.InputOffset())
+		}
+	} else {
+		from := int64(0)
+		scanner := newWireScanner(bytes.NewReader(payload), 0, func(v scannedValue) {
+			if v.depth == 0 && v.kind != kindRecordDef {
+				add(from, v.offset+v.size)
+				from = v.offset + v.size
+			}
+		})
+		for len(sources) < count {
+			if err := scanner.scanDocument(); err != nil {
+				break
+			}
+		}
+	}
+	for len(sources) < count {
+		sources = append(sources, nil)
+	}
+	return sources
+}
+
+// envelope wraps the converted document doc in an object recording its
+// source file, the conversion time, and, if its source bytes are known, their
+// offset, size, and SHA-256 checksum.
+func envelope(doc any, inputPath string, source *documentSource, converted time.Time) map[string]any {
+	wrapped := map[string]any{
+		"source":    inputPath,
+		"converted": converted.UTC().Format(time.RFC3339Nano),
+		"document":  doc,
+	}
+	if inputPath == "-" {
+		wrapped["source"] = "stdin"
+	}
+	if source != nil {
+		sum := sha256.Sum256(source.data)
+		wrapped["offset"] = source.offset
+		wrapped["size"] = int64(len(source.data))
+		wrapped["sha256"] = hex.EncodeToString(sum[:])
+	}
+	return wrapped
+}
@@ -0,0 +1,105 @@
+// ABOUTME: The examples command: a curated corpus of edge-case documents embedded in the binary.
+// ABOUTME: Lists, shows, or writes the examples as JSON and BONJSON for testing other decoders.
+
+package main
+
+import (
+	"embed"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+//go:embed examples/*.json
+var exampleFiles embed.FS
+
+// exampleDescriptions describes each file in examples/, by name without the
+// extension.
+var exampleDescriptions = map[string]string{
+	"containers": "Empty, nested, and mixed-type arrays and objects, nulls, and rows",
+	"nesting":    "A value nested 200 levels deep in alternating arrays and objects",
+	"numbers":    "Integers at every encoding size boundary and extreme floats",
+	"strings":    "Strings at the short/long encoding boundary, up to 70 KB",
+	"unicode":    "Scripts, emoji sequences, combining marks, escapes, and plane edges",
+}
+
+// runExamples implements the examples subcommands:
+//
+//	examples list               list the examples
+//	examples show NAME          print an example as JSON
+//	examples write DIR [NAME...]
+//	                            write examples as NAME.json and NAME.boj
+func runExamples(args []string, opts *options) error {
+	names := slices.Sorted(maps.Keys(exampleDescriptions))
+	switch args[0] {
+	case "list":
+		for _, name := range names {
+			fmt.Printf("%-12s %s\n", name, exampleDescriptions[name])
+		}
+		return nil
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: examples show NAME")
+		}
+		data, err := readExample(args[1])
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	case "write":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: examples write DIR [NAME...]")
+		}
+		dir := args[1]
+		if len(args) > 2 {
+			names = args[2:]
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+		for _, name := range names {
+			if err := writeExample(dir, name, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown examples command: %s (expected list, show, or write)", args[0])
+	}
+}
+
+// readExample returns the JSON text of the named example.
+func readExample(name string) ([]byte, error) {
+	if _, ok := exampleDescriptions[name]; !ok {
+		return nil, fmt.Errorf("unknown example: %s (see 'examples list')", name)
+	}
+	return exampleFiles.ReadFile("examples/" + name + ".json")
+}
+
+// writeExample writes the named example to dir as NAME.json and as its
+// BONJSON encoding, NAME.boj.
+func writeExample(dir, name string, opts *options) error {
+	data, err := readExample(name)
+	if err != nil {
+		return err
+	}
+	docs, err := decodeJSON(data, opts)
+	if err != nil {
+		return fmt.Errorf("example %s: %w", name, err)
+	}
+	encoded, err := encodeDocument(docs[0], false, opts)
+	if err != nil {
+		return fmt.Errorf("example %s: %w", name, err)
+	}
+	base := filepath.Join(dir, name)
+	if err := writeFileAtomic(base+".json", data); err != nil {
+		return fmt.Errorf("writing example: %w", err)
+	}
+	if err := writeFileAtomic(base+".boj", encoded); err != nil {
+		return fmt.Errorf("writing example: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// ABOUTME: Inherited file descriptors as inputs and outputs: --in-fd and --out-fd, and /dev/fd/N paths.
+// ABOUTME: A process embedding bonbon can hand it pipes or sockets directly, without temp files or named pipes.
+
+package main
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fdPathPrefix starts the paths that stand for file descriptors.
+const fdPathPrefix = "/dev/fd/"
+
+// descriptors holds the files opened on inherited descriptors. Each
+// descriptor gets one *os.File, since a second would close the descriptor
+// when the garbage collector finalizes the first.
+var descriptors struct {
+	mu    sync.Mutex
+	files map[int]*os.File
+}
+
+// parseDescriptor parses the value of --in-fd or --out-fd, returning the
+// /dev/fd/N path that stands for the descriptor.
+func parseDescriptor(s string) (string, bool) {
+	fd, err := strconv.Atoi(s)
+	if err != nil || fd < 0 {
+		return "", false
+	}
+	return fdPathPrefix + strconv.Itoa(fd), true
+}
+
+// openDescriptor returns the file for the descriptor that filename stands
+// for, if it is a /dev/fd/N path. The descriptor is used as inherited, at
+// its current offset, rather than reopened, which sockets do not allow.
+func openDescriptor(filename string) (*os.File, bool) {
+	s, ok := strings.CutPrefix(filename, fdPathPrefix)
+	if !ok {
+		return nil, false
+	}
+	fd, err := strconv.Atoi(s)
+	if err != nil || fd < 0 {
+		return nil, false
+	}
+	switch fd {
+	case 0:
+		return os.Stdin, true
+	case 1:
+		return os.Stdout, true
+	case 2:
+		return os.Stderr, true
+	}
+	descriptors.mu.Lock()
+	defer descriptors.mu.Unlock()
+	if descriptors.files == nil {
+		descriptors.files = make(map[int]*os.File)
+	}
+	f := descriptors.files[fd]
+	if f == nil {
+		f = os.NewFile(uintptr(fd), filename)
+		descriptors.files[fd] = f
+	}
+	return f, true
+}
+
+// readFile reads the file filename to its end, or the descriptor it stands
+// for if it is a /dev/fd/N path.
+func readFile(filename string) ([]byte, error) {
+	if f, ok := openDescriptor(filename); ok {
+		return io.ReadAll(f)
+	}
+	return os.ReadFile(filename)
+}
+
+// openFile opens the file filename for reading, or returns the descriptor it
+// stands for if it is a /dev/fd/N path. The returned close function closes
+// only files it opened.
+func openFile(filename string) (io.Reader, func() error, error) {
+	if f, ok := openDescriptor(filename); ok {
+		return f, func() error { return nil }, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
@@ -0,0 +1,147 @@
+// ABOUTME: Field-level encryption (--encrypt-paths) and decryption (--decrypt) of selected values with AES-GCM.
+// ABOUTME: An encrypted value is an {"$encrypted": "base64"} object, so the rest of the document stays readable.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// encryptedTag is the key of the object that holds an encrypted value.
+const encryptedTag = "$encrypted"
+
+// loadFieldKey returns the AES-GCM cipher for the key in the environment
+// variable name: the base64 encoding of 16, 24, or 32 bytes, for AES-128,
+// AES-192, or AES-256.
+func loadFieldKey(name string) (cipher.AEAD, error) {
+	encoded, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %s: key must be base64: %w", name, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %s: key must be 16, 24, or 32 bytes, not %d", name, len(key))
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseEncryptPaths parses an --encrypt-paths value: comma-separated paths,
+// such as $.ssn,$.card.
+func parseEncryptPaths(s string) ([]path, error) {
+	var paths []path
+	for _, spec := range strings.Split(s, ",") {
+		p, err := parsePath(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		if len(p) == 0 {
+			return nil, fmt.Errorf("invalid path %q: cannot encrypt the whole document", spec)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// encryptFields replaces the value at each of paths in v that is present
+// with its encryption. The plaintext is the value's BONJSON encoding, so
+// that it decrypts exactly, and the path is the additional authenticated
+// data, so that a value cannot be moved to another field undetected.
+func encryptFields(v any, paths []path, aead cipher.AEAD, opts *options) error {
+	for _, p := range paths {
+		parent, ok := lookupPath(v, p[:len(p)-1])
+		if !ok {
+			continue
+		}
+		last := p[len(p)-1]
+		var value any
+		switch parent := parent.(type) {
+		case map[string]any:
+			if value, ok = parent[last.key]; !ok || last.isIndex {
+				continue
+			}
+		case []any:
+			if !last.isIndex || last.index >= len(parent) {
+				continue
+			}
+			value = parent[last.index]
+		default:
+			continue
+		}
+		var plaintext bytes.Buffer
+		if err := newBONJSONEncoder(&plaintext, opts).Encode(value); err != nil {
+			return fmt.Errorf("encrypting %s: %w", p, err)
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return fmt.Errorf("encrypting %s: %w", p, err)
+		}
+		sealed := aead.Seal(nonce, nonce, plaintext.Bytes(), []byte(p.String()))
+		encrypted := map[string]any{encryptedTag: base64.StdEncoding.EncodeToString(sealed)}
+		if object, ok := parent.(map[string]any); ok {
+			object[last.key] = encrypted
+		} else {
+			parent.([]any)[last.index] = encrypted
+		}
+	}
+	return nil
+}
+
+// decryptFields replaces each {"$encrypted": "..."} object within v with
+// the value it holds. One that does not decrypt with the key at its path,
+// as when it was encrypted at another path or with another key, is an
+// error. at is v's path.
+func decryptFields(v any, at path, aead cipher.AEAD, opts *options) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		if encoded, ok := v[encryptedTag]; ok && len(v) == 1 {
+			s, ok := encoded.(string)
+			if !ok {
+				return nil, fmt.Errorf("decrypting %s: %s must be a string", at, encryptedTag)
+			}
+			sealed, err := base64.StdEncoding.DecodeString(s)
+			if err != nil || len(sealed) < aead.NonceSize() {
+				return nil, fmt.Errorf("decrypting %s: invalid %s", at, encryptedTag)
+			}
+			plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(at.String()))
+			if err != nil {
+				return nil, fmt.Errorf("decrypting %s: %w", at, err)
+			}
+			single := *opts
+			single.stream, single.allowTrailing = false, false
+			docs, _, err := decodeBONJSON(plaintext, &single)
+			if err != nil {
+				return nil, fmt.Errorf("decrypting %s: %w", at, err)
+			}
+			return docs[0], nil
+		}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			decrypted, err := decryptFields(v[key], append(at, pathSegment{key: key}), aead, opts)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = decrypted
+		}
+	case []any:
+		for i, elem := range v {
+			decrypted, err := decryptFields(elem, append(at, pathSegment{index: i, isIndex: true}), aead, opts)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = decrypted
+		}
+	}
+	return v, nil
+}
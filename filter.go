@@ -0,0 +1,150 @@
+// ABOUTME: The --filter mode: a stdin-to-stdout contract for editor plugins.
+// ABOUTME: Never writes files, writes output only on success, and uses stable exit codes.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes of --filter mode. Plugins can rely on these not changing.
+const (
+	filterExitOK           = 0 // the output is complete
+	filterExitUsage        = 1 // bad command line; nothing was read
+	filterExitInvalidInput = 2 // the input could not be decoded; no output
+	filterExitFailed       = 3 // anything else went wrong; no output
+)
+
+// filterCommands maps the commands available in --filter mode to their input
+// and output formats. j and b only validate, producing no output.
+var filterCommands = map[string]struct{ inputJSON, outputJSON bool }{
+	"j":   {true, false},
+	"b":   {false, false},
+	"j2b": {true, false},
+	"j2j": {true, true},
+	"b2j": {false, true},
+	"b2b": {false, false},
+}
+
+// runFilter converts stdin to stdout for editor plugins and returns the exit
+// code. Unlike a normal conversion, nothing is written unless the whole
+// conversion succeeds, so a plugin can replace its buffer with the output
+// whenever the exit code is 0. Diagnostics go to stderr.
+func runFilter(args []string, opts *options) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Error: --filter takes a command and no file arguments (input is stdin, output stdout)")
+		return filterExitUsage
+	}
+	formats, ok := filterCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: --filter does not support the %s command (use j, b, j2b, j2j, b2j, or b2b)\n", args[0])
+		return filterExitUsage
+	}
+	if opts.writesFiles() || opts.windowed() {
+		fmt.Fprintln(os.Stderr, "Error: --filter cannot be combined with options that write files or read input windows")
+		return filterExitUsage
+	}
+	if option := opts.bufferUnsupported(); option != "" {
+		fmt.Fprintf(os.Stderr, "Error: --filter cannot be combined with %s\n", option)
+		return filterExitUsage
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: reading stdin: %v\n", err)
+		return filterExitFailed
+	}
+	if opts.expectSHA256 != "" {
+		if err := checkSHA256(data, opts.expectSHA256); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return filterExitInvalidInput
+		}
+	}
+	docs, err := decodeBuffer(data, formats.inputJSON, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return filterExitInvalidInput
+	}
+	if args[0] == "j" || args[0] == "b" {
+		return filterExitOK
+	}
+
+	output, err := encodeBuffer(docs, formats.outputJSON, opts)
+	if err == nil {
+		_, err = os.Stdout.Write(output)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return filterExitFailed
+	}
+	return filterExitOK
+}
+
+// writesFiles reports whether any option asks for a file to be written.
+func (opts *options) writesFiles() bool {
+	return opts.manifestPath != "" || opts.splitSize > 0 || opts.splitDocs > 0 ||
+		opts.trailingOut != "" || opts.cpuProfile != "" || opts.memProfile != "" ||
+		opts.traceFile != "" || opts.benchSaveBaseline != "" || opts.outFile != "" ||
+		opts.provenanceFile != "" || opts.offsetMapFile != "" || opts.decodeTraceFile != "" ||
+		opts.snapshotDir != ""
+}
+
+// bufferUnsupported returns the first option set in opts that the in-memory
+// conversion of decodeBuffer and encodeBuffer does not implement, or "".
+// Modes built on them reject these rather than silently ignore them.
+func (opts *options) bufferUnsupported() string {
+	switch {
+	case opts.envelope:
+		return "--envelope"
+	case opts.lossinessReport:
+		return "--lossiness-report"
+	case opts.timeFiltered():
+		return "--since or --until"
+	case opts.inputFormat != "":
+		return "--from"
+	}
+	return ""
+}
+
+// decodeBuffer decodes a whole in-memory input, after skipping -s bytes. Any
+// error means the input itself is unusable.
+func decodeBuffer(data []byte, inputJSON bool, opts *options) ([]any, error) {
+	payload, err := inputWindow{start: opts.skipBytes, length: -1}.slice(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		if payload, err = emptyInput(inputJSON, opts); err != nil {
+			return nil, err
+		}
+	}
+	docs, decodeErr, err := decodePayload(payload, opts.skipBytes, inputJSON, opts)
+	if err != nil {
+		return nil, err
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("invalid BONJSON: %w", decodeErr)
+	}
+	return docs, nil
+}
+
+// encodeBuffer transforms decoded documents and encodes them in memory, as a
+// conversion to stdout would write them.
+func encodeBuffer(docs []any, outputJSON bool, opts *options) ([]byte, error) {
+	docs, err := transformDocuments(docs, "-", opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.targetProfile != nil {
+		if err := checkTargetProfile(os.Stderr, docs, opts.targetProfile); err != nil {
+			return nil, err
+		}
+	}
+	output, err := encodeOutput(docs, outputJSON, opts)
+	if err == nil && outputJSON && opts.outputFormat == "" && !opts.stream {
+		output = append(output, '\n')
+	}
+	return output, err
+}
@@ -0,0 +1,311 @@
+// ABOUTME: The format registry: each format's name, media type, and file extensions.
+// ABOUTME: Shared by extension-based format selection, batch output naming, serve mode, and the formats command.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Media types of the formats.
+const (
+	mediaJSON     = "application/json"
+	mediaBONJSON  = "application/bonjson"
+	mediaMarkdown = "text/markdown"
+	mediaCSV      = "text/csv"
+	mediaBontext  = "text/x-bonjson"
+)
+
+// format describes a data format that bonbon reads or writes, and what it
+// can do. The first extension is the one given to output files.
+type format struct {
+	Name       string   `json:"name"`
+	MediaType  string   `json:"media_type"`
+	Extensions []string `json:"extensions"`
+	Input      bool     `json:"input"`
+	Output     bool     `json:"output"`
+
+	// Streaming is whether --stream reads or writes many documents
+	// incrementally; Binary whether the format is unsafe to print to a
+	// terminal.
+	Streaming bool `json:"streaming"`
+	Binary    bool `json:"binary"`
+
+	// Detection is how reliably the format can be recognized from its
+	// content: "reliable", "heuristic", or "none".
+	Detection string `json:"detection"`
+
+	// Lossiness lists what does not survive a round trip through the format.
+	Lossiness []string `json:"lossiness"`
+
+	// magic is the signature data in the format starts with, if it has one,
+	// and probe reports why sample is not in the format, if it can tell:
+	// sample is all of the data if complete, and otherwise its first bytes.
+	// They are the format's detectors, tried by detectFormats.
+	magic []byte
+	probe func(sample []byte, complete bool) error
+}
+
+// formats is the registry. table and csv are output-only renderings, chosen
+// with --to; bontext is read with --from and written with --to.
+var formats = []format{
+	{
+		Name: "json", MediaType: mediaJSON, Extensions: []string{".json"}, Input: true, Output: true,
+		Streaming: true, Detection: "reliable", probe: probeJSONStructure,
+		Lossiness: []string{
+			"numbers are decoded as 64-bit floats, so integers beyond 2^53 and long decimals are rounded",
+			"duplicate keys: the last value wins",
+			"object key order is not kept (keys are written sorted)",
+		},
+	},
+	{
+		Name: "bonjson", MediaType: mediaBONJSON, Extensions: []string{".boj", ".bonjson"}, Input: true, Output: true,
+		Streaming: true, Binary: true, Detection: "heuristic", probe: probeBONJSONStructure,
+		Lossiness: []string{
+			"typed arrays become plain arrays",
+			"big numbers become strings in JSON output",
+			"NaN and Infinity are rejected unless -f allows or stringifies them",
+			"object key order is not kept (keys are written sorted)",
+		},
+	},
+	{
+		Name: "table", MediaType: mediaMarkdown, Extensions: []string{".md"}, Output: true,
+		Detection: "none",
+		Lossiness: []string{"output only: rows of cells, with nested values as JSON text"},
+	},
+	{
+		Name: "csv", MediaType: mediaCSV, Extensions: []string{".csv"}, Output: true,
+		Detection: "none",
+		Lossiness: []string{"output only: rows of cells, with every value as text and nested values as JSON"},
+	},
+	{
+		Name: "bontext", MediaType: mediaBontext, Extensions: []string{".bontext"}, Input: true, Output: true,
+		Detection: "none",
+		Lossiness: []string{
+			"none in the text itself: every token keeps its encoding, so the BONJSON it holds is rebuilt byte for byte",
+			"as with BONJSON input, a conversion decodes the documents, so records and typed arrays become plain values",
+		},
+	},
+}
+
+// formatByName returns the registered format with the given name, or nil.
+func formatByName(name string) *format {
+	for i := range formats {
+		if formats[i].Name == name {
+			return &formats[i]
+		}
+	}
+	return nil
+}
+
+// formatForPath returns the format that filename's extension names, or nil.
+func formatForPath(filename string) *format {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for i := range formats {
+		for _, e := range formats[i].Extensions {
+			if e == ext {
+				return &formats[i]
+			}
+		}
+	}
+	return nil
+}
+
+// formatForMediaType returns the format of a Content-Type or Accept value,
+// ignoring parameters such as charset, or nil.
+func formatForMediaType(value string) *format {
+	mediaType, _, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for i := range formats {
+		if formats[i].MediaType == mediaType {
+			return &formats[i]
+		}
+	}
+	return nil
+}
+
+// foreignSignatures are the magic bytes of formats bonbon does not read,
+// named when input matches no registered format, so that a compressed or
+// otherwise foreign file is reported as what it is.
+var foreignSignatures = []struct {
+	name  string
+	magic []byte
+}{
+	{"gzip-compressed data", []byte{0x1f, 0x8b}},
+	{"zstd-compressed data", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"bzip2-compressed data", []byte("BZh")},
+	{"xz-compressed data", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"a zip archive", []byte("PK\x03\x04")},
+	{"CBOR", []byte{0xd9, 0xd9, 0xf7}},
+}
+
+// preferFormats are the values of --prefer: the formats detection can be
+// told to favor when content is valid in both.
+var preferFormats = []string{"json", "bonjson"}
+
+// defaultDetectBudget is how many bytes of the input detection trial-parses
+// without --detect-budget.
+const defaultDetectBudget = 1 << 20
+
+// detection is a format that data could be in, and the detector that
+// recognized it.
+type detection struct {
+	format *format
+	by     string // "magic bytes", or "structure" of all or part of the data
+}
+
+// detectFormats returns every registered input format that data could be
+// in, most likely first: formats whose magic bytes data starts with, then
+// those whose structure it has, in registry order. JSON text is often valid
+// BONJSON as well (small integers and short strings are single bytes), so
+// JSON comes first; the BONJSON check is lenient, accepting what the
+// decoding options could allow. With --prefer, the preferred format comes
+// first of those matched by structure. Structure is probed by trial-parsing at
+// most opts.detectBudget bytes; data beyond that need only start well. If no
+// format matches, the error says why for each.
+func detectFormats(data []byte, opts *options) ([]detection, error) {
+	budget := opts.detectBudget
+	if budget == 0 {
+		budget = defaultDetectBudget
+	}
+	sample, complete := data, true
+	if int64(len(data)) > budget {
+		sample, complete = data[:budget], false
+	}
+	by := "structure"
+	if !complete {
+		by = fmt.Sprintf("structure of the first %d bytes", budget)
+	}
+	var magic, structural []detection
+	var reasons []string
+	for i := range formats {
+		f := &formats[i]
+		switch {
+		case !f.Input:
+		case f.magic != nil && bytes.HasPrefix(data, f.magic):
+			magic = append(magic, detection{f, "magic bytes"})
+		case f.probe != nil:
+			if err := f.probe(sample, complete); err != nil {
+				reasons = append(reasons, fmt.Sprintf("%s (%v)", f.Name, err))
+			} else {
+				structural = append(structural, detection{f, by})
+			}
+		}
+	}
+	if i := slices.IndexFunc(structural, func(d detection) bool { return d.format.Name == opts.prefer }); i > 0 {
+		preferred := structural[i]
+		structural = slices.Insert(slices.Delete(structural, i, i+1), 0, preferred)
+	}
+	if found := append(magic, structural...); len(found) > 0 {
+		return found, nil
+	}
+	for _, sig := range foreignSignatures {
+		if bytes.HasPrefix(data, sig.magic) {
+			return nil, fmt.Errorf("%s, which bonbon does not read", sig.name)
+		}
+	}
+	return nil, fmt.Errorf("not %s", strings.Join(reasons, " nor "))
+}
+
+// detectFormat returns the most likely input format of data, judged by its
+// content rather than a file name.
+func detectFormat(data []byte, opts *options) (*format, error) {
+	found, err := detectFormats(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return found[0].format, nil
+}
+
+// probeJSONStructure reports why sample is not JSON documents, or the start
+// of them if it is not complete, if it is not.
+func probeJSONStructure(sample []byte, complete bool) error {
+	if complete {
+		_, err := decodeJSON(sample, &options{stream: true})
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(sample))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// probeBONJSONStructure reports why sample is not BONJSON documents, or the
+// start of them if it is not complete, under the most lenient decoding
+// options, if it is not.
+func probeBONJSONStructure(sample []byte, complete bool) error {
+	lenient := &options{stream: true, allowNUL: true, dupKeyMode: "keeplast", utf8Mode: "ignore", nanInfMode: "allow"}
+	_, _, err := decodeBONJSON(sample, lenient)
+	if !complete && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return nil
+	}
+	return err
+}
+
+// outputFormat returns the format a conversion writes: the --to rendering if
+// one is selected, and otherwise JSON or BONJSON.
+func outputFormat(outputJSON bool, opts *options) *format {
+	switch {
+	case opts.outputFormat != "":
+		return formatByName(opts.outputFormat)
+	case outputJSON:
+		return formatByName("json")
+	default:
+		return formatByName("bonjson")
+	}
+}
+
+// runFormats prints the format registry with each format's capabilities, as
+// a JSON array with --json.
+func runFormats(opts *options) error {
+	if opts.printJSON {
+		output, err := json.Marshal(formats)
+		if err != nil {
+			return fmt.Errorf("encoding JSON: %w", err)
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+	for i, f := range formats {
+		if i > 0 {
+			fmt.Println()
+		}
+		use := "input, output"
+		if !f.Input {
+			use = "output (--to " + f.Name + ")"
+		}
+		fmt.Printf("%s (%s; %s)\n", f.Name, f.MediaType, strings.Join(f.Extensions, " "))
+		fmt.Printf("  use:        %s\n", use)
+		fmt.Printf("  streaming:  %s\n", yesNo(f.Streaming))
+		fmt.Printf("  binary:     %s\n", yesNo(f.Binary))
+		fmt.Printf("  detection:  %s\n", f.Detection)
+		for j, note := range f.Lossiness {
+			label := ""
+			if j == 0 {
+				label = "lossiness:"
+			}
+			fmt.Printf("  %-11s %s\n", label, note)
+		}
+	}
+	return nil
+}
+
+// yesNo formats a capability flag.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
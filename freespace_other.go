@@ -0,0 +1,13 @@
+// ABOUTME: Free disk space of a directory where statfs is unavailable.
+// ABOUTME: Reports it as unsupported, so the doctor command skips the check.
+
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+// freeSpace is not implemented on this platform.
+func freeSpace(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
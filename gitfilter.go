@@ -0,0 +1,92 @@
+// ABOUTME: The git integration modes: --git-textconv for diffs, --git-clean and --git-smudge for filters.
+// ABOUTME: Lets repositories store BONJSON while diffs and working trees show JSON.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// runGitFilter runs the git helper selected by opts.gitMode and returns the
+// exit code, 0 on success and 1 otherwise; git treats any other output than
+// the converted content as part of it, so diagnostics go to stderr only.
+//
+//   - textconv converts the file named by the single argument, JSON or
+//     BONJSON, to indented JSON on stdout, for diff.NAME.textconv.
+//   - clean converts JSON on stdin to BONJSON on stdout, for
+//     filter.NAME.clean, so the repository stores BONJSON.
+//   - smudge converts BONJSON on stdin to indented JSON on stdout, for
+//     filter.NAME.smudge, so the working tree holds JSON.
+//
+// clean passes BONJSON through unchanged and smudge passes JSON through
+// unchanged, so that content committed before the filter was set up, or
+// converted by hand, survives either way. Empty input gives empty output.
+// Input is read as a document stream, whatever --stream says. clean and
+// smudge accept a file name argument (git's %f) and ignore it.
+func runGitFilter(args []string, opts *options) int {
+	if opts.writesFiles() || opts.windowed() {
+		fmt.Fprintln(os.Stderr, "Error: git modes cannot be combined with options that write files or read input windows")
+		return 1
+	}
+	if option := opts.bufferUnsupported(); option != "" {
+		fmt.Fprintf(os.Stderr, "Error: git modes cannot be combined with %s\n", option)
+		return 1
+	}
+	if opts.expectSHA256 != "" {
+		fmt.Fprintln(os.Stderr, "Error: git modes cannot be combined with --expect-sha256")
+		return 1
+	}
+	var data []byte
+	var err error
+	if opts.gitMode == "textconv" {
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "Error: --git-textconv takes a file name")
+			return 1
+		}
+		data, err = os.ReadFile(args[0])
+	} else {
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "Error: --git-%s reads stdin and takes at most a file name\n", opts.gitMode)
+			return 1
+		}
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: reading input: %v\n", err)
+		return 1
+	}
+	if len(data) == 0 {
+		return 0
+	}
+
+	f, err := detectFormat(data, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: input is %v\n", err)
+		return 1
+	}
+	outputJSON := opts.gitMode != "clean"
+	if (f.Name == "json") == outputJSON && opts.gitMode != "textconv" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: writing output: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	streamed := *opts
+	streamed.stream = true
+	docs, err := decodeBuffer(data, f.Name == "json", &streamed)
+	if err == nil {
+		data, err = encodeBuffer(docs, outputJSON, &streamed)
+	}
+	if err == nil {
+		_, err = os.Stdout.Write(data)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -4,4 +4,4 @@ go 1.25.5
 
 require github.com/kstenerud/go-bonjson v0.0.0-20260213181334-e5a773df23f2
 
-require golang.org/x/text v0.33.0 // indirect
+require golang.org/x/text v0.33.0
@@ -0,0 +1,290 @@
+// ABOUTME: Secondary indexes on a document field of a multi-document BONJSON file.
+// ABOUTME: Implements the index command (build, get) with an on-disk hash table for direct lookup.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An index file is laid out as:
+//
+//	header   indexMagic, then as little-endian uint64s the slot count, the
+//	         size of the indexed stream, and the length of the indexed path
+//	path     the indexed path, such as $.id
+//	slots    an open-addressing hash table of indexSlotSize-byte slots, each
+//	         holding the key hash, document offset, and document length
+//
+// A lookup hashes the key, reads the slots from its home slot onward until
+// it reaches an empty one (length 0), and decodes each candidate document to
+// confirm that its key matches, so a lookup reads a handful of slots and
+// documents however large the stream is.
+const indexMagic = "BONBIDX1"
+
+const (
+	indexHeaderSize = len(indexMagic) + 3*8
+	indexSlotSize   = 3 * 8
+)
+
+// indexEntry locates one document and the hash of its key.
+type indexEntry struct {
+	hash   uint64
+	offset int64
+	length int64
+}
+
+// runIndex implements the index subcommands:
+//
+//	index build STREAM --path PATH [--out FILE]
+//	index get STREAM --id VALUE [--index FILE] [OUTPUT]
+//
+// The index file defaults to the stream's name with the extension .idx.
+func runIndex(args []string, opts *options) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: index build|get STREAM ...")
+	}
+	streamPath := args[1]
+	if isJSONPath(streamPath) {
+		return fmt.Errorf("index requires BONJSON input")
+	}
+	switch args[0] {
+	case "build":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: index build STREAM --path PATH [--out FILE]")
+		}
+		if opts.indexPath == nil {
+			return fmt.Errorf("index build requires --path")
+		}
+		indexFile := opts.outFile
+		if indexFile == "" {
+			indexFile = defaultIndexFile(streamPath)
+		}
+		return buildIndex(streamPath, indexFile, opts)
+	case "get":
+		if len(args) > 3 {
+			return fmt.Errorf("usage: index get STREAM --id VALUE [--index FILE] [OUTPUT]")
+		}
+		if opts.indexID == nil {
+			return fmt.Errorf("index get requires --id")
+		}
+		indexFile := opts.indexFile
+		if indexFile == "" {
+			indexFile = defaultIndexFile(streamPath)
+		}
+		outputPath := ""
+		if len(args) == 3 {
+			outputPath = args[2]
+		}
+		return getIndexedDocuments(streamPath, indexFile, *opts.indexID, outputPath, opts)
+	default:
+		return fmt.Errorf("unknown index command: %s (expected build or get)", args[0])
+	}
+}
+
+// defaultIndexFile returns streamPath with its extension replaced by .idx.
+func defaultIndexFile(streamPath string) string {
+	return strings.TrimSuffix(streamPath, filepath.Ext(streamPath)) + ".idx"
+}
+
+// indexKey returns the lookup key of a value: the string itself for strings,
+// and the JSON encoding for numbers and booleans, so that --id 12345 finds
+// both 12345 and "12345". Null, arrays, and objects cannot be keys.
+func indexKey(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case nil, []any, map[string]any:
+		return "", false
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+func hashIndexKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// buildIndex indexes every document of the BONJSON stream at streamPath by
+// the value at opts.indexPath and writes the index to indexFile. Documents
+// without a value at the path are left out and counted on stderr.
+func buildIndex(streamPath, indexFile string, opts *options) error {
+	f, err := os.Open(streamPath)
+	if err != nil {
+		return fmt.Errorf("reading input file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []indexEntry
+	missing := 0
+	dec := newBONJSONDecoder(bufio.NewReader(f), opts)
+	for seq := 0; ; seq++ {
+		start := dec.InputOffset()
+		var value any
+		if err := dec.Decode(&value); err != nil {
+			if err == io.EOF && dec.InputOffset() == start {
+				break
+			}
+			return fmt.Errorf("invalid BONJSON: document %d: %w", seq, err)
+		}
+		field, ok := lookupPath(value, opts.indexPath)
+		if !ok || field == nil {
+			missing++
+			continue
+		}
+		key, ok := indexKey(field)
+		if !ok {
+			return fmt.Errorf("document %d: value at %s is not a string, number, or boolean", seq, opts.indexPath)
+		}
+		entries = append(entries, indexEntry{hash: hashIndexKey(key), offset: start, length: dec.InputOffset() - start})
+	}
+	streamSize := dec.InputOffset()
+	if streamSize == 0 {
+		return fmt.Errorf("input is empty")
+	}
+	if missing > 0 {
+		fmt.Fprintf(os.Stderr, "%d documents have no value at %s\n", missing, opts.indexPath)
+	}
+
+	// Keep the table at most half full so probe sequences stay short.
+	slotCount := uint64(1)
+	for slotCount < uint64(2*len(entries)) {
+		slotCount <<= 1
+	}
+	slots := make([]indexEntry, slotCount)
+	for _, e := range entries {
+		i := e.hash & (slotCount - 1)
+		for slots[i].length != 0 {
+			i = (i + 1) & (slotCount - 1)
+		}
+		slots[i] = e
+	}
+
+	indexedPath := opts.indexPath.String()
+	buf := make([]byte, 0, indexHeaderSize+len(indexedPath)+len(slots)*indexSlotSize)
+	buf = append(buf, indexMagic...)
+	buf = binary.LittleEndian.AppendUint64(buf, slotCount)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(streamSize))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(indexedPath)))
+	buf = append(buf, indexedPath...)
+	for _, s := range slots {
+		buf = binary.LittleEndian.AppendUint64(buf, s.hash)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(s.offset))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(s.length))
+	}
+	if err := writeFileAtomic(indexFile, buf); err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+	return nil
+}
+
+// getIndexedDocuments looks up the documents whose indexed value is id and
+// writes them to outputPath, as BONJSON if the name is *.boj or *.bonjson
+// and as JSON otherwise. It fails if no document matches.
+func getIndexedDocuments(streamPath, indexFile, id, outputPath string, opts *options) error {
+	idx, err := os.Open(indexFile)
+	if err != nil {
+		return fmt.Errorf("opening index: %w", err)
+	}
+	defer idx.Close()
+	stream, err := os.Open(streamPath)
+	if err != nil {
+		return fmt.Errorf("reading input file: %w", err)
+	}
+	defer stream.Close()
+
+	header := make([]byte, indexHeaderSize)
+	if _, err := io.ReadFull(idx, header); err != nil || string(header[:len(indexMagic)]) != indexMagic {
+		return fmt.Errorf("%s is not a bonbon index", indexFile)
+	}
+	slotCount := binary.LittleEndian.Uint64(header[8:])
+	streamSize := int64(binary.LittleEndian.Uint64(header[16:]))
+	pathLength := binary.LittleEndian.Uint64(header[24:])
+	if slotCount == 0 || slotCount&(slotCount-1) != 0 || pathLength > 1<<16 {
+		return fmt.Errorf("%s: corrupt index header", indexFile)
+	}
+	if info, err := stream.Stat(); err != nil {
+		return fmt.Errorf("reading input file: %w", err)
+	} else if info.Size() != streamSize {
+		return fmt.Errorf("index %s is stale: built for %d bytes of input, %s has %d", indexFile, streamSize, streamPath, info.Size())
+	}
+	rawPath := make([]byte, pathLength)
+	if _, err := io.ReadFull(idx, rawPath); err != nil {
+		return fmt.Errorf("%s: corrupt index header", indexFile)
+	}
+	indexedPath, err := parsePath(string(rawPath))
+	if err != nil {
+		return fmt.Errorf("%s: corrupt index header: %w", indexFile, err)
+	}
+	slotsStart := int64(indexHeaderSize) + int64(pathLength)
+
+	var matches [][]byte
+	var values []any
+	hash := hashIndexKey(id)
+	slot := make([]byte, indexSlotSize)
+	for i, probes := hash&(slotCount-1), uint64(0); probes < slotCount; i, probes = (i+1)&(slotCount-1), probes+1 {
+		if _, err := idx.ReadAt(slot, slotsStart+int64(i)*int64(indexSlotSize)); err != nil {
+			return fmt.Errorf("reading index: %w", err)
+		}
+		length := int64(binary.LittleEndian.Uint64(slot[16:]))
+		if length == 0 {
+			break
+		}
+		if binary.LittleEndian.Uint64(slot) != hash {
+			continue
+		}
+		offset := int64(binary.LittleEndian.Uint64(slot[8:]))
+		if offset < 0 || length > streamSize-offset {
+			return fmt.Errorf("%s: corrupt index entry", indexFile)
+		}
+		doc := make([]byte, length)
+		if _, err := stream.ReadAt(doc, offset); err != nil {
+			return fmt.Errorf("reading document at offset %d: %w", offset, err)
+		}
+		docs, _, err := decodeBONJSON(doc, opts)
+		if err != nil {
+			return fmt.Errorf("document at offset %d: invalid BONJSON: %w", offset, err)
+		}
+		// Distinct keys can share a hash, so confirm the match.
+		if field, ok := lookupPath(docs[0], indexedPath); ok {
+			if key, ok := indexKey(field); ok && key == id {
+				matches = append(matches, doc)
+				values = append(values, docs[0])
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no document with %s = %s", indexedPath, id)
+	}
+
+	if isBONJSONPath(outputPath) {
+		var output []byte
+		for _, doc := range matches {
+			output = append(output, doc...)
+		}
+		return writeOutput(output, outputPath, false)
+	}
+	streamed := *opts
+	streamed.stream = len(values) > 1
+	encoded, err := encodeDocuments(values, true, &streamed)
+	if err != nil {
+		return err
+	}
+	var output []byte
+	for _, doc := range encoded {
+		output = append(output, doc...)
+	}
+	return writeOutput(output, outputPath, true)
+}
@@ -0,0 +1,53 @@
+// ABOUTME: The inspect command: reports wire-level features of BONJSON input that decoding would reject.
+// ABOUTME: With --reserved, finds reserved or misplaced type codes, for input from encoders of newer spec versions.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runInspect scans the BONJSON input at inputPath with the wire scanner and
+// reports, to stdout, the first value whose type code is reserved or out of
+// place, with its document, offset, and path. A value with such a type code
+// has no known size, so nothing after it can be located, and scanning stops
+// there. It fails if such a type code is found.
+func runInspect(inputPath string, opts *options) error {
+	var r io.Reader = os.Stdin
+	if inputPath != "-" {
+		f, err := os.Open(inputPath)
+		if err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	scanner := newWireScanner(r, 0, func(scannedValue) {})
+	for document := int64(0); ; document++ {
+		err := scanner.scanDocument()
+		if err == io.EOF {
+			return nil
+		}
+		var codeErr *typeCodeError
+		if errors.As(err, &codeErr) {
+			what := "unexpected"
+			if codeErr.reserved() {
+				what = "reserved"
+			}
+			fmt.Printf("document %d: offset %d: %s: %s type code 0x%02x\n", document, codeErr.offset, codeErr.path, what, codeErr.code)
+			return fmt.Errorf("found a %s type code; the input after it cannot be scanned", what)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid BONJSON: document %d: %w", document, err)
+		}
+		if !opts.stream {
+			if !scanner.atEOF() {
+				return fmt.Errorf("invalid BONJSON: trailing data at offset %d", scanner.offset)
+			}
+			return nil
+		}
+	}
+}
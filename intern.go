@@ -0,0 +1,32 @@
+// ABOUTME: Object key interning for decoded BONJSON documents.
+// ABOUTME: Repeated keys across objects end up sharing a single string allocation.
+
+package main
+
+// keyInterner maps each distinct object key seen so far to the one instance
+// of it that decoded documents share. It is not safe for concurrent use.
+type keyInterner map[string]string
+
+// internKeys replaces every object key in v, at any depth, with the shared
+// instance from the interner, so that the copies the decoder allocated for
+// each object can be garbage collected. On logs and other arrays of similar
+// objects this collapses millions of duplicate keys to a handful of strings.
+func (in keyInterner) internKeys(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, elem := range v {
+			shared, ok := in[key]
+			if !ok {
+				in[key] = key
+			} else {
+				// Storing to an existing key replaces the key the map holds.
+				v[shared] = elem
+			}
+			in.internKeys(elem)
+		}
+	case []any:
+		for _, elem := range v {
+			in.internKeys(elem)
+		}
+	}
+}
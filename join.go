@@ -0,0 +1,105 @@
+// ABOUTME: The join command: combines the documents of two streams that share a key.
+// ABOUTME: Enriches a large stream with reference data held in memory from a smaller one.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runJoin joins the documents of the stream at leftPath with those of the
+// stream at rightPath whose values at opts.joinOn match, each stream JSON
+// or BONJSON by file extension, and writes the combined documents to
+// opts.outFile, as BONJSON if it is *.boj or *.bonjson and as JSON
+// otherwise, or to stdout as JSON if it is not set.
+//
+// The right stream is held in memory; the left one is streamed, and the
+// output follows its order. A combined document is the right document with
+// the left one deep-merged over it, so the left side's values win. A left
+// document matching several right ones gives one combined document for
+// each. With an inner join, left documents without a match are dropped;
+// with a left join, they are written as they are. Values match as for
+// index get: strings by content and numbers and booleans by their JSON
+// encoding, so 12 matches "12"; null, arrays, objects, and missing values
+// match nothing.
+func runJoin(leftPath, rightPath string, opts *options) error {
+	rightFile, err := os.Open(rightPath)
+	if err != nil {
+		return fmt.Errorf("reading right input: %w", err)
+	}
+	defer rightFile.Close()
+	right := make(map[string][]any)
+	err = decodeStream(bufio.NewReader(rightFile), !isBONJSONPath(rightPath), opts, func(seq int, doc any) bool {
+		if field, ok := lookupPath(doc, opts.joinOn); ok {
+			if key, ok := indexKey(field); ok {
+				right[key] = append(right[key], doc)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("reading right input: %w", err)
+	}
+
+	var in io.Reader = os.Stdin
+	if leftPath != "-" {
+		f, err := os.Open(leftPath)
+		if err != nil {
+			return fmt.Errorf("reading left input: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	progress.begin(leftPath)
+
+	outPath := opts.outFile
+	if outPath == "" {
+		outPath = "-"
+	}
+	outputJSON := !isBONJSONPath(outPath)
+	out := &lazyOutput{path: outPath}
+	defer out.Close()
+	streamed := *opts
+	streamed.stream = true
+	var writeErr error
+	write := func(doc any) bool {
+		encoded, err := encodeDocument(doc, outputJSON, &streamed)
+		if err == nil {
+			_, err = out.Write(encoded)
+		}
+		if err != nil {
+			writeErr = fmt.Errorf("writing output: %w", err)
+			return false
+		}
+		progress.documents.Add(1)
+		return true
+	}
+
+	err = decodeStream(bufio.NewReader(progressReader{in}), !isBONJSONPath(leftPath), opts, func(seq int, doc any) bool {
+		var matches []any
+		if field, ok := lookupPath(doc, opts.joinOn); ok {
+			if key, ok := indexKey(field); ok {
+				matches = right[key]
+			}
+		}
+		if len(matches) == 0 {
+			return opts.joinType != "left" || write(doc)
+		}
+		for _, match := range matches {
+			if !write(deepMerge(match, doc, false)) {
+				return false
+			}
+		}
+		return true
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return fmt.Errorf("reading left input: %w", err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,79 @@
+// ABOUTME: The --uint64 option: what JSON output does with integers JavaScript cannot hold exactly.
+// ABOUTME: Large IDs can be written as strings, clamped with a warning, or refused, instead of being rounded by consumers.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+)
+
+// uint64Modes are the values of --uint64.
+var uint64Modes = []string{"string", "clamp", "error"}
+
+// maxSafeInteger is the largest integer a JavaScript number holds exactly,
+// 2^53-1.
+const maxSafeInteger = 1<<53 - 1
+
+// limitIntegers applies the --uint64 mode to each integer within v beyond
+// JavaScript's safe range of +/-(2^53-1): "string" writes its digits as a
+// string, "clamp" replaces it with the nearest safe integer and warns on
+// stderr, and "error" fails. at is v's path, for messages.
+func limitIntegers(v any, at path, mode string) (any, error) {
+	var n *big.Int
+	switch v := v.(type) {
+	case int64:
+		if v >= -maxSafeInteger && v <= maxSafeInteger {
+			return v, nil
+		}
+		n = big.NewInt(v)
+	case uint64:
+		if v <= maxSafeInteger {
+			return v, nil
+		}
+		n = new(big.Int).SetUint64(v)
+	case *big.Int:
+		if v.IsInt64() && v.Int64() >= -maxSafeInteger && v.Int64() <= maxSafeInteger {
+			return v, nil
+		}
+		n = v
+	case map[string]any:
+		// Members are visited in sorted order, so that clamp warnings and
+		// the value refused come out the same from run to run.
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			member := v[key]
+			limited, err := limitIntegers(member, append(at, pathSegment{key: key}), mode)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = limited
+		}
+		return v, nil
+	case []any:
+		for i, elem := range v {
+			limited, err := limitIntegers(elem, append(at, pathSegment{index: i, isIndex: true}), mode)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = limited
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+
+	switch mode {
+	case "string":
+		return n.String(), nil
+	case "clamp":
+		clamped := int64(maxSafeInteger)
+		if n.Sign() < 0 {
+			clamped = -maxSafeInteger
+		}
+		warnf("%s: %s clamped to %d", at, n, clamped)
+		return clamped, nil
+	}
+	return nil, fmt.Errorf("%s: %s is beyond the integers JavaScript holds exactly (+/-2^53-1)", at, n)
+}
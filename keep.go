@@ -0,0 +1,155 @@
+// ABOUTME: Allowlist projection during conversion (--keep): only the listed paths of each document survive.
+// ABOUTME: A * in a path matches any member or element, so $.metrics.* keeps every metric.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// keepNode is a node of the trie of --keep paths. A leaf keeps the whole
+// value it is reached at.
+type keepNode struct {
+	leaf     bool
+	keys     map[string]*keepNode
+	indexes  map[int]*keepNode
+	wildcard *keepNode
+}
+
+// addKeepPaths parses a --keep value, a comma-separated list of paths, into
+// the trie rooted at root. A segment .* or [*] matches any member of an
+// object and any element of an array.
+func addKeepPaths(root *keepNode, spec string) error {
+	for _, s := range splitPathList(spec) {
+		p, err := parsePath(strings.ReplaceAll(strings.TrimSpace(s), "[*]", ".*"))
+		if err != nil {
+			return err
+		}
+		if len(p) == 0 {
+			return fmt.Errorf("invalid --keep path %q: keeping $ keeps everything", s)
+		}
+		node := root
+		for _, seg := range p {
+			switch {
+			case seg.isIndex:
+				if node.indexes == nil {
+					node.indexes = make(map[int]*keepNode)
+				}
+				if node.indexes[seg.index] == nil {
+					node.indexes[seg.index] = &keepNode{}
+				}
+				node = node.indexes[seg.index]
+			case seg.key == "*":
+				if node.wildcard == nil {
+					node.wildcard = &keepNode{}
+				}
+				node = node.wildcard
+			default:
+				if node.keys == nil {
+					node.keys = make(map[string]*keepNode)
+				}
+				if node.keys[seg.key] == nil {
+					node.keys[seg.key] = &keepNode{}
+				}
+				node = node.keys[seg.key]
+			}
+		}
+		node.leaf = true
+	}
+	return nil
+}
+
+// splitPathList splits a comma-separated list of paths, ignoring commas
+// inside quoted keys.
+func splitPathList(s string) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// keepPaths returns the projection of the document v onto the paths of the
+// trie root: the values at the paths, within the objects and arrays that
+// lead to them. Array elements that are not kept are dropped, so later
+// elements move up. A document with nothing to keep becomes an empty object
+// or array, or null if it is a scalar.
+func keepPaths(v any, root *keepNode) any {
+	if kept, ok := projectKept(v, []*keepNode{root}); ok {
+		return kept
+	}
+	switch v.(type) {
+	case map[string]any:
+		return map[string]any{}
+	case []any:
+		return []any{}
+	}
+	return nil
+}
+
+// projectKept projects v onto the union of the tries nodes, reporting false
+// if nothing of v is kept.
+func projectKept(v any, nodes []*keepNode) (any, bool) {
+	for _, node := range nodes {
+		if node.leaf {
+			return v, true
+		}
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		kept := make(map[string]any)
+		for key, member := range v {
+			var next []*keepNode
+			for _, node := range nodes {
+				if child := node.keys[key]; child != nil {
+					next = append(next, child)
+				}
+				if node.wildcard != nil {
+					next = append(next, node.wildcard)
+				}
+			}
+			if len(next) == 0 {
+				continue
+			}
+			if projected, ok := projectKept(member, next); ok {
+				kept[key] = projected
+			}
+		}
+		return kept, len(kept) > 0
+	case []any:
+		var kept []any
+		for i, elem := range v {
+			var next []*keepNode
+			for _, node := range nodes {
+				if child := node.indexes[i]; child != nil {
+					next = append(next, child)
+				}
+				if node.wildcard != nil {
+					next = append(next, node.wildcard)
+				}
+			}
+			if len(next) == 0 {
+				continue
+			}
+			if projected, ok := projectKept(elem, next); ok {
+				kept = append(kept, projected)
+			}
+		}
+		return kept, len(kept) > 0
+	}
+	return nil, false
+}
@@ -0,0 +1,120 @@
+// ABOUTME: Key case conversion applied during conversion (--keys).
+// ABOUTME: Rewrites every object key to snake_case, camelCase, kebab-case, or lowercase.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// keyCases are the key case styles --keys accepts.
+var keyCases = []string{"snake", "camel", "kebab", "lower"}
+
+// convertKeyCase rewrites every object key within v, at any depth, in the
+// style style. It is an error for two keys of one object to convert to the
+// same key, as it is for --rename.
+func convertKeyCase(v any, at path, style string) error {
+	switch v := v.(type) {
+	case map[string]any:
+		converted := make(map[string]string, len(v))
+		for key := range v {
+			if to := keyInCase(key, style); to != key {
+				converted[key] = to
+			}
+		}
+		// Keys are visited in sorted order, so that the collision reported
+		// is the same from run to run.
+		for _, from := range slices.Sorted(maps.Keys(converted)) {
+			to := converted[from]
+			if _, exists := v[to]; exists && converted[to] == "" {
+				return fmt.Errorf("cannot convert %q to %q at %s: key already exists", from, to, at)
+			}
+		}
+		moved := make(map[string]any, len(converted))
+		for _, from := range slices.Sorted(maps.Keys(converted)) {
+			to := converted[from]
+			if _, exists := moved[to]; exists {
+				return fmt.Errorf("cannot convert %q to %q at %s: another key converts to it too", from, to, at)
+			}
+			moved[to] = v[from]
+			delete(v, from)
+		}
+		for key, elem := range moved {
+			v[key] = elem
+		}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			if err := convertKeyCase(v[key], append(at, pathSegment{key: key}), style); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, elem := range v {
+			if err := convertKeyCase(elem, append(at, pathSegment{index: i, isIndex: true}), style); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// keyInCase returns key in the style style. Leading underscores, hyphens,
+// and spaces are kept, so that keys such as _id keep their meaning.
+func keyInCase(key, style string) string {
+	if style == "lower" {
+		return strings.ToLower(key)
+	}
+	rest := strings.TrimLeft(key, "_- ")
+	prefix := key[:len(key)-len(rest)]
+	words := keyWords(rest)
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if style == "camel" && i > 0 {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			word = string(runes)
+		}
+		words[i] = word
+	}
+	switch style {
+	case "snake":
+		return prefix + strings.Join(words, "_")
+	case "kebab":
+		return prefix + strings.Join(words, "-")
+	}
+	return prefix + strings.Join(words, "")
+}
+
+// keyWords splits a key into words at underscores, hyphens, and spaces, and
+// where the case changes: before an upper case letter that follows a lower
+// case letter or digit, and before the last letter of a run of upper case
+// letters that is followed by a lower case one, so HTTPServer is HTTP and
+// Server.
+func keyWords(key string) []string {
+	var words []string
+	runes := []rune(key)
+	start := 0
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ':
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+		case i > start && unicode.IsUpper(r):
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
@@ -0,0 +1,267 @@
+// ABOUTME: The lint-expr command: dry-runs transform options and JSON Patch files against a sample document.
+// ABOUTME: Reports paths that select nothing and values of the wrong type, before the transforms run in production.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// lintStages are the transform options lint-expr checks, in the order the
+// transforms run, so each is checked against the sample as the earlier ones
+// leave it: a --scale may name a key that a --rename introduces.
+var lintStages = [][]string{
+	{"--keep"},
+	{"--rename", "--rename-file"},
+	{"--scale"},
+	{"--redact-rules"},
+	{"--field"},
+	{"--encrypt-paths"},
+}
+
+// runLintExpr implements the lint-expr command. It checks the transform
+// options given with it, and the JSON Patch files patchPaths, against the
+// document in opts.lintSample, prints each problem to stdout, and fails if
+// there are any. The options themselves were already parsed, so only
+// well-formed ones reach it.
+func runLintExpr(patchPaths []string, opts *options) error {
+	sample, err := loadDocument(opts.lintSample)
+	if err != nil {
+		return fmt.Errorf("reading sample: %w", err)
+	}
+	var problems, checked int
+	report := func(expr, format string, args ...any) {
+		problems++
+		fmt.Printf("%s: %s\n", expr, fmt.Sprintf(format, args...))
+	}
+
+	doc := cloneValue(sample)
+	for _, stage := range lintStages {
+		for _, option := range opts.optionArgs {
+			if !slices.Contains(stage, option[0]) {
+				continue
+			}
+			checked++
+			if doc, err = lintOption(doc, option, func(format string, args ...any) {
+				report(strings.Join(option, " "), format, args...)
+			}); err != nil {
+				return err
+			}
+		}
+		if stage[0] == "--keep" && opts.keep != nil {
+			doc = keepPaths(doc, opts.keep)
+		}
+	}
+
+	for _, patchPath := range patchPaths {
+		checked++
+		patch, err := loadDocument(patchPath)
+		if err != nil {
+			return fmt.Errorf("reading patch: %w", err)
+		}
+		ops, ok := patch.([]any)
+		if !ok {
+			report(patchPath, "patch must be an array of operations")
+			continue
+		}
+		// Later operations depend on earlier ones, so checking stops at the
+		// first that fails.
+		doc := cloneValue(sample)
+		for i, op := range ops {
+			if doc, err = applyPatchOp(doc, op); err != nil {
+				report(patchPath, "operation %d: %v", i, err)
+				break
+			}
+		}
+	}
+
+	if checked == 0 {
+		return fmt.Errorf("nothing to check: give transform options or patch files")
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problems in transform expressions", problems)
+	}
+	return nil
+}
+
+// lintOption checks one transform option against doc, reporting each
+// problem, and returns doc as the transform leaves it.
+func lintOption(doc any, option []string, report func(format string, args ...any)) (any, error) {
+	switch option[0] {
+	case "--keep":
+		for _, s := range splitPathList(option[1]) {
+			p, err := parsePath(strings.ReplaceAll(strings.TrimSpace(s), "[*]", ".*"))
+			if err != nil {
+				return nil, err
+			}
+			if !pathMatches(doc, p) {
+				report("%s selects nothing in the sample", strings.TrimSpace(s))
+			}
+		}
+	case "--rename", "--rename-file":
+		var renames []keyRename
+		if option[0] == "--rename" {
+			r, err := parseRename(option[1])
+			if err != nil {
+				return nil, err
+			}
+			renames = []keyRename{r}
+		} else {
+			var err error
+			if renames, err = loadRenameFile(option[1]); err != nil {
+				return nil, err
+			}
+		}
+		for _, r := range renames {
+			if r.scope != nil {
+				if target, ok := lintLookup(doc, r.scope, "an object", report); ok {
+					if _, ok := target.(map[string]any)[r.from]; !ok {
+						report("%s has no key %q", r.scope, r.from)
+					}
+				}
+			} else if !hasKey(doc, r.from) {
+				report("no object in the sample has the key %q", r.from)
+			}
+			if err := applyRename(doc, r); err != nil {
+				report("%v", err)
+			}
+		}
+	case "--scale":
+		s, err := parseScale(option[1])
+		if err != nil {
+			return nil, err
+		}
+		value, ok := lintLookup(doc, s.target, "", report)
+		if !ok {
+			break
+		}
+		numbers := []any{value}
+		if array, ok := value.([]any); ok {
+			numbers = array
+		}
+		for _, n := range numbers {
+			if _, err := scaleNumber(n, s.factor); err != nil {
+				report("%s is %s, not a number or an array of numbers", s.target, lintTypeName(value))
+				break
+			}
+		}
+		applyScale(doc, s)
+	case "--redact-rules":
+		rules, err := loadRedactRules(option[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			switch {
+			case rule.at != nil:
+				if _, ok := lookupPath(doc, rule.at); !ok {
+					report("rule %q: %s selects nothing in the sample", rule.name, rule.at)
+				}
+			case rule.key != "":
+				if !hasKey(doc, rule.key) {
+					report("rule %q: no object in the sample has the key %q", rule.name, rule.key)
+				}
+			}
+		}
+		doc = redact(doc, rules, make([]int64, len(rules)))
+	case "--field":
+		field, err := parseAnonymizeField(option[1])
+		if err != nil {
+			return nil, err
+		}
+		if field.at != nil {
+			lintLookup(doc, field.at, "", report)
+		} else if !hasKey(doc, field.name) {
+			report("no object in the sample has the key %q", field.name)
+		}
+	case "--encrypt-paths":
+		paths, err := parseEncryptPaths(option[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			lintLookup(doc, p, "", report)
+		}
+	}
+	return doc, nil
+}
+
+// lintLookup returns the value at p within doc, reporting if there is none
+// or, when want is not empty, if it is not of that type.
+func lintLookup(doc any, p path, want string, report func(format string, args ...any)) (any, bool) {
+	value, ok := lookupPath(doc, p)
+	if !ok {
+		report("%s selects nothing in the sample", p)
+		return nil, false
+	}
+	if want != "" && lintTypeName(value) != want {
+		report("%s is %s, not %s", p, lintTypeName(value), want)
+		return nil, false
+	}
+	return value, true
+}
+
+// pathMatches reports whether p, in which a key * matches any member or
+// element, selects a value within v.
+func pathMatches(v any, p path) bool {
+	if len(p) == 0 {
+		return true
+	}
+	seg := p[0]
+	switch v := v.(type) {
+	case map[string]any:
+		if seg.isIndex {
+			return false
+		}
+		if seg.key == "*" {
+			return slices.ContainsFunc(slices.Collect(maps.Values(v)), func(elem any) bool { return pathMatches(elem, p[1:]) })
+		}
+		elem, ok := v[seg.key]
+		return ok && pathMatches(elem, p[1:])
+	case []any:
+		if seg.key == "*" && !seg.isIndex {
+			return slices.ContainsFunc(v, func(elem any) bool { return pathMatches(elem, p[1:]) })
+		}
+		return seg.isIndex && seg.index < len(v) && pathMatches(v[seg.index], p[1:])
+	}
+	return false
+}
+
+// hasKey reports whether any object within v has the key.
+func hasKey(v any, key string) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		if _, ok := v[key]; ok {
+			return true
+		}
+		for _, elem := range v {
+			if hasKey(elem, key) {
+				return true
+			}
+		}
+	case []any:
+		return slices.ContainsFunc(v, func(elem any) bool { return hasKey(elem, key) })
+	}
+	return false
+}
+
+// lintTypeName returns the JSON type of the decoded value v, with an
+// article, for messages.
+func lintTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case string:
+		return "a string"
+	case []any:
+		return "an array"
+	case map[string]any:
+		return "an object"
+	}
+	return "a number"
+}
@@ -0,0 +1,32 @@
+// ABOUTME: Exclusive file locking where flock is unavailable, using a lock file.
+// ABOUTME: Serializes writers that append to the same output file.
+
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockFile blocks until it holds an exclusive lock on f, and returns a
+// function that releases it. The lock is a f.Name()+".lock" file created
+// exclusively, so a process that dies holding it leaves it behind and it has
+// to be removed by hand.
+func lockFile(f *os.File) (func(), error) {
+	lockPath := f.Name() + ".lock"
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			lock.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("locking %s: %w", f.Name(), err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,21 @@
+// ABOUTME: Exclusive file locking on Unix, using flock.
+// ABOUTME: Serializes writers that append to the same output file.
+
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile blocks until it holds an exclusive lock on f, and returns a
+// function that releases it. The lock is released if the process dies.
+func lockFile(f *os.File) (func(), error) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, fmt.Errorf("locking %s: %w", f.Name(), err)
+	}
+	return func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }, nil
+}
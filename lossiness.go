@@ -0,0 +1,204 @@
+// ABOUTME: The --lossiness-report: lists every place where a conversion was lossy or approximate.
+// ABOUTME: Walks the raw input alongside the decoded documents, since decoding hides what changed.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// lossyMapping is one place where the output does not faithfully reproduce
+// the input.
+type lossyMapping struct {
+	document int
+	path     string
+	detail   string
+}
+
+// lossinessReport collects the lossy mappings of a conversion. Paths refer to
+// the input documents, before any transforms.
+type lossinessReport struct {
+	mappings  []lossyMapping
+	documents int
+}
+
+func (r *lossinessReport) add(document int, p path, format string, args ...any) {
+	r.mappings = append(r.mappings, lossyMapping{document: document, path: p.String(), detail: fmt.Sprintf(format, args...)})
+}
+
+// analyze records the lossy mappings in one payload, given the documents it
+// decoded to. Both encoders write object keys in sorted order, so any other
+// input order is reported, as are values that the decoded form or the output
+// format cannot represent exactly.
+func (r *lossinessReport) analyze(payload []byte, docs []any, inputJSON, outputJSON bool, opts *options) {
+	if inputJSON {
+		r.analyzeJSON(payload, len(docs))
+	} else {
+		r.analyzeBONJSON(payload, docs, outputJSON, opts)
+	}
+	r.documents += len(docs)
+}
+
+// analyzeJSON walks the tokens of count JSON documents, reporting numbers
+// that change when decoded to float64, duplicate keys (the last one wins),
+// and objects whose keys are not in sorted order.
+func (r *lossinessReport) analyzeJSON(payload []byte, count int) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	for i := 0; i < count; i++ {
+		if err := r.walkJSON(dec, r.documents+i, nil); err != nil {
+			return
+		}
+	}
+}
+
+func (r *lossinessReport) walkJSON(dec *json.Decoder, document int, p path) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch token := token.(type) {
+	case json.Number:
+		literal := token.String()
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return nil
+		}
+		exact, ok := new(big.Rat).SetString(literal)
+		if ok && exact.Cmp(new(big.Rat).SetFloat64(f)) != 0 {
+			// Decimal fractions like 0.1 are never exact in binary; only
+			// report numbers whose shortest float64 form reads differently.
+			shortest := strconv.FormatFloat(f, 'g', -1, 64)
+			if approx, _ := new(big.Rat).SetString(shortest); approx == nil || exact.Cmp(approx) != 0 {
+				r.add(document, p, "number %s rounded to %s", literal, shortest)
+			}
+		}
+	case json.Delim:
+		switch token {
+		case '[':
+			for index := 0; dec.More(); index++ {
+				if err := r.walkJSON(dec, document, append(p, pathSegment{index: index, isIndex: true})); err != nil {
+					return err
+				}
+			}
+		case '{':
+			seen := make(map[string]bool)
+			var keys []string
+			for dec.More() {
+				keyToken, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key := keyToken.(string)
+				if seen[key] {
+					r.add(document, p, "duplicate key %q: earlier value dropped", key)
+				}
+				seen[key] = true
+				keys = append(keys, key)
+				if err := r.walkJSON(dec, document, append(p, pathSegment{key: key})); err != nil {
+					return err
+				}
+			}
+			r.checkKeyOrder(document, p, keys)
+		}
+		_, err := dec.Token() // closing delimiter
+		return err
+	}
+	return nil
+}
+
+// checkKeyOrder reports an object whose keys were not in the sorted order
+// the encoders write them in.
+func (r *lossinessReport) checkKeyOrder(document int, p path, keys []string) {
+	for i := 1; i < len(keys); i++ {
+		if keys[i] < keys[i-1] {
+			r.add(document, p, "object keys reordered (%s)", strings.Join(keys, ", "))
+			return
+		}
+	}
+}
+
+// analyzeBONJSON scans the documents on the wire, reporting non-finite
+// floats that were stringified, big numbers that JSON output writes as
+// strings, typed arrays (their element type is lost), duplicate keys that
+// were dropped, and objects whose keys are not in sorted order.
+//
+// The scanner visits each value after its nested values, so the members of
+// an object are all visited, in input order, before the object itself.
+func (r *lossinessReport) analyzeBONJSON(payload []byte, docs []any, outputJSON bool, opts *options) {
+	var members [][]string // keys of the object being scanned, by member depth
+	document := r.documents
+	visit := func(v scannedValue) {
+		switch v.kind {
+		case kindKey, kindRecordDef:
+			return
+		case kindObject:
+			if v.depth+1 < len(members) {
+				keys := members[v.depth+1]
+				seen := make(map[string]bool, len(keys))
+				for _, key := range keys {
+					if seen[key] {
+						r.add(document, v.path, "duplicate key %q: one value dropped", key)
+					}
+					seen[key] = true
+				}
+				r.checkKeyOrder(document, v.path, keys)
+				members[v.depth+1] = keys[:0]
+			}
+		case kindFloat:
+			f := decodeRawFloat(v.raw)
+			if (math.IsNaN(f) || math.IsInf(f, 0)) && opts.nanInfMode == "stringify" {
+				r.add(document, v.path, "non-finite float %v written as a string", f)
+			}
+		case kindBigNumber:
+			if value, ok := lookupPath(docs[document-r.documents], v.path); ok && outputJSON {
+				if f, ok := value.(*big.Float); ok {
+					r.add(document, v.path, "big number %s written as a JSON string", f.Text('g', -1))
+				}
+			}
+		case kindTypedArray:
+			r.add(document, v.path, "typed array written as a plain array (element type lost)")
+		}
+		if len(v.path) > 0 && !v.path[len(v.path)-1].isIndex {
+			for len(members) <= v.depth {
+				members = append(members, nil)
+			}
+			members[v.depth] = append(members[v.depth], v.path[len(v.path)-1].key)
+		}
+	}
+
+	scanner := newWireScanner(bytes.NewReader(payload), 0, visit)
+	scanner.captureScalars = true
+	for ; document-r.documents < len(docs); document++ {
+		if err := scanner.scanDocument(); err != nil {
+			return
+		}
+	}
+}
+
+// decodeRawFloat decodes the encoded bytes of a BONJSON float.
+func decodeRawFloat(raw []byte) float64 {
+	switch {
+	case len(raw) == 5 && raw[0] == 0xB0:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(raw[1:])))
+	case len(raw) == 9 && raw[0] == 0xB1:
+		return math.Float64frombits(binary.LittleEndian.Uint64(raw[1:]))
+	}
+	return 0
+}
+
+// print writes the report to w.
+func (r *lossinessReport) print(w io.Writer) {
+	for _, m := range r.mappings {
+		fmt.Fprintf(w, "lossy: document %d: %s: %s\n", m.document, m.path, m.detail)
+	}
+	fmt.Fprintf(w, "%d lossy or approximate mappings in %d documents\n", len(r.mappings), r.documents)
+}
@@ -5,19 +5,26 @@ package main
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"bonbon/codec"
 	"github.com/kstenerud/go-bonjson"
 )
 
 func printUsage() {
-	fmt.Fprintln(os.Stderr, "Usage: bonbon [options] <command> <input> [output]")
-	fmt.Fprintln(os.Stderr, "  Use '-' for stdin/stdout.")
+	fmt.Fprintln(os.Stderr, "Usage: bonbon [options] <command> <input> [output] [options]")
+	fmt.Fprintln(os.Stderr, "  Use '-' for stdin/stdout. If input is a directory, every file in it with")
+	fmt.Fprintln(os.Stderr, "  the input format's extension is converted into the output directory.")
 	fmt.Fprintln(os.Stderr, "Commands:")
 	fmt.Fprintln(os.Stderr, "  j        Validate JSON input (no output)")
 	fmt.Fprintln(os.Stderr, "  b        Validate BONJSON input (no output)")
@@ -25,121 +32,1842 @@ func printUsage() {
 	fmt.Fprintln(os.Stderr, "  j2j      Convert JSON to JSON (reformat)")
 	fmt.Fprintln(os.Stderr, "  b2j      Convert BONJSON to JSON")
 	fmt.Fprintln(os.Stderr, "  b2b      Convert BONJSON to BONJSON (dechunk)")
+	fmt.Fprintln(os.Stderr, "  stats    Report value counts and encoded bytes per type and depth of")
+	fmt.Fprintln(os.Stderr, "           BONJSON input, in one streaming pass (no output file)")
+	fmt.Fprintln(os.Stderr, "  agg      Count documents and --sum numbers of a stream (JSON, or BONJSON")
+	fmt.Fprintln(os.Stderr, "           if *.boj/*.bonjson) in one pass, per --group-by value;")
+	fmt.Fprintln(os.Stderr, "           writes the summary to --out")
+	fmt.Fprintln(os.Stderr, "  sort     Order a stream (JSON, or BONJSON if *.boj/*.bonjson) by the")
+	fmt.Fprintln(os.Stderr, "           value at --by, spilling sorted runs to temporary files when")
+	fmt.Fprintln(os.Stderr, "           it outgrows memory; writes to --out")
+	fmt.Fprintln(os.Stderr, "  join     Combine each document of the first stream with the documents")
+	fmt.Fprintln(os.Stderr, "           of the second whose value at --on matches, the first's values")
+	fmt.Fprintln(os.Stderr, "           winning; --type inner (default) or left; writes to --out")
+	fmt.Fprintln(os.Stderr, "  validate Check each document (JSON, or BONJSON if *.boj/*.bonjson)")
+	fmt.Fprintln(os.Stderr, "           against the JSON Schema in --schema, printing violations;")
+	fmt.Fprintln(os.Stderr, "           BONJSON is checked without being decoded into a tree")
+	fmt.Fprintln(os.Stderr, "  schema compat OLD NEW")
+	fmt.Fprintln(os.Stderr, "           Report the breaking changes from one JSON Schema to the next")
+	fmt.Fprintln(os.Stderr, "           under --mode backward (default), forward, or full")
+	fmt.Fprintln(os.Stderr, "  inspect  With --reserved, report the first reserved or misplaced type")
+	fmt.Fprintln(os.Stderr, "           code in BONJSON input, with its offset and path")
+	fmt.Fprintln(os.Stderr, "  cross-check")
+	fmt.Fprintln(os.Stderr, "           Decode BONJSON input with bonbon and with the --other")
+	fmt.Fprintln(os.Stderr, "           decoder, which prints JSON, and report the first difference")
+	fmt.Fprintln(os.Stderr, "  describe Report the path, type, and value enclosing byte --offset N of")
+	fmt.Fprintln(os.Stderr, "           BONJSON input (no output file)")
+	fmt.Fprintln(os.Stderr, "  anonymize")
+	fmt.Fprintln(os.Stderr, "           Convert, replacing each --field with a deterministic HMAC")
+	fmt.Fprintln(os.Stderr, "           pseudonym keyed by --key-file; formats follow the file")
+	fmt.Fprintln(os.Stderr, "           extensions (*.boj/*.bonjson is BONJSON, stdin/stdout JSON)")
+	fmt.Fprintln(os.Stderr, "  container build INPUT OUTPUT | list FILE | get FILE N [OUTPUT]")
+	fmt.Fprintln(os.Stderr, "           Pack a document stream into an indexed container, list its")
+	fmt.Fprintln(os.Stderr, "           documents, or extract document N (as BONJSON if OUTPUT is")
+	fmt.Fprintln(os.Stderr, "           *.boj/*.bonjson, JSON otherwise) without scanning the others")
+	fmt.Fprintln(os.Stderr, "  container update FILE INPUT | compact FILE")
+	fmt.Fprintln(os.Stderr, "           Bring a container up to date with INPUT, re-encoding only the")
+	fmt.Fprintln(os.Stderr, "           changed subtrees and appending only the changed documents, or")
+	fmt.Fprintln(os.Stderr, "           reclaim the space of documents replaced by updates")
+	fmt.Fprintln(os.Stderr, "  append   Append the documents of the second file (JSON, or BONJSON if")
+	fmt.Fprintln(os.Stderr, "           *.boj/*.bonjson) to the BONJSON stream or container named first")
+	fmt.Fprintln(os.Stderr, "  convert  Convert every input, files and - (stdin) mixed, in argument")
+	fmt.Fprintln(os.Stderr, "           order to one stream (or array, with --wrap-array) at --out;")
+	fmt.Fprintln(os.Stderr, "           formats by extension, or detected for stdin")
+	fmt.Fprintln(os.Stderr, "  combine  Merge every input document (JSON, or BONJSON if *.boj/*.bonjson)")
+	fmt.Fprintln(os.Stderr, "           into one, in order, per --strategy; writes to --out")
+	fmt.Fprintln(os.Stderr, "  delta    Write the JSON Patch (RFC 6902) that turns the first document")
+	fmt.Fprintln(os.Stderr, "           into the second to --out (BONJSON if *.boj/*.bonjson)")
+	fmt.Fprintln(os.Stderr, "  apply    Apply the patch in the second file to the first document")
+	fmt.Fprintln(os.Stderr, "  merge3   Three-way merge: apply the changes from the first (base) to the")
+	fmt.Fprintln(os.Stderr, "           third (theirs) file to the second (ours), writing to --out;")
+	fmt.Fprintln(os.Stderr, "           conflicts become marker objects and make the command fail")
+	fmt.Fprintln(os.Stderr, "  mergetool BASE LOCAL REMOTE MERGED")
+	fmt.Fprintln(os.Stderr, "           merge3 for git mergetool: writes MERGED in its own format")
+	fmt.Fprintln(os.Stderr, "  diff     Compare two files' documents, or two directory trees with files")
+	fmt.Fprintln(os.Stderr, "           paired by relative path whatever their format; exits 0 if the")
+	fmt.Fprintln(os.Stderr, "           same, 1 if different, 2 on errors")
+	fmt.Fprintln(os.Stderr, "  index build STREAM --path PATH | get STREAM --id VALUE [OUTPUT]")
+	fmt.Fprintln(os.Stderr, "           Index a BONJSON stream by the value at PATH, or fetch the")
+	fmt.Fprintln(os.Stderr, "           documents whose value is VALUE without scanning the stream")
+	fmt.Fprintln(os.Stderr, "  lint-expr [PATCH...] --against SAMPLE")
+	fmt.Fprintln(os.Stderr, "           Dry-run the path options given with it (--keep, --rename,")
+	fmt.Fprintln(os.Stderr, "           --scale, --field, ...) and JSON Patch files against SAMPLE,")
+	fmt.Fprintln(os.Stderr, "           reporting paths that select nothing and mistyped values")
+	fmt.Fprintln(os.Stderr, "  history FILE [OUTPUT]")
+	fmt.Fprintln(os.Stderr, "           List the --snapshots of FILE, or with --at TIME write the one")
+	fmt.Fprintln(os.Stderr, "           in effect then (as BONJSON if OUTPUT is *.boj/*.bonjson)")
+	fmt.Fprintln(os.Stderr, "  bench    Benchmark decoding and encoding the input (no output file)")
+	fmt.Fprintln(os.Stderr, "  serve    Serve conversions over HTTP at the address given as input")
+	fmt.Fprintln(os.Stderr, "           (HOST:PORT, or unix:PATH for a Unix socket); see README")
+	fmt.Fprintln(os.Stderr, "  formats  List the formats with their media types, file extensions,")
+	fmt.Fprintln(os.Stderr, "           capabilities, and what a round trip through each loses")
+	fmt.Fprintln(os.Stderr, "  doctor [INPUT]")
+	fmt.Fprintln(os.Stderr, "           Check the terminal, locale, and temporary directory, and probe")
+	fmt.Fprintln(os.Stderr, "           INPUT for format, spec, and size problems, with advice")
+	fmt.Fprintln(os.Stderr, "  examples list | show NAME | write DIR [NAME...]")
+	fmt.Fprintln(os.Stderr, "           List the built-in edge-case examples, print one as JSON, or")
+	fmt.Fprintln(os.Stderr, "           write them to DIR as NAME.json and NAME.boj")
 	fmt.Fprintln(os.Stderr, "Options:")
-	fmt.Fprintln(os.Stderr, "  -d MODE  Duplicate key handling (BONJSON input only):")
-	fmt.Fprintln(os.Stderr, "           reject (default), keepfirst, keeplast")
-	fmt.Fprintln(os.Stderr, "  -e       Print end offset to stderr (BONJSON input only)")
-	fmt.Fprintln(os.Stderr, "  -f MODE  Special float (NaN, Infinity) handling (BONJSON only):")
-	fmt.Fprintln(os.Stderr, "           reject (default), allow, stringify")
-	fmt.Fprintln(os.Stderr, "  -n       Allow NUL characters in strings (BONJSON input only)")
-	fmt.Fprintln(os.Stderr, "  -s N     Skip N bytes before decoding")
-	fmt.Fprintln(os.Stderr, "  -t       Allow trailing data (BONJSON input only)")
-	fmt.Fprintln(os.Stderr, "  -u MODE  Invalid UTF-8 handling (BONJSON input only):")
-	fmt.Fprintln(os.Stderr, "           reject (default), replace, delete, ignore")
+	fmt.Fprintln(os.Stderr, "  -d MODE            Duplicate key handling (BONJSON input only):")
+	fmt.Fprintln(os.Stderr, "                     reject (default), keepfirst, keeplast")
+	fmt.Fprintln(os.Stderr, "  -e                 Print end offset to stderr (BONJSON input only)")
+	fmt.Fprintln(os.Stderr, "  -f MODE            Special float (NaN, Infinity) handling (BONJSON only):")
+	fmt.Fprintln(os.Stderr, "                     reject (default), allow, stringify")
+	fmt.Fprintln(os.Stderr, "  -n                 Allow NUL characters in strings (BONJSON input only)")
+	fmt.Fprintln(os.Stderr, "  -s, --start N      Skip N bytes before decoding; repeat (with --length) to")
+	fmt.Fprintln(os.Stderr, "                     decode several windows of the input as one stream")
+	fmt.Fprintln(os.Stderr, "  -t, --allow-trailing")
+	fmt.Fprintln(os.Stderr, "                     Allow trailing data (BONJSON input only)")
+	fmt.Fprintln(os.Stderr, "  -u MODE            Invalid UTF-8 handling (BONJSON input only):")
+	fmt.Fprintln(os.Stderr, "                     reject (default), replace, delete, ignore")
+	fmt.Fprintln(os.Stderr, "  --against FILE     lint-expr: the sample document to check against")
+	fmt.Fprintln(os.Stderr, "  --allow-params LIST")
+	fmt.Fprintln(os.Stderr, "                     serve: the request parameters clients may set, comma-")
+	fmt.Fprintln(os.Stderr, "                     separated (default: all; empty: none)")
+	fmt.Fprintln(os.Stderr, "  --assert PATH:TYPE Fail at the first input document whose value at PATH is")
+	fmt.Fprintln(os.Stderr, "                     not of TYPE: null, bool, int, number, string, array,")
+	fmt.Fprintln(os.Stderr, "                     object, or array<TYPE>; repeatable")
+	fmt.Fprintln(os.Stderr, "  --at TIME          history: the snapshot in effect at TIME (RFC 3339 or a date)")
+	fmt.Fprintln(os.Stderr, "  --auth-hmac-key-file FILE")
+	fmt.Fprintln(os.Stderr, "                     serve: accept convert requests signed with the HMAC")
+	fmt.Fprintln(os.Stderr, "                     key (16+ bytes) in FILE; see README")
+	fmt.Fprintln(os.Stderr, "  --auth-token-file FILE")
+	fmt.Fprintln(os.Stderr, "                     serve: accept convert requests bearing any token listed")
+	fmt.Fprintln(os.Stderr, "                     in FILE (one per line)")
+	fmt.Fprintln(os.Stderr, "  --baseline FILE    bench: compare results against a saved baseline")
+	fmt.Fprintln(os.Stderr, "  --by PATH          sort: the value to order documents by, such as $.time")
+	fmt.Fprintln(os.Stderr, "  --cache-dir DIR    Cache conversion output in DIR, keyed by a digest of the")
+	fmt.Fprintln(os.Stderr, "                     input, the options, and the bonbon build; a repeated")
+	fmt.Fprintln(os.Stderr, "                     conversion reads its output from there")
+	fmt.Fprintln(os.Stderr, "  --columns LIST     Comma-separated columns for table/CSV output; each is")
+	fmt.Fprintln(os.Stderr, "                     a top-level key or a path such as $.a.b")
+	fmt.Fprintln(os.Stderr, "  --compact-arrays   Put JSON arrays of scalars on one line whatever their")
+	fmt.Fprintln(os.Stderr, "                     length, keeping objects expanded")
+	fmt.Fprintln(os.Stderr, "  --count            agg: count the documents (the default without --sum)")
+	fmt.Fprintln(os.Stderr, "  --cpu-profile FILE Write a pprof CPU profile of the run to FILE")
+	fmt.Fprintln(os.Stderr, "  --csv-delimiter C  CSV output: the field delimiter, one character or tab")
+	fmt.Fprintln(os.Stderr, "                     (default ,)")
+	fmt.Fprintln(os.Stderr, "  --csv-quote MODE   CSV output: quote the fields that need it (minimal,")
+	fmt.Fprintln(os.Stderr, "                     default) or all fields")
+	fmt.Fprintln(os.Stderr, "  --decimal-separator C")
+	fmt.Fprintln(os.Stderr, "                     Table/CSV output: write numbers with C as the decimal")
+	fmt.Fprintln(os.Stderr, "                     point, such as , (default .)")
+	fmt.Fprintln(os.Stderr, "  --decimals MODE    How JSON holds decimals too precise for a float64:")
+	fmt.Fprintln(os.Stderr, "                     string (default, output only), or tag: written and read")
+	fmt.Fprintln(os.Stderr, "                     as {\"$decimal\": \"digits\"}, losing nothing")
+	fmt.Fprintln(os.Stderr, "  --decrypt          Decrypt the {\"$encrypted\": ...} values of --encrypt-paths")
+	fmt.Fprintln(os.Stderr, "                     with the --key-env key")
+	fmt.Fprintln(os.Stderr, "  --defaults FILE    Deep-merge a defaults document (JSON, or BONJSON if")
+	fmt.Fprintln(os.Stderr, "                     named *.boj or *.bonjson) beneath each input document")
+	fmt.Fprintln(os.Stderr, "  --detect-budget SIZE")
+	fmt.Fprintln(os.Stderr, "                     Bytes of input that format detection trial-parses")
+	fmt.Fprintln(os.Stderr, "                     (e.g. 64KiB; default 1MiB); the rest need not be read")
+	fmt.Fprintln(os.Stderr, "  --fail-on-regress PCT")
+	fmt.Fprintln(os.Stderr, "                     bench: fail if throughput drops or allocations grow by")
+	fmt.Fprintln(os.Stderr, "                     more than PCT percent (e.g. 10%) against --baseline")
+	fmt.Fprintln(os.Stderr, "  --drain-timeout DURATION")
+	fmt.Fprintln(os.Stderr, "                     serve: on SIGTERM, wait up to DURATION (e.g. 10s) for")
+	fmt.Fprintln(os.Stderr, "                     in-flight requests before exiting (default 30s)")
+	fmt.Fprintln(os.Stderr, "  --empty-as MODE    What empty input converts to: null, empty-object, or")
+	fmt.Fprintln(os.Stderr, "                     error (default)")
+	fmt.Fprintln(os.Stderr, "  --encode-max-big-exponent N")
+	fmt.Fprintln(os.Stderr, "                     BONJSON output: fail on a big number whose exponent is")
+	fmt.Fprintln(os.Stderr, "                     beyond +/-N (default 0, no limit)")
+	fmt.Fprintln(os.Stderr, "  --encode-max-big-magnitude N")
+	fmt.Fprintln(os.Stderr, "                     BONJSON output: fail on a big number whose magnitude is")
+	fmt.Fprintln(os.Stderr, "                     over N bytes (default 0, no limit)")
+	fmt.Fprintln(os.Stderr, "  --encode-max-depth N")
+	fmt.Fprintln(os.Stderr, "                     BONJSON output: fail on containers nested more than N")
+	fmt.Fprintln(os.Stderr, "                     deep (default 0, no limit)")
+	fmt.Fprintln(os.Stderr, "  --encode-nul       Allow NUL characters in strings of BONJSON output")
+	fmt.Fprintln(os.Stderr, "  --encrypt-paths LIST")
+	fmt.Fprintln(os.Stderr, "                     Encrypt the values at these comma-separated paths with")
+	fmt.Fprintln(os.Stderr, "                     AES-GCM under the --key-env key, as {\"$encrypted\": ...}")
+	fmt.Fprintln(os.Stderr, "  --envelope         Wrap each output document in an object with its source")
+	fmt.Fprintln(os.Stderr, "                     file, byte offset and size, conversion time, and SHA-256")
+	fmt.Fprintln(os.Stderr, "  --expand-env       Substitute ${VAR} placeholders in string values with")
+	fmt.Fprintln(os.Stderr, "                     environment variables ($${ for a literal ${)")
+	fmt.Fprintln(os.Stderr, "  --expect-sha256 HEX")
+	fmt.Fprintln(os.Stderr, "                     Fail, writing no output, unless the input has this")
+	fmt.Fprintln(os.Stderr, "                     SHA-256 digest (computed as it is read)")
+	fmt.Fprintln(os.Stderr, "  --field FIELD      anonymize: pseudonymize this key everywhere, or the value")
+	fmt.Fprintln(os.Stderr, "                     at a path such as $.user.email (repeatable)")
+	fmt.Fprintln(os.Stderr, "  --filter           Editor filter mode: convert stdin to stdout with the given")
+	fmt.Fprintln(os.Stderr, "                     command only, writing nothing unless it succeeds; exit")
+	fmt.Fprintln(os.Stderr, "                     0 ok, 1 usage, 2 invalid input, 3 other failure")
+	fmt.Fprintln(os.Stderr, "  --follow-symlinks  Directory input: follow symlinks to files and directories")
+	fmt.Fprintln(os.Stderr, "                     (each directory is converted once, so cycles end)")
+	fmt.Fprintln(os.Stderr, "  --from FORMAT      Override the input format of a conversion command:")
+	fmt.Fprintln(os.Stderr, "                     bontext (bonjson-text, as --to bontext writes it)")
+	fmt.Fprintln(os.Stderr, "  --git-textconv FILE")
+	fmt.Fprintln(os.Stderr, "                     Print FILE (JSON or BONJSON) as JSON, for git's")
+	fmt.Fprintln(os.Stderr, "                     diff.NAME.textconv")
+	fmt.Fprintln(os.Stderr, "  --git-clean, --git-smudge")
+	fmt.Fprintln(os.Stderr, "                     Convert stdin JSON to BONJSON (clean) or BONJSON to")
+	fmt.Fprintln(os.Stderr, "                     JSON (smudge) on stdout, passing the other format")
+	fmt.Fprintln(os.Stderr, "                     through, for git's filter.NAME.clean and .smudge")
+	fmt.Fprintln(os.Stderr, "  --group-by PATH    agg: aggregate per value at PATH; repeatable")
+	fmt.Fprintln(os.Stderr, "  --hashes           container build: record a SHA-256 of each document,")
+	fmt.Fprintln(os.Stderr, "                     verified when it is read back")
+	fmt.Fprintln(os.Stderr, "  --id VALUE         index get: the key to look up")
+	fmt.Fprintln(os.Stderr, "  --in-fd N          Read the input from inherited file descriptor N, in place")
+	fmt.Fprintln(os.Stderr, "                     of the input argument (as does a /dev/fd/N path)")
+	fmt.Fprintln(os.Stderr, "  --incremental      Skip inputs whose content, output, and options are")
+	fmt.Fprintln(os.Stderr, "                     unchanged since the run recorded in --manifest")
+	fmt.Fprintln(os.Stderr, "  --indent N         Indent JSON output by N spaces, 0 for compact (default 4)")
+	fmt.Fprintln(os.Stderr, "  --index FILE       index get: index file (default: STREAM with extension .idx)")
+	fmt.Fprintln(os.Stderr, "  --json             describe, formats, doctor, diff: print the result as JSON")
+	fmt.Fprintln(os.Stderr, "  --keep PATHS       Keep only the comma-separated paths of each document (and")
+	fmt.Fprintln(os.Stderr, "                     the containers leading to them); * matches any member or")
+	fmt.Fprintln(os.Stderr, "                     element, as in $.metrics.*; repeatable")
+	fmt.Fprintln(os.Stderr, "  --keep-temp        Keep the temporary files of output left by a failure,")
+	fmt.Fprintln(os.Stderr, "                     interrupt, or crash, and report their names (debugging)")
+	fmt.Fprintln(os.Stderr, "  --keys STYLE       Rewrite every object key in STYLE: snake, camel, kebab,")
+	fmt.Fprintln(os.Stderr, "                     or lower")
+	fmt.Fprintln(os.Stderr, "  --key-env VAR      --encrypt-paths and --decrypt: the AES key, base64 of 16,")
+	fmt.Fprintln(os.Stderr, "                     24, or 32 bytes, in environment variable VAR")
+	fmt.Fprintln(os.Stderr, "  --key-file FILE    anonymize: read the secret HMAC key (16+ bytes) from FILE")
+	fmt.Fprintln(os.Stderr, "  --length N         Limit the window started by the preceding -s to N bytes")
+	fmt.Fprintln(os.Stderr, "  --lossiness-report")
+	fmt.Fprintln(os.Stderr, "                     Report to stderr every value or object the conversion")
+	fmt.Fprintln(os.Stderr, "                     maps lossily or approximately: rounded numbers, dropped")
+	fmt.Fprintln(os.Stderr, "                     duplicate keys, reordered keys, stringified non-finite")
+	fmt.Fprintln(os.Stderr, "                     floats and big numbers, typed arrays")
+	fmt.Fprintln(os.Stderr, "  --manifest FILE    Write a JSON (or BONJSON if *.boj) manifest listing each")
+	fmt.Fprintln(os.Stderr, "                     input, output, sizes, SHA-256 checksums, and status")
+	fmt.Fprintln(os.Stderr, "  --mem-profile FILE Write a pprof allocation profile of the run to FILE")
+	fmt.Fprintln(os.Stderr, "  --mode MODE        schema compat: backward (default; the new schema accepts")
+	fmt.Fprintln(os.Stderr, "                     all data the old one does), forward (the reverse), or full")
+	fmt.Fprintln(os.Stderr, "  --newline MODE     Table/CSV output: line endings, lf (default) or crlf")
+	fmt.Fprintln(os.Stderr, "  --nfc, --nfd       Put string values and object keys into Unicode")
+	fmt.Fprintln(os.Stderr, "                     normalization form NFC or NFD")
+	fmt.Fprintln(os.Stderr, "  --nulls-as-absent  Treat null values like missing keys: empty table/CSV")
+	fmt.Fprintln(os.Stderr, "                     cells (count reported to stderr), and overridden by")
+	fmt.Fprintln(os.Stderr, "                     --defaults")
+	fmt.Fprintln(os.Stderr, "  --offset N         describe: the byte offset to describe")
+	fmt.Fprintln(os.Stderr, "  --offset-map FILE  Write to FILE, as JSON, the byte range in the BONJSON")
+	fmt.Fprintln(os.Stderr, "                     output of every value, by document and path")
+	fmt.Fprintln(os.Stderr, "  --omit-nulls       Drop null-valued object keys from the output;")
+	fmt.Fprintln(os.Stderr, "                     reports the count to stderr")
+	fmt.Fprintln(os.Stderr, "  --on PATH          join: the value that matches documents, such as $.id")
+	fmt.Fprintln(os.Stderr, "  --other CMD        cross-check: the reference decoder, run with the input")
+	fmt.Fprintln(os.Stderr, "                     file as its last argument (stdin for -); prints JSON")
+	fmt.Fprintln(os.Stderr, "  --out FILE         index build: index file to write (default: STREAM with")
+	fmt.Fprintln(os.Stderr, "                     extension .idx); combine, delta, apply, merge3, agg,")
+	fmt.Fprintln(os.Stderr, "                     sort, join: output file (default stdout, as JSON)")
+	fmt.Fprintln(os.Stderr, "  --out-fd N         Write the output to inherited file descriptor N, in place")
+	fmt.Fprintln(os.Stderr, "                     of the output argument or --out (as does /dev/fd/N)")
+	fmt.Fprintln(os.Stderr, "  --out-template T   Lay out output paths under the output directory as T,")
+	fmt.Fprintln(os.Stderr, "                     with {dir} (relative to the input), {name}, {format},")
+	fmt.Fprintln(os.Stderr, "                     {input_format}, and {shard} (with --split-*)")
+	fmt.Fprintln(os.Stderr, "  --path PATH        index build: the key to index, such as $.id")
+	fmt.Fprintln(os.Stderr, "  --prefer FORMAT    Format detection picks for input valid as both JSON and")
+	fmt.Fprintln(os.Stderr, "                     BONJSON, such as 5: json (default) or bonjson")
+	fmt.Fprintln(os.Stderr, "  --preserve-mode    Give each output file the permissions of its input file")
+	fmt.Fprintln(os.Stderr, "  --preserve-times   Give each output file the modification time of its input")
+	fmt.Fprintln(os.Stderr, "  --provenance FILE  Write to FILE, as JSON, the byte range in the BONJSON")
+	fmt.Fprintln(os.Stderr, "                     input of each document and of each top-level member")
+	fmt.Fprintln(os.Stderr, "  --queue-depth N    Maximum documents in flight in the --stream pipeline")
+	fmt.Fprintln(os.Stderr, "                     (default 64)")
+	fmt.Fprintln(os.Stderr, "  --redact-rules FILE")
+	fmt.Fprintln(os.Stderr, "                     Remove, replace, or mask the values selected by the rules")
+	fmt.Fprintln(os.Stderr, "                     in FILE (by path, key, or regex), reporting counts per rule")
+	fmt.Fprintln(os.Stderr, "  --rename OLD=NEW   Rename object keys (repeatable); OLD may be a path such")
+	fmt.Fprintln(os.Stderr, "                     as $.user.name to rename only within one object")
+	fmt.Fprintln(os.Stderr, "  --rename-file FILE Rename keys using a JSON object mapping OLD to NEW")
+	fmt.Fprintln(os.Stderr, "  --report FORMAT    validate: print a report of the violations for CI instead:")
+	fmt.Fprintln(os.Stderr, "                     junit (a test case per document) or sarif")
+	fmt.Fprintln(os.Stderr, "  --reserved         inspect: report reserved or misplaced type codes")
+	fmt.Fprintln(os.Stderr, "  --resolve-refs     Replace {\"$include\": \"file\"} objects with the file's")
+	fmt.Fprintln(os.Stderr, "                     contents and local {\"$ref\": \"#/pointer\"} objects with")
+	fmt.Fprintln(os.Stderr, "                     the value they point to")
+	fmt.Fprintln(os.Stderr, "  --run-size SIZE    sort: bytes of documents to sort in memory before spilling")
+	fmt.Fprintln(os.Stderr, "                     them to a temporary file (default 64MiB)")
+	fmt.Fprintln(os.Stderr, "  --save-baseline FILE")
+	fmt.Fprintln(os.Stderr, "                     bench: save the results as a baseline")
+	fmt.Fprintln(os.Stderr, "  --scale PATH*N     Multiply the number at PATH, or each number of the array")
+	fmt.Fprintln(os.Stderr, "                     there, by N (or divide, with PATH/N); repeatable")
+	fmt.Fprintln(os.Stderr, "  --since TIME       Convert only the documents of a BONJSON --stream whose")
+	fmt.Fprintln(os.Stderr, "                     --time-path value is at or after TIME (RFC 3339, a date,")
+	fmt.Fprintln(os.Stderr, "                     or a number); others are skipped without being decoded")
+	fmt.Fprintln(os.Stderr, "  --schema FILE      validate: the JSON Schema to check against")
+	fmt.Fprintln(os.Stderr, "  --schema-types FILE")
+	fmt.Fprintln(os.Stderr, "                     Decode JSON input by the type hints of the JSON Schema in")
+	fmt.Fprintln(os.Stderr, "                     FILE: integers exactly where it declares them")
+	fmt.Fprintln(os.Stderr, "  --shape            stats: also profile the structure of the documents: key")
+	fmt.Fprintln(os.Stderr, "                     presence, types, and distinct values per path")
+	fmt.Fprintln(os.Stderr, "  --snapshots DIR    Keep in DIR a timestamped canonical BONJSON snapshot of")
+	fmt.Fprintln(os.Stderr, "                     each conversion's documents that differ from the last;")
+	fmt.Fprintln(os.Stderr, "                     also where history finds them")
+	fmt.Fprintln(os.Stderr, "  --spec             serve: print the OpenAPI document of the protocol and exit")
+	fmt.Fprintln(os.Stderr, "  --split-docs N     Write the output as numbered shards of at most N")
+	fmt.Fprintln(os.Stderr, "                     documents each (name-00000.ext, name-00001.ext, ...)")
+	fmt.Fprintln(os.Stderr, "  --split-size SIZE  Write the output as numbered shards of at most SIZE")
+	fmt.Fprintln(os.Stderr, "                     bytes each (e.g. 64MB, 512KiB)")
+	fmt.Fprintln(os.Stderr, "  --stream           Input is a stream of concatenated documents (NDJSON")
+	fmt.Fprintln(os.Stderr, "                     or back-to-back BONJSON)")
+	fmt.Fprintln(os.Stderr, "  --strategy NAME    combine: deep-merge (default), last-wins (top-level")
+	fmt.Fprintln(os.Stderr, "                     keys replaced whole), concat-arrays (deep merge with")
+	fmt.Fprintln(os.Stderr, "                     arrays appended)")
+	fmt.Fprintln(os.Stderr, "  --strict-env       Like --expand-env, but fail on undefined variables")
+	fmt.Fprintln(os.Stderr, "  --strict-json      Reject JSON input that is not strictly RFC 8259, or that")
+	fmt.Fprintln(os.Stderr, "                     would be silently altered: duplicate keys, invalid UTF-8,")
+	fmt.Fprintln(os.Stderr, "                     unpaired surrogates, integers beyond +/-2^53")
+	fmt.Fprintln(os.Stderr, "  --sum PATH         agg: sum the numbers at PATH; repeatable")
+	fmt.Fprintln(os.Stderr, "  --summary          After each conversion, print a one-line key=value summary")
+	fmt.Fprintln(os.Stderr, "                     to stderr: formats, bytes in and out, ratio, duration,")
+	fmt.Fprintln(os.Stderr, "                     warnings, and status")
+	fmt.Fprintln(os.Stderr, "  --target-profile NAME")
+	fmt.Fprintln(os.Stderr, "                     Fail, listing each value beyond them, unless the output")
+	fmt.Fprintln(os.Stderr, "                     fits the limits of a class of decoder: embedded (depth 16,")
+	fmt.Fprintln(os.Stderr, "                     strings of 255 bytes, 64-bit integers only, no NaN/inf)")
+	fmt.Fprintln(os.Stderr, "  --time-path PATH   Where --since and --until find a document's timestamp")
+	fmt.Fprintln(os.Stderr, "                     (default $.timestamp)")
+	fmt.Fprintln(os.Stderr, "  --to FORMAT        Override the output format of a conversion command:")
+	fmt.Fprintln(os.Stderr, "                     table (markdown table of rows), csv, bontext (BONJSON")
+	fmt.Fprintln(os.Stderr, "                     as text, one token per line with its offset)")
+	fmt.Fprintln(os.Stderr, "  --type TYPE        join: inner (default) drops unmatched documents of the")
+	fmt.Fprintln(os.Stderr, "                     first stream, left keeps them")
+	fmt.Fprintln(os.Stderr, "  --top N            stats: also list the N largest strings, arrays, and")
+	fmt.Fprintln(os.Stderr, "                     objects by encoded size, with their paths")
+	fmt.Fprintln(os.Stderr, "  --trace-decode FILE")
+	fmt.Fprintln(os.Stderr, "                     Write to FILE every token of the BONJSON input as it is")
+	fmt.Fprintln(os.Stderr, "                     decoded: offset, type, value preview, container push/pop")
+	fmt.Fprintln(os.Stderr, "  --trace-file FILE  Write a runtime/trace execution trace of the run to FILE")
+	fmt.Fprintln(os.Stderr, "  --trailing-out FILE")
+	fmt.Fprintln(os.Stderr, "                     Allow trailing data (like -t), write it to FILE, and")
+	fmt.Fprintln(os.Stderr, "                     report its offset and length to stderr")
+	fmt.Fprintln(os.Stderr, "  --uint64 MODE      What JSON output does with integers beyond +/-(2^53-1),")
+	fmt.Fprintln(os.Stderr, "                     which JavaScript rounds: string, clamp (with a warning),")
+	fmt.Fprintln(os.Stderr, "                     or error (default: write them exactly)")
+	fmt.Fprintln(os.Stderr, "  --until TIME       Like --since, but only documents before TIME")
+	fmt.Fprintln(os.Stderr, "  --warnings-as-errors")
+	fmt.Fprintln(os.Stderr, "                     Fail on JSON input that decoding would silently change:")
+	fmt.Fprintln(os.Stderr, "                     rounded numbers, duplicate keys, text replaced by U+FFFD")
+	fmt.Fprintln(os.Stderr, "  --width N          Keep JSON arrays and objects that fit in N columns on one")
+	fmt.Fprintln(os.Stderr, "                     line, and wrap the rest (ignored with --indent 0)")
+	fmt.Fprintln(os.Stderr, "  --workers SPEC     Worker goroutines for the --stream pipeline: N for")
+	fmt.Fprintln(os.Stderr, "                     every stage, or transform=N,encode=N (default: CPUs)")
+	fmt.Fprintln(os.Stderr, "  --wrap-array       convert: write the documents of all inputs as one array")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Default options are read, one per line, from $BONBON_CONFIG or, if that is")
+	fmt.Fprintln(os.Stderr, "not set, bonbon/config in the user configuration directory.")
+}
+
+// options holds the settings collected from the command line.
+type options struct {
+	allowTrailing     bool
+	skipBytes         int
+	printEndOffset    bool
+	allowNUL          bool
+	encodeNUL         bool
+	encodeMaxDepth    int
+	encodeMaxBigNum   int // bytes of a big number's magnitude
+	encodeMaxBigExp   int
+	dupKeyMode        string
+	utf8Mode          string
+	nanInfMode        string
+	inputFormat       string
+	outputFormat      string
+	columns           []string
+	csvDelimiter      rune
+	csvQuote          string
+	decimalSeparator  string
+	newline           string
+	stream            bool
+	omitNulls         bool
+	nullsAsAbsent     bool
+	renames           []keyRename
+	keyCase           string
+	scales            []numberScale
+	groupBy           []path
+	aggCount          bool
+	aggSums           []path
+	sortBy            path
+	sortRunSize       int64
+	detectBudget      int64
+	prefer            string
+	joinOn            path
+	joinType          string
+	defaults          any
+	expandEnv         bool
+	strictEnv         bool
+	normalization     string
+	resolveRefs       bool
+	splitSize         int64
+	splitDocs         int
+	manifestPath      string
+	incremental       bool
+	transformWorkers  int
+	encodeWorkers     int
+	queueDepth        int
+	cpuProfile        string
+	memProfile        string
+	traceFile         string
+	benchBaseline     string
+	benchSaveBaseline string
+	benchFailPercent  float64
+	statsTop          int
+	statsShape        bool
+	anonymizeFields   []anonymizeField
+	redactRules       []redactRule
+	encryptPaths      []path
+	decrypt           bool
+	fieldKey          cipher.AEAD
+	anonymizeKey      []byte
+	strictJSON        bool
+	warningsAsErrors  bool
+	summary           bool
+	trailingOut       string
+	windows           []inputWindow
+	containerHashes   bool
+	indexPath         path
+	indexID           *string
+	outFile           string
+	mergeStrategy     string
+	lossinessReport   bool
+	filter            bool
+	describeOffset    *int64
+	printJSON         bool
+	serveSpec         bool
+	authTokens        [][]byte
+	authHMACKey       []byte
+	indent            *int
+	width             int
+	compactArrays     bool
+	serveAllowParams  []string
+	drainTimeout      time.Duration
+	indexFile         string
+	keepTemp          bool
+	gitMode           string
+	provenanceFile    string
+	offsetMapFile     string
+	decodeTraceFile   string
+	schemaTypes       *typeSchema
+	schema            *typeSchema
+	report            string
+	targetProfile     *targetProfile
+	snapshotDir       string
+	compatMode        string
+	historyAt         *time.Time
+	lintSample        string
+	asserts           []pathAssertion
+	inspectReserved   bool
+	otherDecoder      string
+	emptyAs           string
+	wrapArray         bool
+	inFD              string
+	outTemplate       string
+	followSymlinks    bool
+	preserveMode      bool
+	preserveTimes     bool
+	expectSHA256      string
+	cacheDir          string
+	outFD             string
+	decimals          string
+	uint64Mode        string
+	keep              *keepNode
+	envelope          bool
+	since             *timeBound
+	until             *timeBound
+	timePath          path
+
+	// The options as given on the command line, with their values, for the
+	// conversion cache key.
+	optionArgs [][]string
+
+	// The files that file-backed settings were loaded from, so that serve
+	// can reload them, and how many of optionArgs, which come first, are
+	// the config file's.
+	defaultsFile      string
+	anonymizeKeyFile  string
+	authTokenFile     string
+	authHMACKeyFile   string
+	schemaTypesFile   string
+	redactRulesFile   string
+	configOptionCount int
 }
 
 func main() {
-	var allowTrailing bool
-	var skipBytes int
-	var printEndOffset bool
-	var allowNUL bool
-	var dupKeyMode string
-	var utf8Mode string
-	var nanInfMode string
-	args := os.Args[1:]
-
-	// Parse flags
-	for len(args) > 0 && len(args[0]) > 0 && args[0][0] == '-' && args[0] != "-" {
+	var opts options
+	args, err := configArgs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.configOptionCount = len(args) / 2
+	args = append(args, os.Args[1:]...)
+
+	// Parse flags, which may appear before or after the command and its
+	// arguments. Everything after "--" is positional.
+	var positional []string
+	for len(args) > 0 {
+		if args[0] == "--" {
+			positional = append(positional, args[1:]...)
+			break
+		}
+		if len(args[0]) == 0 || args[0][0] != '-' || args[0] == "-" {
+			positional = append(positional, args[0])
+			args = args[1:]
+			continue
+		}
+		option := args
 		switch args[0] {
 		case "-d":
 			if len(args) < 2 {
 				fmt.Fprintln(os.Stderr, "Error: -d requires an argument")
 				os.Exit(1)
 			}
-			dupKeyMode = args[1]
-			switch dupKeyMode {
+			opts.dupKeyMode = args[1]
+			switch opts.dupKeyMode {
 			case "reject", "keepfirst", "keeplast":
 				// valid
 			default:
-				fmt.Fprintf(os.Stderr, "Error: invalid duplicate key mode: %s\n", dupKeyMode)
+				fmt.Fprintf(os.Stderr, "Error: invalid duplicate key mode: %s\n", opts.dupKeyMode)
 				os.Exit(1)
 			}
 			args = args[2:]
 		case "-e":
-			printEndOffset = true
+			opts.printEndOffset = true
 			args = args[1:]
 		case "-f":
 			if len(args) < 2 {
 				fmt.Fprintln(os.Stderr, "Error: -f requires an argument")
 				os.Exit(1)
 			}
-			nanInfMode = args[1]
-			switch nanInfMode {
+			opts.nanInfMode = args[1]
+			switch opts.nanInfMode {
 			case "reject", "allow", "stringify":
 				// valid
 			default:
-				fmt.Fprintf(os.Stderr, "Error: invalid special float mode: %s\n", nanInfMode)
+				fmt.Fprintf(os.Stderr, "Error: invalid special float mode: %s\n", opts.nanInfMode)
 				os.Exit(1)
 			}
 			args = args[2:]
 		case "-n":
-			allowNUL = true
+			opts.allowNUL = true
+			args = args[1:]
+		case "--encode-nul":
+			opts.encodeNUL = true
 			args = args[1:]
-		case "-s":
+		case "--encode-max-depth":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --encode-max-depth requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.encodeMaxDepth, err = parseEncoderLimit(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--encode-max-big-magnitude":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --encode-max-big-magnitude requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.encodeMaxBigNum, err = parseEncoderLimit(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--encode-max-big-exponent":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --encode-max-big-exponent requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.encodeMaxBigExp, err = parseEncoderLimit(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "-s", "--start":
 			if len(args) < 2 {
-				fmt.Fprintln(os.Stderr, "Error: -s requires an argument")
+				fmt.Fprintf(os.Stderr, "Error: %s requires an argument\n", args[0])
 				os.Exit(1)
 			}
 			var err error
-			skipBytes, err = strconv.Atoi(args[1])
-			if err != nil || skipBytes < 0 {
+			opts.skipBytes, err = strconv.Atoi(args[1])
+			if err != nil || opts.skipBytes < 0 {
 				fmt.Fprintf(os.Stderr, "Error: invalid skip value: %s\n", args[1])
 				os.Exit(1)
 			}
+			opts.windows = append(opts.windows, inputWindow{start: opts.skipBytes, length: -1})
 			args = args[2:]
-		case "-t":
-			allowTrailing = true
+		case "-t", "--allow-trailing":
+			opts.allowTrailing = true
 			args = args[1:]
 		case "-u":
 			if len(args) < 2 {
 				fmt.Fprintln(os.Stderr, "Error: -u requires an argument")
 				os.Exit(1)
 			}
-			utf8Mode = args[1]
-			switch utf8Mode {
+			opts.utf8Mode = args[1]
+			switch opts.utf8Mode {
 			case "reject", "replace", "delete", "ignore":
 				// valid
 			default:
-				fmt.Fprintf(os.Stderr, "Error: invalid UTF-8 mode: %s\n", utf8Mode)
+				fmt.Fprintf(os.Stderr, "Error: invalid UTF-8 mode: %s\n", opts.utf8Mode)
 				os.Exit(1)
 			}
 			args = args[2:]
-		default:
-			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[0])
+		case "--allow-params":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --allow-params requires an argument")
+				os.Exit(1)
+			}
+			opts.serveAllowParams = []string{}
+			for name := range strings.SplitSeq(args[1], ",") {
+				if name = strings.TrimSpace(name); name == "" {
+					continue
+				}
+				if !slices.ContainsFunc(serveParameters, func(p serveParameter) bool { return p.name == name }) {
+					fmt.Fprintf(os.Stderr, "Error: unknown serve parameter in --allow-params: %s\n", name)
+					os.Exit(1)
+				}
+				opts.serveAllowParams = append(opts.serveAllowParams, name)
+			}
+			args = args[2:]
+		case "--auth-token-file":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --auth-token-file requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.authTokenFile = args[1]
+			opts.authTokens, err = loadAuthTokens(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--auth-hmac-key-file":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --auth-hmac-key-file requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.authHMACKeyFile = args[1]
+			opts.authHMACKey, err = loadHMACKey(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--baseline":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --baseline requires an argument")
+				os.Exit(1)
+			}
+			opts.benchBaseline = args[1]
+			args = args[2:]
+		case "--columns":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --columns requires an argument")
+				os.Exit(1)
+			}
+			opts.columns = splitList(args[1])
+			if len(opts.columns) == 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid column list: %s\n", args[1])
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--csv-delimiter":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --csv-delimiter requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			if opts.csvDelimiter, err = parseCSVDelimiter(args[1]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--csv-quote":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --csv-quote requires an argument")
+				os.Exit(1)
+			}
+			if !slices.Contains(csvQuoteModes, args[1]) {
+				fmt.Fprintf(os.Stderr, "Error: invalid csv-quote mode: %s (expected minimal or all)\n", args[1])
+				os.Exit(1)
+			}
+			opts.csvQuote = args[1]
+			args = args[2:]
+		case "--decimal-separator":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --decimal-separator requires an argument")
+				os.Exit(1)
+			}
+			if !validDecimalSeparator(args[1]) {
+				fmt.Fprintf(os.Stderr, "Error: invalid decimal separator: %s (expected one character, such as ,)\n", args[1])
+				os.Exit(1)
+			}
+			opts.decimalSeparator = args[1]
+			args = args[2:]
+		case "--newline":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --newline requires an argument")
+				os.Exit(1)
+			}
+			if !slices.Contains(newlineModes, args[1]) {
+				fmt.Fprintf(os.Stderr, "Error: invalid newline mode: %s (expected lf or crlf)\n", args[1])
+				os.Exit(1)
+			}
+			opts.newline = args[1]
+			args = args[2:]
+		case "--trace-file":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --trace-file requires an argument")
+				os.Exit(1)
+			}
+			opts.traceFile = args[1]
+			args = args[2:]
+		case "--top":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --top requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.statsTop, err = strconv.Atoi(args[1])
+			if err != nil || opts.statsTop <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid count: %s\n", args[1])
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--trailing-out":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --trailing-out requires an argument")
+				os.Exit(1)
+			}
+			opts.trailingOut = args[1]
+			opts.allowTrailing = true
+			args = args[2:]
+		case "--width":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --width requires an argument")
+				os.Exit(1)
+			}
+			width, err := parseWidth(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.width = width
+			args = args[2:]
+		case "--workers":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --workers requires an argument")
+				os.Exit(1)
+			}
+			if err := parseWorkers(args[1], &opts); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--to":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --to requires an argument")
+				os.Exit(1)
+			}
+			opts.outputFormat = args[1]
+			switch opts.outputFormat {
+			case "table", "csv", "bontext":
+				// valid
+			default:
+				fmt.Fprintf(os.Stderr, "Error: invalid output format: %s\n", opts.outputFormat)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--from":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --from requires an argument")
+				os.Exit(1)
+			}
+			opts.inputFormat = args[1]
+			if opts.inputFormat != "bontext" {
+				fmt.Fprintf(os.Stderr, "Error: invalid input format: %s\n", opts.inputFormat)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--cpu-profile":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --cpu-profile requires an argument")
+				os.Exit(1)
+			}
+			opts.cpuProfile = args[1]
+			args = args[2:]
+		case "--decimals":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --decimals requires an argument")
+				os.Exit(1)
+			}
+			if !slices.Contains(decimalModes, args[1]) {
+				fmt.Fprintf(os.Stderr, "Error: invalid decimals mode: %s (expected string or tag)\n", args[1])
+				os.Exit(1)
+			}
+			opts.decimals = args[1]
+			args = args[2:]
+		case "--empty-as":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --empty-as requires an argument")
+				os.Exit(1)
+			}
+			if !slices.Contains(emptyModes, args[1]) {
+				fmt.Fprintf(os.Stderr, "Error: invalid empty-as mode: %s (expected null, empty-object, or error)\n", args[1])
+				os.Exit(1)
+			}
+			opts.emptyAs = args[1]
+			args = args[2:]
+		case "--uint64":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --uint64 requires an argument")
+				os.Exit(1)
+			}
+			if !slices.Contains(uint64Modes, args[1]) {
+				fmt.Fprintf(os.Stderr, "Error: invalid uint64 mode: %s (expected string, clamp, or error)\n", args[1])
+				os.Exit(1)
+			}
+			opts.uint64Mode = args[1]
+			args = args[2:]
+		case "--keep":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --keep requires an argument")
+				os.Exit(1)
+			}
+			if opts.keep == nil {
+				opts.keep = &keepNode{}
+			}
+			if err := addKeepPaths(opts.keep, args[1]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--mode":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --mode requires an argument")
+				os.Exit(1)
+			}
+			opts.compatMode = args[1]
+			if !slices.Contains(compatModes, opts.compatMode) {
+				fmt.Fprintf(os.Stderr, "Error: invalid compatibility mode: %s (expected %s)\n", opts.compatMode, strings.Join(compatModes, ", "))
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--snapshots":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --snapshots requires an argument")
+				os.Exit(1)
+			}
+			opts.snapshotDir = args[1]
+			args = args[2:]
+		case "--against":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --against requires an argument")
+				os.Exit(1)
+			}
+			opts.lintSample = args[1]
+			args = args[2:]
+		case "--assert":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --assert requires an argument")
+				os.Exit(1)
+			}
+			a, err := parseAssertion(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.asserts = append(opts.asserts, a)
+			args = args[2:]
+		case "--at":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --at requires an argument")
+				os.Exit(1)
+			}
+			at, ok := parseTimestamp(args[1])
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Error: invalid time %q: expected RFC 3339 (2024-05-01T12:00:00Z) or a date (2024-05-01)\n", args[1])
+				os.Exit(1)
+			}
+			opts.historyAt = &at
+			args = args[2:]
+		case "--target-profile":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --target-profile requires an argument")
+				os.Exit(1)
+			}
+			opts.targetProfile = targetProfiles[args[1]]
+			if opts.targetProfile == nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid target profile: %s (expected %s)\n", args[1], strings.Join(slices.Sorted(maps.Keys(targetProfiles)), " or "))
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--report":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --report requires an argument")
+				os.Exit(1)
+			}
+			opts.report = args[1]
+			if !slices.Contains(reportFormats, opts.report) {
+				fmt.Fprintf(os.Stderr, "Error: invalid report format: %s (expected %s)\n", opts.report, strings.Join(reportFormats, " or "))
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--reserved":
+			opts.inspectReserved = true
+			args = args[1:]
+		case "--other":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --other requires an argument")
+				os.Exit(1)
+			}
+			opts.otherDecoder = args[1]
+			args = args[2:]
+		case "--schema":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --schema requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.schema, err = loadTypeSchema(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: loading schema: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--schema-types":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --schema-types requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.schemaTypesFile = args[1]
+			opts.schemaTypes, err = loadTypeSchema(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: loading schema: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--defaults":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --defaults requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.defaultsFile = args[1]
+			opts.defaults, err = loadDocument(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: loading defaults: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--fail-on-regress":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --fail-on-regress requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.benchFailPercent, err = parsePercent(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--git-textconv", "--git-clean", "--git-smudge":
+			mode := strings.TrimPrefix(args[0], "--git-")
+			if opts.gitMode != "" && opts.gitMode != mode {
+				fmt.Fprintln(os.Stderr, "Error: only one of --git-textconv, --git-clean, and --git-smudge can be given")
+				os.Exit(1)
+			}
+			opts.gitMode = mode
+			args = args[1:]
+		case "--filter":
+			opts.filter = true
+			args = args[1:]
+		case "--id":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --id requires an argument")
+				os.Exit(1)
+			}
+			opts.indexID = &args[1]
+			args = args[2:]
+		case "--indent":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --indent requires an argument")
+				os.Exit(1)
+			}
+			indent, err := parseIndent(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.indent = &indent
+			args = args[2:]
+		case "--index":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --index requires an argument")
+				os.Exit(1)
+			}
+			opts.indexFile = args[1]
+			args = args[2:]
+		case "--offset":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --offset requires an argument")
+				os.Exit(1)
+			}
+			offset, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || offset < 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid offset: %s\n", args[1])
+				os.Exit(1)
+			}
+			opts.describeOffset = &offset
+			args = args[2:]
+		case "--out":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --out requires an argument")
+				os.Exit(1)
+			}
+			opts.outFile = args[1]
+			args = args[2:]
+		case "--out-template":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --out-template requires an argument")
+				os.Exit(1)
+			}
+			opts.outTemplate = args[1]
+			args = args[2:]
+		case "--in-fd", "--out-fd":
+			if len(args) < 2 {
+				fmt.Fprintf(os.Stderr, "Error: %s requires an argument\n", args[0])
+				os.Exit(1)
+			}
+			fd, ok := parseDescriptor(args[1])
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Error: invalid file descriptor: %s\n", args[1])
+				os.Exit(1)
+			}
+			if args[0] == "--in-fd" {
+				opts.inFD = fd
+			} else {
+				opts.outFD = fd
+			}
+			args = args[2:]
+		case "--path":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --path requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.indexPath, err = parsePath(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid path %q: %v\n", args[1], err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--drain-timeout":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --drain-timeout requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.drainTimeout, err = time.ParseDuration(args[1])
+			if err != nil || opts.drainTimeout <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid duration: %s\n", args[1])
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--expand-env":
+			opts.expandEnv = true
+			args = args[1:]
+		case "--field":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --field requires an argument")
+				os.Exit(1)
+			}
+			field, err := parseAnonymizeField(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.anonymizeFields = append(opts.anonymizeFields, field)
+			args = args[2:]
+		case "--encrypt-paths":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --encrypt-paths requires an argument")
+				os.Exit(1)
+			}
+			paths, err := parseEncryptPaths(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.encryptPaths = append(opts.encryptPaths, paths...)
+			args = args[2:]
+		case "--decrypt":
+			opts.decrypt = true
+			args = args[1:]
+		case "--key-env":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --key-env requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.fieldKey, err = loadFieldKey(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--redact-rules":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --redact-rules requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.redactRulesFile = args[1]
+			opts.redactRules, err = loadRedactRules(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--incremental":
+			opts.incremental = true
+			args = args[1:]
+		case "--count":
+			opts.aggCount = true
+			args = args[1:]
+		case "--group-by", "--sum":
+			if len(args) < 2 {
+				fmt.Fprintf(os.Stderr, "Error: %s requires an argument\n", args[0])
+				os.Exit(1)
+			}
+			p, err := parsePath(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if args[0] == "--sum" {
+				opts.aggSums = append(opts.aggSums, p)
+			} else {
+				opts.groupBy = append(opts.groupBy, p)
+			}
+			args = args[2:]
+		case "--compact-arrays":
+			opts.compactArrays = true
+			args = args[1:]
+		case "--keep-temp":
+			opts.keepTemp = true
+			args = args[1:]
+		case "--keys":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --keys requires an argument")
+				os.Exit(1)
+			}
+			if !slices.Contains(keyCases, args[1]) {
+				fmt.Fprintf(os.Stderr, "Error: invalid key style: %s (expected %s)\n", args[1], strings.Join(keyCases, ", "))
+				os.Exit(1)
+			}
+			opts.keyCase = args[1]
+			args = args[2:]
+		case "--key-file":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --key-file requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.anonymizeKeyFile = args[1]
+			opts.anonymizeKey, err = loadHMACKey(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--length":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --length requires an argument")
+				os.Exit(1)
+			}
+			length, err := strconv.Atoi(args[1])
+			if err != nil || length <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid length: %s\n", args[1])
+				os.Exit(1)
+			}
+			if len(opts.windows) == 0 || opts.windows[len(opts.windows)-1].length >= 0 {
+				opts.windows = append(opts.windows, inputWindow{start: 0})
+			}
+			opts.windows[len(opts.windows)-1].length = length
+			args = args[2:]
+		case "--json":
+			opts.printJSON = true
+			args = args[1:]
+		case "--lossiness-report":
+			opts.lossinessReport = true
+			args = args[1:]
+		case "--envelope":
+			opts.envelope = true
+			args = args[1:]
+		case "--cache-dir":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --cache-dir requires an argument")
+				os.Exit(1)
+			}
+			opts.cacheDir = args[1]
+			args = args[2:]
+		case "--expect-sha256":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --expect-sha256 requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			if opts.expectSHA256, err = parseSHA256(args[1]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--preserve-mode":
+			opts.preserveMode = true
+			args = args[1:]
+		case "--preserve-times":
+			opts.preserveTimes = true
+			args = args[1:]
+		case "--follow-symlinks":
+			opts.followSymlinks = true
+			args = args[1:]
+		case "--wrap-array":
+			opts.wrapArray = true
+			args = args[1:]
+		case "--offset-map":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --offset-map requires an argument")
+				os.Exit(1)
+			}
+			opts.offsetMapFile = args[1]
+			args = args[2:]
+		case "--provenance":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --provenance requires an argument")
+				os.Exit(1)
+			}
+			opts.provenanceFile = args[1]
+			args = args[2:]
+		case "--trace-decode":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --trace-decode requires an argument")
+				os.Exit(1)
+			}
+			opts.decodeTraceFile = args[1]
+			args = args[2:]
+		case "--since", "--until":
+			if len(args) < 2 {
+				fmt.Fprintf(os.Stderr, "Error: %s requires an argument\n", args[0])
+				os.Exit(1)
+			}
+			bound, err := parseTimeBound(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if args[0] == "--since" {
+				opts.since = bound
+			} else {
+				opts.until = bound
+			}
+			args = args[2:]
+		case "--time-path":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --time-path requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.timePath, err = parsePath(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--manifest":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --manifest requires an argument")
+				os.Exit(1)
+			}
+			opts.manifestPath = args[1]
+			args = args[2:]
+		case "--mem-profile":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --mem-profile requires an argument")
+				os.Exit(1)
+			}
+			opts.memProfile = args[1]
+			args = args[2:]
+		case "--nfc", "--nfd":
+			if opts.normalization != "" && opts.normalization != args[0][2:] {
+				fmt.Fprintln(os.Stderr, "Error: --nfc and --nfd cannot be combined")
+				os.Exit(1)
+			}
+			opts.normalization = args[0][2:]
+			args = args[1:]
+		case "--nulls-as-absent":
+			opts.nullsAsAbsent = true
+			args = args[1:]
+		case "--omit-nulls":
+			opts.omitNulls = true
+			args = args[1:]
+		case "--queue-depth":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --queue-depth requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.queueDepth, err = strconv.Atoi(args[1])
+			if err != nil || opts.queueDepth <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid queue depth: %s\n", args[1])
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--rename":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --rename requires an argument")
+				os.Exit(1)
+			}
+			r, err := parseRename(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.renames = append(opts.renames, r)
+			args = args[2:]
+		case "--rename-file":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --rename-file requires an argument")
+				os.Exit(1)
+			}
+			renames, err := loadRenameFile(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.renames = append(opts.renames, renames...)
+			args = args[2:]
+		case "--scale":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --scale requires an argument")
+				os.Exit(1)
+			}
+			scale, err := parseScale(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.scales = append(opts.scales, scale)
+			args = args[2:]
+		case "--strict-json":
+			opts.strictJSON = true
+			args = args[1:]
+		case "--summary":
+			opts.summary = true
+			args = args[1:]
+		case "--warnings-as-errors":
+			opts.warningsAsErrors = true
+			args = args[1:]
+		case "--strict-env":
+			opts.expandEnv = true
+			opts.strictEnv = true
+			args = args[1:]
+		case "--resolve-refs":
+			opts.resolveRefs = true
+			args = args[1:]
+		case "--save-baseline":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --save-baseline requires an argument")
+				os.Exit(1)
+			}
+			opts.benchSaveBaseline = args[1]
+			args = args[2:]
+		case "--hashes":
+			opts.containerHashes = true
+			args = args[1:]
+		case "--strategy":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --strategy requires an argument")
+				os.Exit(1)
+			}
+			opts.mergeStrategy = args[1]
+			switch opts.mergeStrategy {
+			case "deep-merge", "last-wins", "concat-arrays":
+				// valid
+			default:
+				fmt.Fprintf(os.Stderr, "Error: invalid merge strategy: %s\n", opts.mergeStrategy)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--shape":
+			opts.statsShape = true
+			args = args[1:]
+		case "--spec":
+			opts.serveSpec = true
+			args = args[1:]
+		case "--split-docs":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --split-docs requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.splitDocs, err = strconv.Atoi(args[1])
+			if err != nil || opts.splitDocs <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid document count: %s\n", args[1])
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--by":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --by requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.sortBy, err = parsePath(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--on":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --on requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.joinOn, err = parsePath(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--type":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --type requires an argument")
+				os.Exit(1)
+			}
+			if args[1] != "inner" && args[1] != "left" {
+				fmt.Fprintf(os.Stderr, "Error: invalid join type: %s (expected inner or left)\n", args[1])
+				os.Exit(1)
+			}
+			opts.joinType = args[1]
+			args = args[2:]
+		case "--prefer":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --prefer requires an argument")
+				os.Exit(1)
+			}
+			opts.prefer = args[1]
+			if !slices.Contains(preferFormats, opts.prefer) {
+				fmt.Fprintf(os.Stderr, "Error: invalid preferred format: %s (expected %s)\n", opts.prefer, strings.Join(preferFormats, " or "))
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--detect-budget":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --detect-budget requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.detectBudget, err = parseSize(args[1])
+			if err != nil || opts.detectBudget <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid size: %s\n", args[1])
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--run-size":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --run-size requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.sortRunSize, err = parseSize(args[1])
+			if err != nil || opts.sortRunSize <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid size: %s\n", args[1])
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--split-size":
+			if len(args) < 2 {
+				fmt.Fprintln(os.Stderr, "Error: --split-size requires an argument")
+				os.Exit(1)
+			}
+			var err error
+			opts.splitSize, err = parseSize(args[1])
+			if err != nil || opts.splitSize <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid size: %s\n", args[1])
+				os.Exit(1)
+			}
+			args = args[2:]
+		case "--stream":
+			opts.stream = true
+			args = args[1:]
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[0])
+			os.Exit(1)
+		}
+		opts.optionArgs = append(opts.optionArgs, option[:len(option)-len(args)])
+	}
+
+	args = positional
+	tempFiles.keep = opts.keepTemp
+
+	// --in-fd stands in for the command's first input, and --out-fd for its
+	// output argument, or for --out in commands that take one.
+	if opts.inFD != "" && len(args) > 0 {
+		args = slices.Insert(args, 1, opts.inFD)
+	}
+	if opts.outFD != "" && len(args) > 0 {
+		switch {
+		case slices.Contains([]string{"j2b", "j2j", "b2j", "b2b", "anonymize"}, args[0]):
+			args = append(args, opts.outFD)
+		case opts.outFile != "":
+			fmt.Fprintln(os.Stderr, "Error: --out-fd cannot be combined with --out")
+			os.Exit(1)
+		default:
+			opts.outFile = opts.outFD
+		}
+	}
+	defer tempFiles.cleanupOnPanic()
+
+	if opts.filter {
+		os.Exit(runFilter(args, &opts))
+	}
+
+	if opts.gitMode != "" {
+		os.Exit(runGitFilter(args, &opts))
+	}
+
+	if len(args) > 0 && args[0] == "diff" {
+		os.Exit(runDiff(args[1:], &opts))
+	}
+
+	if opts.serveSpec {
+		if len(args) != 1 || args[0] != "serve" {
+			fmt.Fprintln(os.Stderr, "Error: --spec is only valid as 'serve --spec', without an address")
+			os.Exit(1)
+		}
+		spec, err := json.MarshalIndent(openAPISpec(), "", "    ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(spec))
+		return
+	}
+
+	// lint-expr only checks the transform options, so --encrypt-paths needs
+	// no key there.
+	if len(args) > 0 && args[0] == "lint-expr" {
+		if opts.lintSample == "" {
+			fmt.Fprintln(os.Stderr, "Error: lint-expr requires --against")
+			os.Exit(1)
+		}
+		if err := runLintExpr(args[1:], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if (opts.encryptPaths != nil || opts.decrypt) && opts.fieldKey == nil {
+		fmt.Fprintln(os.Stderr, "Error: --encrypt-paths and --decrypt require --key-env")
+		os.Exit(1)
+	}
+
+	if opts.trailingOut != "" && (opts.stream || len(opts.windows) > 1) {
+		fmt.Fprintln(os.Stderr, "Error: --trailing-out cannot be used with --stream or multiple windows")
+		os.Exit(1)
+	}
+
+	if len(args) == 1 && args[0] == "formats" {
+		if err := runFormats(&opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "doctor" {
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: doctor command takes at most one input")
+			os.Exit(1)
+		}
+		inputPath := ""
+		if len(args) == 2 {
+			inputPath = args[1]
+		}
+		if err := runDoctor(inputPath, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	command := args[0]
+	inputPath := args[1]
+	outputPath := ""
+
+	// Determine input/output formats and required args based on command
+	var inputJSON, outputJSON bool
+	var needsOutput bool
+
+	switch command {
+	case "bench":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: bench command does not accept an output file")
+			os.Exit(1)
+		}
+		if err := runBench(inputPath, !isBONJSONPath(inputPath), &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "container":
+		if err := runContainer(args[1:], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "describe":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: describe command does not accept an output file")
+			os.Exit(1)
+		}
+		if opts.describeOffset == nil {
+			fmt.Fprintln(os.Stderr, "Error: describe requires --offset")
+			os.Exit(1)
+		}
+		if err := runDescribe(inputPath, *opts.describeOffset, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "serve":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: serve command takes only an address")
+			os.Exit(1)
+		}
+		if err := runServe(inputPath, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "examples":
+		if err := runExamples(args[1:], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "convert":
+		if err := runConvertInputs(args[1:], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "combine":
+		if err := runCombine(args[1:], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "delta", "apply":
+		if len(args) != 3 {
+			fmt.Fprintf(os.Stderr, "Error: %s command requires two input files\n", command)
+			os.Exit(1)
+		}
+		run := runDelta
+		if command == "apply" {
+			run = runApply
+		}
+		if err := run(args[1], args[2], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "merge3":
+		if len(args) != 4 {
+			fmt.Fprintln(os.Stderr, "Error: merge3 command requires base, ours, and theirs files")
+			os.Exit(1)
+		}
+		if err := runMerge3(args[1], args[2], args[3], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "mergetool":
+		if len(args) != 5 {
+			fmt.Fprintln(os.Stderr, "Error: mergetool command requires BASE, LOCAL, REMOTE, and MERGED files")
+			os.Exit(1)
+		}
+		if err := runMergetool(args[1], args[2], args[3], args[4], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "append":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "Error: append command requires a target file and an input file")
+			os.Exit(1)
+		}
+		if err := runAppend(args[1], args[2], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "schema":
+		if err := runSchema(args[1:], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "index":
+		if err := runIndex(args[1:], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "agg":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: agg command takes one input; use --out for the output file")
+			os.Exit(1)
+		}
+		if err := runAgg(inputPath, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "sort":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: sort command takes one input; use --out for the output file")
+			os.Exit(1)
+		}
+		if opts.sortBy == nil {
+			fmt.Fprintln(os.Stderr, "Error: sort requires --by")
+			os.Exit(1)
+		}
+		if err := runSort(inputPath, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "join":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "Error: join command requires left and right input files; use --out for the output file")
+			os.Exit(1)
+		}
+		if opts.joinOn == nil {
+			fmt.Fprintln(os.Stderr, "Error: join requires --on")
+			os.Exit(1)
+		}
+		if err := runJoin(args[1], args[2], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "history":
+		if len(args) > 3 {
+			fmt.Fprintln(os.Stderr, "Error: history command takes a file and an optional output file")
 			os.Exit(1)
 		}
-	}
-
-	if len(args) < 2 {
-		printUsage()
-		os.Exit(1)
-	}
-
-	command := args[0]
-	inputPath := args[1]
-	outputPath := ""
-
-	// Determine input/output formats and required args based on command
-	var inputJSON, outputJSON bool
-	var needsOutput bool
-
-	switch command {
+		if len(args) == 3 {
+			outputPath = args[2]
+		}
+		if opts.snapshotDir == "" {
+			fmt.Fprintln(os.Stderr, "Error: history requires --snapshots")
+			os.Exit(1)
+		}
+		if err := runHistory(inputPath, outputPath, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "validate":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: validate command does not accept an output file")
+			os.Exit(1)
+		}
+		if opts.schema == nil {
+			fmt.Fprintln(os.Stderr, "Error: validate requires --schema")
+			os.Exit(1)
+		}
+		if err := runValidate(inputPath, opts.schema, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "inspect":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: inspect command does not accept an output file")
+			os.Exit(1)
+		}
+		if !opts.inspectReserved {
+			fmt.Fprintln(os.Stderr, "Error: inspect requires --reserved")
+			os.Exit(1)
+		}
+		if err := runInspect(inputPath, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "cross-check":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: cross-check command does not accept an output file")
+			os.Exit(1)
+		}
+		if opts.otherDecoder == "" {
+			fmt.Fprintln(os.Stderr, "Error: cross-check requires --other")
+			os.Exit(1)
+		}
+		if err := runCrossCheck(inputPath, opts.otherDecoder, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "stats":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "Error: stats command does not accept an output file")
+			os.Exit(1)
+		}
+		if isJSONPath(inputPath) {
+			fmt.Fprintln(os.Stderr, "Error: stats requires BONJSON input")
+			os.Exit(1)
+		}
+		if err := runStats(inputPath, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	case "j":
 		inputJSON = true
 		needsOutput = false
 	case "b":
 		inputJSON = false
 		needsOutput = false
+	case "anonymize":
+		// Formats follow the file names; stdin and stdout are JSON.
+		inputJSON = !isBONJSONPath(inputPath)
+		if len(args) > 2 {
+			outputJSON = !isBONJSONPath(args[2])
+		}
+		needsOutput = true
+		if len(opts.anonymizeFields) == 0 || opts.anonymizeKey == nil {
+			fmt.Fprintln(os.Stderr, "Error: anonymize requires --field and --key-file")
+			os.Exit(1)
+		}
 	case "j2b":
 		inputJSON = true
 		outputJSON = false
@@ -175,96 +1903,212 @@ func main() {
 		}
 	}
 
-	if err := convert(inputPath, outputPath, inputJSON, outputJSON, allowTrailing, skipBytes, printEndOffset, allowNUL, dupKeyMode, utf8Mode, nanInfMode); err != nil {
+	if opts.outTemplate != "" {
+		if outputPath == "" || outputPath == "-" {
+			fmt.Fprintln(os.Stderr, "Error: --out-template requires an output directory")
+			os.Exit(1)
+		}
+		if err := checkOutTemplate(opts.outTemplate, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if (opts.preserveMode || opts.preserveTimes) && (opts.splitSize > 0 || opts.splitDocs > 0) {
+		fmt.Fprintln(os.Stderr, "Error: --preserve-mode and --preserve-times cannot be used with --split-size or --split-docs")
+		os.Exit(1)
+	}
+	if opts.inputFormat == "bontext" {
+		inputJSON = false
+	}
+	if opts.provenanceFile != "" && inputJSON {
+		fmt.Fprintln(os.Stderr, "Error: --provenance requires BONJSON input")
+		os.Exit(1)
+	}
+	if opts.offsetMapFile != "" {
+		switch {
+		case outputPath == "" || outputJSON || opts.outputFormat != "":
+			fmt.Fprintln(os.Stderr, "Error: --offset-map requires BONJSON output")
+			os.Exit(1)
+		case opts.splitSize > 0 || opts.splitDocs > 0:
+			fmt.Fprintln(os.Stderr, "Error: --offset-map cannot be used with --split-size or --split-docs")
+			os.Exit(1)
+		}
+	}
+	if opts.decodeTraceFile != "" && inputJSON {
+		fmt.Fprintln(os.Stderr, "Error: --trace-decode requires BONJSON input")
+		os.Exit(1)
+	}
+	if opts.schemaTypes != nil && !inputJSON {
+		fmt.Fprintln(os.Stderr, "Error: --schema-types requires JSON input")
+		os.Exit(1)
+	}
+	if opts.timeFiltered() {
+		switch {
+		case inputJSON:
+			fmt.Fprintln(os.Stderr, "Error: --since and --until require BONJSON input")
+			os.Exit(1)
+		case !opts.stream:
+			fmt.Fprintln(os.Stderr, "Error: --since and --until require --stream")
+			os.Exit(1)
+		case opts.provenanceFile != "" || opts.envelope || opts.lossinessReport || opts.printEndOffset || opts.trailingOut != "":
+			fmt.Fprintln(os.Stderr, "Error: --since and --until cannot be combined with --provenance, --envelope, --lossiness-report, -e, or --trailing-out")
+			os.Exit(1)
+		}
+	}
+
+	notifyProgress()
+	stopProfiling, err := startProfiling(&opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	err = runBatch(command, inputPath, outputPath, inputJSON, outputJSON, &opts)
+	if stopErr := stopProfiling(); err == nil {
+		err = stopErr
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-// convert reads the input and converts it to the specified output format.
-// If inputPath is "-", reads from stdin. If outputPath is "-", output goes to
+// convert reads the input and converts it to the specified output format. If
+// inputPath is "-", reads from stdin. If outputPath is "-", output goes to
 // stdout. If outputPath is empty, only validates the input without producing
-// output. inputJSON and outputJSON specify the formats. If allowTrailing is
-// true, trailing data after a BONJSON document is ignored. If skipBytes > 0,
-// that many bytes are skipped before decoding. If printEndOffset is true and
-// input is BONJSON, prints the end offset to stderr. allowNUL, dupKeyMode,
-// utf8Mode, and nanInfMode configure BONJSON behavior for NUL characters,
-// duplicate keys, invalid UTF-8 sequences, and special float values respectively.
-func convert(inputPath, outputPath string, inputJSON, outputJSON bool, allowTrailing bool, skipBytes int, printEndOffset bool, allowNUL bool, dupKeyMode, utf8Mode, nanInfMode string) error {
+// output. inputJSON and outputJSON specify the formats, unless
+// opts.inputFormat overrides the input side or opts.outputFormat the output
+// side. If opts.stream is true, the input is a sequence of concatenated
+// documents rather than a single one. If opts.allowTrailing is true, trailing
+// data after a BONJSON document is ignored. If opts.skipBytes > 0, that many
+// bytes are skipped before decoding. If opts.printEndOffset is true and input
+// is BONJSON, prints the end offset to stderr. opts.allowNUL, opts.dupKeyMode,
+// opts.utf8Mode, and opts.nanInfMode configure BONJSON behavior for NUL
+// characters, duplicate keys, invalid UTF-8 sequences, and special float
+// values respectively.
+func convert(inputPath, outputPath string, inputJSON, outputJSON bool, opts *options) error {
+	progress.begin(inputPath)
+	if usePipeline(outputPath, inputJSON, opts) {
+		return convertStream(inputPath, outputPath, inputJSON, outputJSON, opts)
+	}
+
 	var data []byte
 	var err error
 	if inputPath == "-" {
-		data, err = io.ReadAll(os.Stdin)
+		data, err = io.ReadAll(progressReader{os.Stdin})
 		if err != nil {
 			return fmt.Errorf("reading stdin: %w", err)
 		}
 	} else {
-		data, err = os.ReadFile(inputPath)
+		data, err = readFile(inputPath)
 		if err != nil {
 			return fmt.Errorf("reading input file: %w", err)
 		}
+		progress.advance(len(data))
 	}
-
-	if skipBytes > 0 {
-		if skipBytes >= len(data) {
-			return fmt.Errorf("skip value %d exceeds input size %d", skipBytes, len(data))
+	if opts.expectSHA256 != "" {
+		if err := checkSHA256(data, opts.expectSHA256); err != nil {
+			return err
+		}
+	}
+	var cacheKey string
+	if cacheable(outputPath, opts) {
+		cacheKey = conversionCacheKey(data, inputJSON, outputJSON, opts)
+		if output, ok := cachedOutput(cacheKey, opts); ok {
+			if len(output) > 0 {
+				if err := writeOutput(output, outputPath, outputJSON); err != nil {
+					return err
+				}
+				progress.written.Add(int64(len(output)))
+			}
+			return nil
+		}
+	}
+	if opts.inputFormat == "bontext" {
+		if data, err = parseBontext(data); err != nil {
+			return fmt.Errorf("invalid bonjson-text: %w", err)
 		}
-		data = data[skipBytes:]
 	}
 
-	if len(data) == 0 {
-		return fmt.Errorf("input is empty")
+	windows := opts.windows
+	if len(windows) == 0 {
+		windows = []inputWindow{{start: opts.skipBytes, length: -1}}
 	}
 
-	// Decode input
-	var value any
-	var byteCount int64
+	// Decode the payload in each window
+	var docs []any
 	var decodeErr error
-
-	if inputJSON {
-		if err := json.Unmarshal(data, &value); err != nil {
-			return fmt.Errorf("invalid JSON: %w", err)
+	var lossiness lossinessReport
+	sources := provenance{Source: inputPath}
+	var envelopeSources []*documentSource
+	var trace *os.File
+	if opts.decodeTraceFile != "" {
+		if trace, err = os.Create(opts.decodeTraceFile); err != nil {
+			return fmt.Errorf("creating decode trace: %w", err)
 		}
-	} else {
-		dec := bonjson.NewDecoder(bytes.NewReader(data))
-		if allowNUL {
-			dec.AllowNUL()
-		}
-		switch dupKeyMode {
-		case "keepfirst":
-			dec.SetDuplicateKeyMode(bonjson.DupKeyKeepFirst)
-		case "keeplast":
-			dec.SetDuplicateKeyMode(bonjson.DupKeyKeepLast)
-		}
-		switch utf8Mode {
-		case "replace":
-			dec.SetInvalidUTF8Mode(bonjson.UTF8Replace)
-		case "delete":
-			dec.SetInvalidUTF8Mode(bonjson.UTF8Delete)
-		case "ignore":
-			dec.SetInvalidUTF8Mode(bonjson.UTF8Ignore)
-		}
-		switch nanInfMode {
-		case "allow":
-			dec.SetNaNInfinityMode(bonjson.NaNInfAllow)
-		case "stringify":
-			dec.SetNaNInfinityMode(bonjson.NaNInfStringify)
-		}
-		decodeErr = dec.Decode(&value)
-		byteCount = dec.InputOffset()
-		if decodeErr == nil && byteCount < int64(len(data)) {
-			decodeErr = &bonjson.TrailingDataError{Offset: byteCount}
+		defer trace.Close()
+	}
+	for i, w := range windows {
+		payload, err := w.slice(data)
+		if err == nil && trace != nil {
+			if err = traceDecode(trace, data[:w.start+len(payload)], w.start); err != nil {
+				err = fmt.Errorf("writing decode trace: %w", err)
+			}
 		}
-		if decodeErr != nil {
-			var trailingErr *bonjson.TrailingDataError
-			if allowTrailing && errors.As(decodeErr, &trailingErr) {
-				decodeErr = nil
+		if err == nil && len(payload) == 0 {
+			payload, err = emptyInput(inputJSON, opts)
+		}
+		// A scan error while filtering by time is reported like a decode
+		// error, after the documents before it.
+		var filterErr error
+		if err == nil && opts.timeFiltered() {
+			payload, filterErr = io.ReadAll(filterTimeWindow(bytes.NewReader(payload), opts))
+		}
+		if err == nil {
+			var windowDocs []any
+			windowDocs, decodeErr, err = decodePayload(payload, w.start, inputJSON, opts)
+			if decodeErr == nil {
+				decodeErr = filterErr
+			}
+			docs = append(docs, windowDocs...)
+			if err == nil && opts.lossinessReport {
+				lossiness.analyze(payload, windowDocs, inputJSON, outputJSON, opts)
+			}
+			if err == nil && opts.provenanceFile != "" {
+				sources.scan(payload, int64(w.start))
 			}
+			if err == nil && opts.envelope {
+				envelopeSources = append(envelopeSources, scanDocumentSources(payload, int64(w.start), len(windowDocs), inputJSON)...)
+			}
+		}
+		if len(windows) > 1 {
+			if err != nil {
+				err = fmt.Errorf("window %d: %w", i, err)
+			}
+			if decodeErr != nil {
+				decodeErr = fmt.Errorf("window %d: %w", i, decodeErr)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if decodeErr != nil {
+			break
 		}
-		if printEndOffset {
-			fmt.Fprintf(os.Stderr, "%d\n", skipBytes+int(byteCount))
+	}
+	if opts.lossinessReport {
+		lossiness.print(os.Stderr)
+	}
+	if opts.provenanceFile != "" {
+		if err := sources.write(opts.provenanceFile); err != nil {
+			return err
 		}
 	}
+	if len(windows) > 1 {
+		// Each window's documents are output as a stream.
+		streamed := *opts
+		streamed.stream = true
+		opts = &streamed
+	}
 
 	// Validate-only mode: no output
 	if outputPath == "" {
@@ -274,26 +2118,37 @@ func convert(inputPath, outputPath string, inputJSON, outputJSON bool, allowTrai
 		return nil
 	}
 
-	// Encode output
-	var output []byte
-	if outputJSON {
-		output, err = json.MarshalIndent(value, "", "    ")
-		if err != nil {
-			return fmt.Errorf("encoding JSON: %w", err)
+	docs, err = transformDocuments(docs, inputPath, opts)
+	if err != nil {
+		return err
+	}
+	if opts.envelope {
+		converted := time.Now()
+		for i, doc := range docs {
+			docs[i] = envelope(doc, inputPath, envelopeSources[i], converted)
 		}
-	} else {
-		var buf bytes.Buffer
-		enc := bonjson.NewEncoder(&buf)
-		switch nanInfMode {
-		case "allow":
-			enc.SetNaNInfinityMode(bonjson.NaNInfAllow)
-		case "stringify":
-			enc.SetNaNInfinityMode(bonjson.NaNInfStringify)
+	}
+	if opts.targetProfile != nil {
+		if err := checkTargetProfile(os.Stderr, docs, opts.targetProfile); err != nil {
+			return err
+		}
+	}
+
+	if opts.splitSize > 0 || opts.splitDocs > 0 {
+		if err := writeShards(docs, outputPath, outputJSON, opts); err != nil {
+			return err
 		}
-		if err := enc.Encode(value); err != nil {
-			return fmt.Errorf("encoding BONJSON: %w", err)
+		progress.documents.Add(int64(len(docs)))
+		if decodeErr != nil {
+			return fmt.Errorf("decoding BONJSON: %w", decodeErr)
 		}
-		output = buf.Bytes()
+		return nil
+	}
+
+	// Encode output
+	output, err := encodeOutput(docs, outputJSON, opts)
+	if err != nil {
+		return err
 	}
 
 	// Write output (may be partial on BONJSON decode error)
@@ -301,40 +2156,403 @@ func convert(inputPath, outputPath string, inputJSON, outputJSON bool, allowTrai
 		if err := writeOutput(output, outputPath, outputJSON); err != nil {
 			return err
 		}
+		progress.written.Add(int64(len(output)))
+	}
+	if opts.offsetMapFile != "" {
+		if err := mapOffsets(output, outputPath).write(opts.offsetMapFile); err != nil {
+			return err
+		}
 	}
+	progress.documents.Add(int64(len(docs)))
 
 	// Report any decode error after writing partial output
 	if decodeErr != nil {
 		return fmt.Errorf("decoding BONJSON: %w", decodeErr)
 	}
+	if opts.snapshotDir != "" {
+		if err := takeSnapshot(inputPath, docs, opts); err != nil {
+			return err
+		}
+	}
+	if cacheKey != "" {
+		storeCachedOutput(cacheKey, output, opts)
+	}
+
+	return nil
+}
+
+// decodePayload decodes the documents in payload, which starts at offset
+// start of the input. A BONJSON decoding error is returned as decodeErr,
+// along with the documents decoded before it; any other error means nothing
+// could be decoded.
+func decodePayload(payload []byte, start int, inputJSON bool, opts *options) (docs []any, decodeErr, err error) {
+	if inputJSON {
+		if opts.strictJSON {
+			if err := validateStrictJSON(payload, opts.stream); err != nil {
+				return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+		}
+		docs, err = decodeJSON(payload, opts)
+		if errors.Is(err, errWarning) {
+			return nil, nil, err
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		if err := checkAssertions(docs, payload, start, true, opts); err != nil {
+			return nil, nil, err
+		}
+		return docs, nil, nil
+	}
+
+	docs, byteCount, decodeErr := decodeBONJSON(payload, opts)
+	if err := checkAssertions(docs, payload, start, false, opts); err != nil {
+		return nil, nil, err
+	}
+	if opts.printEndOffset {
+		fmt.Fprintf(os.Stderr, "%d\n", start+int(byteCount))
+	}
+	if opts.trailingOut != "" && decodeErr == nil {
+		if err := writeTrailing(payload[byteCount:], start+int(byteCount), opts.trailingOut); err != nil {
+			return nil, nil, err
+		}
+	}
+	return docs, decodeErr, nil
+}
+
+// decodeJSON decodes the JSON document in data, or every whitespace-separated
+// document (such as NDJSON) in stream mode.
+func decodeJSON(data []byte, opts *options) ([]any, error) {
+	var docs []any
+	err := decodeJSONEach(bytes.NewReader(data), opts, func(doc any) error {
+		if !opts.stream && len(docs) == 1 {
+			return errExtraDocument
+		}
+		docs = append(docs, doc)
+		return nil
+	})
+	var decodeErr *codec.DecodeError
+	switch {
+	case err == errExtraDocument, !opts.stream && errors.As(err, &decodeErr) && decodeErr.Document > 0:
+		return nil, fmt.Errorf("invalid data after top-level value")
+	case err != nil:
+		return nil, err
+	case !opts.stream && len(docs) == 0:
+		return nil, io.ErrUnexpectedEOF
+	}
+	return docs, nil
+}
+
+// errExtraDocument stops decoding JSON that is not a stream at a second
+// document.
+var errExtraDocument = errors.New("extra document")
+
+// decodeBONJSON decodes the BONJSON document in data, or every concatenated
+// document in stream mode. On error it returns whatever was decoded so far
+// along with the error. The returned byte count is the offset at which
+// decoding stopped. Object keys are interned across all documents.
+func decodeBONJSON(data []byte, opts *options) ([]any, int64, error) {
+	dec := newBONJSONDecoder(bytes.NewReader(data), opts)
+	interner := make(keyInterner)
+	var docs []any
+	for {
+		var value any
+		err := dec.Decode(&value)
+		byteCount := dec.InputOffset()
+		interner.internKeys(value)
+
+		if !opts.stream {
+			if err == nil && byteCount < int64(len(data)) {
+				err = &bonjson.TrailingDataError{Offset: byteCount}
+			}
+			if err != nil {
+				var trailingErr *bonjson.TrailingDataError
+				if opts.allowTrailing && errors.As(err, &trailingErr) {
+					err = nil
+				}
+			}
+			return []any{value}, byteCount, err
+		}
+
+		if err != nil {
+			if value != nil {
+				docs = append(docs, value)
+			}
+			return docs, byteCount, fmt.Errorf("document %d: %w", len(docs), err)
+		}
+		docs = append(docs, value)
+		if byteCount >= int64(len(data)) {
+			return docs, byteCount, nil
+		}
+	}
+}
+
+// newBONJSONEncoder returns a BONJSON encoder configured from opts. Its
+// limits are 0, for none, unless set.
+func newBONJSONEncoder(w io.Writer, opts *options) *bonjson.Encoder {
+	enc := bonjson.NewEncoder(w)
+	switch opts.nanInfMode {
+	case "allow":
+		enc.SetNaNInfinityMode(bonjson.NaNInfAllow)
+	case "stringify":
+		enc.SetNaNInfinityMode(bonjson.NaNInfStringify)
+	}
+	if opts.encodeNUL {
+		enc.AllowNUL()
+	}
+	enc.SetMaxDepth(opts.encodeMaxDepth)
+	enc.SetMaxBigNumberMagnitude(opts.encodeMaxBigNum)
+	enc.SetMaxBigNumberExponent(opts.encodeMaxBigExp)
+	return enc
+}
+
+// parseEncoderLimit parses the value of an --encode-max-* option: a
+// non-negative number, 0 for no limit.
+func parseEncoderLimit(s string) (int, error) {
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid limit: %s (expected a number, 0 for none)", s)
+	}
+	return limit, nil
+}
+
+// newBONJSONDecoder returns a BONJSON decoder configured from opts.
+func newBONJSONDecoder(r io.Reader, opts *options) *bonjson.Decoder {
+	dec := bonjson.NewDecoder(r)
+	if opts.allowNUL {
+		dec.AllowNUL()
+	}
+	switch opts.dupKeyMode {
+	case "keepfirst":
+		dec.SetDuplicateKeyMode(bonjson.DupKeyKeepFirst)
+	case "keeplast":
+		dec.SetDuplicateKeyMode(bonjson.DupKeyKeepLast)
+	}
+	switch opts.utf8Mode {
+	case "replace":
+		dec.SetInvalidUTF8Mode(bonjson.UTF8Replace)
+	case "delete":
+		dec.SetInvalidUTF8Mode(bonjson.UTF8Delete)
+	case "ignore":
+		dec.SetInvalidUTF8Mode(bonjson.UTF8Ignore)
+	}
+	switch opts.nanInfMode {
+	case "allow":
+		dec.SetNaNInfinityMode(bonjson.NaNInfAllow)
+	case "stringify":
+		dec.SetNaNInfinityMode(bonjson.NaNInfStringify)
+	}
+	return dec
+}
+
+// encodeOutput encodes the decoded documents in the output format. In stream
+// mode, JSON documents are each followed by a newline and BONJSON documents
+// are concatenated.
+func encodeOutput(docs []any, outputJSON bool, opts *options) ([]byte, error) {
+	switch opts.outputFormat {
+	case "table":
+		output, err := renderTable(docs, opts)
+		if err != nil {
+			return nil, fmt.Errorf("rendering table: %w", err)
+		}
+		return output, nil
+	case "csv":
+		output, err := renderCSV(docs, opts)
+		if err != nil {
+			return nil, fmt.Errorf("rendering CSV: %w", err)
+		}
+		return output, nil
+	case "bontext":
+		encoded, err := encodeDocuments(docs, false, opts)
+		if err != nil {
+			return nil, err
+		}
+		return renderBontext(bytes.Join(encoded, nil))
+	}
+
+	encoded, err := encodeDocuments(docs, outputJSON, opts)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join(encoded, nil), nil
+}
+
+// encodeDocuments encodes each document separately as JSON or BONJSON. In
+// stream mode, each JSON document includes a trailing newline.
+func encodeDocuments(docs []any, outputJSON bool, opts *options) ([][]byte, error) {
+	encoded := make([][]byte, len(docs))
+	for i, value := range docs {
+		doc, err := encodeDocument(value, outputJSON, opts)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = doc
+	}
+	return encoded, nil
+}
+
+// encodeDocument encodes one document as JSON or BONJSON. JSON is indented
+// by four spaces unless --indent says otherwise, and laid out to --width and
+// --compact-arrays if set. In stream mode, a JSON document includes a trailing newline.
+func encodeDocument(value any, outputJSON bool, opts *options) ([]byte, error) {
+	if outputJSON {
+		if opts.decimals == "tag" {
+			value = tagDecimals(value)
+		}
+		if opts.uint64Mode != "" {
+			limited, err := limitIntegers(value, nil, opts.uint64Mode)
+			if err != nil {
+				return nil, fmt.Errorf("encoding JSON: %w", err)
+			}
+			value = limited
+		}
+		indent := "    "
+		if opts.indent != nil {
+			indent = strings.Repeat(" ", *opts.indent)
+		}
+		var doc []byte
+		var err error
+		switch {
+		case indent == "":
+			doc, err = json.Marshal(value)
+		case opts.width > 0 || opts.compactArrays:
+			printer := prettyPrinter{indent: indent, width: opts.width, compactArrays: opts.compactArrays}
+			doc, err = printer.marshal(value)
+		default:
+			doc, err = json.MarshalIndent(value, "", indent)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encoding JSON: %w", err)
+		}
+		if opts.stream {
+			doc = append(doc, '\n')
+		}
+		return doc, nil
+	}
+
+	var buf bytes.Buffer
+	if err := newBONJSONEncoder(&buf, opts).Encode(value); err != nil {
+		return nil, fmt.Errorf("encoding BONJSON: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseIndent parses an --indent value.
+func parseIndent(s string) (int, error) {
+	indent, err := strconv.Atoi(s)
+	if err != nil || indent < 0 || indent > 16 {
+		return 0, fmt.Errorf("invalid indent: %s (expected 0 to 16)", s)
+	}
+	return indent, nil
+}
+
+// parseWidth parses a --width value.
+func parseWidth(s string) (int, error) {
+	width, err := strconv.Atoi(s)
+	if err != nil || width < 1 {
+		return 0, fmt.Errorf("invalid width: %s (expected a positive number of columns)", s)
+	}
+	return width, nil
+}
+
+// loadDocument reads an auxiliary document such as a defaults file. Files
+// named *.boj or *.bonjson are decoded as BONJSON, everything else as JSON.
+func loadDocument(filename string) (any, error) {
+	var value any
+	if err := loadDocumentInto(filename, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
 
+// loadDocumentInto reads filename into v, as BONJSON if the file is named
+// *.boj or *.bonjson, and as JSON otherwise.
+func loadDocumentInto(filename string, v any) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if isBONJSONPath(filename) {
+		err = bonjson.Unmarshal(data, v)
+	} else {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
 	return nil
 }
 
+// isJSONPath reports whether filename names a JSON file (*.json).
+func isJSONPath(filename string) bool {
+	f := formatForPath(filename)
+	return f != nil && f.Name == "json"
+}
+
+// isBONJSONPath reports whether filename names a BONJSON file (*.boj or
+// *.bonjson).
+func isBONJSONPath(filename string) bool {
+	f := formatForPath(filename)
+	return f != nil && f.Name == "bonjson"
+}
+
+// saveDocument writes v to filename, as BONJSON if the file is named *.boj or
+// *.bonjson, and as indented JSON otherwise.
+func saveDocument(filename string, v any) error {
+	var data []byte
+	var err error
+	if isBONJSONPath(filename) {
+		data, err = bonjson.Marshal(v)
+	} else {
+		data, err = json.MarshalIndent(v, "", "    ")
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
 // writeOutput writes data to the specified file, or to stdout if path is empty
-// or "-". When outputting JSON to stdout, a trailing newline is added for
-// better terminal display.
+// or "-". Files are replaced atomically. When outputting JSON to stdout, a
+// trailing newline is added for better terminal display.
 func writeOutput(data []byte, outputPath string, isJSON bool) error {
-	var w io.Writer
-	if outputPath == "" || outputPath == "-" {
-		w = os.Stdout
-	} else {
-		f, err := os.Create(outputPath)
-		if err != nil {
-			return fmt.Errorf("creating output file: %w", err)
+	if outputPath != "" && outputPath != "-" {
+		if err := writeFileAtomic(outputPath, data); err != nil {
+			return fmt.Errorf("writing output: %w", err)
 		}
-		defer f.Close()
-		w = f
+		return nil
 	}
 
-	if _, err := w.Write(data); err != nil {
+	if _, err := os.Stdout.Write(data); err != nil {
 		return fmt.Errorf("writing output: %w", err)
 	}
 
 	// Add trailing newline for JSON output to stdout for better terminal display
 	if outputPath == "" && isJSON {
-		fmt.Fprintln(w)
+		fmt.Println()
 	}
 
 	return nil
 }
+
+// writeTrailing writes the trailing data found after a BONJSON document to
+// filename, and reports its offset and length to stderr.
+func writeTrailing(trailing []byte, offset int, filename string) error {
+	if err := writeFileAtomic(filename, trailing); err != nil {
+		return fmt.Errorf("writing trailing data: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "trailing data: offset %d, length %d\n", offset, len(trailing))
+	return nil
+}
+
+// splitList splits a comma-separated option value into its non-empty,
+// whitespace-trimmed elements.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
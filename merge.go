@@ -0,0 +1,117 @@
+// ABOUTME: Structural merging of decoded documents.
+// ABOUTME: Used to lay an input document over a defaults document, and by the combine command.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+)
+
+// deepMerge returns overlay merged on top of base. Objects are merged key by
+// key, recursively; any other overlay value replaces the base value
+// entirely. If nullsAsAbsent is true, a null in the overlay does not replace
+// the base value. Neither argument is modified, but the result may share
+// unmodified subtrees with them.
+func deepMerge(base, overlay any, nullsAsAbsent bool) any {
+	return merge(base, overlay, nullsAsAbsent, false)
+}
+
+// merge implements deepMerge. If concatArrays is true, an array in the
+// overlay is appended to an array in the base instead of replacing it.
+func merge(base, overlay any, nullsAsAbsent, concatArrays bool) any {
+	if overlay == nil && nullsAsAbsent {
+		return base
+	}
+	if concatArrays {
+		baseArray, baseOK := base.([]any)
+		overlayArray, overlayOK := overlay.([]any)
+		if baseOK && overlayOK {
+			return append(append(make([]any, 0, len(baseArray)+len(overlayArray)), baseArray...), overlayArray...)
+		}
+	}
+	baseObj, ok := base.(map[string]any)
+	if !ok {
+		return overlay
+	}
+	overlayObj, ok := overlay.(map[string]any)
+	if !ok {
+		return overlay
+	}
+	merged := make(map[string]any, len(baseObj)+len(overlayObj))
+	for key, value := range baseObj {
+		merged[key] = value
+	}
+	for key, value := range overlayObj {
+		if baseValue, ok := baseObj[key]; ok {
+			merged[key] = merge(baseValue, value, nullsAsAbsent, concatArrays)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// combineDocuments merges docs into one, each over the ones before it:
+//
+//	deep-merge     objects merge recursively, anything else is replaced
+//	last-wins      top-level keys of later objects replace earlier ones whole
+//	concat-arrays  like deep-merge, but arrays are concatenated
+//
+// If any document is not an object, the later document replaces the earlier
+// one under every strategy, except that arrays concatenate under
+// concat-arrays.
+func combineDocuments(docs []any, strategy string, nullsAsAbsent bool) (any, error) {
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("nothing to combine")
+	}
+	combined := docs[0]
+	for _, doc := range docs[1:] {
+		switch strategy {
+		case "", "deep-merge":
+			combined = merge(combined, doc, nullsAsAbsent, false)
+		case "concat-arrays":
+			combined = merge(combined, doc, nullsAsAbsent, true)
+		case "last-wins":
+			baseObj, baseOK := combined.(map[string]any)
+			overlayObj, overlayOK := doc.(map[string]any)
+			if !baseOK || !overlayOK {
+				if doc != nil || !nullsAsAbsent {
+					combined = doc
+				}
+				continue
+			}
+			merged := maps.Clone(baseObj)
+			for key, value := range overlayObj {
+				if value != nil || !nullsAsAbsent {
+					merged[key] = value
+				}
+			}
+			combined = merged
+		default:
+			return nil, fmt.Errorf("unknown merge strategy: %s (expected deep-merge, last-wins, or concat-arrays)", strategy)
+		}
+	}
+	return combined, nil
+}
+
+// cloneValue returns a deep copy of v, so that later in-place transforms of
+// the copy cannot affect the original.
+func cloneValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		clone := make(map[string]any, len(v))
+		for key, elem := range v {
+			clone[key] = cloneValue(elem)
+		}
+		return clone
+	case []any:
+		clone := make([]any, len(v))
+		for i, elem := range v {
+			clone[i] = cloneValue(elem)
+		}
+		return clone
+	default:
+		return v
+	}
+}
@@ -0,0 +1,177 @@
+// ABOUTME: The merge3 and mergetool commands: structural three-way merge, usable as a git merge driver or mergetool.
+// ABOUTME: Conflicts are left in the merged document as {"$conflict": ...} marker objects, as diff3 leaves them in text.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// conflictMarker is the key of a conflict marker object, which takes the place
+// of a value that could not be merged. Its value holds the conflicting values
+// under "ours", "base", and "theirs"; a side that deleted the value has no
+// key.
+const conflictMarker = "$conflict"
+
+// runMerge3 merges the changes from basePath to oursPath and from basePath to
+// theirsPath, and writes the result to opts.outFile, or to stdout as JSON if
+// it is not set. See mergeFiles.
+func runMerge3(basePath, oursPath, theirsPath string, opts *options) error {
+	return mergeFiles(basePath, oursPath, theirsPath, opts.outFile, opts)
+}
+
+// runMergetool is merge3 with git mergetool's arguments: it merges LOCAL and
+// REMOTE from BASE into MERGED, in the format MERGED's name gives, or else
+// LOCAL's.
+func runMergetool(basePath, localPath, remotePath, mergedPath string, opts *options) error {
+	return mergeFiles(basePath, localPath, remotePath, mergedPath, opts)
+}
+
+// mergeFiles three-way merges the documents in oursPath and theirsPath from
+// basePath and writes the result to outPath, or to stdout as JSON if it is
+// empty. Each file is decoded by its content, so the temporary files git
+// passes to merge drivers and mergetools can be read whatever their names. An
+// empty base file means the two sides have no common ancestor. An output file
+// without a format extension gets the format of oursPath, which is what a
+// merge driver writing back over %A needs. If any change conflicts, the
+// output holds conflict markers and an error lists where.
+func mergeFiles(basePath, oursPath, theirsPath, outPath string, opts *options) error {
+	base, _, err := loadDetectedDocument(basePath, opts)
+	hasBase := err == nil
+	if err != nil && !errors.Is(err, errEmptyDocument) {
+		return fmt.Errorf("reading base: %w", err)
+	}
+	ours, oursFormat, err := loadDetectedDocument(oursPath, opts)
+	if err != nil {
+		return fmt.Errorf("reading ours: %w", err)
+	}
+	theirs, _, err := loadDetectedDocument(theirsPath, opts)
+	if err != nil {
+		return fmt.Errorf("reading theirs: %w", err)
+	}
+
+	var conflicts []string
+	merged, _ := mergeValues(base, ours, theirs, hasBase, true, true, "", &conflicts)
+
+	outputJSON := true
+	if outPath != "" {
+		f := formatForPath(outPath)
+		if f == nil {
+			f = oursFormat
+		}
+		outputJSON = f.Name == "json"
+	}
+	output, err := encodeDocument(merged, outputJSON, opts)
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(output, outPath, outputJSON); err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		for _, pointer := range conflicts {
+			fmt.Fprintf(os.Stderr, "conflict at %q\n", pointer)
+		}
+		return fmt.Errorf("%d conflicts", len(conflicts))
+	}
+	return nil
+}
+
+// errEmptyDocument is returned by loadDetectedDocument for an empty file.
+var errEmptyDocument = errors.New("file is empty")
+
+// loadDetectedDocument reads the single document in filename, in the format
+// its content shows.
+func loadDetectedDocument(filename string, opts *options) (any, *format, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%s: %w", filename, errEmptyDocument)
+	}
+	f, err := detectFormat(data, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	docs, err := decodeBuffer(data, f.Name == "json", opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return docs[0], f, nil
+}
+
+// mergeValues merges the value at the JSON pointer pointer, where each hasX
+// says whether the value exists on that side. It returns the merged value and
+// whether it exists. A change made on one side only is taken; the same change
+// made on both sides is taken once. Objects whose members changed on both
+// sides are merged member by member, and arrays likewise if all three have
+// the same length. Anything else changed on both sides is a conflict: a
+// conflict marker takes its place and its pointer is added to conflicts.
+func mergeValues(base, ours, theirs any, hasBase, hasOurs, hasTheirs bool, pointer string, conflicts *[]string) (any, bool) {
+	same := func(a, b any, hasA, hasB bool) bool {
+		return hasA == hasB && (!hasA || jsonEqual(a, b))
+	}
+	switch {
+	case same(ours, theirs, hasOurs, hasTheirs):
+		return ours, hasOurs
+	case same(base, ours, hasBase, hasOurs):
+		return theirs, hasTheirs
+	case same(base, theirs, hasBase, hasTheirs):
+		return ours, hasOurs
+	}
+
+	baseObject, baseIsObject := base.(map[string]any)
+	oursObject, oursIsObject := ours.(map[string]any)
+	theirsObject, theirsIsObject := theirs.(map[string]any)
+	if oursIsObject && theirsIsObject && (baseIsObject || !hasBase) {
+		merged := make(map[string]any)
+		keys := slices.Collect(maps.Keys(oursObject))
+		for key := range theirsObject {
+			if _, ok := oursObject[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			b, hasB := baseObject[key]
+			o, hasO := oursObject[key]
+			t, hasT := theirsObject[key]
+			if value, ok := mergeValues(b, o, t, hasB, hasO, hasT, pointer+"/"+escapePointerToken(key), conflicts); ok {
+				merged[key] = value
+			}
+		}
+		return merged, true
+	}
+
+	baseArray, baseIsArray := base.([]any)
+	oursArray, oursIsArray := ours.([]any)
+	theirsArray, theirsIsArray := theirs.([]any)
+	if baseIsArray && oursIsArray && theirsIsArray &&
+		len(baseArray) == len(oursArray) && len(oursArray) == len(theirsArray) {
+		merged := make([]any, len(oursArray))
+		for i := range merged {
+			merged[i], _ = mergeValues(baseArray[i], oursArray[i], theirsArray[i], true, true, true,
+				pointer+"/"+strconv.Itoa(i), conflicts)
+		}
+		return merged, true
+	}
+
+	*conflicts = append(*conflicts, pointer)
+	sides := make(map[string]any)
+	if hasOurs {
+		sides["ours"] = ours
+	}
+	if hasBase {
+		sides["base"] = base
+	}
+	if hasTheirs {
+		sides["theirs"] = theirs
+	}
+	return map[string]any{conflictMarker: sides}, true
+}
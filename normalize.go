@@ -0,0 +1,59 @@
+// ABOUTME: Unicode normalization of strings applied during conversion (--nfc, --nfd).
+// ABOUTME: Makes text that looks the same encode the same, whichever source it was assembled from.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizationForms are the Unicode normalization forms, by option name.
+var normalizationForms = map[string]norm.Form{
+	"nfc": norm.NFC,
+	"nfd": norm.NFD,
+}
+
+// normalizeStrings puts every string value and object key within v into
+// Unicode normalization form form, and returns the result. It is an error
+// for two keys of one object to normalize to the same key, since one of the
+// members would be lost.
+func normalizeStrings(v any, at path, form norm.Form) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return form.String(v), nil
+	case map[string]any:
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			elem := v[key]
+			normalized, err := normalizeStrings(elem, append(at, pathSegment{key: key}), form)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = normalized
+		}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			elem := v[key]
+			normalizedKey := form.String(key)
+			if normalizedKey == key {
+				continue
+			}
+			if _, exists := v[normalizedKey]; exists {
+				return nil, fmt.Errorf("at %s: keys %+q and %+q are the same once normalized", at, key, normalizedKey)
+			}
+			delete(v, key)
+			v[normalizedKey] = elem
+		}
+	case []any:
+		for i, elem := range v {
+			normalized, err := normalizeStrings(elem, append(at, pathSegment{index: i, isIndex: true}), form)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = normalized
+		}
+	}
+	return v, nil
+}
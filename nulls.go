@@ -0,0 +1,27 @@
+// ABOUTME: Null handling policies applied during conversion.
+// ABOUTME: Drops null-valued keys, or treats nulls as absent in tabular output.
+
+package main
+
+// omitNulls removes every object key whose value is null, at any depth, and
+// returns the number of keys removed. Null array elements are kept, since
+// removing them would shift the positions of the remaining elements.
+func omitNulls(v any) int {
+	count := 0
+	switch v := v.(type) {
+	case map[string]any:
+		for key, elem := range v {
+			if elem == nil {
+				delete(v, key)
+				count++
+				continue
+			}
+			count += omitNulls(elem)
+		}
+	case []any:
+		for _, elem := range v {
+			count += omitNulls(elem)
+		}
+	}
+	return count
+}
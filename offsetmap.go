@@ -0,0 +1,63 @@
+// ABOUTME: The --offset-map sidecar: where each value of BONJSON output was written, by path.
+// ABOUTME: Lets tools correlate output bytes with the values they encode without decoding the output themselves.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// offsetMap is the --offset-map sidecar of a conversion.
+type offsetMap struct {
+	Output string        `json:"output"`
+	Values []mappedValue `json:"values"`
+}
+
+// mappedValue is the byte range in the output of one value, the whole of a
+// container included. A member's range covers its value, not its key.
+type mappedValue struct {
+	Document int    `json:"document"`
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+}
+
+// mapOffsets returns the offset map of the BONJSON output written to
+// outputPath, listing its values in the order they start.
+func mapOffsets(output []byte, outputPath string) *offsetMap {
+	m := &offsetMap{Output: outputPath, Values: []mappedValue{}}
+	document := 0
+	scanner := newWireScanner(bytes.NewReader(output), 0, func(v scannedValue) {
+		if v.kind == kindKey || v.kind == kindRecordDef {
+			return
+		}
+		m.Values = append(m.Values, mappedValue{Document: document, Path: v.path.String(), Kind: v.kind.String(), Offset: v.offset, Size: v.size})
+		if v.depth == 0 {
+			document++
+		}
+	})
+	for {
+		if err := scanner.scanDocument(); err != nil {
+			break
+		}
+	}
+	// The scanner visits containers after their contents.
+	slices.SortStableFunc(m.Values, func(a, b mappedValue) int { return int(a.Offset - b.Offset) })
+	return m
+}
+
+// write saves the sidecar to filename as indented JSON.
+func (m *offsetMap) write(filename string) error {
+	output, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return fmt.Errorf("encoding offset map: %w", err)
+	}
+	if err := writeFileAtomic(filename, append(output, '\n')); err != nil {
+		return fmt.Errorf("writing offset map: %w", err)
+	}
+	return nil
+}
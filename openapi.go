@@ -0,0 +1,188 @@
+// ABOUTME: Generates the OpenAPI document of the serve protocol from its parameter and error tables.
+// ABOUTME: Served at /v1/openapi.json and printed by serve --spec, for generating client stubs.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// openAPISpec returns an OpenAPI 3.0 document describing protocol version 1.
+// Query parameters, error responses, and media types come from
+// serveParameters, serveErrors, and the format registry, so adding to any of
+// them updates the spec.
+func openAPISpec() map[string]any {
+	var parameters []any
+	for _, p := range serveParameters {
+		schema := map[string]any{"type": p.schemaType}
+		if p.values != nil {
+			schema["enum"] = p.values
+		}
+		description := p.description + ". Defaults to the server's command-line setting. Servers may disallow it (--allow-params)."
+		parameters = append(parameters, map[string]any{
+			"name":        p.name,
+			"in":          "query",
+			"required":    false,
+			"description": description,
+			"schema":      schema,
+		}, map[string]any{
+			"name":        "Bonbon-Option-" + p.name,
+			"in":          "header",
+			"required":    false,
+			"description": description + " The query parameter takes precedence.",
+			"schema":      schema,
+		})
+	}
+
+	// Request and response bodies in each registered format.
+	inputs, outputs := map[string]any{}, map[string]any{}
+	for _, f := range formats {
+		schema := map[string]any{"type": "string"}
+		switch f.Name {
+		case "json":
+			schema = map[string]any{}
+		case "bonjson":
+			schema["format"] = "binary"
+		}
+		if f.Input {
+			inputs[f.MediaType] = map[string]any{"schema": schema}
+		}
+		if f.Output {
+			outputs[f.MediaType] = map[string]any{"schema": schema}
+		}
+	}
+	errorBody := map[string]any{
+		mediaJSON: map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+	}
+
+	// Group the error codes by status, since a response is keyed by status.
+	convertResponses := map[string]any{
+		"200": map[string]any{
+			"description": "The converted documents, in the format named by Accept",
+			"headers":     protocolHeader(),
+			"content":     outputs,
+		},
+	}
+	codesByStatus := make(map[int][]string)
+	for _, e := range serveErrors {
+		if e.code != "not_found" {
+			codesByStatus[e.status] = append(codesByStatus[e.status], fmt.Sprintf("%s (%s)", e.code, e.description))
+		}
+	}
+	for status, codes := range codesByStatus {
+		convertResponses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status) + ": " + strings.Join(codes, "; "),
+			"headers":     protocolHeader(),
+			"content":     errorBody,
+		}
+	}
+
+	var errorCodes []string
+	for _, e := range serveErrors {
+		errorCodes = append(errorCodes, e.code)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "bonbon conversion service",
+			"version":     strconv.Itoa(serveProtocol),
+			"description": "Converts between JSON and BONJSON. Requests valid in this protocol version keep working in later releases; clients must ignore unknown response fields and headers.",
+		},
+		"paths": map[string]any{
+			"/v1/convert": map[string]any{
+				"post": map[string]any{
+					"operationId": "convert",
+					"summary":     "Convert documents between JSON and BONJSON",
+					"description": "Content-Type names the format of the body. Accept names the format of the response; without it, the response is in the other format.",
+					"parameters":  parameters,
+					"requestBody": map[string]any{"required": true, "content": inputs},
+					"responses":   convertResponses,
+					// Authentication depends on how the server was started,
+					// so it is optional here.
+					"security": []any{map[string]any{}, map[string]any{"bearer": []string{}}, map[string]any{"signature": []string{}}},
+				},
+			},
+			"/v1/version": map[string]any{
+				"get": map[string]any{
+					"operationId": "version",
+					"summary":     "Report the protocol versions the server supports",
+					"responses": map[string]any{
+						"200": map[string]any{
+							"description": "The current and supported protocol versions",
+							"headers":     protocolHeader(),
+							"content": map[string]any{
+								mediaJSON: map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Version"}},
+							},
+						},
+					},
+				},
+			},
+			"/v1/openapi.json": map[string]any{
+				"get": map[string]any{
+					"operationId": "openapi",
+					"summary":     "Return this document",
+					"responses": map[string]any{
+						"200": map[string]any{
+							"description": "The OpenAPI document",
+							"content":     map[string]any{mediaJSON: map[string]any{"schema": map[string]any{"type": "object"}}},
+						},
+					},
+				},
+			},
+		},
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A token from the server's --auth-token-file",
+				},
+				"signature": map[string]any{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "Bonbon-Signature",
+					"description": "Hex HMAC-SHA256, under the key in the server's --auth-hmac-key-file, of the Bonbon-Timestamp header value (Unix seconds), " +
+						"the method, the request URI, and the body, each of the first three followed by a newline",
+				},
+			},
+			"schemas": map[string]any{
+				"Error": map[string]any{
+					"type":     "object",
+					"required": []string{"error"},
+					"properties": map[string]any{
+						"error": map[string]any{
+							"type":     "object",
+							"required": []string{"code", "message"},
+							"properties": map[string]any{
+								"code":    map[string]any{"type": "string", "description": "A stable error code: " + strings.Join(errorCodes, ", ") + ". Later versions may add codes."},
+								"message": map[string]any{"type": "string", "description": "Human-readable detail; may change between releases"},
+							},
+						},
+					},
+				},
+				"Version": map[string]any{
+					"type":     "object",
+					"required": []string{"protocol", "supported"},
+					"properties": map[string]any{
+						"protocol":  map[string]any{"type": "integer"},
+						"supported": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// protocolHeader describes the Bonbon-Protocol response header.
+func protocolHeader() map[string]any {
+	return map[string]any{
+		"Bonbon-Protocol": map[string]any{
+			"description": "The protocol version of the response",
+			"schema":      map[string]any{"type": "integer"},
+		},
+	}
+}
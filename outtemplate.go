@@ -0,0 +1,90 @@
+// ABOUTME: Output path templates (--out-template): how batch and split conversions name their outputs.
+// ABOUTME: Variables such as {dir}, {name}, {format}, and {shard} fit layouts that swapping extensions cannot.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// outTemplateVariables are the variables of an --out-template, without their
+// braces.
+var outTemplateVariables = []string{"dir", "name", "format", "input_format", "shard"}
+
+// shardVariable stands for the shard index in a templated output path until
+// the shards are written.
+const shardVariable = "{shard}"
+
+// checkOutTemplate reports an error if template uses a variable other than
+// outTemplateVariables, or an unbalanced brace, or if whether it uses
+// {shard} does not match whether output is split. {shard} may only appear
+// in the file name, not in a directory.
+func checkOutTemplate(template string, opts *options) error {
+	rest := template
+	for {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if rest[start] == '}' || end < 0 {
+			return fmt.Errorf("invalid --out-template %q: unbalanced brace", template)
+		}
+		name := rest[start+1 : start+end]
+		if !slices.Contains(outTemplateVariables, name) {
+			return fmt.Errorf("invalid --out-template %q: unknown variable {%s} (expected {%s})",
+				template, name, strings.Join(outTemplateVariables, "}, {"))
+		}
+		rest = rest[start+end+1:]
+	}
+	split := opts.splitSize > 0 || opts.splitDocs > 0
+	switch {
+	case split && !strings.Contains(template, shardVariable):
+		return fmt.Errorf("--out-template must use {shard} with --split-size or --split-docs")
+	case !split && strings.Contains(template, shardVariable):
+		return fmt.Errorf("--out-template can only use {shard} with --split-size or --split-docs")
+	case strings.Contains(filepath.Dir(template), shardVariable):
+		return fmt.Errorf("--out-template can only use {shard} in the file name")
+	}
+	return nil
+}
+
+// templatedOutputPath returns the output path of inputPath under the output
+// directory outputDir, as opts.outTemplate lays it out. {dir} is the
+// directory of inputPath relative to root, the input directory of a batch
+// (and "." for a single file); {name} its base name without extension
+// ("stdin" for -); {format} the output format's extension without its dot;
+// and {input_format} the name of the input format. {shard} is left for
+// shardPath to fill in.
+func templatedOutputPath(inputPath, root, outputDir string, inputJSON, outputJSON bool, opts *options) (string, error) {
+	dir, name := ".", "stdin"
+	if inputPath != "-" {
+		rel, err := filepath.Rel(root, filepath.Dir(inputPath))
+		if err != nil {
+			return "", err
+		}
+		dir = rel
+		name = strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+	}
+	inputFormat := "bonjson"
+	switch {
+	case opts.inputFormat != "":
+		inputFormat = opts.inputFormat
+	case inputJSON:
+		inputFormat = "json"
+	}
+	expanded := strings.NewReplacer(
+		"{dir}", dir,
+		"{name}", name,
+		"{format}", strings.TrimPrefix(outputExtension(outputJSON, opts), "."),
+		"{input_format}", inputFormat,
+	).Replace(opts.outTemplate)
+	target := filepath.Join(outputDir, expanded)
+	if rel, err := filepath.Rel(outputDir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("--out-template puts %s outside the output directory %s", inputPath, outputDir)
+	}
+	return target, nil
+}
@@ -0,0 +1,320 @@
+// ABOUTME: Structural patches between document versions, with JSON Patch (RFC 6902) semantics.
+// ABOUTME: Implements the delta command, which computes a patch, and apply, which applies one.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// runDelta writes the patch that turns the document in oldPath into the one
+// in newPath to opts.outFile, or to stdout as JSON if it is not set.
+func runDelta(oldPath, newPath string, opts *options) error {
+	oldDoc, err := loadDocument(oldPath)
+	if err != nil {
+		return fmt.Errorf("reading old document: %w", err)
+	}
+	newDoc, err := loadDocument(newPath)
+	if err != nil {
+		return fmt.Errorf("reading new document: %w", err)
+	}
+	ops := diffValues(oldDoc, newDoc, "", nil)
+	if ops == nil {
+		ops = []any{}
+	}
+	return writeDocument(ops, opts.outFile, opts)
+}
+
+// runApply applies the patch in patchPath to the document in docPath and
+// writes the result to opts.outFile, or to stdout as JSON if it is not set.
+func runApply(docPath, patchPath string, opts *options) error {
+	doc, err := loadDocument(docPath)
+	if err != nil {
+		return fmt.Errorf("reading document: %w", err)
+	}
+	patch, err := loadDocument(patchPath)
+	if err != nil {
+		return fmt.Errorf("reading patch: %w", err)
+	}
+	ops, ok := patch.([]any)
+	if !ok {
+		return fmt.Errorf("patch must be an array of operations")
+	}
+	for i, op := range ops {
+		if doc, err = applyPatchOp(doc, op); err != nil {
+			return fmt.Errorf("patch operation %d: %w", i, err)
+		}
+	}
+	return writeDocument(doc, opts.outFile, opts)
+}
+
+// writeDocument encodes v to filename, as BONJSON if it is named *.boj or
+// *.bonjson and as JSON otherwise, or to stdout as JSON if filename is empty.
+func writeDocument(v any, filename string, opts *options) error {
+	outputJSON := !isBONJSONPath(filename)
+	output, err := encodeDocument(v, outputJSON, opts)
+	if err != nil {
+		return err
+	}
+	return writeOutput(output, filename, outputJSON)
+}
+
+// diffValues appends to ops the operations that turn oldValue, at the JSON
+// pointer pointer, into newValue. Objects are compared key by key (in sorted
+// order, so the patch is deterministic) and arrays index by index, with
+// elements added or removed at the end; anything else that differs is
+// replaced.
+func diffValues(oldValue, newValue any, pointer string, ops []any) []any {
+	switch oldValue := oldValue.(type) {
+	case map[string]any:
+		newMap, ok := newValue.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, key := range slices.Sorted(maps.Keys(oldValue)) {
+			if _, ok := newMap[key]; !ok {
+				ops = append(ops, patchOp("remove", pointer+"/"+escapePointerToken(key), nil))
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(newMap)) {
+			child := pointer + "/" + escapePointerToken(key)
+			if old, ok := oldValue[key]; ok {
+				ops = diffValues(old, newMap[key], child, ops)
+			} else {
+				ops = append(ops, patchOp("add", child, newMap[key]))
+			}
+		}
+		return ops
+	case []any:
+		newArray, ok := newValue.([]any)
+		if !ok {
+			break
+		}
+		common := min(len(oldValue), len(newArray))
+		for i := 0; i < common; i++ {
+			ops = diffValues(oldValue[i], newArray[i], pointer+"/"+strconv.Itoa(i), ops)
+		}
+		for i := len(oldValue) - 1; i >= common; i-- {
+			ops = append(ops, patchOp("remove", pointer+"/"+strconv.Itoa(i), nil))
+		}
+		for i := common; i < len(newArray); i++ {
+			ops = append(ops, patchOp("add", pointer+"/-", newArray[i]))
+		}
+		return ops
+	}
+	if !jsonEqual(oldValue, newValue) {
+		ops = append(ops, patchOp("replace", pointer, newValue))
+	}
+	return ops
+}
+
+func patchOp(op, pointer string, value any) map[string]any {
+	entry := map[string]any{"op": op, "path": pointer}
+	if op != "remove" {
+		entry["value"] = value
+	}
+	return entry
+}
+
+// jsonEqual reports whether a and b have the same JSON encoding, which
+// compares numbers by value whatever type they were decoded as.
+func jsonEqual(a, b any) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(encodedA) == string(encodedB)
+}
+
+// escapePointerToken escapes a key for use in a JSON pointer.
+func escapePointerToken(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// splitPointer splits a JSON pointer into its unescaped tokens.
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// applyPatchOp applies one RFC 6902 operation (add, remove, replace, move,
+// copy, or test) to doc and returns the result.
+func applyPatchOp(doc, op any) (any, error) {
+	fields, ok := op.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("operation must be an object")
+	}
+	name, _ := fields["op"].(string)
+	pointer, ok := fields["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing \"path\"")
+	}
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	value, hasValue := fields["value"]
+	from, hasFrom := fields["from"].(string)
+
+	switch name {
+	case "add", "replace", "test":
+		if !hasValue {
+			return nil, fmt.Errorf("%s requires \"value\"", name)
+		}
+	case "move", "copy":
+		if !hasFrom {
+			return nil, fmt.Errorf("%s requires \"from\"", name)
+		}
+	}
+
+	switch name {
+	case "add":
+		return addAt(doc, tokens, value)
+	case "remove":
+		return removeAt(doc, tokens)
+	case "replace":
+		if _, err := resolvePointer(doc, pointer); err != nil {
+			return nil, fmt.Errorf("replace %s: %w", pointer, err)
+		}
+		if len(tokens) == 0 {
+			return value, nil
+		}
+		if doc, err = removeAt(doc, tokens); err != nil {
+			return nil, err
+		}
+		return addAt(doc, tokens, value)
+	case "move":
+		if pointer == from || strings.HasPrefix(pointer, from+"/") {
+			if pointer == from {
+				return doc, nil
+			}
+			return nil, fmt.Errorf("cannot move %s into its own child %s", from, pointer)
+		}
+		moved, err := resolvePointer(doc, from)
+		if err != nil {
+			return nil, fmt.Errorf("move from %s: %w", from, err)
+		}
+		fromTokens, err := splitPointer(from)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = removeAt(doc, fromTokens); err != nil {
+			return nil, err
+		}
+		return addAt(doc, tokens, moved)
+	case "copy":
+		copied, err := resolvePointer(doc, from)
+		if err != nil {
+			return nil, fmt.Errorf("copy from %s: %w", from, err)
+		}
+		return addAt(doc, tokens, cloneValue(copied))
+	case "test":
+		actual, err := resolvePointer(doc, pointer)
+		if err != nil {
+			return nil, fmt.Errorf("test %s: %w", pointer, err)
+		}
+		if !jsonEqual(actual, value) {
+			return nil, fmt.Errorf("test %s: value does not match", pointer)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", name)
+	}
+}
+
+// modifyAt applies fn to the container holding the value at tokens and the
+// last token, and returns doc with that container replaced by fn's result.
+func modifyAt(doc any, tokens []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+	switch container := doc.(type) {
+	case map[string]any:
+		child, ok := container[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", tokens[0])
+		}
+		updated, err := modifyAt(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		container[tokens[0]] = updated
+		return container, nil
+	case []any:
+		index, err := strconv.Atoi(tokens[0])
+		if err != nil || index < 0 || index >= len(container) {
+			return nil, fmt.Errorf("invalid array index %q", tokens[0])
+		}
+		updated, err := modifyAt(container[index], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = updated
+		return container, nil
+	default:
+		return nil, fmt.Errorf("cannot descend into a scalar at %q", tokens[0])
+	}
+}
+
+// addAt adds value at tokens: setting an object key, or inserting into an
+// array ("-" appends). An empty pointer replaces the whole document.
+func addAt(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return modifyAt(doc, tokens, func(container any, token string) (any, error) {
+		switch container := container.(type) {
+		case map[string]any:
+			container[token] = value
+			return container, nil
+		case []any:
+			if token == "-" {
+				return append(container, value), nil
+			}
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index > len(container) {
+				return nil, fmt.Errorf("invalid array index %q", token)
+			}
+			return slices.Insert(container, index, value), nil
+		default:
+			return nil, fmt.Errorf("cannot add to a scalar at %q", token)
+		}
+	})
+}
+
+// removeAt removes the value at tokens.
+func removeAt(doc any, tokens []string) (any, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	return modifyAt(doc, tokens, func(container any, token string) (any, error) {
+		switch container := container.(type) {
+		case map[string]any:
+			if _, ok := container[token]; !ok {
+				return nil, fmt.Errorf("key %q not found", token)
+			}
+			delete(container, token)
+			return container, nil
+		case []any:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil, fmt.Errorf("invalid array index %q", token)
+			}
+			return slices.Delete(container, index, index+1), nil
+		default:
+			return nil, fmt.Errorf("cannot remove from a scalar at %q", token)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+// ABOUTME: Path expressions for addressing values inside documents.
+// ABOUTME: Supports $ for the root, .key and ["key"] for members, and [N] for elements.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is one step of a path: either an object key or an array index.
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// path is a parsed path expression. An empty path refers to the root.
+type path []pathSegment
+
+// parsePath parses a path expression such as $.a.b[2]["c.d"]. A string that
+// does not start with "$" is taken as a single top-level key, so plain
+// column names like "name" keep working.
+func parsePath(s string) (path, error) {
+	if !strings.HasPrefix(s, "$") {
+		if s == "" {
+			return nil, fmt.Errorf("empty path")
+		}
+		return path{{key: s}}, nil
+	}
+	var p path
+	rest := s[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("invalid path %q: empty key", s)
+			}
+			p = append(p, pathSegment{key: key})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if strings.HasPrefix(rest, "[\"") {
+				end = closingQuote(rest[1:]) + 2
+				if end < 2 || end >= len(rest) || rest[end] != ']' {
+					return nil, fmt.Errorf("invalid path %q: unterminated key", s)
+				}
+				var key string
+				if err := json.Unmarshal([]byte(rest[1:end]), &key); err != nil {
+					return nil, fmt.Errorf("invalid path %q: %w", s, err)
+				}
+				p = append(p, pathSegment{key: key})
+				rest = rest[end+1:]
+				continue
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unterminated index", s)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid path %q: bad index %q", s, rest[1:end])
+			}
+			p = append(p, pathSegment{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid path %q: unexpected %q", s, rest[0])
+		}
+	}
+	return p, nil
+}
+
+// closingQuote returns the index of the quote that closes the JSON string
+// starting at s[0], or -1 if there is none.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// String returns the canonical textual form of the path.
+func (p path) String() string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, seg := range p {
+		switch {
+		case seg.isIndex:
+			fmt.Fprintf(&sb, "[%d]", seg.index)
+		case strings.ContainsAny(seg.key, ".[]\"") || seg.key == "":
+			quoted, _ := json.Marshal(seg.key)
+			fmt.Fprintf(&sb, "[%s]", quoted)
+		default:
+			sb.WriteString(".")
+			sb.WriteString(seg.key)
+		}
+	}
+	return sb.String()
+}
+
+// lookupPath returns the value at p within v, and whether it exists.
+func lookupPath(v any, p path) (any, bool) {
+	for _, seg := range p {
+		if seg.isIndex {
+			array, ok := v.([]any)
+			if !ok || seg.index >= len(array) {
+				return nil, false
+			}
+			v = array[seg.index]
+			continue
+		}
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[seg.key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,364 @@
+// ABOUTME: Streaming conversion pipeline for document streams: read, decode, transform, encode, write.
+// ABOUTME: Transform and encode run on worker pools; output keeps input order and memory is bounded.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultQueueDepth is the number of documents allowed in flight between the
+// decode and write stages when --queue-depth is not given.
+const defaultQueueDepth = 64
+
+// pipelineItem is one document travelling through the pipeline. seq is its
+// position in the input, which the write stage uses to restore input order.
+type pipelineItem struct {
+	seq     int
+	doc     any
+	encoded []byte
+	err     error
+}
+
+// parseWorkers parses a --workers value: either a single count applied to
+// every parallel stage, or a comma-separated list of STAGE=N settings where
+// STAGE is "transform" or "encode".
+func parseWorkers(spec string, opts *options) error {
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n <= 0 {
+			return fmt.Errorf("invalid worker count: %s", spec)
+		}
+		opts.transformWorkers = n
+		opts.encodeWorkers = n
+		return nil
+	}
+	for _, item := range splitList(spec) {
+		stage, count, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("invalid worker setting %q (expected STAGE=N)", item)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid worker count: %s", count)
+		}
+		switch stage {
+		case "transform":
+			opts.transformWorkers = n
+		case "encode":
+			opts.encodeWorkers = n
+		default:
+			return fmt.Errorf("unknown pipeline stage: %s (expected transform or encode)", stage)
+		}
+	}
+	return nil
+}
+
+// usePipeline reports whether a conversion should go through the streaming
+// pipeline. That is the case for document streams converted to JSON or
+// BONJSON in a single output; bonjson-text input, table, CSV, and
+// bonjson-text rendering, output splitting, input windows, strict JSON
+// validation, --target-profile, --assert, --snapshots, the lossiness report,
+// the provenance and offset map sidecars, --envelope, and the decode trace
+// need the whole input at once. So does --expect-sha256 with output
+// to stdout, which cannot be held back until the digest is checked, and a
+// cached conversion, whose key is a digest of the whole input.
+func usePipeline(outputPath string, inputJSON bool, opts *options) bool {
+	return opts.stream && outputPath != "" && opts.inputFormat == "" && opts.outputFormat == "" &&
+		opts.splitSize == 0 && opts.splitDocs == 0 && !opts.windowed() &&
+		!(inputJSON && opts.strictJSON) && opts.targetProfile == nil && len(opts.asserts) == 0 && opts.snapshotDir == "" && !opts.lossinessReport && opts.provenanceFile == "" && opts.offsetMapFile == "" && !opts.envelope &&
+		opts.decodeTraceFile == "" && !(opts.expectSHA256 != "" && outputPath == "-") && !cacheable(outputPath, opts)
+}
+
+// convertStream converts a document stream through the pipeline. Reading and
+// decoding happen in sequence, transformation and encoding on pools of
+// workers, and the write stage emits documents in input order. At most
+// opts.queueDepth documents are in flight at any time.
+//
+// As in the buffered path, everything decoded before a decode error is still
+// written. A transform or encode error stops the output at the failing
+// document.
+func convertStream(inputPath, outputPath string, inputJSON, outputJSON bool, opts *options) error {
+	var in io.Reader
+	if inputPath == "-" {
+		in = os.Stdin
+	} else {
+		f, closeInput, err := openFile(inputPath)
+		if err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+		defer closeInput()
+		in = f
+	}
+	var digest *digestReader
+	if opts.expectSHA256 != "" {
+		digest = newDigestReader(in)
+		in = digest
+	}
+	r := bufio.NewReaderSize(progressReader{in}, 256*1024)
+
+	if opts.skipBytes > 0 {
+		n, err := io.CopyN(io.Discard, r, int64(opts.skipBytes))
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reading input: %w", err)
+		}
+		if _, err := r.Peek(1); n < int64(opts.skipBytes) || err == io.EOF {
+			return fmt.Errorf("skip value %d exceeds input size %d", opts.skipBytes, n)
+		}
+	}
+	if _, err := r.Peek(1); err == io.EOF {
+		empty, err := emptyInput(inputJSON, opts)
+		if err != nil {
+			return err
+		}
+		r = bufio.NewReader(bytes.NewReader(empty))
+	}
+	if opts.timeFiltered() {
+		r = bufio.NewReaderSize(filterTimeWindow(r, opts), 256*1024)
+	}
+
+	queueDepth := opts.queueDepth
+	if queueDepth <= 0 {
+		queueDepth = defaultQueueDepth
+	}
+	done := make(chan struct{})
+	defer close(done)
+	// Each decoded document takes a slot, which the write stage returns once
+	// the document is written.
+	slots := make(chan struct{}, queueDepth)
+
+	var decodeErr error
+	decoded := make(chan pipelineItem, queueDepth)
+	go func() {
+		defer close(decoded)
+		decodeErr = decodeStream(r, inputJSON, opts, func(seq int, doc any) bool {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return false
+			}
+			select {
+			case decoded <- pipelineItem{seq: seq, doc: doc}:
+				return true
+			case <-done:
+				return false
+			}
+		})
+	}()
+
+	dir := transformDir(inputPath)
+	var countsMu sync.Mutex
+	var total transformCounts
+	transformed := runStage(decoded, workerCount(opts.transformWorkers), queueDepth, done, func(item *pipelineItem) {
+		doc, counts, err := transformDocument(item.doc, dir, opts)
+		item.doc, item.err = doc, err
+		countsMu.Lock()
+		total.add(counts)
+		countsMu.Unlock()
+	})
+	encoded := runStage(transformed, workerCount(opts.encodeWorkers), queueDepth, done, func(item *pipelineItem) {
+		item.encoded, item.err = encodeDocument(item.doc, outputJSON, opts)
+		item.doc = nil
+	})
+
+	out := &lazyOutput{path: outputPath}
+	defer out.Close()
+	pending := make(map[int]pipelineItem)
+	next := 0
+	for item := range encoded {
+		pending[item.seq] = item
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if ready.err != nil {
+				return ready.err
+			}
+			if _, err := out.Write(ready.encoded); err != nil {
+				return fmt.Errorf("writing output: %w", err)
+			}
+			progress.documents.Add(1)
+			<-slots
+			next++
+		}
+	}
+	if digest != nil {
+		if err := digest.verify(opts.expectSHA256); err != nil {
+			out.discard()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+
+	total.report(opts)
+	if decodeErr != nil {
+		if inputJSON {
+			return fmt.Errorf("invalid JSON: %w", decodeErr)
+		}
+		return fmt.Errorf("decoding BONJSON: %w", decodeErr)
+	}
+	return nil
+}
+
+// errStopDecoding stops decodeStream's JSON decoding when emit declines a
+// document.
+var errStopDecoding = errors.New("stop decoding")
+
+// decodeStream decodes each document in r in order, passing it to emit along
+// with its sequence number. It stops early if emit returns false. For
+// BONJSON, object keys are interned across documents, and a partially
+// decoded document is emitted before the error that cut it short is returned.
+func decodeStream(r io.Reader, inputJSON bool, opts *options, emit func(int, any) bool) error {
+	if inputJSON {
+		seq := 0
+		err := decodeJSONEach(r, opts, func(doc any) error {
+			if !emit(seq, doc) {
+				return errStopDecoding
+			}
+			seq++
+			return nil
+		})
+		if err == errStopDecoding {
+			return nil
+		}
+		return err
+	}
+
+	dec := newBONJSONDecoder(r, opts)
+	interner := make(keyInterner)
+	var err error
+	seq := 0
+	for ; ; seq++ {
+		start := dec.InputOffset()
+		var value any
+		err = dec.Decode(&value)
+		interner.internKeys(value)
+		if err != nil {
+			if err == io.EOF && dec.InputOffset() == start {
+				err = nil
+			} else if value != nil && emit(seq, value) {
+				seq++
+			}
+			break
+		}
+		if !emit(seq, value) {
+			return nil
+		}
+	}
+	if opts.printEndOffset {
+		fmt.Fprintf(os.Stderr, "%d\n", int64(opts.skipBytes)+dec.InputOffset())
+	}
+	if err != nil {
+		return fmt.Errorf("document %d: %w", seq, err)
+	}
+	return nil
+}
+
+// runStage starts workers goroutines that apply fn to each item from in and
+// pass it on. Items that already carry an error are passed on untouched. The
+// returned channel is closed once every worker has finished.
+func runStage(in <-chan pipelineItem, workers, queueDepth int, done <-chan struct{}, fn func(*pipelineItem)) <-chan pipelineItem {
+	out := make(chan pipelineItem, queueDepth)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range in {
+				if item.err == nil {
+					fn(&item)
+				}
+				select {
+				case out <- item:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// workerCount returns n, or the number of CPUs if n is unset.
+func workerCount(n int) int {
+	if n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
+// lazyOutput writes to stdout, or to a file that is only created on the first
+// write, so that a conversion failing before any output leaves no file behind.
+// A regular file is written under a temporary name and renamed into place on
+// Close, so an interrupted conversion leaves no partial file either. A
+// /dev/fd/N path is written to the descriptor directly, and other files that
+// are not regular ones in place (see createOutput).
+type lazyOutput struct {
+	path string
+	w    *bufio.Writer
+	f    *outputFile
+}
+
+func (o *lazyOutput) Write(p []byte) (int, error) {
+	if o.w == nil {
+		if o.path == "-" {
+			o.w = bufio.NewWriter(os.Stdout)
+		} else if f, ok := openDescriptor(o.path); ok {
+			o.w = bufio.NewWriter(f)
+		} else {
+			f, err := createOutput(o.path)
+			if err != nil {
+				return 0, fmt.Errorf("creating output file: %w", err)
+			}
+			o.f = f
+			o.w = bufio.NewWriter(f)
+		}
+	}
+	n, err := o.w.Write(p)
+	progress.written.Add(int64(n))
+	return n, err
+}
+
+// discard drops the output written so far, removing the temporary file, if
+// any. Output already on stdout, a descriptor, or a file written in place is
+// not recalled.
+func (o *lazyOutput) discard() {
+	if o.f != nil {
+		o.f.discard()
+	}
+	o.w, o.f = nil, nil
+}
+
+// Close flushes buffered output and closes the file, if any. It is safe to
+// call more than once.
+func (o *lazyOutput) Close() error {
+	if o.w == nil {
+		return nil
+	}
+	err := o.w.Flush()
+	if o.f != nil {
+		if err != nil {
+			o.f.discard()
+		} else {
+			err = o.f.commit()
+		}
+	}
+	o.w, o.f = nil, nil
+	return err
+}
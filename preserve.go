@@ -0,0 +1,41 @@
+// ABOUTME: The --preserve-mode and --preserve-times options: output files take on their source's metadata.
+// ABOUTME: Build systems that compare modification times then see a converted file as old as its source.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// preserveMetadata gives outputPath the permission bits of inputPath with
+// opts.preserveMode, and its modification time with opts.preserveTimes. The
+// access time is left as it is. Nothing is done for stdin, stdout, or an
+// inherited descriptor.
+func preserveMetadata(inputPath, outputPath string, opts *options) error {
+	if !opts.preserveMode && !opts.preserveTimes {
+		return nil
+	}
+	if inputPath == "-" || outputPath == "" || outputPath == "-" {
+		return nil
+	}
+	if _, ok := openDescriptor(outputPath); ok {
+		return nil
+	}
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return fmt.Errorf("preserving metadata: %w", err)
+	}
+	if opts.preserveMode {
+		if err := os.Chmod(outputPath, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("preserving mode: %w", err)
+		}
+	}
+	if opts.preserveTimes {
+		if err := os.Chtimes(outputPath, time.Time{}, info.ModTime()); err != nil {
+			return fmt.Errorf("preserving modification time: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// ABOUTME: The JSON pretty printer behind --width and --compact-arrays.
+// ABOUTME: Keeps arrays and objects that fit on one line there, and wraps the rest one member per line.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"slices"
+)
+
+// prettyPrinter renders indented JSON, keeping any array or object that fits
+// within width columns, including the indentation before it and the comma
+// after it, on a single line; nothing fits a width of 0. With compactArrays,
+// arrays of scalars go on a single line whatever their length. Object keys
+// are sorted, as json.Marshal sorts them.
+type prettyPrinter struct {
+	indent        string
+	width         int
+	compactArrays bool
+}
+
+// marshal renders value as JSON, without a trailing newline.
+func (p *prettyPrinter) marshal(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.write(&buf, value, 0, 0, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// write renders value at the given nesting depth, starting at column, with
+// trailing more columns (such as a comma) to follow it on its line.
+func (p *prettyPrinter) write(buf *bytes.Buffer, value any, depth, column, trailing int) error {
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) == 0 || p.fits(value, p.width-column-trailing) {
+			return p.writeInline(buf, value)
+		}
+		buf.WriteString("{\n")
+		keys := slices.Sorted(maps.Keys(v))
+		for i, key := range keys {
+			p.writeIndent(buf, depth+1)
+			encodedKey, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			buf.Write(encodedKey)
+			buf.WriteString(": ")
+			column := len(p.indent)*(depth+1) + len(encodedKey) + 2
+			if err := p.write(buf, v[key], depth+1, column, separatorWidth(i, len(keys))); err != nil {
+				return err
+			}
+			p.writeSeparator(buf, i, len(keys))
+		}
+		p.writeIndent(buf, depth)
+		buf.WriteByte('}')
+		return nil
+	case []any:
+		if len(v) == 0 || (p.compactArrays && isScalarArray(v)) || p.fits(value, p.width-column-trailing) {
+			return p.writeInline(buf, value)
+		}
+		buf.WriteString("[\n")
+		for i, element := range v {
+			p.writeIndent(buf, depth+1)
+			if err := p.write(buf, element, depth+1, len(p.indent)*(depth+1), separatorWidth(i, len(v))); err != nil {
+				return err
+			}
+			p.writeSeparator(buf, i, len(v))
+		}
+		p.writeIndent(buf, depth)
+		buf.WriteByte(']')
+		return nil
+	}
+	return p.writeInline(buf, value)
+}
+
+// writeInline renders value on a single line, with a space after each comma
+// and colon.
+func (p *prettyPrinter) writeInline(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case map[string]any:
+		buf.WriteByte('{')
+		for i, key := range slices.Sorted(maps.Keys(v)) {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			encodedKey, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			buf.Write(encodedKey)
+			buf.WriteString(": ")
+			if err := p.writeInline(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case []any:
+		buf.WriteByte('[')
+		for i, element := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := p.writeInline(buf, element); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buf.Write(encoded)
+	return nil
+}
+
+// fits reports whether value rendered on a single line takes at most room
+// columns. It stops measuring as soon as the answer is no, so that deciding
+// how to lay out a large document does not render it over and over.
+func (p *prettyPrinter) fits(value any, room int) bool {
+	used := 0
+	var measure func(value any) bool
+	measure = func(value any) bool {
+		switch v := value.(type) {
+		case map[string]any:
+			used += 2 + max(0, 2*(len(v)-1)) + 2*len(v)
+			for key, member := range v {
+				encodedKey, err := json.Marshal(key)
+				if err != nil {
+					return false
+				}
+				used += len(encodedKey)
+				if used > room || !measure(member) {
+					return false
+				}
+			}
+		case []any:
+			used += 2 + max(0, 2*(len(v)-1))
+			for _, element := range v {
+				if used > room || !measure(element) {
+					return false
+				}
+			}
+		default:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return false
+			}
+			used += len(encoded)
+		}
+		return used <= room
+	}
+	return measure(value)
+}
+
+func (p *prettyPrinter) writeIndent(buf *bytes.Buffer, depth int) {
+	for range depth {
+		buf.WriteString(p.indent)
+	}
+}
+
+// writeSeparator ends the line of member i of n.
+func (p *prettyPrinter) writeSeparator(buf *bytes.Buffer, i, n int) {
+	if i < n-1 {
+		buf.WriteByte(',')
+	}
+	buf.WriteByte('\n')
+}
+
+// isScalarArray reports whether no element of array is an array or object.
+func isScalarArray(array []any) bool {
+	for _, element := range array {
+		switch element.(type) {
+		case map[string]any, []any:
+			return false
+		}
+	}
+	return true
+}
+
+// separatorWidth is the width of what writeSeparator puts after member i of
+// n on its line.
+func separatorWidth(i, n int) int {
+	if i < n-1 {
+		return 1
+	}
+	return 0
+}
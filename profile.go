@@ -0,0 +1,83 @@
+// ABOUTME: CPU, heap, and execution trace profiling for diagnosing slow conversions.
+// ABOUTME: Profiles are written in the standard pprof and runtime/trace formats.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// startProfiling starts the CPU profile and execution trace requested in
+// opts. The returned function stops them and writes the heap profile, and
+// must be called once the work being profiled is done.
+func startProfiling(opts *options) (func() error, error) {
+	var stops []func() error
+	stop := func() error {
+		var firstErr error
+		for i := len(stops) - 1; i >= 0; i-- {
+			if err := stops[i](); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	if opts.cpuProfile != "" {
+		f, err := os.Create(opts.cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("creating CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("starting CPU profile: %w", err)
+		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			return f.Close()
+		})
+	}
+
+	if opts.traceFile != "" {
+		f, err := os.Create(opts.traceFile)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("creating trace file: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stop()
+			return nil, fmt.Errorf("starting trace: %w", err)
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return f.Close()
+		})
+	}
+
+	if opts.memProfile != "" {
+		stops = append(stops, func() error {
+			return writeHeapProfile(opts.memProfile)
+		})
+	}
+
+	return stop, nil
+}
+
+// writeHeapProfile writes a heap profile reflecting all allocations made so
+// far to filename.
+func writeHeapProfile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("creating memory profile: %w", err)
+	}
+	defer f.Close()
+	runtime.GC()
+	if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+		return fmt.Errorf("writing memory profile: %w", err)
+	}
+	return f.Close()
+}
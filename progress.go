@@ -0,0 +1,101 @@
+// ABOUTME: Progress of the running conversion: input offset, documents converted, throughput, memory.
+// ABOUTME: Updated as conversions run and reported to stderr on request (SIGUSR1 on Unix).
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// conversionProgress counts the work done by the conversions of one run. The
+// offset, size, bytes written, and warnings are those of the current input;
+// the other counts cover the whole run, such as every file of a directory
+// conversion.
+type conversionProgress struct {
+	start     time.Time
+	mu        sync.Mutex
+	input     string
+	size      int64 // -1 if unknown
+	offset    atomic.Int64
+	written   atomic.Int64
+	warnings  atomic.Int64
+	read      atomic.Int64
+	documents atomic.Int64
+	files     atomic.Int64
+}
+
+// progress is the process's progress, which the conversion paths update.
+var progress = conversionProgress{start: time.Now(), size: -1}
+
+// begin records that a conversion of inputPath is starting.
+func (p *conversionProgress) begin(inputPath string) {
+	size := int64(-1)
+	if inputPath != "-" {
+		if info, err := os.Stat(inputPath); err == nil && info.Mode().IsRegular() {
+			size = info.Size()
+		}
+	}
+	p.mu.Lock()
+	if p.input != "" {
+		p.files.Add(1)
+	}
+	p.input, p.size = inputPath, size
+	p.offset.Store(0)
+	p.written.Store(0)
+	p.warnings.Store(0)
+	p.mu.Unlock()
+}
+
+// advance records n more bytes read from the current input.
+func (p *conversionProgress) advance(n int) {
+	p.offset.Add(int64(n))
+	p.read.Add(int64(n))
+}
+
+// report writes a one-line summary of the progress to w.
+func (p *conversionProgress) report(w io.Writer) {
+	p.mu.Lock()
+	input, size, files := p.input, p.size, p.files.Load()
+	p.mu.Unlock()
+	if input == "" {
+		fmt.Fprintln(w, "progress: no conversion started")
+		return
+	}
+	if input == "-" {
+		input = "stdin"
+	}
+	offset := p.offset.Load()
+	position := fmt.Sprintf("offset %d", offset)
+	if size > 0 {
+		position += fmt.Sprintf(" of %d bytes (%.0f%%)", size, percentOf(offset, size))
+	} else {
+		position += " bytes"
+	}
+	if files > 0 {
+		input += fmt.Sprintf(" (%d files done)", files)
+	}
+	elapsed := time.Since(p.start)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintf(w, "progress: %s: %s, %d documents converted, %.2f MB/s, %d bytes heap, %s elapsed\n",
+		input, position, p.documents.Load(), float64(p.read.Load())/1e6/elapsed.Seconds(),
+		mem.HeapAlloc, elapsed.Round(100*time.Millisecond))
+}
+
+// progressReader counts the bytes read through it as progress on the
+// current input.
+type progressReader struct {
+	r io.Reader
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	progress.advance(n)
+	return n, err
+}
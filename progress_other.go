@@ -0,0 +1,9 @@
+// ABOUTME: Progress reporting where SIGUSR1 does not exist.
+// ABOUTME: Progress is still counted, but there is no signal to request a report.
+
+//go:build !unix
+
+package main
+
+// notifyProgress does nothing, as there is no SIGUSR1 on this platform.
+func notifyProgress() {}
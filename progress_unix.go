@@ -0,0 +1,24 @@
+// ABOUTME: Reports conversion progress to stderr when the process receives SIGUSR1.
+// ABOUTME: Lets users check on long-running conversions without interrupting them.
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyProgress reports the progress to stderr on every SIGUSR1 (as in
+// kill -USR1 PID) until the process exits.
+func notifyProgress() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			progress.report(os.Stderr)
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+// ABOUTME: The --provenance sidecar: where each document and top-level member of BONJSON input came from.
+// ABOUTME: Records byte ranges in the source, to trace portions of converted output back to their encoding.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// provenance is the --provenance sidecar of a conversion.
+type provenance struct {
+	Source    string               `json:"source"`
+	Documents []documentProvenance `json:"documents"`
+}
+
+// documentProvenance is the byte range of one input document, and of each of
+// its top-level members in input order if it is an object. A member's range
+// covers its key and its value; a record instance's members have no keys in
+// the instance, so their ranges cover only the values.
+type documentProvenance struct {
+	Offset  int64              `json:"offset"`
+	Size    int64              `json:"size"`
+	Members []memberProvenance `json:"members,omitempty"`
+}
+
+type memberProvenance struct {
+	Key    string `json:"key"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// scan adds the documents of a BONJSON payload that starts at input offset
+// start, stopping quietly where the payload stops decoding, since the
+// conversion reports that error itself.
+func (p *provenance) scan(payload []byte, start int64) {
+	var members []memberProvenance
+	keyStart := int64(-1)
+	scanner := newWireScanner(bytes.NewReader(payload), start, func(v scannedValue) {
+		switch {
+		case v.kind == kindKey && v.depth == 1:
+			keyStart = v.offset
+		case v.depth == 1 && len(v.path) == 1 && !v.path[0].isIndex:
+			offset := v.offset
+			if keyStart >= 0 {
+				offset = keyStart
+			}
+			members = append(members, memberProvenance{Key: v.path[0].key, Offset: offset, Size: v.offset + v.size - offset})
+			keyStart = -1
+		case v.depth == 0 && v.kind != kindRecordDef:
+			p.Documents = append(p.Documents, documentProvenance{Offset: v.offset, Size: v.size, Members: members})
+			members = nil
+		}
+	})
+	for {
+		if err := scanner.scanDocument(); err != nil {
+			return
+		}
+	}
+}
+
+// write saves the sidecar to filename as indented JSON.
+func (p *provenance) write(filename string) error {
+	output, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return fmt.Errorf("encoding provenance: %w", err)
+	}
+	if err := writeFileAtomic(filename, append(output, '\n')); err != nil {
+		return fmt.Errorf("writing provenance: %w", err)
+	}
+	return nil
+}
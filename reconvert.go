@@ -0,0 +1,278 @@
+// ABOUTME: Difference-aware reconversion: container update re-encodes only what changed.
+// ABOUTME: Splices unchanged subtrees from the old encoding and appends only changed documents.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+
+	"github.com/kstenerud/go-bonjson"
+)
+
+// updateContainer brings the container at containerPath up to date with the
+// documents in inputPath (a JSON stream, or BONJSON if named *.boj or
+// *.bonjson), for watch workflows where a large artifact is rebuilt after a
+// small edit.
+//
+// Each new document is compared with the decoded old one. A document that is
+// unchanged keeps its bytes; a changed one is rebuilt from the old encoding,
+// re-encoding only the subtrees that differ. As with append, the changed and
+// added documents, the new index, and the new footer go after the old footer,
+// which stays the last thing in the file until the new footer is written
+// over everything it points at; nothing is overwritten. Replaced documents
+// and the old index leave unused bytes behind until the container is
+// compacted.
+func updateContainer(containerPath, inputPath string, opts *options) error {
+	var data []byte
+	var err error
+	if inputPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(inputPath)
+	}
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+	streamed := *opts
+	streamed.stream = true
+	var docs []any
+	if isBONJSONPath(inputPath) {
+		if docs, _, err = decodeBONJSON(data, &streamed); err != nil {
+			return fmt.Errorf("invalid BONJSON: %w", err)
+		}
+	} else if docs, err = decodeJSON(data, &streamed); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if len(docs) == 0 {
+		return fmt.Errorf("input is empty")
+	}
+	if docs, err = transformDocuments(docs, inputPath, opts); err != nil {
+		return err
+	}
+
+	f, unlock, err := openLocked(containerPath, os.O_RDWR)
+	if err != nil {
+		return fmt.Errorf("opening container: %w", err)
+	}
+	defer f.Close()
+	defer unlock()
+	index, _, err := readContainerIndex(f, containerPath)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", containerPath, err)
+	}
+
+	oldCount := len(index.Offsets)
+	var tail []byte
+	var added [][]byte
+	dataEnd := info.Size()
+	changed, subtrees := 0, 0
+	for i, doc := range docs {
+		if i >= oldCount {
+			encoded, err := encodeDocument(doc, false, opts)
+			if err != nil {
+				return fmt.Errorf("document %d: %w", i, err)
+			}
+			added = append(added, encoded)
+			continue
+		}
+		old, err := readContainerDocument(f, index, i)
+		if err != nil {
+			return err
+		}
+		splicer, err := newDocumentSplicer(old, opts)
+		if err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+		encoded, err := splicer.encode(nil, splicer.value, doc, nil)
+		if err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+		if bytes.Equal(encoded, old) {
+			continue
+		}
+		index.Offsets[i] = dataEnd
+		index.Lengths[i] = int64(len(encoded))
+		if index.SHA256 != nil {
+			index.SHA256[i] = documentDigest(encoded)
+		}
+		tail = append(tail, encoded...)
+		dataEnd += int64(len(encoded))
+		changed++
+		subtrees += splicer.reencoded
+	}
+	removed := max(oldCount-len(docs), 0)
+	if removed > 0 {
+		index.Offsets = index.Offsets[:len(docs)]
+		index.Lengths = index.Lengths[:len(docs)]
+		if index.SHA256 != nil {
+			index.SHA256 = index.SHA256[:len(docs)]
+		}
+	}
+
+	if changed+len(added)+removed > 0 {
+		if tail, err = appendContainerDocuments(tail, &index, dataEnd, added); err != nil {
+			return err
+		}
+		footerAt := len(tail) - containerFooterSize
+		if _, err := f.WriteAt(tail[:footerAt], info.Size()); err != nil {
+			return fmt.Errorf("updating %s: %w", containerPath, err)
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("updating %s: %w", containerPath, err)
+		}
+		if _, err := f.WriteAt(tail[footerAt:], info.Size()+int64(footerAt)); err != nil {
+			return fmt.Errorf("updating %s: %w", containerPath, err)
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("updating %s: %w", containerPath, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d documents changed (%d subtrees re-encoded), %d added, %d removed\n",
+		changed, min(oldCount, len(docs)), subtrees, len(added), removed)
+	return nil
+}
+
+// byteSpan is the extent of an encoded value within a document.
+type byteSpan struct {
+	start, end int64
+}
+
+// documentSplicer re-encodes a new version of a document, reusing the bytes
+// of every subtree that is unchanged from the old encoding.
+type documentSplicer struct {
+	raw       []byte
+	value     any
+	spans     map[string]byteSpan // by path string
+	opts      *options
+	reencoded int
+}
+
+// newDocumentSplicer decodes an encoded document and maps the path of each of
+// its values to the value's bytes. A document that starts with record
+// definitions has no spans, since its record instances cannot be copied into
+// a document that lacks the definitions; it is re-encoded in full.
+func newDocumentSplicer(raw []byte, opts *options) (*documentSplicer, error) {
+	docs, _, err := decodeBONJSON(raw, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BONJSON: %w", err)
+	}
+	s := &documentSplicer{raw: raw, value: docs[0], spans: make(map[string]byteSpan), opts: opts}
+	if len(raw) > 0 && raw[0] == 0xB9 {
+		return s, nil
+	}
+	scanner := newWireScanner(bytes.NewReader(raw), 0, func(v scannedValue) {
+		if v.kind != kindKey {
+			s.spans[v.path.String()] = byteSpan{v.offset, v.offset + v.size}
+		}
+	})
+	if err := scanner.scanDocument(); err != nil {
+		return nil, fmt.Errorf("invalid BONJSON: %w", err)
+	}
+	return s, nil
+}
+
+// encode appends the encoding of value, found at p, to buf, given the old
+// value at the same path. Unchanged values are copied from the old encoding;
+// objects and arrays that changed are rebuilt member by member (with keys in
+// sorted order, as the encoder writes them), and anything else is encoded
+// afresh.
+func (s *documentSplicer) encode(buf []byte, old, value any, p path) ([]byte, error) {
+	span, ok := s.spans[p.String()]
+	if ok && sameValue(old, value) {
+		return append(buf, s.raw[span.start:span.end]...), nil
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		if oldObject, isObject := old.(map[string]any); ok && isObject && s.raw[span.start] == 0xB8 {
+			buf = append(buf, 0xB8)
+			for _, key := range slices.Sorted(maps.Keys(value)) {
+				encodedKey, err := bonjson.Marshal(key)
+				if err != nil {
+					return nil, fmt.Errorf("encoding BONJSON: %w", err)
+				}
+				buf = append(buf, encodedKey...)
+				if buf, err = s.encode(buf, oldObject[key], value[key], append(p, pathSegment{key: key})); err != nil {
+					return nil, err
+				}
+			}
+			return append(buf, 0xB6), nil
+		}
+	case []any:
+		if oldArray, isArray := old.([]any); ok && isArray && s.raw[span.start] == 0xB7 {
+			buf = append(buf, 0xB7)
+			for i, element := range value {
+				var oldElement any
+				if i < len(oldArray) {
+					oldElement = oldArray[i]
+				}
+				var err error
+				if buf, err = s.encode(buf, oldElement, element, append(p, pathSegment{index: i, isIndex: true})); err != nil {
+					return nil, err
+				}
+			}
+			return append(buf, 0xB6), nil
+		}
+	}
+	encoded, err := encodeDocument(value, false, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	s.reencoded++
+	return append(buf, encoded...), nil
+}
+
+// sameValue reports whether a decoded value and a new value would encode the
+// same way. Numbers compare by value, since the encoder writes integral
+// floats as integers; floats beyond 2^53 only match other floats, as their
+// integer encodings may differ. Zero and negative zero differ, since the
+// encoder keeps the sign.
+func sameValue(old, value any) bool {
+	switch value := value.(type) {
+	case map[string]any:
+		oldObject, ok := old.(map[string]any)
+		if !ok || len(oldObject) != len(value) {
+			return false
+		}
+		for key, member := range value {
+			oldMember, ok := oldObject[key]
+			if !ok || !sameValue(oldMember, member) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		oldArray, ok := old.([]any)
+		if !ok || len(oldArray) != len(value) {
+			return false
+		}
+		for i, element := range value {
+			if !sameValue(oldArray[i], element) {
+				return false
+			}
+		}
+		return true
+	case float64:
+		switch old := old.(type) {
+		case float64:
+			return old == value && math.Signbit(old) == math.Signbit(value) || (math.IsNaN(old) && math.IsNaN(value))
+		case int64:
+			return math.Abs(value) < 1<<53 && float64(old) == value && math.Signbit(float64(old)) == math.Signbit(value)
+		case uint64:
+			return value < 1<<53 && float64(old) == value && math.Signbit(float64(old)) == math.Signbit(value)
+		}
+		return false
+	case int64, uint64, string, bool, nil:
+		return old == value
+	}
+	return false
+}
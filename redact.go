@@ -0,0 +1,212 @@
+// ABOUTME: Declarative redaction (--redact-rules): rules select values by path, key, or regex and remove, replace, or mask them.
+// ABOUTME: After the conversion, the number of values each rule redacted is reported as an audit trail.
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// redactStrategies are what a rule may do with the values it selects.
+var redactStrategies = []string{"remove", "replace", "mask"}
+
+// redactRuleSpec is a rule as written in a rules file.
+type redactRuleSpec struct {
+	Name        string          `json:"name"`
+	Path        string          `json:"path"`
+	Key         string          `json:"key"`
+	Match       string          `json:"match"`
+	Strategy    string          `json:"strategy"`
+	Replacement json.RawMessage `json:"replacement"`
+}
+
+// redactRule selects the values at a path, of a key at any depth, or,
+// with match, the strings that match a regular expression (among those,
+// if a path or key is also given), and redacts them by strategy.
+type redactRule struct {
+	name        string
+	at          path
+	key         string
+	match       *regexp.Regexp
+	strategy    string
+	replacement any
+}
+
+// loadRedactRules reads the rules file filename: a JSON object whose
+// "rules" array lists the rules, applied in order. Unknown fields are
+// errors, so that a misspelled selector does not leave values unredacted.
+func loadRedactRules(filename string) ([]redactRule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading redaction rules: %w", err)
+	}
+	var file struct {
+		Rules []redactRuleSpec `json:"rules"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid redaction rules %s: %w", filename, err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, fmt.Errorf("redaction rules %s: no rules", filename)
+	}
+	rules := make([]redactRule, len(file.Rules))
+	for i, spec := range file.Rules {
+		rule, err := parseRedactRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rules %s: rule %d: %w", filename, i, err)
+		}
+		rules[i] = rule
+	}
+	return rules, nil
+}
+
+// parseRedactRule checks spec and compiles it into a rule.
+func parseRedactRule(spec redactRuleSpec) (redactRule, error) {
+	r := redactRule{name: spec.Name, key: spec.Key, strategy: spec.Strategy}
+	if spec.Path == "" && spec.Key == "" && spec.Match == "" {
+		return r, fmt.Errorf("expected a path, key, or match")
+	}
+	if spec.Path != "" {
+		var err error
+		if r.at, err = parsePath(spec.Path); err != nil {
+			return r, err
+		}
+		if len(r.at) == 0 {
+			return r, fmt.Errorf("cannot redact the whole document")
+		}
+	}
+	if spec.Match != "" {
+		var err error
+		if r.match, err = regexp.Compile(spec.Match); err != nil {
+			return r, fmt.Errorf("invalid match: %w", err)
+		}
+	}
+	if !slices.Contains(redactStrategies, r.strategy) {
+		return r, fmt.Errorf("invalid strategy: %q (expected %s)", r.strategy, strings.Join(redactStrategies, ", "))
+	}
+	if r.strategy == "replace" {
+		r.replacement = "[REDACTED]"
+		if spec.Replacement != nil {
+			if err := json.Unmarshal(spec.Replacement, &r.replacement); err != nil {
+				return r, fmt.Errorf("invalid replacement: %w", err)
+			}
+		}
+		if _, ok := r.replacement.(string); r.match != nil && !ok {
+			return r, fmt.Errorf("replacement of matches must be a string")
+		}
+	}
+	if r.name == "" {
+		r.name = cmp.Or(spec.Path, spec.Key, spec.Match)
+	}
+	return r, nil
+}
+
+// redact applies rules to the document v, adding the number of values each
+// redacted to counts, and returns the redacted document.
+func redact(v any, rules []redactRule, counts []int64) any {
+	for i, rule := range rules {
+		v, _ = rule.apply(v, nil, &counts[i])
+	}
+	return v
+}
+
+// apply redacts the value v at path at, or, if r does not select it, the
+// values inside it, and returns the result and whether to remove it.
+func (r *redactRule) apply(v any, at path, count *int64) (any, bool) {
+	if r.selects(v, at) {
+		*count++
+		return r.redactValue(v)
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			value, remove := r.apply(v[key], append(at, pathSegment{key: key}), count)
+			if remove {
+				delete(v, key)
+			} else {
+				v[key] = value
+			}
+		}
+	case []any:
+		kept := v[:0]
+		for i, elem := range v {
+			value, remove := r.apply(elem, append(at, pathSegment{index: i, isIndex: true}), count)
+			if !remove {
+				kept = append(kept, value)
+			}
+		}
+		return kept, false
+	}
+	return v, false
+}
+
+// selects reports whether r selects the value v at path at. A document
+// itself is never selected.
+func (r *redactRule) selects(v any, at path) bool {
+	if len(at) == 0 {
+		return false
+	}
+	if r.at != nil && at.String() != r.at.String() {
+		return false
+	}
+	if r.key != "" && (at[len(at)-1].isIndex || at[len(at)-1].key != r.key) {
+		return false
+	}
+	if r.match != nil {
+		s, ok := v.(string)
+		return ok && r.match.MatchString(s)
+	}
+	return true
+}
+
+// redactValue returns what a selected value becomes, and whether it is
+// removed instead. With match, replace and mask change only the matching
+// parts of the string.
+func (r *redactRule) redactValue(v any) (any, bool) {
+	switch r.strategy {
+	case "remove":
+		return nil, true
+	case "replace":
+		if r.match != nil {
+			return r.match.ReplaceAllString(v.(string), r.replacement.(string)), false
+		}
+		return cloneValue(r.replacement), false
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "***", false
+	}
+	if r.match != nil {
+		return r.match.ReplaceAllStringFunc(s, maskString), false
+	}
+	return maskString(s), false
+}
+
+// maskString returns s with each character replaced by *.
+func maskString(s string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(s))
+}
+
+// printRedactAudit writes the number of values each rule redacted to w.
+// counts is nil if there were no documents.
+func printRedactAudit(w io.Writer, rules []redactRule, counts []int64) {
+	for i, rule := range rules {
+		var n int64
+		if counts != nil {
+			n = counts[i]
+		}
+		fmt.Fprintf(w, "redacted %d values by rule %q (%s)\n", n, rule.name, rule.strategy)
+	}
+}
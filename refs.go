@@ -0,0 +1,130 @@
+// ABOUTME: Resolution of {"$include": "file"} directives and local $ref pointers.
+// ABOUTME: Compiles modular JSON source trees into a single self-contained document.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// resolveIncludes replaces every object of the form {"$include": "file"}
+// with the contents of that file, resolved relative to dir. Included
+// documents are themselves resolved relative to their own directory.
+// including holds the files currently being included, to detect cycles.
+func resolveIncludes(v any, dir string, including []string) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		if target, ok := v["$include"]; ok && len(v) == 1 {
+			filename, ok := target.(string)
+			if !ok {
+				return nil, fmt.Errorf("$include value must be a string")
+			}
+			if !filepath.IsAbs(filename) {
+				filename = filepath.Join(dir, filename)
+			}
+			for _, f := range including {
+				if f == filename {
+					return nil, fmt.Errorf("$include cycle: %s", strings.Join(append(including, filename), " -> "))
+				}
+			}
+			included, err := loadDocument(filename)
+			if err != nil {
+				return nil, fmt.Errorf("$include: %w", err)
+			}
+			return resolveIncludes(included, filepath.Dir(filename), append(including, filename))
+		}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			elem := v[key]
+			resolved, err := resolveIncludes(elem, dir, including)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+	case []any:
+		for i, elem := range v {
+			resolved, err := resolveIncludes(elem, dir, including)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	}
+	return v, nil
+}
+
+// resolveRefs replaces every object of the form {"$ref": "#/json/pointer"}
+// within v with a copy of the value the pointer refers to in root. Refs that
+// are not local pointers (not starting with "#") are left untouched.
+// resolving holds the pointers currently being resolved, to detect cycles.
+func resolveRefs(v, root any, resolving []string) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		if ref, ok := v["$ref"].(string); ok && len(v) == 1 && strings.HasPrefix(ref, "#") {
+			for _, r := range resolving {
+				if r == ref {
+					return nil, fmt.Errorf("$ref cycle: %s", strings.Join(append(resolving, ref), " -> "))
+				}
+			}
+			target, err := resolvePointer(root, ref[1:])
+			if err != nil {
+				return nil, fmt.Errorf("$ref %q: %w", ref, err)
+			}
+			return resolveRefs(cloneValue(target), root, append(resolving, ref))
+		}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			elem := v[key]
+			resolved, err := resolveRefs(elem, root, resolving)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+	case []any:
+		for i, elem := range v {
+			resolved, err := resolveRefs(elem, root, resolving)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	}
+	return v, nil
+}
+
+// resolvePointer returns the value that the RFC 6901 JSON pointer refers to
+// within root.
+func resolvePointer(root any, pointer string) (any, error) {
+	if pointer == "" {
+		return root, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer")
+	}
+	v := root
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch container := v.(type) {
+		case map[string]any:
+			elem, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("key %q not found", token)
+			}
+			v = elem
+		case []any:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil, fmt.Errorf("invalid array index %q", token)
+			}
+			v = container[index]
+		default:
+			return nil, fmt.Errorf("cannot descend into a scalar at %q", token)
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,117 @@
+// ABOUTME: Key renaming applied during conversion.
+// ABOUTME: Renames keys everywhere, or only in the object addressed by a path.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// keyRename renames the key from to the key to. If scope is non-nil, only
+// the object at scope is affected; otherwise every object at any depth is.
+type keyRename struct {
+	from  string
+	to    string
+	scope path
+}
+
+// parseRename parses an old=new rename specification. If old is a path such
+// as $.user.name, the rename only applies to the key "name" of the object at
+// $.user.
+func parseRename(spec string) (keyRename, error) {
+	from, to, ok := strings.Cut(spec, "=")
+	if !ok || from == "" || to == "" {
+		return keyRename{}, fmt.Errorf("invalid rename %q: expected old=new", spec)
+	}
+	if !strings.HasPrefix(from, "$") {
+		return keyRename{from: from, to: to}, nil
+	}
+	p, err := parsePath(from)
+	if err != nil {
+		return keyRename{}, err
+	}
+	if len(p) == 0 || p[len(p)-1].isIndex {
+		return keyRename{}, fmt.Errorf("invalid rename %q: path must end in a key", spec)
+	}
+	return keyRename{from: p[len(p)-1].key, to: to, scope: p[:len(p)-1]}, nil
+}
+
+// loadRenameFile reads a JSON object mapping old keys (or paths) to new keys.
+// The renames are returned sorted by old key so they apply deterministically.
+func loadRenameFile(filename string) ([]keyRename, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading rename file: %w", err)
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("invalid rename file %s: %w", filename, err)
+	}
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	renames := make([]keyRename, len(keys))
+	for i, key := range keys {
+		if renames[i], err = parseRename(key + "=" + mapping[key]); err != nil {
+			return nil, fmt.Errorf("rename file %s: %w", filename, err)
+		}
+	}
+	return renames, nil
+}
+
+// applyRename applies r to the document v.
+func applyRename(v any, r keyRename) error {
+	if r.scope != nil {
+		target, ok := lookupPath(v, r.scope)
+		if !ok {
+			return nil
+		}
+		if obj, ok := target.(map[string]any); ok {
+			return renameKey(obj, r.scope, r)
+		}
+		return nil
+	}
+	return renameEverywhere(v, nil, r)
+}
+
+// renameEverywhere renames the key in every object within v.
+func renameEverywhere(v any, at path, r keyRename) error {
+	switch v := v.(type) {
+	case map[string]any:
+		if err := renameKey(v, at, r); err != nil {
+			return err
+		}
+		for key, elem := range v {
+			if err := renameEverywhere(elem, append(at, pathSegment{key: key}), r); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, elem := range v {
+			if err := renameEverywhere(elem, append(at, pathSegment{index: i, isIndex: true}), r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renameKey renames the key in the object obj located at path at.
+func renameKey(obj map[string]any, at path, r keyRename) error {
+	value, ok := obj[r.from]
+	if !ok {
+		return nil
+	}
+	if _, exists := obj[r.to]; exists {
+		return fmt.Errorf("cannot rename %q to %q at %s: key already exists", r.from, r.to, at)
+	}
+	delete(obj, r.from)
+	obj[r.to] = value
+	return nil
+}
@@ -0,0 +1,177 @@
+// ABOUTME: Numeric unit scaling applied during conversion (--scale).
+// ABOUTME: Multiplies or divides the number at a path by a constant exactly, so integers stay integers when they can.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// numberScale multiplies the numbers at target by factor.
+type numberScale struct {
+	target path
+	factor *big.Rat
+}
+
+// parseScale parses a PATH*FACTOR or PATH/DIVISOR specification such as
+// $.latency_ms*0.001, where the constant is a decimal number, optionally
+// with an exponent.
+func parseScale(spec string) (numberScale, error) {
+	i := strings.LastIndexAny(spec, "*/")
+	if i <= 0 || i == len(spec)-1 {
+		return numberScale{}, fmt.Errorf("invalid scale %q: expected PATH*FACTOR or PATH/DIVISOR", spec)
+	}
+	target, err := parsePath(spec[:i])
+	if err != nil {
+		return numberScale{}, err
+	}
+	if len(target) == 0 {
+		return numberScale{}, fmt.Errorf("invalid scale %q: path must name a value inside the document", spec)
+	}
+	factor, ok := new(big.Rat).SetString(spec[i+1:])
+	if !ok {
+		return numberScale{}, fmt.Errorf("invalid scale %q: %q is not a number", spec, spec[i+1:])
+	}
+	if spec[i] == '/' {
+		if factor.Sign() == 0 {
+			return numberScale{}, fmt.Errorf("invalid scale %q: division by zero", spec)
+		}
+		factor.Inv(factor)
+	}
+	return numberScale{target: target, factor: factor}, nil
+}
+
+// applyScale scales the number at s.target within the document v, or each
+// number of the array there. A document without the path is left alone.
+func applyScale(v any, s numberScale) error {
+	parent, ok := lookupPath(v, s.target[:len(s.target)-1])
+	if !ok {
+		return nil
+	}
+	last := s.target[len(s.target)-1]
+	value, ok := lookupPath(parent, path{last})
+	if !ok {
+		return nil
+	}
+
+	if array, ok := value.([]any); ok {
+		for i, elem := range array {
+			scaled, err := scaleNumber(elem, s.factor)
+			if err != nil {
+				return fmt.Errorf("cannot scale %s: %w", append(s.target, pathSegment{index: i, isIndex: true}), err)
+			}
+			array[i] = scaled
+		}
+		return nil
+	}
+	scaled, err := scaleNumber(value, s.factor)
+	if err != nil {
+		return fmt.Errorf("cannot scale %s: %w", s.target, err)
+	}
+	if last.isIndex {
+		parent.([]any)[last.index] = scaled
+	} else {
+		parent.(map[string]any)[last.key] = scaled
+	}
+	return nil
+}
+
+// scaleNumber multiplies the decoded number n by factor. The product is
+// computed exactly, and given as decodedNumber gives it, so scaling seconds
+// to milliseconds keeps every digit; a big float stays a big float, with
+// every digit of the scaled decimal. NaN and the infinities are left as they
+// are.
+func scaleNumber(n any, factor *big.Rat) (any, error) {
+	switch n := n.(type) {
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return n, nil
+		}
+	case *big.Float:
+		if n.IsInf() {
+			return n, nil
+		}
+	}
+	if f, ok := n.(*big.Float); ok {
+		// A big float holds a decimal to within its precision. Scaling that
+		// decimal, as its shortest text gives it, rather than the binary
+		// value, keeps its digits free of rounding noise.
+		value, _ := new(big.Rat).SetString(f.Text('g', -1))
+		return decimalFloat(value.Mul(value, factor), f.Prec()), nil
+	}
+	value, whole, ok := exactNumber(n)
+	if !ok {
+		return nil, fmt.Errorf("not a number")
+	}
+	return decodedNumber(value.Mul(value, factor), whole), nil
+}
+
+// decimalFloat returns value as a *big.Float. A value with a finite decimal
+// expansion gets a precision that keeps every one of its digits, as
+// untagDecimals gives; any other is rounded to prec bits.
+func decimalFloat(value *big.Rat, prec uint) *big.Float {
+	denom := new(big.Int).Set(value.Denom())
+	twos := denom.TrailingZeroBits()
+	denom.Rsh(denom, twos)
+	fives := uint(0)
+	five, q, m := big.NewInt(5), new(big.Int), new(big.Int)
+	for q.QuoRem(denom, five, m); m.Sign() == 0; q.QuoRem(denom, five, m) {
+		denom.Set(q)
+		fives++
+	}
+	if !denom.IsInt64() || denom.Int64() != 1 {
+		return new(big.Float).SetPrec(prec).SetRat(value)
+	}
+	s := value.FloatString(int(max(twos, fives)))
+	f, _, _ := big.ParseFloat(s, 10, uint(len(s))*4+64, big.ToNearestEven)
+	return f
+}
+
+// exactNumber returns the exact value of the decoded number n and whether it
+// is a whole number (JSON decodes integers as float64, so a float64 can be
+// one), or false if n is not a finite number.
+func exactNumber(n any) (*big.Rat, bool, bool) {
+	value := new(big.Rat)
+	switch n := n.(type) {
+	case int64:
+		return value.SetInt64(n), true, true
+	case uint64:
+		return value.SetUint64(n), true, true
+	case *big.Int:
+		return value.SetInt(n), true, true
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, false, false
+		}
+		return value.SetFloat64(n), n == math.Trunc(n), true
+	case *big.Float:
+		if n.IsInf() {
+			return nil, false, false
+		}
+		n.Rat(value)
+		return value, false, true
+	}
+	return nil, false, false
+}
+
+// decodedNumber returns value as a decoder would give it: an integer, of
+// the smallest of int64, uint64, and big.Int that holds it, if whole says the
+// inputs it was computed from were whole numbers and it is one too, and the
+// nearest float64 otherwise.
+func decodedNumber(value *big.Rat, whole bool) any {
+	if !whole || !value.IsInt() {
+		f, _ := value.Float64()
+		return f
+	}
+	n := value.Num()
+	switch {
+	case n.IsInt64():
+		return n.Int64()
+	case n.IsUint64():
+		return n.Uint64()
+	}
+	return n
+}
@@ -0,0 +1,407 @@
+// ABOUTME: Streaming scanner over the BONJSON wire format that never materializes values.
+// ABOUTME: Reports every value's kind, depth, path, offset, and encoded size to a visitor.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// valueKind classifies an encoded value.
+type valueKind int
+
+const (
+	kindNull valueKind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindBigNumber
+	kindString
+	kindKey
+	kindArray
+	kindTypedArray
+	kindObject
+	kindRecordDef
+	numValueKinds
+)
+
+var valueKindNames = [numValueKinds]string{
+	kindNull:       "null",
+	kindBool:       "bool",
+	kindInt:        "int",
+	kindFloat:      "float",
+	kindBigNumber:  "bignumber",
+	kindString:     "string",
+	kindKey:        "key",
+	kindArray:      "array",
+	kindTypedArray: "typed-array",
+	kindObject:     "object",
+	kindRecordDef:  "record-def",
+}
+
+func (k valueKind) String() string {
+	return valueKindNames[k]
+}
+
+// scannedValue describes one value found by a wireScanner. size is the full
+// encoded size including any nested values; own excludes nested values, so
+// the own sizes of all values in a document add up to its size. path is only
+// valid during the visit call, as is raw, the encoded bytes of a scalar value
+// when the scanner captures them.
+type scannedValue struct {
+	kind   valueKind
+	depth  int
+	offset int64
+	size   int64
+	own    int64
+	path   path
+	raw    []byte
+}
+
+// typeCodeError reports a type code that cannot start a value: a reserved
+// one, or a structural one out of place. Since nothing says how long such a
+// value is, nothing after it can be scanned.
+type typeCodeError struct {
+	offset int64
+	code   byte
+	path   path
+}
+
+func (e *typeCodeError) Error() string {
+	return fmt.Sprintf("offset %d: invalid type code 0x%02x", e.offset, e.code)
+}
+
+// reserved reports whether the type code is one the format reserves for
+// future use, rather than a structural one out of place.
+func (e *typeCodeError) reserved() bool {
+	return e.code >= 0xBB && e.code <= 0xF4
+}
+
+// wireScanner walks BONJSON documents token by token, reading each value's
+// bytes exactly once and keeping only the path to the current value in
+// memory, so documents far larger than memory can be examined. Record
+// instances are reported as objects, using the keys of their definition.
+type wireScanner struct {
+	r          *bufio.Reader
+	offset     int64
+	path       path
+	recordDefs [][]string
+	visit      func(scannedValue)
+
+	// captureScalars makes the scanner pass each scalar's encoded bytes to
+	// visit, at the cost of copying them into raw.
+	captureScalars bool
+	raw            []byte
+}
+
+// newWireScanner returns a scanner reading from r that reports values to
+// visit. offset is the input offset of the first byte in r.
+func newWireScanner(r io.Reader, offset int64, visit func(scannedValue)) *wireScanner {
+	return &wireScanner{r: bufio.NewReaderSize(r, 64*1024), offset: offset, visit: visit}
+}
+
+// scanDocument scans the next document, including any record definitions
+// before it. As in go-bonjson's Decoder, record definitions stay in effect
+// for the rest of the stream. It returns io.EOF if the input ends before a
+// document starts.
+func (s *wireScanner) scanDocument() error {
+	for {
+		tc, err := s.r.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return io.EOF
+			}
+			return err
+		}
+		if tc[0] != 0xB9 {
+			break
+		}
+		if err := s.scanRecordDef(); err != nil {
+			return err
+		}
+	}
+	_, err := s.scanValue(0)
+	return err
+}
+
+// atEOF reports whether the input has been fully consumed.
+func (s *wireScanner) atEOF() bool {
+	_, err := s.r.Peek(1)
+	return err == io.EOF
+}
+
+func (s *wireScanner) readByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, s.unexpected(err)
+	}
+	s.offset++
+	if s.captureScalars {
+		s.raw = append(s.raw, b)
+	}
+	return b, nil
+}
+
+func (s *wireScanner) skip(n int64) error {
+	skipped, err := s.r.Discard(int(n))
+	s.offset += int64(skipped)
+	if err != nil {
+		return s.unexpected(err)
+	}
+	return nil
+}
+
+// consume skips n bytes of a scalar value, capturing them if enabled.
+func (s *wireScanner) consume(n int64) error {
+	if !s.captureScalars {
+		return s.skip(n)
+	}
+	start := len(s.raw)
+	s.raw = append(s.raw, make([]byte, n)...)
+	read, err := io.ReadFull(s.r, s.raw[start:])
+	s.offset += int64(read)
+	if err != nil {
+		return s.unexpected(err)
+	}
+	return nil
+}
+
+func (s *wireScanner) readLEB128() (uint64, error) {
+	var result uint64
+	for shift := uint(0); ; shift += 7 {
+		if shift >= 64 {
+			return 0, fmt.Errorf("offset %d: LEB128 value overflows 64 bits", s.offset)
+		}
+		b, err := s.readByte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+	}
+}
+
+// unexpected converts an end of input inside a value to io.ErrUnexpectedEOF.
+func (s *wireScanner) unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("offset %d: %w", s.offset, err)
+}
+
+// readString reads the body of a string whose type code tc has already been
+// read. It returns the string only if keep is set.
+func (s *wireScanner) readString(tc byte, keep bool) (string, error) {
+	if tc != 0xFF {
+		n := int(tc - 0x65)
+		if !keep {
+			return "", s.consume(int64(n))
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(s.r, buf); err != nil {
+			return "", s.unexpected(err)
+		}
+		s.offset += int64(n)
+		return string(buf), nil
+	}
+	var buf []byte
+	for {
+		chunk, err := s.r.ReadSlice(0xFF)
+		s.offset += int64(len(chunk))
+		if s.captureScalars && !keep {
+			s.raw = append(s.raw, chunk...)
+		}
+		if err == nil {
+			if keep {
+				buf = append(buf, chunk[:len(chunk)-1]...)
+			}
+			return string(buf), nil
+		}
+		if err != bufio.ErrBufferFull {
+			return "", s.unexpected(err)
+		}
+		if keep {
+			buf = append(buf, chunk...)
+		}
+	}
+}
+
+func isStringTypeCode(tc byte) bool {
+	return (tc >= 0x65 && tc <= 0xA7) || tc == 0xFF
+}
+
+// scanRecordDef scans a record definition, remembering its keys.
+func (s *wireScanner) scanRecordDef() error {
+	start := s.offset
+	if _, err := s.readByte(); err != nil {
+		return err
+	}
+	var keys []string
+	for {
+		tc, err := s.readByte()
+		if err != nil {
+			return err
+		}
+		if tc == 0xB6 {
+			break
+		}
+		if !isStringTypeCode(tc) {
+			return fmt.Errorf("offset %d: expected string key in record definition, got type code 0x%02x", s.offset-1, tc)
+		}
+		key, err := s.readString(tc, true)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	s.recordDefs = append(s.recordDefs, keys)
+	size := s.offset - start
+	s.visit(scannedValue{kind: kindRecordDef, offset: start, size: size, own: size, path: s.path})
+	return nil
+}
+
+// scanValue scans one value at the given depth and returns its encoded size.
+func (s *wireScanner) scanValue(depth int) (int64, error) {
+	start := s.offset
+	s.raw = s.raw[:0]
+	tc, err := s.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	var kind valueKind
+	var nested int64
+	switch {
+	case tc <= 0x64:
+		kind = kindInt
+	case isStringTypeCode(tc):
+		kind = kindString
+		_, err = s.readString(tc, false)
+	case tc >= 0xA8 && tc <= 0xAF:
+		kind = kindInt
+		err = s.consume(1 << (tc & 0x03))
+	case tc == 0xB0:
+		kind = kindFloat
+		err = s.consume(4)
+	case tc == 0xB1:
+		kind = kindFloat
+		err = s.consume(8)
+	case tc == 0xB2:
+		kind = kindBigNumber
+		if _, err = s.readLEB128(); err == nil {
+			var length uint64
+			if length, err = s.readLEB128(); err == nil {
+				// The signed length is zigzag encoded; its magnitude is the byte count.
+				err = s.consume(int64(length>>1) + int64(length&1))
+			}
+		}
+	case tc == 0xB3:
+		kind = kindNull
+	case tc == 0xB4, tc == 0xB5:
+		kind = kindBool
+	case tc == 0xB7:
+		kind = kindArray
+		nested, err = s.scanContainer(depth, false, nil)
+	case tc == 0xB8:
+		kind = kindObject
+		nested, err = s.scanContainer(depth, true, nil)
+	case tc == 0xBA:
+		kind = kindObject
+		var index uint64
+		if index, err = s.readLEB128(); err == nil {
+			if index >= uint64(len(s.recordDefs)) {
+				return 0, fmt.Errorf("offset %d: record instance refers to undefined record %d", start, index)
+			}
+			nested, err = s.scanContainer(depth, false, s.recordDefs[index])
+		}
+	case tc >= 0xF5 && tc <= 0xFE:
+		kind = kindTypedArray
+		var count uint64
+		if count, err = s.readLEB128(); err == nil {
+			err = s.skip(int64(count) * typedArrayElementSize(tc))
+		}
+	default:
+		return 0, &typeCodeError{offset: start, code: tc, path: slices.Clone(s.path)}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	size := s.offset - start
+	var raw []byte
+	if s.captureScalars && kind != kindArray && kind != kindTypedArray && kind != kindObject {
+		raw = s.raw
+	}
+	s.visit(scannedValue{kind: kind, depth: depth, offset: start, size: size, own: size - nested, path: s.path, raw: raw})
+	return size, nil
+}
+
+// scanContainer scans the members of a container up to and including its end
+// marker, returning the total size of the nested values. Object members are
+// key/value pairs; record instance members are values for the given keys.
+func (s *wireScanner) scanContainer(depth int, isObject bool, recordKeys []string) (int64, error) {
+	var nested int64
+	for index := 0; ; index++ {
+		tc, err := s.r.Peek(1)
+		if err != nil {
+			return 0, s.unexpected(err)
+		}
+		if tc[0] == 0xB6 {
+			_, err := s.readByte()
+			return nested, err
+		}
+
+		var segment pathSegment
+		switch {
+		case isObject:
+			keyTC, _ := s.readByte()
+			keyStart := s.offset - 1
+			if !isStringTypeCode(keyTC) {
+				return 0, fmt.Errorf("offset %d: expected string object key, got type code 0x%02x", keyStart, keyTC)
+			}
+			key, err := s.readString(keyTC, true)
+			if err != nil {
+				return 0, err
+			}
+			keySize := s.offset - keyStart
+			s.visit(scannedValue{kind: kindKey, depth: depth + 1, offset: keyStart, size: keySize, own: keySize, path: s.path})
+			nested += keySize
+			segment = pathSegment{key: key}
+		case recordKeys != nil:
+			if index >= len(recordKeys) {
+				return 0, fmt.Errorf("offset %d: record instance has more values than its definition has keys", s.offset)
+			}
+			segment = pathSegment{key: recordKeys[index]}
+		default:
+			segment = pathSegment{index: index, isIndex: true}
+		}
+
+		s.path = append(s.path, segment)
+		size, err := s.scanValue(depth + 1)
+		s.path = s.path[:len(s.path)-1]
+		if err != nil {
+			return 0, err
+		}
+		nested += size
+	}
+}
+
+// typedArrayElementSize returns the element size of a typed array type code.
+func typedArrayElementSize(tc byte) int64 {
+	switch tc {
+	case 0xF5, 0xF7, 0xFB:
+		return 8
+	case 0xF6, 0xF8, 0xFC:
+		return 4
+	case 0xF9, 0xFD:
+		return 2
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,190 @@
+// ABOUTME: The schema compat command: reports the breaking changes between two versions of a JSON Schema.
+// ABOUTME: A change breaks compatibility where the reading schema rejects data that the writing schema accepts.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// compatModes are the modes of --mode: backward (the new schema accepts
+// all data valid under the old one), forward (the old schema accepts all
+// data valid under the new one), and full (both).
+var compatModes = []string{"backward", "forward", "full"}
+
+// runSchema implements the schema subcommands.
+func runSchema(args []string, opts *options) error {
+	if len(args) != 3 || args[0] != "compat" {
+		return fmt.Errorf("usage: schema compat OLD NEW [--mode backward|forward|full]")
+	}
+	return checkSchemaCompat(args[1], args[2], opts)
+}
+
+// checkSchemaCompat prints each breaking change from the schema in oldPath
+// to the one in newPath under opts.compatMode to stdout, and fails if there
+// are any.
+func checkSchemaCompat(oldPath, newPath string, opts *options) error {
+	oldSchema, err := loadTypeSchema(oldPath)
+	if err != nil {
+		return fmt.Errorf("loading old schema: %w", err)
+	}
+	newSchema, err := loadTypeSchema(newPath)
+	if err != nil {
+		return fmt.Errorf("loading new schema: %w", err)
+	}
+	mode := opts.compatMode
+	if mode == "" {
+		mode = "backward"
+	}
+	var breaks int
+	check := func(direction, reader string, writer, read *typeSchema) {
+		c := &schemaComparison{seen: make(map[[2]*typeSchema]bool)}
+		c.compare(writer, read, "$", func(at, format string, args ...any) {
+			breaks++
+			fmt.Printf("%s: %s: %s schema %s\n", direction, at, reader, fmt.Sprintf(format, args...))
+		})
+	}
+	if mode != "forward" {
+		check("backward", "new", oldSchema, newSchema)
+	}
+	if mode != "backward" {
+		check("forward", "old", newSchema, oldSchema)
+	}
+	if breaks > 0 {
+		return fmt.Errorf("%d breaking schema changes", breaks)
+	}
+	return nil
+}
+
+// schemaComparison compares a writing schema with a reading one. seen holds
+// the pairs already compared, so recursive schemas end.
+type schemaComparison struct {
+	seen map[[2]*typeSchema]bool
+}
+
+// compare reports, for the value at path at, each way in which read
+// rejects data that write accepts. A nil schema accepts anything. Paths end
+// in .* for keys beyond the properties, and [*] for array items.
+func (c *schemaComparison) compare(write, read *typeSchema, at string, report func(at, format string, args ...any)) {
+	if read == nil {
+		return
+	}
+	if write == nil {
+		write = &typeSchema{}
+	}
+	if c.seen[[2]*typeSchema{write, read}] {
+		return
+	}
+	c.seen[[2]*typeSchema{write, read}] = true
+
+	switch {
+	case read.types == nil:
+	case write.types == nil:
+		report(at, "only accepts %s", strings.Join(read.types, " or "))
+	default:
+		for _, typ := range write.types {
+			if !slices.Contains(read.types, typ) && !(typ == "integer" && slices.Contains(read.types, "number")) {
+				report(at, "rejects type %s", typ)
+			}
+		}
+	}
+	if read.dateTime && !write.dateTime {
+		report(at, "only accepts RFC 3339 date-time strings")
+	}
+	switch {
+	case read.enum == nil:
+	case write.enum == nil:
+		report(at, "only accepts the values of its enum")
+	default:
+		for _, value := range write.enum {
+			encoded, _ := json.Marshal(value)
+			if !slices.ContainsFunc(read.enum, func(e any) bool {
+				option, _ := json.Marshal(e)
+				return string(option) == string(encoded)
+			}) {
+				report(at, "rejects the value %s", encoded)
+			}
+		}
+	}
+
+	compareBound(at, "minimum", write.minimum, read.minimum, 1, report)
+	compareBound(at, "maximum", write.maximum, read.maximum, -1, report)
+	compareLimit(at, "minLength", write.minLength, read.minLength, 1, report)
+	compareLimit(at, "maxLength", write.maxLength, read.maxLength, -1, report)
+	compareLimit(at, "minItems", write.minItems, read.minItems, 1, report)
+	compareLimit(at, "maxItems", write.maxItems, read.maxItems, -1, report)
+
+	for _, key := range read.required {
+		if !slices.Contains(write.required, key) {
+			report(at, "requires the key %q", key)
+		}
+	}
+	if read.closed && !write.closed {
+		report(at, "rejects additional keys")
+	}
+	keys := slices.Sorted(maps.Keys(write.properties))
+	for _, key := range slices.Sorted(maps.Keys(read.properties)) {
+		if _, ok := write.properties[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		member := at + path{{key: key}}.String()[1:]
+		writeMember, ok := write.properties[key]
+		if !ok {
+			if write.closed {
+				continue // write never has the key
+			}
+			writeMember = write.additional
+		}
+		readMember, ok := read.properties[key]
+		if !ok {
+			if read.closed {
+				report(member, "rejects the key")
+				continue
+			}
+			readMember = read.additional
+		}
+		c.compare(writeMember, readMember, member, report)
+	}
+	if !write.closed && !read.closed {
+		c.compare(write.additional, read.additional, at+".*", report)
+	}
+	c.compare(write.items, read.items, at+"[*]", report)
+}
+
+// compareBound reports a minimum (sign 1) or maximum (sign -1) of read that
+// is stricter than write's.
+func compareBound(at, name string, write, read *big.Rat, sign int, report func(at, format string, args ...any)) {
+	switch {
+	case read == nil:
+	case write == nil:
+		report(at, "adds a %s of %s", name, read.RatString())
+	case read.Cmp(write)*sign > 0:
+		report(at, "%s the %s from %s to %s", boundVerb(sign), name, write.RatString(), read.RatString())
+	}
+}
+
+// compareLimit is compareBound for length and item count limits.
+func compareLimit(at, name string, write, read *int, sign int, report func(at, format string, args ...any)) {
+	switch {
+	case read == nil:
+	case write == nil:
+		report(at, "adds a %s of %d", name, *read)
+	case (*read-*write)*sign > 0:
+		report(at, "%s the %s from %d to %d", boundVerb(sign), name, *write, *read)
+	}
+}
+
+func boundVerb(sign int) string {
+	if sign > 0 {
+		return "raises"
+	}
+	return "lowers"
+}
@@ -0,0 +1,227 @@
+// ABOUTME: JSON Schemas as bonbon reads them, and schema-guided decoding of JSON input (--schema-types).
+// ABOUTME: Numbers the schema declares integers decode as exact integers instead of float64, so they encode as BONJSON integers.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// typeSchema holds the keywords of a JSON Schema that bonbon uses, for one
+// position in a document: the type hints that guide decoding, and the
+// constraints that validate checks.
+type typeSchema struct {
+	types      []string // the types it allows, or nil for any
+	integer    bool     // "integer" is among its types
+	number     bool     // "number" is among its types
+	dateTime   bool     // it is a string of format "date-time"
+	enum       []any
+	required   []string
+	closed     bool // additionalProperties is false
+	minimum    *big.Rat
+	maximum    *big.Rat
+	minLength  *int
+	maxLength  *int
+	minItems   *int
+	maxItems   *int
+	properties map[string]*typeSchema
+	additional *typeSchema
+	items      *typeSchema
+}
+
+// loadTypeSchema reads the JSON Schema in filename.
+func loadTypeSchema(filename string) (*typeSchema, error) {
+	root, err := loadDocument(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseTypeSchema(root, root, make(map[string]*typeSchema))
+}
+
+// parseTypeSchema extracts the keywords of the schema node s, following
+// "$ref"s to JSON pointers into root ("#/$defs/item"). refs holds the
+// schemas already parsed for each reference, so recursive schemas end.
+// Keywords other than type, format, enum, required, properties,
+// additionalProperties, items, minimum, maximum, minLength, maxLength,
+// minItems, maxItems, and $ref are ignored; a boolean schema has none.
+func parseTypeSchema(s, root any, refs map[string]*typeSchema) (*typeSchema, error) {
+	node, ok := s.(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	if ref, ok := node["$ref"].(string); ok {
+		if parsed, ok := refs[ref]; ok {
+			return parsed, nil
+		}
+		target, err := resolveSchemaRef(ref, root)
+		if err != nil {
+			return nil, err
+		}
+		parsed := &typeSchema{}
+		refs[ref] = parsed
+		resolved, err := parseTypeSchema(target, root, refs)
+		if err != nil || resolved == nil {
+			return resolved, err
+		}
+		*parsed = *resolved
+		return parsed, nil
+	}
+
+	t := &typeSchema{}
+	types := []any{node["type"]}
+	if list, ok := node["type"].([]any); ok {
+		types = list
+	}
+	for _, typ := range types {
+		if name, ok := typ.(string); ok {
+			t.types = append(t.types, name)
+		}
+		switch typ {
+		case "integer":
+			t.integer = true
+		case "number":
+			t.number = true
+		case "string":
+			t.dateTime = node["format"] == "date-time"
+		}
+	}
+	t.enum, _ = node["enum"].([]any)
+	if required, ok := node["required"].([]any); ok {
+		for _, key := range required {
+			if key, ok := key.(string); ok {
+				t.required = append(t.required, key)
+			}
+		}
+	}
+	t.closed = node["additionalProperties"] == false
+	t.minimum, t.maximum = schemaBound(node["minimum"]), schemaBound(node["maximum"])
+	t.minLength, t.maxLength = schemaLimit(node["minLength"]), schemaLimit(node["maxLength"])
+	t.minItems, t.maxItems = schemaLimit(node["minItems"]), schemaLimit(node["maxItems"])
+	var err error
+	if props, ok := node["properties"].(map[string]any); ok {
+		t.properties = make(map[string]*typeSchema, len(props))
+		for key, prop := range props {
+			if t.properties[key], err = parseTypeSchema(prop, root, refs); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if t.additional, err = parseTypeSchema(node["additionalProperties"], root, refs); err != nil {
+		return nil, err
+	}
+	if t.items, err = parseTypeSchema(node["items"], root, refs); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// schemaBound returns the value of a minimum or maximum keyword, or nil if
+// it is not a number.
+func schemaBound(v any) *big.Rat {
+	if n, ok := v.(float64); ok {
+		return new(big.Rat).SetFloat64(n)
+	}
+	return nil
+}
+
+// schemaLimit returns the value of a length or item count keyword, or nil if
+// it is not a number.
+func schemaLimit(v any) *int {
+	if n, ok := v.(float64); ok {
+		limit := int(n)
+		return &limit
+	}
+	return nil
+}
+
+// resolveSchemaRef returns the node of root that the local reference ref
+// points to.
+func resolveSchemaRef(ref string, root any) (any, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q: only references within the schema are", ref)
+	}
+	node := root
+	for _, token := range strings.Split(ref, "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		var ok bool
+		switch n := node.(type) {
+		case map[string]any:
+			node, ok = n[token]
+		case []any:
+			i, err := strconv.Atoi(token)
+			if ok = err == nil && i >= 0 && i < len(n); ok {
+				node = n[i]
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("$ref %q: not found", ref)
+		}
+	}
+	return node, nil
+}
+
+// applySchemaTypes converts the numbers of v, decoded from JSON as
+// json.Numbers, as the schema s says: to exact integers where it allows
+// integers and the number is whole, and to float64 elsewhere. A number the
+// schema only allows to be an integer, or a date-time string that is not
+// RFC 3339, is an error. at is v's path, for errors.
+func applySchemaTypes(v any, s *typeSchema, at path) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if s != nil && s.integer {
+			if n, ok := new(big.Rat).SetString(string(v)); ok && n.IsInt() {
+				return decodedNumber(n, true), nil
+			}
+			if !s.number {
+				return nil, fmt.Errorf("%s: %s is not an integer", at, v)
+			}
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", at, err)
+		}
+		return f, nil
+	case string:
+		if s != nil && s.dateTime {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				return nil, fmt.Errorf("%s: %q is not an RFC 3339 date-time", at, v)
+			}
+		}
+	case map[string]any:
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			member := v[key]
+			var ms *typeSchema
+			if s != nil {
+				ms = s.properties[key]
+				if ms == nil {
+					ms = s.additional
+				}
+			}
+			converted, err := applySchemaTypes(member, ms, append(at, pathSegment{key: key}))
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+	case []any:
+		var es *typeSchema
+		if s != nil {
+			es = s.items
+		}
+		for i, elem := range v {
+			converted, err := applySchemaTypes(elem, es, append(at, pathSegment{index: i, isIndex: true}))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,515 @@
+// ABOUTME: The serve command: a conversion daemon over HTTP, on a TCP address or a Unix socket.
+// ABOUTME: Speaks a versioned request protocol whose existing requests keep working across upgrades.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Protocol version 1:
+//
+//	POST /v1/convert    convert the request body
+//	GET  /v1/version    {"protocol": 1, "supported": [1]}
+//	GET  /v1/openapi.json
+//	                    an OpenAPI document describing the protocol
+//
+// A convert request names the format of its body in Content-Type, which is
+// application/json or application/bonjson, and the format it wants back in
+// Accept, which may also be text/markdown or text/csv (as with --to). Media
+// types come from the format registry. Without an Accept header (or with
+// */*), the response is in the other of JSON and BONJSON. Conversion options
+// are query parameters or Bonbon-Option-NAME headers, limited to those the
+// server's --allow-params permits. Options not given in the request take
+// their values from the serve command line. serveParameters lists the
+// parameters; serveErrors lists the error codes. When the server requires
+// authentication (see auth.go), convert requests need a bearer token or an
+// HMAC signature. Every response carries a Bonbon-Protocol header. A failed
+// request gets a non-2xx status and a JSON body
+// {"error": {"code": C, "message": M}}; clients should branch on the code,
+// not the message.
+//
+// Compatibility: a request that is valid in version 1 keeps working, with
+// the same meaning, in every later release. Later releases may add endpoints,
+// query parameters, headers, error codes, and fields in JSON responses, so
+// clients must ignore what they don't recognize. Unknown query parameters
+// are rejected rather than ignored, so a client never silently gets a
+// conversion without an option it asked for. Incompatible changes get a new
+// version under a new path prefix, served alongside /v1/.
+const serveProtocol = 1
+
+// serveParameter is a conversion option that a convert request can set,
+// either as a query parameter or as a Bonbon-Option-NAME header. The handler
+// and the OpenAPI document are both driven by serveParameters, so the spec
+// cannot drift from what the server accepts. values, if set, lists the valid
+// values.
+type serveParameter struct {
+	name        string
+	schemaType  string // OpenAPI schema type
+	values      []string
+	description string
+	apply       func(opts *options, value string) error
+}
+
+var serveParameters = []serveParameter{
+	{"stream", "boolean", nil, "The body is a stream of documents (like --stream)", func(opts *options, value string) error {
+		return parseBoolParameter(value, &opts.stream)
+	}},
+	{"indent", "integer", nil, "Indent JSON output by this many spaces, 0 for compact (like --indent)", func(opts *options, value string) error {
+		indent, err := parseIndent(value)
+		if err != nil {
+			return fmt.Errorf("expected 0 to 16")
+		}
+		opts.indent = &indent
+		return nil
+	}},
+	{"width", "integer", nil, "Keep JSON arrays and objects that fit in this many columns on one line (like --width)", func(opts *options, value string) error {
+		width, err := parseWidth(value)
+		if err != nil {
+			return fmt.Errorf("expected a positive number")
+		}
+		opts.width = width
+		return nil
+	}},
+	{"compact-arrays", "boolean", nil, "Put JSON arrays of scalars on one line whatever their length (like --compact-arrays)", func(opts *options, value string) error {
+		return parseBoolParameter(value, &opts.compactArrays)
+	}},
+	{"normalize", "string", []string{"nfc", "nfd"}, "Unicode normalization form for string values and keys (like --nfc and --nfd)", func(opts *options, value string) error {
+		opts.normalization = value
+		return nil
+	}},
+	{"keys", "string", keyCases, "Rewrite every object key in this style (like --keys)", func(opts *options, value string) error {
+		opts.keyCase = value
+		return nil
+	}},
+	{"decimals", "string", decimalModes, "How JSON holds decimals too precise for a float64 (like --decimals)", func(opts *options, value string) error {
+		opts.decimals = value
+		return nil
+	}},
+	{"uint64", "string", uint64Modes, "What JSON output does with integers beyond +/-(2^53-1) (like --uint64)", func(opts *options, value string) error {
+		opts.uint64Mode = value
+		return nil
+	}},
+	{"csv-delimiter", "string", nil, "Field delimiter of text/csv output, one character or tab (like --csv-delimiter)", func(opts *options, value string) error {
+		delimiter, err := parseCSVDelimiter(value)
+		if err != nil {
+			return fmt.Errorf("expected one character other than a quote or line break, or tab")
+		}
+		opts.csvDelimiter = delimiter
+		return nil
+	}},
+	{"csv-quote", "string", csvQuoteModes, "Which fields of text/csv output are quoted (like --csv-quote)", func(opts *options, value string) error {
+		opts.csvQuote = value
+		return nil
+	}},
+	{"decimal-separator", "string", nil, "Decimal separator of numbers in text/csv and text/markdown output (like --decimal-separator)", func(opts *options, value string) error {
+		if !validDecimalSeparator(value) {
+			return fmt.Errorf("expected one character, such as ,")
+		}
+		opts.decimalSeparator = value
+		return nil
+	}},
+	{"newline", "string", newlineModes, "Line ending of text/csv and text/markdown output (like --newline)", func(opts *options, value string) error {
+		opts.newline = value
+		return nil
+	}},
+	{"empty-as", "string", emptyModes, "What an empty request body converts to (like --empty-as)", func(opts *options, value string) error {
+		opts.emptyAs = value
+		return nil
+	}},
+	{"dup-keys", "string", []string{"reject", "keepfirst", "keeplast"}, "Duplicate key handling for BONJSON input (like -d)", func(opts *options, value string) error {
+		opts.dupKeyMode = value
+		return nil
+	}},
+	{"nan-inf", "string", []string{"reject", "allow", "stringify"}, "NaN and Infinity handling for BONJSON (like -f)", func(opts *options, value string) error {
+		opts.nanInfMode = value
+		return nil
+	}},
+	{"utf8", "string", []string{"reject", "replace", "delete", "ignore"}, "Invalid UTF-8 handling for BONJSON input (like -u)", func(opts *options, value string) error {
+		opts.utf8Mode = value
+		return nil
+	}},
+	{"allow-nul", "boolean", nil, "Allow NUL characters in strings of BONJSON input (like -n)", func(opts *options, value string) error {
+		return parseBoolParameter(value, &opts.allowNUL)
+	}},
+	{"encode-nul", "boolean", nil, "Allow NUL characters in strings of BONJSON output (like --encode-nul)", func(opts *options, value string) error {
+		return parseBoolParameter(value, &opts.encodeNUL)
+	}},
+	{"encode-max-depth", "integer", nil, "Fail on BONJSON output nested more than this deep, 0 for no limit (like --encode-max-depth)", func(opts *options, value string) error {
+		limit, err := parseEncoderLimit(value)
+		if err != nil {
+			return fmt.Errorf("expected a non-negative number")
+		}
+		opts.encodeMaxDepth = limit
+		return nil
+	}},
+	{"encode-max-big-magnitude", "integer", nil, "Fail on BONJSON output with a big number magnitude of more bytes, 0 for no limit (like --encode-max-big-magnitude)", func(opts *options, value string) error {
+		limit, err := parseEncoderLimit(value)
+		if err != nil {
+			return fmt.Errorf("expected a non-negative number")
+		}
+		opts.encodeMaxBigNum = limit
+		return nil
+	}},
+	{"encode-max-big-exponent", "integer", nil, "Fail on BONJSON output with a big number exponent beyond this, 0 for no limit (like --encode-max-big-exponent)", func(opts *options, value string) error {
+		limit, err := parseEncoderLimit(value)
+		if err != nil {
+			return fmt.Errorf("expected a non-negative number")
+		}
+		opts.encodeMaxBigExp = limit
+		return nil
+	}},
+	{"strict-json", "boolean", nil, "Reject JSON input that is not strictly RFC 8259 (like --strict-json)", func(opts *options, value string) error {
+		return parseBoolParameter(value, &opts.strictJSON)
+	}},
+	{"warnings-as-errors", "boolean", nil, "Reject JSON input that decoding would change (like --warnings-as-errors)", func(opts *options, value string) error {
+		return parseBoolParameter(value, &opts.warningsAsErrors)
+	}},
+}
+
+// parseBoolParameter parses the value of a boolean parameter into b.
+func parseBoolParameter(value string, b *bool) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("expected true or false")
+	}
+	*b = parsed
+	return nil
+}
+
+// applyServeParameters applies the options a request sets to opts, and
+// returns the error code and error of the first invalid one. Query
+// parameters take precedence over headers. A parameter that the server's
+// --allow-params excludes is rejected, not ignored.
+func applyServeParameters(r *http.Request, opts *options) (string, error) {
+	settings := make(map[string]string)
+	for header, values := range r.Header {
+		if name, ok := strings.CutPrefix(header, "Bonbon-Option-"); ok {
+			settings[strings.ToLower(name)] = values[len(values)-1]
+		}
+	}
+	for name, values := range r.URL.Query() {
+		settings[name] = values[len(values)-1]
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		value := settings[name]
+		i := slices.IndexFunc(serveParameters, func(p serveParameter) bool { return p.name == name })
+		if i < 0 {
+			return "unknown_parameter", fmt.Errorf("unknown parameter: %s", name)
+		}
+		if opts.serveAllowParams != nil && !slices.Contains(opts.serveAllowParams, name) {
+			return "parameter_not_allowed", fmt.Errorf("this server does not let requests set %s", name)
+		}
+		p := serveParameters[i]
+		if p.values != nil && !slices.Contains(p.values, value) {
+			return "bad_parameter", fmt.Errorf("invalid %s value: %s (expected %s)", name, value, strings.Join(p.values, ", "))
+		}
+		if err := p.apply(opts, value); err != nil {
+			return "bad_parameter", fmt.Errorf("invalid %s value: %s (%v)", name, value, err)
+		}
+	}
+	return "", nil
+}
+
+// serveError is an error code of the protocol and its HTTP status.
+type serveError struct {
+	code        string
+	status      int
+	description string
+}
+
+var serveErrors = []serveError{
+	{"bad_parameter", http.StatusBadRequest, "A request option has an invalid value"},
+	{"unknown_parameter", http.StatusBadRequest, "A request option is not supported by this server"},
+	{"bad_request", http.StatusBadRequest, "The request body could not be read"},
+	{"invalid_input", http.StatusBadRequest, "The body is not valid in its declared format"},
+	{"unauthorized", http.StatusUnauthorized, "The request has no valid bearer token or signature"},
+	{"parameter_not_allowed", http.StatusForbidden, "The server does not let requests set this parameter"},
+	{"not_found", http.StatusNotFound, "No such endpoint"},
+	{"not_acceptable", http.StatusNotAcceptable, "Accept names an unsupported format"},
+	{"too_large", http.StatusRequestEntityTooLarge, "The body exceeds the size limit"},
+	{"unsupported_media_type", http.StatusUnsupportedMediaType, "Content-Type names an unsupported format"},
+	{"conversion_failed", http.StatusUnprocessableEntity, "The documents could not be converted"},
+}
+
+// maxRequestBody limits the size of a request body.
+const maxRequestBody = 256 << 20
+
+// defaultDrainTimeout is how long a stopping server waits for in-flight
+// requests without --drain-timeout.
+const defaultDrainTimeout = 30 * time.Second
+
+// runServe serves conversions at addr, a host:port or unix:PATH, until the
+// server fails or receives SIGTERM or SIGINT, when it drains in-flight
+// requests and returns nil. SIGHUP reloads file-backed settings. Requests are
+// handled concurrently, each with its own copy of opts.
+func runServe(addr string, opts *options) error {
+	if opts.writesFiles() || opts.windowed() || opts.outputFormat != "" {
+		return fmt.Errorf("serve cannot be combined with options that write files, read input windows, or select --to")
+	}
+	if option := opts.bufferUnsupported(); option != "" {
+		return fmt.Errorf("serve cannot be combined with %s", option)
+	}
+	if opts.expectSHA256 != "" {
+		return fmt.Errorf("serve cannot be combined with --expect-sha256")
+	}
+	network := "tcp"
+	if socketPath, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", socketPath
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "serving protocol %d on %s\n", serveProtocol, listener.Addr())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/version", func(w http.ResponseWriter, r *http.Request) {
+		writeServeJSON(w, http.StatusOK, map[string]any{"protocol": serveProtocol, "supported": []int{serveProtocol}})
+	})
+	mux.HandleFunc("GET /v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeServeJSON(w, http.StatusOK, openAPISpec())
+	})
+	var current atomic.Pointer[options]
+	current.Store(opts)
+	mux.HandleFunc("POST /v1/convert", func(w http.ResponseWriter, r *http.Request) {
+		serveConvert(w, r, current.Load())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeServeError(w, "not_found", fmt.Sprintf("no such endpoint: %s %s", r.Method, r.URL.Path))
+	})
+	var inFlight atomic.Int64
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		mux.ServeHTTP(w, r)
+	})}
+
+	stopping, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	for waiting := true; waiting; {
+		select {
+		case err := <-served:
+			return fmt.Errorf("serving: %w", err)
+		case <-reload:
+			reloadServeOptions(&current)
+		case <-stopping.Done():
+			waiting = false
+		}
+	}
+	// Restore the default handling, so a second signal stops at once.
+	stop()
+	return drainServer(server, &inFlight, opts.drainTimeout)
+}
+
+// reloadServeOptions reads the files behind file-backed settings again, on
+// SIGHUP. Requests already in flight finish with the settings they started
+// with. If any file fails to load, the previous settings stay in effect.
+func reloadServeOptions(current *atomic.Pointer[options]) {
+	reloaded, err := current.Load().reloadFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reload failed, keeping the previous settings: %v\n", err)
+		return
+	}
+	current.Store(reloaded)
+	fmt.Fprintln(os.Stderr, "reloaded settings")
+}
+
+// reloadFiles returns a copy of opts with the settings loaded from files
+// (--defaults, --key-file, --auth-token-file, --auth-hmac-key-file,
+// --schema-types, --rename-file, --redact-rules, and the config file) read
+// again.
+func (opts *options) reloadFiles() (*options, error) {
+	reloaded := *opts
+	if err := reloaded.reloadConfig(); err != nil {
+		return nil, err
+	}
+	var err error
+	if opts.defaultsFile != "" {
+		if reloaded.defaults, err = loadDocument(opts.defaultsFile); err != nil {
+			return nil, fmt.Errorf("loading defaults: %w", err)
+		}
+	}
+	if opts.anonymizeKeyFile != "" {
+		if reloaded.anonymizeKey, err = loadHMACKey(opts.anonymizeKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if opts.authTokenFile != "" {
+		if reloaded.authTokens, err = loadAuthTokens(opts.authTokenFile); err != nil {
+			return nil, err
+		}
+	}
+	if opts.authHMACKeyFile != "" {
+		if reloaded.authHMACKey, err = loadHMACKey(opts.authHMACKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if opts.schemaTypesFile != "" {
+		if reloaded.schemaTypes, err = loadTypeSchema(opts.schemaTypesFile); err != nil {
+			return nil, fmt.Errorf("loading schema: %w", err)
+		}
+	}
+	if opts.redactRulesFile != "" {
+		if reloaded.redactRules, err = loadRedactRules(opts.redactRulesFile); err != nil {
+			return nil, err
+		}
+	}
+	// Renames apply in the order given, so those from --rename-file are
+	// read again in place among those from --rename.
+	if slices.ContainsFunc(opts.optionArgs, func(option []string) bool { return option[0] == "--rename-file" }) {
+		reloaded.renames = nil
+		for _, option := range opts.optionArgs {
+			switch option[0] {
+			case "--rename":
+				r, err := parseRename(option[1])
+				if err != nil {
+					return nil, err
+				}
+				reloaded.renames = append(reloaded.renames, r)
+			case "--rename-file":
+				renames, err := loadRenameFile(option[1])
+				if err != nil {
+					return nil, err
+				}
+				reloaded.renames = append(reloaded.renames, renames...)
+			}
+		}
+	}
+	return &reloaded, nil
+}
+
+// drainServer shuts the server down gracefully: it stops accepting
+// connections (removing a Unix socket), lets in-flight requests finish for
+// up to timeout, and then closes whatever is left.
+func drainServer(server *http.Server, inFlight *atomic.Int64, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = defaultDrainTimeout
+	}
+	fmt.Fprintf(os.Stderr, "shutting down: draining %d in-flight requests (up to %v)\n", inFlight.Load(), timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		abandoned := inFlight.Load()
+		server.Close()
+		return fmt.Errorf("shutting down: %d requests still in flight after %v", abandoned, timeout)
+	}
+	fmt.Fprintln(os.Stderr, "shut down cleanly")
+	return nil
+}
+
+// serveConvert handles a version 1 convert request.
+func serveConvert(w http.ResponseWriter, r *http.Request, serverOpts *options) {
+	opts := *serverOpts
+	bearer := bearerAuthorized(r, opts.authTokens)
+	if opts.authRequired() && !bearer && opts.authHMACKey == nil {
+		writeUnauthorized(w, &opts, "missing or invalid bearer token")
+		return
+	}
+	if code, err := applyServeParameters(r, &opts); err != nil {
+		writeServeError(w, code, err.Error())
+		return
+	}
+
+	input := formatForMediaType(r.Header.Get("Content-Type"))
+	if input == nil || !input.Input {
+		writeServeError(w, "unsupported_media_type",
+			fmt.Sprintf("Content-Type must be %s or %s", mediaJSON, mediaBONJSON))
+		return
+	}
+	inputJSON := input.Name == "json"
+	outputJSON := !inputJSON
+	if accept := r.Header.Get("Accept"); accept != "" && accept != "*/*" {
+		output := formatForMediaType(accept)
+		if output == nil || !output.Output {
+			writeServeError(w, "not_acceptable",
+				fmt.Sprintf("Accept must be %s, %s, %s, or %s", mediaJSON, mediaBONJSON, mediaMarkdown, mediaCSV))
+			return
+		}
+		outputJSON = output.Name != "bonjson"
+		if output.Name != "json" && output.Name != "bonjson" {
+			opts.outputFormat = output.Name
+		}
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeServeError(w, "too_large", fmt.Sprintf("request body exceeds %d bytes", maxRequestBody))
+		} else {
+			writeServeError(w, "bad_request", fmt.Sprintf("reading request body: %v", err))
+		}
+		return
+	}
+	if opts.authRequired() && !bearer {
+		if err := checkSignature(r, data, opts.authHMACKey, time.Now()); err != nil {
+			writeUnauthorized(w, &opts, err.Error())
+			return
+		}
+	}
+	if input.Name == "bontext" {
+		if data, err = parseBontext(data); err != nil {
+			writeServeError(w, "invalid_input", fmt.Sprintf("invalid bonjson-text: %v", err))
+			return
+		}
+	}
+	docs, err := decodeBuffer(data, inputJSON, &opts)
+	if err != nil {
+		writeServeError(w, "invalid_input", err.Error())
+		return
+	}
+	output, err := encodeBuffer(docs, outputJSON, &opts)
+	if err != nil {
+		writeServeError(w, "conversion_failed", err.Error())
+		return
+	}
+
+	w.Header().Set("Bonbon-Protocol", strconv.Itoa(serveProtocol))
+	w.Header().Set("Content-Type", outputFormat(outputJSON, &opts).MediaType)
+	w.Write(output)
+}
+
+// writeUnauthorized rejects a request that failed authentication.
+func writeUnauthorized(w http.ResponseWriter, opts *options, message string) {
+	if opts.authTokens != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeServeError(w, "unauthorized", message)
+}
+
+// writeServeJSON writes a JSON response.
+func writeServeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Bonbon-Protocol", strconv.Itoa(serveProtocol))
+	w.Header().Set("Content-Type", mediaJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeServeError writes a protocol error response, with the status of its
+// code in serveErrors.
+func writeServeError(w http.ResponseWriter, code, message string) {
+	i := slices.IndexFunc(serveErrors, func(e serveError) bool { return e.code == code })
+	writeServeJSON(w, serveErrors[i].status, map[string]any{"error": map[string]string{"code": code, "message": message}})
+}
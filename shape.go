@@ -0,0 +1,145 @@
+// ABOUTME: Structural profile of a document stream for stats --shape.
+// ABOUTME: Tracks key presence, observed types, and estimated distinct values per path.
+
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// shapeNode aggregates every value found at one path, with array indices
+// collapsed to [*] so that all elements of an array share a node.
+type shapeNode struct {
+	path     string
+	parent   *shapeNode
+	isMember bool // reached through an object key
+	keys     map[string]*shapeNode
+	elements *shapeNode
+	count    int64
+	kinds    [numValueKinds]int64
+	distinct hyperLogLog
+}
+
+// shapeProfile is the structural profile of a document stream.
+type shapeProfile struct {
+	root  *shapeNode
+	nodes []*shapeNode // in order of first appearance
+	seed  maphash.Seed
+}
+
+func newShapeProfile() *shapeProfile {
+	root := &shapeNode{path: "$"}
+	return &shapeProfile{root: root, nodes: []*shapeNode{root}, seed: maphash.MakeSeed()}
+}
+
+// add records a scanned value. Scalars must have been captured, so their
+// distinct values can be counted.
+func (sp *shapeProfile) add(v scannedValue) {
+	if v.kind == kindKey || v.kind == kindRecordDef {
+		return
+	}
+	node := sp.root
+	for _, seg := range v.path {
+		node = sp.child(node, seg)
+	}
+	node.count++
+	node.kinds[v.kind]++
+	if v.raw != nil {
+		node.distinct.add(maphash.Bytes(sp.seed, v.raw))
+	}
+}
+
+// child returns the node for seg below node, creating it on first use.
+func (sp *shapeProfile) child(node *shapeNode, seg pathSegment) *shapeNode {
+	if seg.isIndex {
+		if node.elements == nil {
+			node.elements = &shapeNode{path: node.path + "[*]", parent: node}
+			sp.nodes = append(sp.nodes, node.elements)
+		}
+		return node.elements
+	}
+	child, ok := node.keys[seg.key]
+	if !ok {
+		if node.keys == nil {
+			node.keys = make(map[string]*shapeNode)
+		}
+		// path{seg}.String() is "$" followed by the segment.
+		child = &shapeNode{path: node.path + path{seg}.String()[1:], parent: node, isMember: true}
+		node.keys[seg.key] = child
+		sp.nodes = append(sp.nodes, child)
+	}
+	return child
+}
+
+// print writes the profile as a table: how often each path occurs, the share
+// of its parent objects that contain it, the types seen there, and an
+// estimate of its number of distinct scalar values.
+func (sp *shapeProfile) print(w io.Writer, documents int64) {
+	fmt.Fprintf(w, "%-32s %10s %8s  %-28s %10s\n", "path", "count", "present", "types", "distinct")
+	for _, node := range sp.nodes {
+		present := "-"
+		switch {
+		case node == sp.root:
+			present = fmt.Sprintf("%.1f%%", percentOf(node.count, documents))
+		case node.isMember:
+			present = fmt.Sprintf("%.1f%%", percentOf(node.count, node.parent.kinds[kindObject]))
+		}
+
+		var types []string
+		for kind, count := range node.kinds {
+			if count > 0 {
+				types = append(types, fmt.Sprintf("%s:%d", valueKind(kind), count))
+			}
+		}
+
+		distinct := "-"
+		if node.distinct.registers != nil {
+			distinct = fmt.Sprintf("~%d", node.distinct.estimate())
+		}
+		fmt.Fprintf(w, "%-32s %10d %8s  %-28s %10s\n", node.path, node.count, present, strings.Join(types, " "), distinct)
+	}
+}
+
+// hyperLogLogPrecision is the number of hash bits used to pick a register.
+// 2^10 registers give a typical error of about 3%.
+const hyperLogLogPrecision = 10
+
+// hyperLogLog estimates the number of distinct hashes added to it, in a
+// fixed 1 KiB of memory.
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func (h *hyperLogLog) add(hash uint64) {
+	if h.registers == nil {
+		h.registers = make([]uint8, 1<<hyperLogLogPrecision)
+	}
+	index := hash >> (64 - hyperLogLogPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hyperLogLogPrecision|1<<(hyperLogLogPrecision-1))) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() int64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities.
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
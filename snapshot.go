@@ -0,0 +1,151 @@
+// ABOUTME: The snapshot store (--snapshots) and the history command, an audit trail of how a file's content evolved.
+// ABOUTME: Each conversion that changes a file's documents keeps a timestamped canonical BONJSON copy of them.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshotLayout names snapshot files: sortable, and free of characters that
+// some file systems refuse.
+const snapshotLayout = "20060102T150405.000000000Z"
+
+// snapshot is one stored snapshot of a file.
+type snapshot struct {
+	time     time.Time
+	filename string
+}
+
+// snapshotDir returns the directory in opts.snapshotDir holding the
+// snapshots of the file inputPath: its base name, made unique by a digest
+// of its absolute path.
+func snapshotDir(inputPath string, opts *options) (string, error) {
+	if inputPath == "-" {
+		return "", fmt.Errorf("--snapshots requires an input file")
+	}
+	abs, err := filepath.Abs(inputPath)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(abs))
+	return filepath.Join(opts.snapshotDir, fmt.Sprintf("%s-%x", filepath.Base(abs), digest[:4])), nil
+}
+
+// listSnapshots returns the snapshots of inputPath, oldest first.
+func listSnapshots(inputPath string, opts *options) ([]snapshot, error) {
+	dir, err := snapshotDir(inputPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshots: %w", err)
+	}
+	var snapshots []snapshot
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		t, err := time.Parse(snapshotLayout, strings.TrimSuffix(entry.Name(), ".boj"))
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{time: t, filename: filepath.Join(dir, entry.Name())})
+	}
+	slices.SortFunc(snapshots, func(a, b snapshot) int { return a.time.Compare(b.time) })
+	return snapshots, nil
+}
+
+// takeSnapshot stores docs, the converted documents of inputPath, as a
+// snapshot in canonical BONJSON (keys sorted, as the encoder writes them),
+// unless they are the same as in the latest snapshot.
+func takeSnapshot(inputPath string, docs []any, opts *options) error {
+	encoded, err := encodeDocuments(docs, false, opts)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	data := bytes.Join(encoded, nil)
+	snapshots, err := listSnapshots(inputPath, opts)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > 0 {
+		if latest, err := os.ReadFile(snapshots[len(snapshots)-1].filename); err == nil && bytes.Equal(latest, data) {
+			return nil
+		}
+	}
+	dir, err := snapshotDir(inputPath, opts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	filename := filepath.Join(dir, time.Now().UTC().Format(snapshotLayout)+".boj")
+	if err := writeFileAtomic(filename, data); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
+
+// runHistory implements the history command. With --at, it writes the
+// documents of the snapshot of inputPath in effect at that time, the
+// latest taken at or before it, to outputPath: as BONJSON if it is named
+// *.boj or *.bonjson, and as JSON otherwise. Without --at, it lists the
+// snapshots.
+func runHistory(inputPath, outputPath string, opts *options) error {
+	snapshots, err := listSnapshots(inputPath, opts)
+	if err != nil {
+		return err
+	}
+	if opts.historyAt == nil {
+		for _, s := range snapshots {
+			info, err := os.Stat(s.filename)
+			if err != nil {
+				return fmt.Errorf("reading snapshots: %w", err)
+			}
+			fmt.Printf("%-30s %10d\n", s.time.Format(time.RFC3339Nano), info.Size())
+		}
+		return nil
+	}
+
+	i := len(snapshots) - 1
+	for i >= 0 && snapshots[i].time.After(*opts.historyAt) {
+		i--
+	}
+	if i < 0 {
+		return fmt.Errorf("no snapshot of %s at or before %s", inputPath, opts.historyAt.Format(time.RFC3339Nano))
+	}
+	data, err := os.ReadFile(snapshots[i].filename)
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	if isBONJSONPath(outputPath) {
+		return writeOutput(data, outputPath, false)
+	}
+	streamed := *opts
+	streamed.stream = true
+	docs, _, err := decodeBONJSON(data, &streamed)
+	if err != nil {
+		return fmt.Errorf("snapshot %s: invalid BONJSON: %w", snapshots[i].filename, err)
+	}
+	if len(docs) != 1 {
+		opts = &streamed
+	}
+	encoded, err := encodeDocuments(docs, true, opts)
+	if err != nil {
+		return err
+	}
+	return writeOutput(bytes.Join(encoded, nil), outputPath, true)
+}
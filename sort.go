@@ -0,0 +1,328 @@
+// ABOUTME: The sort command: orders a document stream by the value at a path, with an external merge sort.
+// ABOUTME: Sorted runs that outgrow --run-size are spilled to temporary files, so streams larger than memory can be sorted.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// defaultRunSize is how many bytes of encoded documents sort collects in
+// memory before spilling them as a sorted run.
+const defaultRunSize = 64 << 20
+
+// sortEntry is a document being sorted: its sort key and its BONJSON
+// encoding.
+type sortEntry struct {
+	key any
+	doc []byte
+}
+
+// runSort sorts the documents of the stream at inputPath, JSON or BONJSON by
+// file extension (stdin is JSON), by the value at opts.sortBy, and writes
+// them to opts.outFile, as BONJSON if it is *.boj or *.bonjson and as JSON
+// otherwise, or to stdout as JSON if it is not set. The sort is stable.
+//
+// Documents are collected until their encodings reach opts.sortRunSize,
+// then sorted and spilled to a temporary run file; the runs are merged into
+// the output in one pass. A stream that fits in one run is never spilled.
+func runSort(inputPath string, opts *options) error {
+	var in io.Reader = os.Stdin
+	if inputPath != "-" {
+		f, err := os.Open(inputPath)
+		if err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	progress.begin(inputPath)
+	runSize := opts.sortRunSize
+	if runSize <= 0 {
+		runSize = defaultRunSize
+	}
+
+	var runs []*os.File
+	defer func() {
+		for _, f := range runs {
+			tempFiles.discard(f)
+		}
+	}()
+	var entries []sortEntry
+	var entriesSize int64
+	var sortErr error
+	decodeErr := decodeStream(bufio.NewReader(progressReader{in}), !isBONJSONPath(inputPath), opts, func(seq int, doc any) bool {
+		key, _ := lookupPath(doc, opts.sortBy)
+		encoded, err := encodeDocument(doc, false, opts)
+		if err != nil {
+			sortErr = fmt.Errorf("document %d: %w", seq, err)
+			return false
+		}
+		entries = append(entries, sortEntry{key: key, doc: encoded})
+		entriesSize += int64(len(encoded))
+		if entriesSize >= runSize {
+			run, err := spillSortRun(entries, opts)
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			runs = append(runs, run)
+			entries, entriesSize = nil, 0
+		}
+		return true
+	})
+	if sortErr != nil {
+		return sortErr
+	}
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	outPath := opts.outFile
+	if outPath == "" {
+		outPath = "-"
+	}
+	outputJSON := !isBONJSONPath(outPath)
+	out := &lazyOutput{path: outPath}
+	defer out.Close()
+	streamed := *opts
+	streamed.stream = true
+	write := func(e sortEntry) error {
+		data := e.doc
+		if outputJSON {
+			doc, err := decodeSortValue(e.doc, opts)
+			if err == nil {
+				data, err = encodeDocument(doc, true, &streamed)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := out.Write(data); err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+		progress.documents.Add(1)
+		return nil
+	}
+
+	if len(runs) == 0 {
+		slices.SortStableFunc(entries, func(a, b sortEntry) int { return compareSortKeys(a.key, b.key) })
+		for _, e := range entries {
+			if err := write(e); err != nil {
+				return err
+			}
+		}
+		return out.Close()
+	}
+	if len(entries) > 0 {
+		run, err := spillSortRun(entries, opts)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+		entries = nil
+	}
+	if err := mergeSortRuns(runs, opts, write); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// spillSortRun sorts entries and writes them to a temporary run file, each
+// as the lengths of its key and document as uvarints followed by the BONJSON
+// encodings of the key and the document. The file is left open at its start.
+func spillSortRun(entries []sortEntry, opts *options) (*os.File, error) {
+	slices.SortStableFunc(entries, func(a, b sortEntry) int { return compareSortKeys(a.key, b.key) })
+	f, err := tempFiles.create(filepath.Join(os.TempDir(), "bonbon-sort-run"), 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("creating sort run: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		key, err := encodeDocument(e.key, false, opts)
+		if err == nil {
+			w.Write(binary.AppendUvarint(nil, uint64(len(key))))
+			w.Write(binary.AppendUvarint(nil, uint64(len(e.doc))))
+			w.Write(key)
+			_, err = w.Write(e.doc)
+		}
+		if err != nil {
+			tempFiles.discard(f)
+			return nil, fmt.Errorf("writing sort run: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tempFiles.discard(f)
+		return nil, fmt.Errorf("writing sort run: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		tempFiles.discard(f)
+		return nil, fmt.Errorf("reading sort run: %w", err)
+	}
+	return f, nil
+}
+
+// sortRun is a run file being merged, and its next entry.
+type sortRun struct {
+	r     *bufio.Reader
+	index int
+	opts  *options
+	next  sortEntry
+}
+
+// read loads the next entry of the run, returning io.EOF at its end.
+func (run *sortRun) read() error {
+	keySize, err := binary.ReadUvarint(run.r)
+	if err != nil {
+		return err
+	}
+	docSize, err := binary.ReadUvarint(run.r)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, keySize+docSize)
+	if _, err := io.ReadFull(run.r, data); err != nil {
+		return err
+	}
+	key, err := decodeSortValue(data[:keySize], run.opts)
+	if err != nil {
+		return err
+	}
+	run.next = sortEntry{key: key, doc: data[keySize:]}
+	return nil
+}
+
+// decodeSortValue decodes a key or document that sort encoded, with opts'
+// decoding options, so that what opts let the input hold survives.
+func decodeSortValue(data []byte, opts *options) (any, error) {
+	var value any
+	if err := newBONJSONDecoder(bytes.NewReader(data), opts).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// sortRunHeap orders runs by their next entries, and equal entries by run,
+// so that the merge keeps the sort stable.
+type sortRunHeap []*sortRun
+
+func (h sortRunHeap) Len() int { return len(h) }
+func (h sortRunHeap) Less(i, j int) bool {
+	if c := compareSortKeys(h[i].next.key, h[j].next.key); c != 0 {
+		return c < 0
+	}
+	return h[i].index < h[j].index
+}
+func (h sortRunHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *sortRunHeap) Push(x any)   { *h = append(*h, x.(*sortRun)) }
+func (h *sortRunHeap) Pop() any {
+	last := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return last
+}
+
+// mergeSortRuns merges the sorted runs, passing each entry to write in order.
+func mergeSortRuns(runs []*os.File, opts *options, write func(sortEntry) error) error {
+	h := make(sortRunHeap, 0, len(runs))
+	for i, f := range runs {
+		run := &sortRun{r: bufio.NewReader(f), index: i, opts: opts}
+		if err := run.read(); err != nil {
+			return fmt.Errorf("reading sort run: %w", err)
+		}
+		h = append(h, run)
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		run := h[0]
+		if err := write(run.next); err != nil {
+			return err
+		}
+		switch err := run.read(); {
+		case errors.Is(err, io.EOF):
+			heap.Pop(&h)
+		case err != nil:
+			return fmt.Errorf("reading sort run: %w", err)
+		default:
+			heap.Fix(&h, 0)
+		}
+	}
+	return nil
+}
+
+// compareSortKeys orders sort keys: missing values and null first, then
+// false and true, then numbers by value, then strings by their bytes (so
+// ISO 8601 timestamps sort by time), then arrays and objects by their JSON
+// encodings.
+func compareSortKeys(a, b any) int {
+	if c := cmp.Compare(sortKeyRank(a), sortKeyRank(b)); c != 0 {
+		return c
+	}
+	switch a := a.(type) {
+	case bool:
+		if a == b.(bool) {
+			return 0
+		}
+		if a {
+			return 1
+		}
+		return -1
+	case string:
+		return cmp.Compare(a, b.(string))
+	case map[string]any, []any:
+		encodedA, _ := json.Marshal(a)
+		encodedB, _ := json.Marshal(b)
+		return bytes.Compare(encodedA, encodedB)
+	case nil:
+		return 0
+	}
+	return compareNumbers(a, b)
+}
+
+// sortKeyRank is the position of a sort key's type in the sort order.
+func sortKeyRank(v any) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return 3
+	case map[string]any, []any:
+		return 4
+	}
+	return 2
+}
+
+// compareNumbers compares two decoded numbers exactly, so that integers
+// beyond float64's precision still sort correctly. NaN sorts first.
+func compareNumbers(a, b any) int {
+	exactA, _, okA := exactNumber(a)
+	exactB, _, okB := exactNumber(b)
+	if okA && okB {
+		return exactA.Cmp(exactB)
+	}
+	float := func(n any, exact *big.Rat) float64 {
+		switch n := n.(type) {
+		case float64:
+			return n
+		case *big.Float:
+			f, _ := n.Float64()
+			return f
+		}
+		f, _ := exact.Float64()
+		return f
+	}
+	return cmp.Compare(float(a, exactA), float(b, exactB))
+}
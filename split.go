@@ -0,0 +1,99 @@
+// ABOUTME: Splitting of converted output into numbered shard files.
+// ABOUTME: Shards are bounded by byte size and/or document count.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// writeShards encodes the documents and writes them to numbered shard files
+// derived from outputPath, starting a new shard whenever the next document
+// would exceed opts.splitSize bytes or opts.splitDocs documents. A document
+// larger than opts.splitSize gets a shard of its own.
+func writeShards(docs []any, outputPath string, outputJSON bool, opts *options) error {
+	if outputPath == "-" {
+		return fmt.Errorf("split output requires an output file, not stdout")
+	}
+	if opts.outputFormat != "" {
+		return fmt.Errorf("split output requires JSON or BONJSON output")
+	}
+	encoded, err := encodeDocuments(docs, outputJSON, opts)
+	if err != nil {
+		return err
+	}
+
+	var shard []byte
+	shardDocs := 0
+	shardIndex := 0
+	flush := func() error {
+		if shardDocs == 0 {
+			return nil
+		}
+		if err := writeOutput(shard, shardPath(outputPath, shardIndex), outputJSON); err != nil {
+			return err
+		}
+		shard = shard[:0]
+		shardDocs = 0
+		shardIndex++
+		return nil
+	}
+	for _, doc := range encoded {
+		full := opts.splitDocs > 0 && shardDocs >= opts.splitDocs
+		tooBig := opts.splitSize > 0 && int64(len(shard)+len(doc)) > opts.splitSize
+		if full || tooBig {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		shard = append(shard, doc...)
+		shardDocs++
+	}
+	return flush()
+}
+
+// shardPath returns the name of shard number index for outputPath, inserting
+// the zero-padded index before the extension: out.boj becomes out-00000.boj.
+// A path from --out-template has the index put in place of its {shard}.
+func shardPath(outputPath string, index int) string {
+	if strings.Contains(outputPath, shardVariable) {
+		return strings.ReplaceAll(outputPath, shardVariable, fmt.Sprintf("%05d", index))
+	}
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s-%05d%s", strings.TrimSuffix(outputPath, ext), index, ext)
+}
+
+// parseSize parses a byte count with an optional unit suffix: K/KB, M/MB, and
+// G/GB are powers of 1000; KiB, MiB, and GiB are powers of 1024. A size that
+// does not fit an int64 is refused.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9},
+		{"B", 1},
+	}
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(unit.suffix)) {
+			s = s[:len(s)-len(unit.suffix)]
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/multiplier || n < math.MinInt64/multiplier {
+		return 0, strconv.ErrRange
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,211 @@
+// ABOUTME: The stats command: reports what a BONJSON input is made of in a single streaming pass.
+// ABOUTME: Counts values and encoded bytes per type and per nesting depth without decoding them.
+
+package main
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// statsCounter accumulates a value count and byte total.
+type statsCounter struct {
+	count int64
+	bytes int64
+}
+
+// documentStats holds the statistics gathered over every scanned document.
+// Byte totals per type and per depth count each value's own bytes (its
+// nested values are counted separately), so each set adds up to the input
+// size.
+type documentStats struct {
+	documents int64
+	bytes     int64
+	maxDepth  int
+	byKind    [numValueKinds]statsCounter
+	byDepth   []statsCounter
+	top       largestValues
+	shape     *shapeProfile
+}
+
+func (st *documentStats) add(v scannedValue) {
+	st.top.add(v, st.documents)
+	if st.shape != nil {
+		st.shape.add(v)
+	}
+	st.byKind[v.kind].count++
+	st.byKind[v.kind].bytes += v.own
+	for len(st.byDepth) <= v.depth {
+		st.byDepth = append(st.byDepth, statsCounter{})
+	}
+	st.byDepth[v.depth].count++
+	st.byDepth[v.depth].bytes += v.own
+	if v.depth > st.maxDepth {
+		st.maxDepth = v.depth
+	}
+}
+
+// runStats scans the BONJSON input at inputPath and prints its statistics,
+// followed by the opts.statsTop largest values if set and the structural
+// profile if opts.statsShape is set. Values are never
+// decoded, so inputs far larger than memory can be examined.
+func runStats(inputPath string, opts *options) error {
+	if opts.windowed() {
+		return fmt.Errorf("stats does not support --length or multiple -s windows")
+	}
+	var r io.Reader
+	if inputPath == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(inputPath)
+		if err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	if opts.skipBytes > 0 {
+		n, err := io.CopyN(io.Discard, r, int64(opts.skipBytes))
+		if err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("skip value %d exceeds input size %d", opts.skipBytes, n)
+			}
+			return fmt.Errorf("reading input: %w", err)
+		}
+	}
+
+	st := documentStats{top: largestValues{limit: opts.statsTop}}
+	scanner := newWireScanner(r, int64(opts.skipBytes), st.add)
+	if opts.statsShape {
+		st.shape = newShapeProfile()
+		scanner.captureScalars = true
+	}
+	for {
+		start := scanner.offset
+		err := scanner.scanDocument()
+		if err == io.EOF {
+			if st.documents == 0 {
+				return fmt.Errorf("input is empty")
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid BONJSON: document %d: %w", st.documents, err)
+		}
+		st.documents++
+		st.bytes += scanner.offset - start
+		if !opts.stream {
+			if !scanner.atEOF() && !opts.allowTrailing {
+				return fmt.Errorf("invalid BONJSON: trailing data at offset %d", scanner.offset)
+			}
+			break
+		}
+	}
+
+	printStats(os.Stdout, &st)
+	return nil
+}
+
+// printStats writes the statistics as a plain-text report.
+func printStats(w io.Writer, st *documentStats) {
+	fmt.Fprintf(w, "documents: %d\n", st.documents)
+	fmt.Fprintf(w, "bytes:     %d\n", st.bytes)
+	fmt.Fprintf(w, "max depth: %d\n", st.maxDepth)
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-12s %12s %14s %7s\n", "type", "count", "bytes", "bytes%")
+	for kind, c := range st.byKind {
+		if c.count > 0 {
+			fmt.Fprintf(w, "%-12s %12d %14d %6.1f%%\n", valueKind(kind), c.count, c.bytes, percentOf(c.bytes, st.bytes))
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-12s %12s %14s %7s\n", "depth", "count", "bytes", "bytes%")
+	for depth, c := range st.byDepth {
+		fmt.Fprintf(w, "%-12d %12d %14d %6.1f%%\n", depth, c.count, c.bytes, percentOf(c.bytes, st.bytes))
+	}
+
+	if st.top.limit > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "%14s %7s  %-11s %8s  %s\n", "bytes", "bytes%", "type", "document", "path")
+		for _, v := range st.top.sorted() {
+			fmt.Fprintf(w, "%14d %6.1f%%  %-11s %8d  %s\n", v.size, percentOf(v.size, st.bytes), v.kind, v.document, v.path)
+		}
+	}
+
+	if st.shape != nil {
+		fmt.Fprintln(w)
+		st.shape.print(w, st.documents)
+	}
+}
+
+// largeValue is an entry in the largest values report.
+type largeValue struct {
+	kind     valueKind
+	size     int64
+	document int64
+	path     string
+}
+
+// largestValues keeps the limit largest strings, arrays, and objects seen, by
+// full encoded size. It is a min-heap, so the smallest kept value is the one
+// replaced by a larger one.
+type largestValues struct {
+	limit  int
+	values []largeValue
+}
+
+func (lv *largestValues) Len() int           { return len(lv.values) }
+func (lv *largestValues) Less(i, j int) bool { return lv.values[i].size < lv.values[j].size }
+func (lv *largestValues) Swap(i, j int)      { lv.values[i], lv.values[j] = lv.values[j], lv.values[i] }
+func (lv *largestValues) Push(x any)         { lv.values = append(lv.values, x.(largeValue)) }
+func (lv *largestValues) Pop() any {
+	last := lv.values[len(lv.values)-1]
+	lv.values = lv.values[:len(lv.values)-1]
+	return last
+}
+
+// add considers v for the report. Only the values that make it in have their
+// path formatted.
+func (lv *largestValues) add(v scannedValue, document int64) {
+	switch v.kind {
+	case kindString, kindArray, kindTypedArray, kindObject:
+	default:
+		return
+	}
+	if lv.limit <= 0 || (len(lv.values) == lv.limit && v.size <= lv.values[0].size) {
+		return
+	}
+	entry := largeValue{kind: v.kind, size: v.size, document: document, path: v.path.String()}
+	if len(lv.values) < lv.limit {
+		heap.Push(lv, entry)
+		return
+	}
+	lv.values[0] = entry
+	heap.Fix(lv, 0)
+}
+
+// sorted returns the kept values, largest first, then in input order.
+func (lv *largestValues) sorted() []largeValue {
+	values := slices.Clone(lv.values)
+	slices.SortFunc(values, func(a, b largeValue) int {
+		if c := cmp.Compare(b.size, a.size); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.document, b.document)
+	})
+	return values
+}
+
+// percentOf returns part as a percentage of total, or 0 if total is 0.
+func percentOf(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
@@ -0,0 +1,316 @@
+// ABOUTME: Strict RFC 8259 validation of JSON input for --strict-json.
+// ABOUTME: Rejects what encoding/json silently tolerates: duplicate keys, bad UTF-8, lone surrogates.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxStrictJSONDepth matches encoding/json's nesting limit.
+const maxStrictJSONDepth = 10000
+
+// maxExactInteger is the largest integer magnitude a float64, and so a
+// decoded JSON number, represents exactly.
+const maxExactInteger = 1 << 53
+
+// strictJSONScanner checks JSON text against RFC 8259 without building
+// values. Beyond the grammar, it rejects input that encoding/json would
+// accept but silently change: duplicate object keys (the last one wins),
+// invalid UTF-8 and unpaired surrogate escapes (both become U+FFFD), and
+// integers too large to survive conversion to float64.
+type strictJSONScanner struct {
+	data []byte
+	pos  int
+}
+
+// validateStrictJSON checks that data is exactly one JSON value, or a
+// whitespace-separated sequence of values in stream mode, meeting RFC 8259.
+func validateStrictJSON(data []byte, stream bool) error {
+	s := &strictJSONScanner{data: data}
+	s.skipWhitespace()
+	if s.pos == len(data) {
+		return fmt.Errorf("strict JSON: input contains no value")
+	}
+	for s.pos < len(data) {
+		if err := s.value(0); err != nil {
+			return err
+		}
+		s.skipWhitespace()
+		if !stream && s.pos < len(data) {
+			return s.errorf("unexpected data after top-level value")
+		}
+	}
+	return nil
+}
+
+func (s *strictJSONScanner) errorf(format string, args ...any) error {
+	return fmt.Errorf("strict JSON: offset %d: %s", s.pos, fmt.Sprintf(format, args...))
+}
+
+func (s *strictJSONScanner) skipWhitespace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *strictJSONScanner) value(depth int) error {
+	if depth > maxStrictJSONDepth {
+		return s.errorf("exceeded maximum nesting depth of %d", maxStrictJSONDepth)
+	}
+	if s.pos == len(s.data) {
+		return s.errorf("unexpected end of input")
+	}
+	switch c := s.data[s.pos]; {
+	case c == '{':
+		return s.object(depth)
+	case c == '[':
+		return s.array(depth)
+	case c == '"':
+		_, err := s.string()
+		return err
+	case c == '-' || (c >= '0' && c <= '9'):
+		return s.number()
+	default:
+		for _, literal := range []string{"true", "false", "null"} {
+			if len(s.data)-s.pos >= len(literal) && string(s.data[s.pos:s.pos+len(literal)]) == literal {
+				s.pos += len(literal)
+				return nil
+			}
+		}
+		return s.errorf("invalid character %q looking for a value", c)
+	}
+}
+
+func (s *strictJSONScanner) object(depth int) error {
+	s.pos++ // '{'
+	keys := make(map[string]struct{})
+	s.skipWhitespace()
+	if s.pos < len(s.data) && s.data[s.pos] == '}' {
+		s.pos++
+		return nil
+	}
+	for {
+		if s.pos == len(s.data) || s.data[s.pos] != '"' {
+			return s.errorf("expected string object key")
+		}
+		keyStart := s.pos
+		key, err := s.string()
+		if err != nil {
+			return err
+		}
+		if _, dup := keys[key]; dup {
+			s.pos = keyStart
+			return s.errorf("duplicate object key %q", key)
+		}
+		keys[key] = struct{}{}
+		s.skipWhitespace()
+		if s.pos == len(s.data) || s.data[s.pos] != ':' {
+			return s.errorf("expected ':' after object key")
+		}
+		s.pos++
+		s.skipWhitespace()
+		if err := s.value(depth + 1); err != nil {
+			return err
+		}
+		s.skipWhitespace()
+		if s.pos == len(s.data) {
+			return s.errorf("unexpected end of input in object")
+		}
+		switch s.data[s.pos] {
+		case ',':
+			s.pos++
+			s.skipWhitespace()
+		case '}':
+			s.pos++
+			return nil
+		default:
+			return s.errorf("expected ',' or '}' in object")
+		}
+	}
+}
+
+func (s *strictJSONScanner) array(depth int) error {
+	s.pos++ // '['
+	s.skipWhitespace()
+	if s.pos < len(s.data) && s.data[s.pos] == ']' {
+		s.pos++
+		return nil
+	}
+	for {
+		if err := s.value(depth + 1); err != nil {
+			return err
+		}
+		s.skipWhitespace()
+		if s.pos == len(s.data) {
+			return s.errorf("unexpected end of input in array")
+		}
+		switch s.data[s.pos] {
+		case ',':
+			s.pos++
+			s.skipWhitespace()
+		case ']':
+			s.pos++
+			return nil
+		default:
+			return s.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// string scans a string and returns its decoded contents.
+func (s *strictJSONScanner) string() (string, error) {
+	s.pos++ // '"'
+	var decoded []byte
+	for {
+		if s.pos == len(s.data) {
+			return "", s.errorf("unexpected end of input in string")
+		}
+		c := s.data[s.pos]
+		switch {
+		case c == '"':
+			s.pos++
+			return string(decoded), nil
+		case c < 0x20:
+			return "", s.errorf("unescaped control character 0x%02x in string", c)
+		case c == '\\':
+			r, err := s.escape()
+			if err != nil {
+				return "", err
+			}
+			decoded = utf8.AppendRune(decoded, r)
+		case c < utf8.RuneSelf:
+			decoded = append(decoded, c)
+			s.pos++
+		default:
+			r, size := utf8.DecodeRune(s.data[s.pos:])
+			if r == utf8.RuneError && size <= 1 {
+				return "", s.errorf("invalid UTF-8 in string")
+			}
+			decoded = append(decoded, s.data[s.pos:s.pos+size]...)
+			s.pos += size
+		}
+	}
+}
+
+// escape scans a backslash escape, including both halves of an escaped
+// surrogate pair.
+func (s *strictJSONScanner) escape() (rune, error) {
+	if s.pos+1 >= len(s.data) {
+		return 0, s.errorf("unexpected end of input in escape")
+	}
+	c := s.data[s.pos+1]
+	s.pos += 2
+	switch c {
+	case '"', '\\', '/':
+		return rune(c), nil
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'u':
+		r, err := s.hex4()
+		if err != nil {
+			return 0, err
+		}
+		if !utf16.IsSurrogate(r) {
+			return r, nil
+		}
+		if r >= 0xDC00 {
+			return 0, s.errorf("unpaired low surrogate \\u%04X", r)
+		}
+		if s.pos+1 >= len(s.data) || s.data[s.pos] != '\\' || s.data[s.pos+1] != 'u' {
+			return 0, s.errorf("unpaired high surrogate \\u%04X", r)
+		}
+		s.pos += 2
+		low, err := s.hex4()
+		if err != nil {
+			return 0, err
+		}
+		combined := utf16.DecodeRune(r, low)
+		if combined == utf8.RuneError {
+			return 0, s.errorf("unpaired high surrogate \\u%04X", r)
+		}
+		return combined, nil
+	default:
+		s.pos--
+		return 0, s.errorf("invalid escape '\\%c' in string", c)
+	}
+}
+
+func (s *strictJSONScanner) hex4() (rune, error) {
+	if s.pos+4 > len(s.data) {
+		return 0, s.errorf("unexpected end of input in \\u escape")
+	}
+	n, err := strconv.ParseUint(string(s.data[s.pos:s.pos+4]), 16, 16)
+	if err != nil {
+		return 0, s.errorf("invalid \\u escape")
+	}
+	s.pos += 4
+	return rune(n), nil
+}
+
+func (s *strictJSONScanner) number() error {
+	start := s.pos
+	if s.data[s.pos] == '-' {
+		s.pos++
+	}
+	switch {
+	case s.pos < len(s.data) && s.data[s.pos] == '0':
+		s.pos++
+	case s.pos < len(s.data) && s.data[s.pos] >= '1' && s.data[s.pos] <= '9':
+		s.digits()
+	default:
+		return s.errorf("invalid number")
+	}
+	integer := true
+	if s.pos < len(s.data) && s.data[s.pos] == '.' {
+		integer = false
+		s.pos++
+		if s.digits() == 0 {
+			return s.errorf("expected digit after decimal point")
+		}
+	}
+	if s.pos < len(s.data) && (s.data[s.pos] == 'e' || s.data[s.pos] == 'E') {
+		integer = false
+		s.pos++
+		if s.pos < len(s.data) && (s.data[s.pos] == '+' || s.data[s.pos] == '-') {
+			s.pos++
+		}
+		if s.digits() == 0 {
+			return s.errorf("expected digit in exponent")
+		}
+	}
+	if integer {
+		literal := string(s.data[start:s.pos])
+		n, err := strconv.ParseInt(literal, 10, 64)
+		if err != nil || n > maxExactInteger || n < -maxExactInteger {
+			s.pos = start
+			return s.errorf("integer %s cannot be represented exactly (limit is ±2^53)", literal)
+		}
+	}
+	return nil
+}
+
+// digits scans a run of decimal digits and returns how many there were.
+func (s *strictJSONScanner) digits() int {
+	start := s.pos
+	for s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+		s.pos++
+	}
+	return s.pos - start
+}
@@ -0,0 +1,66 @@
+// ABOUTME: The --summary line: one machine-parseable line on stderr after each conversion.
+// ABOUTME: Formats, bytes in and out, ratio, duration, warnings, and status, for pipeline logs.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// warnf prints a warning about the current conversion to stderr, counting
+// it for --summary.
+func warnf(format string, args ...any) {
+	progress.warnings.Add(1)
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
+}
+
+// printSummary writes the --summary line of the conversion of inputPath,
+// which took elapsed and ended with err, to w as space-separated key=value
+// pairs. Values with spaces, quotes, or equals signs are quoted.
+func printSummary(w io.Writer, inputPath, outputPath string, inputJSON, outputJSON bool, elapsed time.Duration, err error, opts *options) {
+	inputFormat := opts.inputFormat
+	if inputFormat == "" {
+		inputFormat = "bonjson"
+		if inputJSON {
+			inputFormat = "json"
+		}
+	}
+	outputName := "none"
+	if outputPath != "" {
+		outputName = outputFormat(outputJSON, opts).Name
+	}
+	in, out := progress.offset.Load(), progress.written.Load()
+	ratio := 0.0
+	if in > 0 {
+		ratio = float64(out) / float64(in)
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	fields := []string{
+		"input=" + summaryValue(inputPath),
+		"input_format=" + inputFormat,
+		"output_format=" + outputName,
+		fmt.Sprintf("bytes_in=%d", in),
+		fmt.Sprintf("bytes_out=%d", out),
+		fmt.Sprintf("ratio=%.3f", ratio),
+		fmt.Sprintf("duration_ms=%.3f", float64(elapsed.Microseconds())/1000),
+		fmt.Sprintf("warnings=%d", progress.warnings.Load()),
+		"status=" + status,
+	}
+	fmt.Fprintf(w, "summary: %s\n", strings.Join(fields, " "))
+}
+
+// summaryValue quotes s for the summary line if it needs it.
+func summaryValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
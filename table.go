@@ -0,0 +1,282 @@
+// ABOUTME: Table and CSV rendering for tabular data.
+// ABOUTME: Rows are array elements, or documents in stream mode; columns are paths into each row. Delimiter,
+// ABOUTME: quoting, decimal separator, and line endings can follow regional conventions.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// csvQuoteModes are the values of --csv-quote: "minimal", the default,
+// quotes only the fields that need it, and "all" quotes every field.
+var csvQuoteModes = []string{"minimal", "all"}
+
+// newlineModes are the values of --newline, the line ending of table and CSV
+// output.
+var newlineModes = []string{"lf", "crlf"}
+
+// column is a single output column: a header label and the path of the value
+// to extract from each row.
+type column struct {
+	label string
+	path  path
+}
+
+// renderTable renders the rows of docs as a markdown table. Missing values
+// produce empty cells, and nested values are rendered as compact JSON.
+func renderTable(docs []any, opts *options) ([]byte, error) {
+	rows, columns, err := tabulate(docs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	widths := make([]int, len(columns))
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = escapeTableCell(col.label)
+		widths[i] = max(utf8.RuneCountInString(header[i]), 3)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			row[i] = escapeTableCell(cell)
+			widths[i] = max(widths[i], utf8.RuneCountInString(row[i]))
+		}
+	}
+
+	newline := lineEnding(opts)
+	var sb strings.Builder
+	writeTableLine(&sb, header, widths, newline)
+	separator := make([]string, len(columns))
+	for i, w := range widths {
+		separator[i] = strings.Repeat("-", w)
+	}
+	writeTableLine(&sb, separator, widths, newline)
+	for _, row := range rows {
+		writeTableLine(&sb, row, widths, newline)
+	}
+	return []byte(sb.String()), nil
+}
+
+// renderCSV renders the rows of docs as CSV with a header line, separating
+// fields with opts.csvDelimiter (a comma by default) and quoting them as
+// opts.csvQuote says.
+func renderCSV(docs []any, opts *options) ([]byte, error) {
+	rows, columns, err := tabulate(docs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	delimiter := opts.csvDelimiter
+	if delimiter == 0 {
+		delimiter = ','
+	}
+	newline := lineEnding(opts)
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.label
+	}
+	var buf bytes.Buffer
+	for _, record := range append([][]string{header}, rows...) {
+		for i, field := range record {
+			if i > 0 {
+				buf.WriteRune(delimiter)
+			}
+			if opts.csvQuote != "all" && !csvFieldNeedsQuotes(field, delimiter) {
+				buf.WriteString(field)
+				continue
+			}
+			buf.WriteByte('"')
+			buf.WriteString(strings.ReplaceAll(field, `"`, `""`))
+			buf.WriteByte('"')
+		}
+		buf.WriteString(newline)
+	}
+	return buf.Bytes(), nil
+}
+
+// csvFieldNeedsQuotes reports whether field must be quoted to read back as
+// itself: when it holds the delimiter, a quote, or a line break, or starts
+// with a space, which some readers trim. Like encoding/csv, it also quotes
+// \., which ends the data in PostgreSQL's COPY.
+func csvFieldNeedsQuotes(field string, delimiter rune) bool {
+	if field == "" {
+		return false
+	}
+	return field == `\.` || strings.ContainsRune(field, delimiter) || strings.ContainsAny(field, "\"\r\n") ||
+		field[0] == ' ' || field[0] == '\t'
+}
+
+// parseCSVDelimiter parses the value of --csv-delimiter: a single character
+// other than a quote or line break, or "tab" (or \t) for a tab.
+func parseCSVDelimiter(s string) (rune, error) {
+	if s == "tab" || s == `\t` {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter: %q (expected one character other than a quote or line break, or tab)", s)
+	}
+	return r, nil
+}
+
+// validDecimalSeparator reports whether s can stand for the decimal point
+// of numbers: a single character that is not part of a number otherwise.
+func validDecimalSeparator(s string) bool {
+	return utf8.RuneCountInString(s) == 1 && !strings.ContainsAny(s, "0123456789-+eE")
+}
+
+// lineEnding returns the line ending --newline selects.
+func lineEnding(opts *options) string {
+	if opts.newline == "crlf" {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// tabulate extracts the cell text of every row and column. In stream mode
+// each document is a row; otherwise the document must be an array whose
+// elements are the rows. If no columns were requested, the columns are the
+// sorted union of the keys of all rows, which must then be objects. With
+// opts.nullsAsAbsent, null values leave their cell empty just like missing
+// ones. With opts.decimalSeparator, numbers are written with it in place of
+// the decimal point.
+func tabulate(docs []any, opts *options) ([][]string, []column, error) {
+	var rows []any
+	if opts.stream {
+		rows = docs
+	} else if len(docs) > 0 {
+		array, ok := docs[0].([]any)
+		if !ok {
+			return nil, nil, fmt.Errorf("tabular output requires an array of rows (or --stream)")
+		}
+		rows = array
+	}
+
+	columns, err := parseColumns(opts.columns)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(columns) == 0 {
+		if columns, err = defaultColumns(rows); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(columns) == 0 {
+		return nil, nil, fmt.Errorf("no columns to render")
+	}
+
+	cells := make([][]string, len(rows))
+	nullCount := 0
+	for r, row := range rows {
+		cells[r] = make([]string, len(columns))
+		for i, col := range columns {
+			v, ok := lookupPath(row, col.path)
+			if !ok {
+				continue
+			}
+			if v == nil && opts.nullsAsAbsent {
+				nullCount++
+				continue
+			}
+			if cells[r][i], err = cellText(v); err != nil {
+				return nil, nil, fmt.Errorf("row %d, column %q: %w", r, col.label, err)
+			}
+			if opts.decimalSeparator != "" && isNumber(v) {
+				cells[r][i] = strings.Replace(cells[r][i], ".", opts.decimalSeparator, 1)
+			}
+		}
+	}
+	if opts.nullsAsAbsent {
+		fmt.Fprintf(os.Stderr, "treated %d null values as absent\n", nullCount)
+	}
+	return cells, columns, nil
+}
+
+// parseColumns parses column specifications. A specification is either a
+// plain top-level key or a path such as $.a.b; the header label omits the
+// leading "$.".
+func parseColumns(specs []string) ([]column, error) {
+	columns := make([]column, len(specs))
+	for i, spec := range specs {
+		p, err := parsePath(spec)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = column{label: strings.TrimPrefix(spec, "$."), path: p}
+	}
+	return columns, nil
+}
+
+// defaultColumns returns a column for each key found in any of the rows,
+// sorted by key.
+func defaultColumns(rows []any) ([]column, error) {
+	seen := make(map[string]bool)
+	var keys []string
+	for i, row := range rows {
+		obj, ok := row.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("row %d is not an object (use --columns to select values)", i)
+		}
+		for key := range obj {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	columns := make([]column, len(keys))
+	for i, key := range keys {
+		columns[i] = column{label: key, path: path{{key: key}}}
+	}
+	return columns, nil
+}
+
+// cellText renders a single value for a cell. Strings are shown without
+// quotes; everything else is rendered as compact JSON.
+func cellText(v any) (string, error) {
+	if str, ok := v.(string); ok {
+		return str, nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// isNumber reports whether v is a decoded number.
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int64, uint64, float64, json.Number, *big.Int, *big.Float:
+		return true
+	}
+	return false
+}
+
+// escapeTableCell escapes characters that would break the table layout.
+func escapeTableCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	s = strings.ReplaceAll(s, "\r", "\\r")
+	return strings.ReplaceAll(s, "\n", "\\n")
+}
+
+// writeTableLine writes one padded table line, ended by newline.
+func writeTableLine(sb *strings.Builder, cells []string, widths []int, newline string) {
+	sb.WriteString("|")
+	for i, cell := range cells {
+		sb.WriteString(" ")
+		sb.WriteString(cell)
+		sb.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+		sb.WriteString(" |")
+	}
+	sb.WriteString(newline)
+}
@@ -0,0 +1,111 @@
+// ABOUTME: --target-profile: checks the output documents against the limits of a class of decoder, such as embedded ones.
+// ABOUTME: Every value beyond the profile is reported with its path, and the conversion fails before writing anything.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"math/big"
+	"slices"
+)
+
+// targetProfile is the bundle of limits of a class of decoder.
+type targetProfile struct {
+	name            string
+	maxDepth        int  // of nested containers
+	maxStringLength int  // in bytes, of strings and object keys
+	integersOnly    bool // numbers must be integers of at most 64 bits
+	finiteOnly      bool // no NaN or infinity
+}
+
+// targetProfiles are the profiles of --target-profile. Embedded decoders
+// typically parse with a fixed stack and fixed buffers, and have no floating
+// point or big number support.
+var targetProfiles = map[string]*targetProfile{
+	"embedded": {name: "embedded", maxDepth: 16, maxStringLength: 255, integersOnly: true, finiteOnly: true},
+}
+
+// targetViolation is a value that the target profile's decoders cannot
+// represent.
+type targetViolation struct {
+	document int
+	path     string
+	detail   string
+}
+
+// checkTargetProfile checks docs against profile, printing each value
+// beyond it to w. It fails if there are any.
+func checkTargetProfile(w io.Writer, docs []any, profile *targetProfile) error {
+	var violations []targetViolation
+	for i, doc := range docs {
+		profile.check(doc, 0, nil, func(p path, format string, args ...any) {
+			violations = append(violations, targetViolation{document: i, path: p.String(), detail: fmt.Sprintf(format, args...)})
+		})
+	}
+	for _, v := range violations {
+		fmt.Fprintf(w, "document %d: %s: %s\n", v.document, v.path, v.detail)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d values cannot be represented within the %s target profile", len(violations), profile.name)
+	}
+	return nil
+}
+
+// check reports each value of v at path p, inside depth containers, that is
+// beyond the profile. A container nested too deeply is reported once, not
+// along with its members.
+func (t *targetProfile) check(v any, depth int, p path, report func(p path, format string, args ...any)) {
+	switch v := v.(type) {
+	case map[string]any:
+		if depth == t.maxDepth {
+			report(p, "containers nested more than %d deep", t.maxDepth)
+			return
+		}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			member := append(p, pathSegment{key: key})
+			if len(key) > t.maxStringLength {
+				report(member, "key of %d bytes is longer than %d", len(key), t.maxStringLength)
+			}
+			t.check(v[key], depth+1, member, report)
+		}
+	case []any:
+		if depth == t.maxDepth {
+			report(p, "containers nested more than %d deep", t.maxDepth)
+			return
+		}
+		for i, element := range v {
+			t.check(element, depth+1, append(p, pathSegment{index: i, isIndex: true}), report)
+		}
+	case string:
+		if len(v) > t.maxStringLength {
+			report(p, "string of %d bytes is longer than %d", len(v), t.maxStringLength)
+		}
+	case float64:
+		switch {
+		case math.IsNaN(v) || math.IsInf(v, 0):
+			if t.finiteOnly {
+				report(p, "non-finite float %v", v)
+			}
+		case t.integersOnly && (v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxUint64):
+			report(p, "number %v is not an integer of at most 64 bits", v)
+		}
+	case *big.Int:
+		if t.integersOnly && !v.IsInt64() && !v.IsUint64() {
+			report(p, "integer %s does not fit in 64 bits", v)
+		}
+	case *big.Float:
+		switch {
+		case v.IsInf():
+			if t.finiteOnly {
+				report(p, "non-finite float %s", v.Text('g', -1))
+			}
+		case t.integersOnly:
+			if i, _ := v.Int(nil); !v.IsInt() || !(i.IsInt64() || i.IsUint64()) {
+				report(p, "big number %s is not an integer of at most 64 bits", v.Text('g', -1))
+			}
+		}
+	}
+}
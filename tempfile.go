@@ -0,0 +1,217 @@
+// ABOUTME: Crash-safe output files: each regular file is written under a temporary name and renamed into place.
+// ABOUTME: Temporary files left by a failure, interrupt, or panic are removed unless --keep-temp is given.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// tempRegistry tracks the temporary files not yet renamed into place, so that
+// they can be removed however the process ends. Panics in goroutines other
+// than main's end the process without running its deferred cleanup, and
+// SIGKILL cannot be caught; in those cases temporary files named
+// .NAME.*.tmp are left next to their targets.
+type tempRegistry struct {
+	mu      sync.Mutex
+	files   map[string]bool
+	keep    bool
+	handler sync.Once
+}
+
+// tempFiles is the process's registry. main sets keep from --keep-temp.
+var tempFiles tempRegistry
+
+// create creates a temporary file in the directory of filename, with
+// permissions perm less the umask, to be renamed over it by commit. The
+// first call installs a handler that removes the temporary files on SIGINT
+// or SIGTERM and exits.
+func (r *tempRegistry) create(filename string, perm fs.FileMode) (*os.File, error) {
+	r.handler.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			r.cleanup()
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		}()
+	})
+
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	var f *os.File
+	for {
+		var err error
+		name := filepath.Join(dir, fmt.Sprintf(".%s.%d.tmp", base, rand.Uint32()))
+		f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+	}
+	r.mu.Lock()
+	if r.files == nil {
+		r.files = make(map[string]bool)
+	}
+	r.files[f.Name()] = true
+	r.mu.Unlock()
+	return f, nil
+}
+
+// commit closes the temporary file f and renames it to filename, replacing
+// any file there, so that readers see either the old or the new contents.
+func (r *tempRegistry) commit(f *os.File, filename string) error {
+	err := f.Close()
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		r.discard(f)
+		return err
+	}
+	r.mu.Lock()
+	delete(r.files, f.Name())
+	r.mu.Unlock()
+	return nil
+}
+
+// discard closes and removes the temporary file f, or reports it to stderr
+// if temporary files are kept.
+func (r *tempRegistry) discard(f *os.File) {
+	f.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(f.Name())
+}
+
+// cleanup removes every temporary file not yet committed.
+func (r *tempRegistry) cleanup() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range r.files {
+		r.remove(name)
+	}
+}
+
+// cleanupOnPanic removes the temporary files if the deferring function is
+// panicking, and then carries on panicking.
+func (r *tempRegistry) cleanupOnPanic() {
+	if p := recover(); p != nil {
+		r.cleanup()
+		panic(p)
+	}
+}
+
+// remove removes the temporary file name, or keeps it and reports it. The
+// caller holds r.mu.
+func (r *tempRegistry) remove(name string) {
+	delete(r.files, name)
+	if r.keep {
+		fmt.Fprintf(os.Stderr, "kept temporary file %s\n", name)
+		return
+	}
+	os.Remove(name)
+}
+
+// outputFile is an output file being written: a temporary file to be
+// renamed over target, or, where that would not do, target itself.
+type outputFile struct {
+	*os.File
+	target  string
+	inPlace bool
+}
+
+// createOutput opens filename to write output to. A regular file, or a new
+// one, is written under a temporary name in its directory, created with the
+// mode of the file it replaces, or 0666 less the umask for a new one. Anything
+// else (a symlink, a FIFO, a device such as /dev/null), and a file in a
+// directory where no temporary file can be created, is truncated and written
+// in place, as os.Create would.
+func createOutput(filename string) (*outputFile, error) {
+	perm := fs.FileMode(0o666)
+	info, err := os.Lstat(filename)
+	if err == nil && !info.Mode().IsRegular() {
+		return createInPlace(filename)
+	}
+	if err == nil {
+		perm = info.Mode().Perm()
+	}
+	f, err := tempFiles.create(filename, perm)
+	if errors.Is(err, fs.ErrPermission) {
+		return createInPlace(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The umask applied at creation; an existing file's mode is kept whole.
+	if info != nil {
+		if err := f.Chmod(perm); err != nil {
+			tempFiles.discard(f)
+			return nil, err
+		}
+	}
+	return &outputFile{File: f, target: filename}, nil
+}
+
+func createInPlace(filename string) (*outputFile, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	return &outputFile{File: f, target: filename, inPlace: true}, nil
+}
+
+// commit closes the file, renaming it into place if it is a temporary one.
+func (o *outputFile) commit() error {
+	if o.inPlace {
+		return o.Close()
+	}
+	return tempFiles.commit(o.File, o.target)
+}
+
+// discard closes the file, removing it if it is a temporary one. Output
+// written in place stays.
+func (o *outputFile) discard() {
+	if o.inPlace {
+		o.Close()
+		return
+	}
+	tempFiles.discard(o.File)
+}
+
+// writeFileAtomic writes data to filename through a temporary file, so that
+// a failed or interrupted write leaves any existing file untouched and no
+// partial file behind. A /dev/fd/N path is written to the descriptor
+// directly, and other files that are not regular ones in place, as
+// createOutput says.
+func writeFileAtomic(filename string, data []byte) error {
+	if f, ok := openDescriptor(filename); ok {
+		_, err := f.Write(data)
+		return err
+	}
+	f, err := createOutput(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.discard()
+		return err
+	}
+	return f.commit()
+}
@@ -1,13 +1,17 @@
 #!/bin/bash
 # ABOUTME: Command-line integration tests for bonbon
 
-set -e
+# No set -e: many tests run commands that are expected to fail, and each
+# failure is counted rather than ending the run.
 
 PASS=0
 FAIL=0
 TMPDIR=$(mktemp -d)
 trap "rm -rf $TMPDIR" EXIT
 
+# Keep the tests independent of any config file of the user running them
+export BONBON_CONFIG="$TMPDIR/config"
+
 pass() {
     echo "PASS: $1"
     PASS=$((PASS + 1))
@@ -325,6 +329,1513 @@ else
     pass "-f: rejects invalid mode"
 fi
 
+# Test: --to table renders an array of objects as a table
+echo '[{"name":"Alice","age":30},{"name":"Bob","age":4}]' > "$TMPDIR/people.json"
+OUTPUT=$(./bonbon --to table j2j "$TMPDIR/people.json" -)
+if echo "$OUTPUT" | head -1 | grep -q '| age | name  |' && echo "$OUTPUT" | grep -q '| 30  | Alice |'; then
+    pass "--to table: renders array of objects"
+else
+    fail "--to table: renders array of objects (got: $OUTPUT)"
+fi
+
+# Test: --columns selects and orders table columns
+./bonbon j2b "$TMPDIR/people.json" "$TMPDIR/people.boj"
+OUTPUT=$(./bonbon --to table --columns name b2j "$TMPDIR/people.boj" -)
+if echo "$OUTPUT" | head -1 | grep -q '^| name  |$'; then
+    pass "--columns: selects table columns"
+else
+    fail "--columns: selects table columns (got: $OUTPUT)"
+fi
+
+# Test: --to table rejects non-tabular input
+if echo '{"a":1}' | ./bonbon --to table j2j - - 2>/dev/null; then
+    fail "--to table: rejects non-array input"
+else
+    pass "--to table: rejects non-array input"
+fi
+
+# Test: --to csv extracts paths from each document of a stream
+printf '{"a":1,"b":{"c":"x,y"}}\n{"a":2}\n' > "$TMPDIR/stream.json"
+OUTPUT=$(./bonbon --stream --to csv --columns '$.a,$.b.c' j2j "$TMPDIR/stream.json" -)
+EXPECTED=$(printf 'a,b.c\n1,"x,y"\n2,')
+if [ "$OUTPUT" = "$EXPECTED" ]; then
+    pass "--to csv: extracts columns from stream documents"
+else
+    fail "--to csv: extracts columns from stream documents (got: $OUTPUT)"
+fi
+
+# Test: --stream round-trips multiple documents through BONJSON
+./bonbon --stream j2b "$TMPDIR/stream.json" "$TMPDIR/stream.boj"
+COUNT=$(./bonbon --stream b2j "$TMPDIR/stream.boj" - | grep -c '"a"')
+if [ "$COUNT" = "2" ]; then
+    pass "--stream: converts every document"
+else
+    fail "--stream: converts every document (got $COUNT documents)"
+fi
+
+# Test: without --stream, concatenated BONJSON documents are trailing data
+if ./bonbon b "$TMPDIR/stream.boj" 2>/dev/null; then
+    fail "--stream: required for concatenated documents"
+else
+    pass "--stream: required for concatenated documents"
+fi
+
+# Test: --omit-nulls drops null-valued keys and reports the count
+OUTPUT=$(echo '{"a":null,"b":{"c":null,"d":1}}' | ./bonbon --omit-nulls j2j - - 2>"$TMPDIR/nulls.err")
+if ! echo "$OUTPUT" | grep -q '"a"' && ! echo "$OUTPUT" | grep -q '"c"' && grep -q 'omitted 2 ' "$TMPDIR/nulls.err"; then
+    pass "--omit-nulls: drops null-valued keys"
+else
+    fail "--omit-nulls: drops null-valued keys (got: $OUTPUT)"
+fi
+
+# Test: --nulls-as-absent renders nulls as empty cells
+OUTPUT=$(echo '[{"a":null,"b":1},{"b":2}]' | ./bonbon --nulls-as-absent --to csv j2j - - 2>/dev/null)
+EXPECTED=$(printf 'a,b\n,1\n,2')
+if [ "$OUTPUT" = "$EXPECTED" ]; then
+    pass "--nulls-as-absent: null cells are empty"
+else
+    fail "--nulls-as-absent: null cells are empty (got: $OUTPUT)"
+fi
+
+# Test: --rename renames keys at any depth
+OUTPUT=$(echo '{"name":1,"user":{"name":2}}' | ./bonbon --rename name=n j2j - -)
+if echo "$OUTPUT" | grep -q '"n": 1' && echo "$OUTPUT" | grep -q '"n": 2' && ! echo "$OUTPUT" | grep -q '"name"'; then
+    pass "--rename: renames keys everywhere"
+else
+    fail "--rename: renames keys everywhere (got: $OUTPUT)"
+fi
+
+# Test: --rename with a path only renames within that object
+OUTPUT=$(echo '{"name":1,"user":{"name":2}}' | ./bonbon --rename '$.user.name=fullName' j2j - -)
+if echo "$OUTPUT" | grep -q '"name": 1' && echo "$OUTPUT" | grep -q '"fullName": 2'; then
+    pass "--rename: path-scoped rename"
+else
+    fail "--rename: path-scoped rename (got: $OUTPUT)"
+fi
+
+# Test: --rename-file reads renames from a JSON map
+echo '{"a":"b","$.x.c":"d"}' > "$TMPDIR/renames.json"
+OUTPUT=$(echo '{"a":1,"x":{"c":2}}' | ./bonbon --rename-file "$TMPDIR/renames.json" j2j - -)
+if echo "$OUTPUT" | grep -q '"b": 1' && echo "$OUTPUT" | grep -q '"d": 2'; then
+    pass "--rename-file: applies renames from file"
+else
+    fail "--rename-file: applies renames from file (got: $OUTPUT)"
+fi
+
+# Test: --rename refuses to overwrite an existing key
+if echo '{"a":1,"b":2}' | ./bonbon --rename a=b j2j - - >/dev/null 2>&1; then
+    fail "--rename: rejects collisions"
+else
+    pass "--rename: rejects collisions"
+fi
+
+# Test: --defaults deep-merges a defaults document beneath the input
+echo '{"a":{"x":1,"y":2},"b":true}' > "$TMPDIR/defaults.json"
+./bonbon j2b "$TMPDIR/defaults.json" "$TMPDIR/defaults.boj"
+OUTPUT=$(echo '{"a":{"x":9}}' | ./bonbon --defaults "$TMPDIR/defaults.boj" j2j - -)
+if echo "$OUTPUT" | grep -q '"x": 9' && echo "$OUTPUT" | grep -q '"y": 2' && echo "$OUTPUT" | grep -q '"b": true'; then
+    pass "--defaults: fills in missing values"
+else
+    fail "--defaults: fills in missing values (got: $OUTPUT)"
+fi
+
+# Test: --defaults with --nulls-as-absent replaces null values
+OUTPUT=$(echo '{"b":null}' | ./bonbon --nulls-as-absent --defaults "$TMPDIR/defaults.json" j2j - -)
+if echo "$OUTPUT" | grep -q '"b": true'; then
+    pass "--defaults: nulls-as-absent uses default for null"
+else
+    fail "--defaults: nulls-as-absent uses default for null (got: $OUTPUT)"
+fi
+
+# Test: --expand-env substitutes environment variables in strings
+OUTPUT=$(echo '{"a":"${BONBON_TEST_VAR}/x","b":"$${KEEP}"}' | BONBON_TEST_VAR=hello ./bonbon --expand-env j2j - -)
+if echo "$OUTPUT" | grep -q '"a": "hello/x"' && echo "$OUTPUT" | grep -q '"b": "${KEEP}"'; then
+    pass "--expand-env: substitutes variables"
+else
+    fail "--expand-env: substitutes variables (got: $OUTPUT)"
+fi
+
+# Test: --strict-env fails on undefined variables
+if echo '{"a":"${BONBON_UNDEFINED_VAR}"}' | ./bonbon --strict-env j2j - - >/dev/null 2>&1; then
+    fail "--strict-env: rejects undefined variables"
+else
+    pass "--strict-env: rejects undefined variables"
+fi
+
+# Test: --resolve-refs inlines $include files and local $ref pointers
+mkdir -p "$TMPDIR/refs/sub"
+echo '{"x":{"$include":"sub/b.json"},"defs":{"p":[1,2]},"y":{"$ref":"#/defs/p"}}' > "$TMPDIR/refs/main.json"
+echo '{"deep":"leaf"}' > "$TMPDIR/refs/sub/b.json"
+OUTPUT=$(./bonbon --resolve-refs j2j "$TMPDIR/refs/main.json" - | tr -d ' \n')
+if echo "$OUTPUT" | grep -q '"x":{"deep":"leaf"}' && echo "$OUTPUT" | grep -q '"y":\[1,2\]'; then
+    pass "--resolve-refs: inlines includes and refs"
+else
+    fail "--resolve-refs: inlines includes and refs (got: $OUTPUT)"
+fi
+
+# Test: --resolve-refs detects reference cycles
+if echo '{"a":{"$ref":"#/a"}}' | ./bonbon --resolve-refs j2j - - >/dev/null 2>&1; then
+    fail "--resolve-refs: rejects cycles"
+else
+    pass "--resolve-refs: rejects cycles"
+fi
+
+# Test: --split-docs writes numbered shards
+for i in 1 2 3 4 5; do echo "{\"i\":$i}"; done > "$TMPDIR/five.json"
+./bonbon --stream --split-docs 2 j2b "$TMPDIR/five.json" "$TMPDIR/shard.boj"
+if [ -f "$TMPDIR/shard-00002.boj" ] && [ ! -f "$TMPDIR/shard-00003.boj" ] && ./bonbon --stream b2j "$TMPDIR/shard-00002.boj" - | grep -q '"i": 5'; then
+    pass "--split-docs: writes numbered shards"
+else
+    fail "--split-docs: writes numbered shards"
+fi
+
+# Test: --split-size bounds shard size
+./bonbon --stream --split-size 10B j2b "$TMPDIR/five.json" "$TMPDIR/sized.boj"
+SIZE=$(wc -c < "$TMPDIR/sized-00000.boj" | tr -d ' ')
+if [ "$SIZE" -le 10 ] && [ -f "$TMPDIR/sized-00002.boj" ]; then
+    pass "--split-size: bounds shard size"
+else
+    fail "--split-size: bounds shard size (first shard: $SIZE bytes)"
+fi
+
+# Test: directory input converts a tree and --manifest records it
+mkdir -p "$TMPDIR/tree/sub"
+echo '{"x":1}' > "$TMPDIR/tree/one.json"
+echo '[1,2]' > "$TMPDIR/tree/sub/two.json"
+echo 'not json' > "$TMPDIR/tree/notes.txt"
+./bonbon --manifest "$TMPDIR/manifest.json" j2b "$TMPDIR/tree" "$TMPDIR/tree.out"
+if [ -f "$TMPDIR/tree.out/one.boj" ] && [ -f "$TMPDIR/tree.out/sub/two.boj" ] && [ ! -e "$TMPDIR/tree.out/notes.boj" ]; then
+    pass "batch: converts directory tree"
+else
+    fail "batch: converts directory tree"
+fi
+if [ "$(grep -c '"status": "ok"' "$TMPDIR/manifest.json")" = "2" ] && grep -q '"input_sha256"' "$TMPDIR/manifest.json"; then
+    pass "--manifest: records each conversion"
+else
+    fail "--manifest: records each conversion"
+fi
+
+# Test: batch conversion reports failures but converts the rest
+echo 'bad' > "$TMPDIR/tree/bad.json"
+if ./bonbon j2b "$TMPDIR/tree" "$TMPDIR/tree.out2" 2>/dev/null; then
+    fail "batch: fails when a file fails"
+else
+    if [ -f "$TMPDIR/tree.out2/one.boj" ]; then
+        pass "batch: fails when a file fails"
+    else
+        fail "batch: fails when a file fails (other files not converted)"
+    fi
+fi
+rm "$TMPDIR/tree/bad.json"
+
+# Test: --incremental skips unchanged files and redoes changed ones
+./bonbon --incremental --manifest "$TMPDIR/manifest.json" j2b "$TMPDIR/tree" "$TMPDIR/tree.out"
+if [ "$(grep -c '"status": "unchanged"' "$TMPDIR/manifest.json")" = "2" ]; then
+    pass "--incremental: skips unchanged files"
+else
+    fail "--incremental: skips unchanged files"
+fi
+echo '{"x":2}' > "$TMPDIR/tree/one.json"
+./bonbon --incremental --manifest "$TMPDIR/manifest.json" j2b "$TMPDIR/tree" "$TMPDIR/tree.out"
+if [ "$(grep -c '"status": "unchanged"' "$TMPDIR/manifest.json")" = "1" ] && [ "$(./bonbon b2j "$TMPDIR/tree.out/one.boj" - | tr -d ' \n')" = '{"x":2}' ]; then
+    pass "--incremental: reconverts changed files"
+else
+    fail "--incremental: reconverts changed files"
+fi
+./bonbon --incremental --manifest "$TMPDIR/manifest.json" --omit-nulls j2b "$TMPDIR/tree" "$TMPDIR/tree.out" 2>/dev/null
+if ! grep -q '"status": "unchanged"' "$TMPDIR/manifest.json"; then
+    pass "--incremental: reconverts when options change"
+else
+    fail "--incremental: reconverts when options change"
+fi
+./bonbon --incremental --manifest "$TMPDIR/manifest-indent.json" --indent 8 j2j "$TMPDIR/tree" "$TMPDIR/tree.indent"
+./bonbon --incremental --manifest "$TMPDIR/manifest-indent.json" --indent 8 j2j "$TMPDIR/tree" "$TMPDIR/tree.indent"
+if [ "$(grep -c '"status": "unchanged"' "$TMPDIR/manifest-indent.json")" = "2" ]; then
+    pass "--incremental: skips unchanged files with pointer-valued options"
+else
+    fail "--incremental: skips unchanged files with pointer-valued options"
+fi
+if ./bonbon --incremental j2b "$TMPDIR/tree" "$TMPDIR/tree.out" 2>/dev/null; then
+    fail "--incremental: requires --manifest"
+else
+    pass "--incremental: requires --manifest"
+fi
+
+# Test: the --stream pipeline keeps input order across workers
+seq 1 2000 | awk '{print "{\"i\":"$1"}"}' > "$TMPDIR/many.json"
+./bonbon --stream --workers transform=4,encode=4 --queue-depth 3 j2b "$TMPDIR/many.json" "$TMPDIR/many.boj"
+if ./bonbon --stream --workers 3 b2j "$TMPDIR/many.boj" - | grep '"i"' | tr -dc '0-9\n' | diff -q - <(seq 1 2000) > /dev/null; then
+    pass "--workers: output keeps input order"
+else
+    fail "--workers: output keeps input order"
+fi
+
+# Test: --workers rejects unknown stages
+if ./bonbon --workers decode=2 j2j "$TMPDIR/many.json" - 2>/dev/null; then
+    fail "--workers: rejects unknown stage"
+else
+    pass "--workers: rejects unknown stage"
+fi
+
+# Test: repeated keys across BONJSON objects decode intact
+echo '[{"level":"info","ts":1},{"level":"warn","ts":2},{"ts":3,"level":"error"}]' > "$TMPDIR/logs.json"
+./bonbon j2b "$TMPDIR/logs.json" "$TMPDIR/logs.boj"
+if [ "$(./bonbon b2j "$TMPDIR/logs.boj" - | tr -d ' \n')" = '[{"level":"info","ts":1},{"level":"warn","ts":2},{"level":"error","ts":3}]' ]; then
+    pass "b2j: repeated keys decode intact"
+else
+    fail "b2j: repeated keys decode intact"
+fi
+
+# Test: profiling flags write their profiles
+./bonbon --cpu-profile "$TMPDIR/cpu.prof" --mem-profile "$TMPDIR/mem.prof" --trace-file "$TMPDIR/trace.out" j2b "$TMPDIR/logs.json" "$TMPDIR/profiled.boj"
+if [ -s "$TMPDIR/cpu.prof" ] && [ -s "$TMPDIR/mem.prof" ] && [ -s "$TMPDIR/trace.out" ]; then
+    pass "--cpu-profile/--mem-profile/--trace-file: write profiles"
+else
+    fail "--cpu-profile/--mem-profile/--trace-file: write profiles"
+fi
+
+# Test: options may follow the command
+if [ "$(./bonbon j2j "$TMPDIR/logs.json" - --omit-nulls 2>/dev/null | tr -d ' \n')" = '[{"level":"info","ts":1},{"level":"warn","ts":2},{"level":"error","ts":3}]' ]; then
+    pass "options: accepted after the command"
+else
+    fail "options: accepted after the command"
+fi
+
+# Test: bench saves a baseline and fails on regressions against it
+if ./bonbon bench "$TMPDIR/logs.json" --save-baseline "$TMPDIR/baseline.json" > /dev/null && grep -q '"bonjson-decode"' "$TMPDIR/baseline.json"; then
+    pass "bench: saves baseline"
+else
+    fail "bench: saves baseline"
+fi
+sed 's/"mb_per_sec": [0-9.e+]*/"mb_per_sec": 1e12/' "$TMPDIR/baseline.json" > "$TMPDIR/fast-baseline.json"
+if ./bonbon bench "$TMPDIR/logs.json" --baseline "$TMPDIR/fast-baseline.json" --fail-on-regress 10% > /dev/null 2>&1; then
+    fail "bench: --fail-on-regress detects regressions"
+else
+    pass "bench: --fail-on-regress detects regressions"
+fi
+
+# Test: stats accounts for every byte of the input
+echo '{"a":[1,2,3],"b":{"c":"hello"}}' | ./bonbon j2b - "$TMPDIR/stats.boj"
+OUTPUT=$(./bonbon stats "$TMPDIR/stats.boj")
+SIZE=$(wc -c < "$TMPDIR/stats.boj" | tr -d ' ')
+KIND_TOTAL=$(echo "$OUTPUT" | awk '/^type/{t=1;next} /^$/{t=0} t{s+=$3} END{print s}')
+if echo "$OUTPUT" | grep -q "^bytes: *$SIZE$" && [ "$KIND_TOTAL" = "$SIZE" ] && echo "$OUTPUT" | grep -q "^max depth: 2$"; then
+    pass "stats: per-type bytes add up to input size"
+else
+    fail "stats: per-type bytes add up to input size"
+fi
+
+# Test: stats counts documents in a stream
+if ./bonbon --stream stats "$TMPDIR/stream.boj" | grep -q "^documents: 2$"; then
+    pass "stats: counts stream documents"
+else
+    fail "stats: counts stream documents"
+fi
+
+# Test: stats --top lists the largest values with their paths
+echo '{"small":"ab","big":["xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"],"n":1}' | ./bonbon j2b - "$TMPDIR/top.boj"
+OUTPUT=$(./bonbon stats "$TMPDIR/top.boj" --top 2)
+if [ "$(echo "$OUTPUT" | tail -2 | awk '{print $NF}' | tr '\n' ' ')" = '$ $.big ' ]; then
+    pass "stats --top: lists largest values by path"
+else
+    fail "stats --top: lists largest values by path"
+fi
+
+# Test: stats --shape reports key presence and types across documents
+printf '{"id":1,"name":"a"}\n{"id":2}\n{"id":"x","name":"b"}\n{"id":4}\n' | ./bonbon --stream j2b - "$TMPDIR/shape.boj"
+OUTPUT=$(./bonbon --stream stats --shape "$TMPDIR/shape.boj")
+if echo "$OUTPUT" | grep -q '^\$\.name  *2  *50\.0%  string:2 ' && echo "$OUTPUT" | grep -q '^\$\.id  *4  *100\.0%  int:3 string:1 '; then
+    pass "stats --shape: key presence and types"
+else
+    fail "stats --shape: key presence and types"
+fi
+
+# Test: anonymize replaces fields with deterministic pseudonyms
+echo 'an-example-secret-key' > "$TMPDIR/anon.key"
+echo '{"user":{"email":"a@example.com","id":7},"orders":[{"email":"a@example.com"},{"email":"b@example.com"}]}' > "$TMPDIR/pii.json"
+./bonbon anonymize --field email --key-file "$TMPDIR/anon.key" "$TMPDIR/pii.json" "$TMPDIR/pii.boj" 2>/dev/null
+OUTPUT=$(./bonbon b2j "$TMPDIR/pii.boj" -)
+E1=$(echo "$OUTPUT" | grep -o '"email": "[^"]*"' | sed -n 1p)
+E3=$(echo "$OUTPUT" | grep -o '"email": "[^"]*"' | sed -n 3p)
+if ! echo "$OUTPUT" | grep -q 'example.com' && echo "$OUTPUT" | grep -q '"id": 7' && [ "$E1" != "$(echo "$OUTPUT" | grep -o '"email": "[^"]*"' | sed -n 2p)" ] && echo "$E3" | grep -q '"anon:'; then
+    pass "anonymize: replaces configured fields"
+else
+    fail "anonymize: replaces configured fields"
+fi
+# orders[0] and user share an email, so they must share a token
+if [ "$(echo "$OUTPUT" | grep -o '"email": "[^"]*"' | sort | uniq -d | wc -l | tr -d ' ')" = "1" ]; then
+    pass "anonymize: equal values get equal pseudonyms"
+else
+    fail "anonymize: equal values get equal pseudonyms"
+fi
+
+# Test: anonymize refuses to run without a key
+if ./bonbon anonymize --field email "$TMPDIR/pii.json" - 2>/dev/null; then
+    fail "anonymize: requires --key-file"
+else
+    pass "anonymize: requires --key-file"
+fi
+
+# Test: --strict-json rejects duplicate keys that encoding/json would drop
+echo '{"a":1,"a":2}' > "$TMPDIR/dupkey.json"
+if ./bonbon j "$TMPDIR/dupkey.json" 2>/dev/null && ! ./bonbon --strict-json j "$TMPDIR/dupkey.json" 2>/dev/null; then
+    pass "--strict-json: rejects duplicate keys"
+else
+    fail "--strict-json: rejects duplicate keys"
+fi
+
+# Test: --strict-json rejects unpaired surrogates and inexact integers
+printf '["\\ud800"]' > "$TMPDIR/surrogate.json"
+echo '[9007199254740993]' > "$TMPDIR/bigint.json"
+if ! ./bonbon --strict-json j "$TMPDIR/surrogate.json" 2>/dev/null && ! ./bonbon --strict-json j "$TMPDIR/bigint.json" 2>/dev/null; then
+    pass "--strict-json: rejects lossy input"
+else
+    fail "--strict-json: rejects lossy input"
+fi
+
+# Test: --strict-json accepts valid JSON
+if ./bonbon --strict-json j2b "$TMPDIR/logs.json" "$TMPDIR/strict.boj"; then
+    pass "--strict-json: accepts valid JSON"
+else
+    fail "--strict-json: accepts valid JSON"
+fi
+
+# Test: --trailing-out writes the bytes after the document
+{ printf 'HEAD'; cat "$TMPDIR/stats.boj"; printf 'REST'; } > "$TMPDIR/container.bin"
+STDERR=$(./bonbon -s 4 --trailing-out "$TMPDIR/rest.bin" b2j "$TMPDIR/container.bin" "$TMPDIR/payload.json" 2>&1)
+OFFSET=$((4 + $(wc -c < "$TMPDIR/stats.boj")))
+if [ "$(cat "$TMPDIR/rest.bin")" = "REST" ] && [ "$STDERR" = "trailing data: offset $OFFSET, length 4" ]; then
+    pass "--trailing-out: writes trailing bytes"
+else
+    fail "--trailing-out: writes trailing bytes"
+fi
+
+# Test: repeated -s/--length windows extract several embedded payloads
+echo '{"a":1}' | ./bonbon j2b - "$TMPDIR/p1.boj"
+echo '[1,2]' | ./bonbon j2b - "$TMPDIR/p2.boj"
+L1=$(wc -c < "$TMPDIR/p1.boj" | tr -d ' ')
+L2=$(wc -c < "$TMPDIR/p2.boj" | tr -d ' ')
+{ printf 'HDR'; cat "$TMPDIR/p1.boj"; printf 'XX'; cat "$TMPDIR/p2.boj"; printf 'END'; } > "$TMPDIR/embedded.bin"
+OUTPUT=$(./bonbon -s 3 --length "$L1" --start $((3 + L1 + 2)) --length "$L2" b2j "$TMPDIR/embedded.bin" - | tr -d ' \n')
+if [ "$OUTPUT" = '{"a":1}[1,2]' ]; then
+    pass "--length: extracts multiple windows"
+else
+    fail "--length: extracts multiple windows (got $OUTPUT)"
+fi
+
+# Test: container build/get extracts any document by number
+printf '{"a":1}\n{"b":2}\n{"c":3}\n' > "$TMPDIR/docs.json"
+./bonbon container build "$TMPDIR/docs.json" "$TMPDIR/docs.bbc" --hashes
+if ./bonbon container get "$TMPDIR/docs.bbc" 2 | grep -q '"c": 3' && \
+   ./bonbon container list "$TMPDIR/docs.bbc" | grep -q "^documents: 3$"; then
+    pass "container: build, list, and get by number"
+else
+    fail "container: build, list, and get by number"
+fi
+
+# Test: container get detects a corrupted document via its hash
+cp "$TMPDIR/docs.bbc" "$TMPDIR/corrupt.bbc"
+printf 'X' | dd of="$TMPDIR/corrupt.bbc" bs=1 seek=2 conv=notrunc 2>/dev/null
+if ./bonbon container get "$TMPDIR/corrupt.bbc" 0 2>&1 | grep -q "checksum mismatch"; then
+    pass "container: detects checksum mismatch"
+else
+    fail "container: detects checksum mismatch"
+fi
+
+# Test: container update re-encodes only changed documents, matching a full rebuild
+printf '{"a":{"b":[1,2],"c":"same"}}\n{"d":4}\n' > "$TMPDIR/upd1.json"
+printf '{"a":{"b":[1,2,3],"c":"same"}}\n{"d":4}\n{"e":5}\n' > "$TMPDIR/upd2.json"
+./bonbon container build "$TMPDIR/upd1.json" "$TMPDIR/upd.bbc" --hashes
+./bonbon container build "$TMPDIR/upd2.json" "$TMPDIR/upd-full.bbc" --hashes
+STDERR=$(./bonbon container update "$TMPDIR/upd.bbc" "$TMPDIR/upd2.json" 2>&1)
+./bonbon container get "$TMPDIR/upd.bbc" 0 "$TMPDIR/upd0.boj"
+./bonbon container get "$TMPDIR/upd-full.bbc" 0 "$TMPDIR/upd-full0.boj"
+if echo "$STDERR" | grep -q "^1 of 2 documents changed (1 subtrees re-encoded), 1 added" && \
+   cmp -s "$TMPDIR/upd0.boj" "$TMPDIR/upd-full0.boj" && \
+   ./bonbon container get "$TMPDIR/upd.bbc" 2 | grep -q '"e": 5'; then
+    pass "container: update re-encodes only what changed"
+else
+    fail "container: update re-encodes only what changed ($STDERR)"
+fi
+
+# Test: container compact reclaims the space left by updates
+./bonbon container compact "$TMPDIR/upd.bbc" 2>/dev/null
+if cmp -s "$TMPDIR/upd.bbc" "$TMPDIR/upd-full.bbc"; then
+    pass "container: compact matches a fresh build"
+else
+    fail "container: compact matches a fresh build"
+fi
+
+# Test: container compact replaces the container rather than rewriting it in place
+./bonbon container update "$TMPDIR/upd.bbc" "$TMPDIR/upd1.json" 2>/dev/null
+ln "$TMPDIR/upd.bbc" "$TMPDIR/upd-link.bbc"
+./bonbon container compact "$TMPDIR/upd.bbc" 2>/dev/null
+if ! cmp -s "$TMPDIR/upd.bbc" "$TMPDIR/upd-link.bbc" && \
+   ./bonbon container get "$TMPDIR/upd-link.bbc" 1 | grep -q '"d": 4' && \
+   ./bonbon container get "$TMPDIR/upd.bbc" 1 | grep -q '"d": 4'; then
+    pass "container: compact replaces the container atomically"
+else
+    fail "container: compact replaces the container atomically"
+fi
+
+# Test: container update writes after the old footer and keeps the bytes before it
+printf '{"a":0}\n' > "$TMPDIR/zero1.json"
+printf '{"a":-0.0}\n' > "$TMPDIR/zero2.json"
+./bonbon container build "$TMPDIR/zero1.json" "$TMPDIR/zero.bbc"
+cp "$TMPDIR/zero.bbc" "$TMPDIR/zero-old.bbc"
+STDERR=$(./bonbon container update "$TMPDIR/zero.bbc" "$TMPDIR/zero2.json" 2>&1)
+if echo "$STDERR" | grep -q "^1 of 1 documents changed" && \
+   cmp -s -n "$(wc -c < "$TMPDIR/zero-old.bbc")" "$TMPDIR/zero-old.bbc" "$TMPDIR/zero.bbc" && \
+   ./bonbon container get "$TMPDIR/zero.bbc" 0 | grep -q '"a": -0'; then
+    pass "container: update appends, telling -0 from 0"
+else
+    fail "container: update appends, telling -0 from 0 ($STDERR)"
+fi
+
+# Test: index build/get finds documents by key, matching numbers and strings
+printf '{"id":1,"v":"a"}\n{"id":"2","v":"b"}\n{"v":"none"}\n{"id":3,"v":"c"}\n' | ./bonbon --stream j2b - "$TMPDIR/keyed.boj"
+./bonbon index build "$TMPDIR/keyed.boj" --path '$.id' --out "$TMPDIR/keyed.idx" 2>/dev/null
+OUTPUT=$(./bonbon index get "$TMPDIR/keyed.boj" --id 2 --index "$TMPDIR/keyed.idx")
+if echo "$OUTPUT" | grep -q '"v": "b"' && ! echo "$OUTPUT" | grep -q '"v": "c"'; then
+    pass "index: get by key"
+else
+    fail "index: get by key"
+fi
+
+# Test: index get rejects an index built for a different version of the stream
+cat "$TMPDIR/keyed.boj" "$TMPDIR/keyed.boj" > "$TMPDIR/keyed2.boj"
+if ./bonbon index get "$TMPDIR/keyed2.boj" --id 1 --index "$TMPDIR/keyed.idx" 2>&1 | grep -q "stale"; then
+    pass "index: detects stale index"
+else
+    fail "index: detects stale index"
+fi
+
+# Test: append adds documents to a stream and to a container's index
+echo '{"n":1}' > "$TMPDIR/n1.json"
+./bonbon append "$TMPDIR/appended.boj" "$TMPDIR/n1.json"
+./bonbon append "$TMPDIR/appended.boj" "$TMPDIR/n1.json"
+./bonbon append "$TMPDIR/docs.bbc" "$TMPDIR/n1.json"
+if [ "$(./bonbon --stream b2j "$TMPDIR/appended.boj" - | grep -c '"n": 1')" = 2 ] && \
+   ./bonbon container get "$TMPDIR/docs.bbc" 3 | grep -q '"n": 1'; then
+    pass "append: extends streams and containers"
+else
+    fail "append: extends streams and containers"
+fi
+
+# Test: append to a container leaves the old index and footer intact before the new ones
+SIZE=$(wc -c < "$TMPDIR/docs.bbc")
+cp "$TMPDIR/docs.bbc" "$TMPDIR/docs-before.bbc"
+./bonbon append "$TMPDIR/docs.bbc" "$TMPDIR/n1.json"
+if head -c "$SIZE" "$TMPDIR/docs.bbc" | cmp -s - "$TMPDIR/docs-before.bbc" && \
+   ./bonbon container get "$TMPDIR/docs.bbc" 4 | grep -q '"n": 1'; then
+    pass "append: old container index survives until the new footer"
+else
+    fail "append: old container index survives until the new footer"
+fi
+
+# Test: delta produces a patch that apply turns back into the new document
+echo '{"a":1,"b":[1,2,3],"c":{"d":"x"}}' | ./bonbon j2b - "$TMPDIR/v1.boj"
+echo '{"a":2,"b":[1,2],"c":{"d":"x","e":true}}' > "$TMPDIR/v2.json"
+./bonbon delta "$TMPDIR/v1.boj" "$TMPDIR/v2.json" --out "$TMPDIR/patch.boj"
+./bonbon apply "$TMPDIR/v1.boj" "$TMPDIR/patch.boj" --out "$TMPDIR/v2-applied.json"
+if [ "$(./bonbon delta "$TMPDIR/v2.json" "$TMPDIR/v2-applied.json" | tr -d ' \n')" = "[]" ]; then
+    pass "delta/apply: round trip"
+else
+    fail "delta/apply: round trip"
+fi
+
+# Test: combine merges documents with the chosen strategy
+echo '{"db":{"host":"a","port":1},"tags":["x"]}' > "$TMPDIR/base.json"
+echo '{"db":{"host":"b"},"tags":["y"]}' | ./bonbon j2b - "$TMPDIR/prod.boj"
+OUTPUT=$(./bonbon combine "$TMPDIR/base.json" "$TMPDIR/prod.boj" --strategy concat-arrays | tr -d ' \n')
+if [ "$OUTPUT" = '{"db":{"host":"b","port":1},"tags":["x","y"]}' ]; then
+    pass "combine: concat-arrays strategy"
+else
+    fail "combine: concat-arrays strategy"
+fi
+
+# Test: --lossiness-report flags rounded numbers and reordered keys
+OUTPUT=$(echo '{"b":12345678901234567891,"a":0.1}' | ./bonbon --lossiness-report j2b - "$TMPDIR/lossy.boj" 2>&1)
+if echo "$OUTPUT" | grep -q '\$.b: number 12345678901234567891 rounded' && \
+   echo "$OUTPUT" | grep -q 'object keys reordered' && \
+   ! echo "$OUTPUT" | grep -q '\$.a:'; then
+    pass "--lossiness-report: rounding and key order"
+else
+    fail "--lossiness-report: rounding and key order"
+fi
+
+# Test: examples write produces matching JSON and BONJSON samples
+./bonbon examples write "$TMPDIR/examples" numbers unicode
+if ./bonbon b "$TMPDIR/examples/numbers.boj" && \
+   [ "$(./bonbon b2j "$TMPDIR/examples/unicode.boj" - | tr -d ' \n')" = "$(./bonbon j2j "$TMPDIR/examples/unicode.json" - | tr -d ' \n')" ]; then
+    pass "examples: write JSON and BONJSON samples"
+else
+    fail "examples: write JSON and BONJSON samples"
+fi
+
+# Test: --filter writes nothing and exits 2 on invalid input
+OUTPUT=$(echo '{"a":' | ./bonbon --filter j2j 2>/dev/null)
+CODE=$?
+if [ "$CODE" = 2 ] && [ -z "$OUTPUT" ] && [ "$(echo '{"b":1,"a":2}' | ./bonbon --filter j2j | tr -d ' \n')" = '{"a":2,"b":1}' ]; then
+    pass "--filter: stable exit code and no partial output"
+else
+    fail "--filter: stable exit code and no partial output"
+fi
+
+# Test: --filter rejects options it does not implement instead of ignoring them
+echo '{"a":1}' | ./bonbon --filter --envelope j2j >/dev/null 2>&1
+ENVELOPE_CODE=$?
+echo '{"a":1}' | ./bonbon --filter --snapshots "$TMPDIR/filter-snapshots" j2j >/dev/null 2>&1
+SNAPSHOT_CODE=$?
+if [ "$ENVELOPE_CODE" = 1 ] && [ "$SNAPSHOT_CODE" = 1 ] && [ ! -e "$TMPDIR/filter-snapshots" ]; then
+    pass "--filter: rejects unsupported options"
+else
+    fail "--filter: rejects unsupported options ($ENVELOPE_CODE $SNAPSHOT_CODE)"
+fi
+
+# Test: describe reports the innermost value at a byte offset
+echo '{"user":{"tags":["a","bb"]}}' | ./bonbon j2b - "$TMPDIR/hover.boj"
+OUTPUT=$(./bonbon describe "$TMPDIR/hover.boj" --offset 16 --json)
+if [ "$OUTPUT" = '{"document":0,"path":"$.user.tags[1]","type":"string","offset":15,"size":3,"value":"bb"}' ]; then
+    pass "describe: innermost value at offset"
+else
+    fail "describe: innermost value at offset"
+fi
+
+# Test: serve converts over the versioned HTTP protocol on a Unix socket
+./bonbon serve "unix:$TMPDIR/serve.sock" 2>/dev/null &
+SERVE_PID=$!
+for _ in $(seq 50); do [ -S "$TMPDIR/serve.sock" ] && break; sleep 0.1; done
+echo '{"a":[1,2]}' | curl -s --unix-socket "$TMPDIR/serve.sock" -H 'Content-Type: application/json' \
+    --data-binary @- http://bonbon/v1/convert > "$TMPDIR/served.boj"
+if ./bonbon b2j "$TMPDIR/served.boj" - | grep -q '"a"' && \
+   curl -s --unix-socket "$TMPDIR/serve.sock" http://bonbon/v1/version | grep -q '"protocol":1'; then
+    pass "serve: converts and reports its protocol version"
+else
+    fail "serve: converts and reports its protocol version"
+fi
+
+# Test: serve rejects unknown parameters with a stable error code
+OUTPUT=$(curl -s --unix-socket "$TMPDIR/serve.sock" -H 'Content-Type: application/json' -d '{}' \
+    'http://bonbon/v1/convert?no-such-option=1')
+if echo "$OUTPUT" | grep -q '"code":"unknown_parameter"'; then
+    pass "serve: rejects unknown parameters"
+else
+    fail "serve: rejects unknown parameters (got $OUTPUT)"
+fi
+
+# Test: serve publishes the OpenAPI document that serve --spec prints
+curl -s --unix-socket "$TMPDIR/serve.sock" http://bonbon/v1/openapi.json > "$TMPDIR/served-spec.json"
+./bonbon serve --spec > "$TMPDIR/spec.json"
+if ./bonbon j2j "$TMPDIR/spec.json" - | grep -q '"/v1/convert"' && \
+   [ "$(./bonbon j2j "$TMPDIR/served-spec.json" -)" = "$(./bonbon j2j "$TMPDIR/spec.json" -)" ]; then
+    pass "serve: --spec matches the served OpenAPI document"
+else
+    fail "serve: --spec matches the served OpenAPI document"
+fi
+# Test: serve applies per-request options from headers and queries, within --allow-params
+kill $SERVE_PID 2>/dev/null
+wait $SERVE_PID 2>/dev/null || true
+./bonbon serve --allow-params stream,indent "unix:$TMPDIR/params.sock" 2>/dev/null &
+SERVE_PID=$!
+for _ in $(seq 50); do [ -S "$TMPDIR/params.sock" ] && break; sleep 0.1; done
+COMPACT=$(curl -s --unix-socket "$TMPDIR/params.sock" -H 'Content-Type: application/json' -H 'Accept: application/json' \
+    -H 'Bonbon-Option-Indent: 0' -d '{"a":[1,2]}' http://bonbon/v1/convert)
+DENIED=$(curl -s --unix-socket "$TMPDIR/params.sock" -H 'Content-Type: application/json' -d '{}' \
+    'http://bonbon/v1/convert?strict-json=true')
+if [ "$COMPACT" = '{"a":[1,2]}' ] && echo "$DENIED" | grep -q '"code":"parameter_not_allowed"'; then
+    pass "serve: per-request options limited by --allow-params"
+else
+    fail "serve: per-request options limited by --allow-params ($COMPACT / $DENIED)"
+fi
+kill $SERVE_PID 2>/dev/null
+wait $SERVE_PID 2>/dev/null || true
+
+# Test: serve with authentication rejects anonymous requests and accepts tokens and signatures
+echo "test-token-1" > "$TMPDIR/tokens"
+printf 'an-hmac-key-of-20-bytes' > "$TMPDIR/hmac.key"
+./bonbon serve --auth-token-file "$TMPDIR/tokens" --auth-hmac-key-file "$TMPDIR/hmac.key" \
+    "unix:$TMPDIR/auth.sock" 2>/dev/null &
+SERVE_PID=$!
+for _ in $(seq 50); do [ -S "$TMPDIR/auth.sock" ] && break; sleep 0.1; done
+ANON=$(curl -s --unix-socket "$TMPDIR/auth.sock" -H 'Content-Type: application/json' -d '{"a":1}' http://bonbon/v1/convert)
+BEARER=$(curl -s --unix-socket "$TMPDIR/auth.sock" -H 'Authorization: Bearer test-token-1' \
+    -H 'Content-Type: application/json' -H 'Accept: application/json' -d '{"a":1}' http://bonbon/v1/convert)
+TS=$(date +%s)
+SIG=$(printf '%s\nPOST\n/v1/convert\n{"a":2}' "$TS" | openssl dgst -sha256 -hmac "$(cat "$TMPDIR/hmac.key")" | sed 's/.* //')
+SIGNED=$(curl -s --unix-socket "$TMPDIR/auth.sock" -H "Bonbon-Timestamp: $TS" -H "Bonbon-Signature: $SIG" \
+    -H 'Content-Type: application/json' -H 'Accept: application/json' -d '{"a":2}' http://bonbon/v1/convert)
+if echo "$ANON" | grep -q '"code":"unauthorized"' && echo "$BEARER" | grep -q '"a": 1' && \
+   echo "$SIGNED" | grep -q '"a": 2'; then
+    pass "serve: bearer token and HMAC signature authentication"
+else
+    fail "serve: bearer token and HMAC signature authentication ($ANON / $BEARER / $SIGNED)"
+fi
+kill $SERVE_PID 2>/dev/null
+wait $SERVE_PID 2>/dev/null || true
+
+# Test: serve drains in-flight requests on SIGTERM and exits cleanly
+head -c 100000 /dev/zero | tr '\0' 'x' | sed 's/.*/{"k":"&"}/' > "$TMPDIR/slow.json"
+./bonbon serve "unix:$TMPDIR/drain.sock" 2>"$TMPDIR/drain.log" &
+SERVE_PID=$!
+for _ in $(seq 50); do [ -S "$TMPDIR/drain.sock" ] && break; sleep 0.1; done
+curl -s --limit-rate 100K --unix-socket "$TMPDIR/drain.sock" -H 'Content-Type: application/json' \
+    --data-binary @"$TMPDIR/slow.json" -o /dev/null -w '%{http_code}' http://bonbon/v1/convert > "$TMPDIR/drain.status" &
+CURL_PID=$!
+sleep 0.3
+kill -TERM $SERVE_PID
+SERVE_STATUS=0
+wait $SERVE_PID || SERVE_STATUS=$?
+wait $CURL_PID || true
+if [ "$SERVE_STATUS" = 0 ] && [ "$(cat "$TMPDIR/drain.status")" = 200 ] && \
+   grep -q "shut down cleanly" "$TMPDIR/drain.log" && [ ! -e "$TMPDIR/drain.sock" ]; then
+    pass "serve: drains in-flight requests on SIGTERM"
+else
+    fail "serve: drains in-flight requests on SIGTERM (exit $SERVE_STATUS, status $(cat "$TMPDIR/drain.status"))"
+fi
+
+# Test: serve reloads its token file on SIGHUP, keeping the old settings if the reload fails
+echo "first-token" > "$TMPDIR/reload-tokens"
+./bonbon serve --auth-token-file "$TMPDIR/reload-tokens" "unix:$TMPDIR/reload.sock" 2>"$TMPDIR/reload.log" &
+SERVE_PID=$!
+for _ in $(seq 50); do [ -S "$TMPDIR/reload.sock" ] && break; sleep 0.1; done
+convert_with_token() {
+    curl -s --unix-socket "$TMPDIR/reload.sock" -H "Authorization: Bearer $1" -H 'Content-Type: application/json' \
+        -H 'Accept: application/json' -d '{"a":1}' http://bonbon/v1/convert
+}
+echo "second-token" > "$TMPDIR/reload-tokens"
+kill -HUP $SERVE_PID
+for _ in $(seq 50); do grep -q "reloaded" "$TMPDIR/reload.log" && break; sleep 0.1; done
+OLD=$(convert_with_token first-token)
+NEW=$(convert_with_token second-token)
+: > "$TMPDIR/reload-tokens"
+kill -HUP $SERVE_PID
+for _ in $(seq 50); do grep -q "reload failed" "$TMPDIR/reload.log" && break; sleep 0.1; done
+KEPT=$(convert_with_token second-token)
+kill $SERVE_PID 2>/dev/null
+wait $SERVE_PID 2>/dev/null || true
+if echo "$OLD" | grep -q '"unauthorized"' && echo "$NEW" | grep -q '"a": 1' && echo "$KEPT" | grep -q '"a": 1'; then
+    pass "serve: SIGHUP reloads settings files"
+else
+    fail "serve: SIGHUP reloads settings files ($OLD / $NEW / $KEPT)"
+fi
+
+# Test: serve reloads --rename-file and --redact-rules on SIGHUP, keeping renames in order
+echo '{"x":"y"}' > "$TMPDIR/reload-renames.json"
+echo '{"rules": [{"name": "s", "key": "s", "strategy": "mask"}]}' > "$TMPDIR/reload-redact.json"
+./bonbon serve --rename a=b --rename-file "$TMPDIR/reload-renames.json" --redact-rules "$TMPDIR/reload-redact.json" \
+    "unix:$TMPDIR/reload2.sock" 2>"$TMPDIR/reload2.log" &
+SERVE_PID=$!
+for _ in $(seq 50); do [ -S "$TMPDIR/reload2.sock" ] && break; sleep 0.1; done
+convert_reloaded() {
+    curl -s --unix-socket "$TMPDIR/reload2.sock" -H 'Content-Type: application/json' \
+        -H 'Accept: application/json' -d '{"a":1,"x":2,"s":"k"}' 'http://bonbon/v1/convert?indent=0'
+}
+BEFORE=$(convert_reloaded)
+echo '{"x":"z"}' > "$TMPDIR/reload-renames.json"
+echo '{"rules": [{"name": "s", "key": "s", "strategy": "remove"}]}' > "$TMPDIR/reload-redact.json"
+kill -HUP $SERVE_PID
+for _ in $(seq 50); do grep -q "reloaded" "$TMPDIR/reload2.log" && break; sleep 0.1; done
+AFTER=$(convert_reloaded)
+kill $SERVE_PID 2>/dev/null
+wait $SERVE_PID 2>/dev/null || true
+if [ "$BEFORE" = '{"b":1,"s":"*","y":2}' ] && [ "$AFTER" = '{"b":1,"z":2}' ]; then
+    pass "serve: SIGHUP reloads rename and redaction files"
+else
+    fail "serve: SIGHUP reloads rename and redaction files ($BEFORE / $AFTER)"
+fi
+
+# Test: formats lists the registry with capabilities, and --json exposes it for tooling
+if ./bonbon formats | grep -q "^bonjson (application/bonjson; .boj .bonjson)" && \
+   ./bonbon formats | grep -A3 "^bonjson" | grep -q "binary: *yes" && \
+   ./bonbon formats --json | grep -q '"name":"json".*"streaming":true,"binary":false,"detection":"reliable","lossiness":\["numbers' && \
+   ./bonbon formats --json | grep -q '"name":"csv","media_type":"text/csv"'; then
+    pass "formats: lists the format registry"
+else
+    fail "formats: lists the format registry"
+fi
+
+# Test: doctor spots content that disagrees with its extension, and fails on empty input
+echo '{"a": 1}' | ./bonbon j2b - "$TMPDIR/doctor.json"
+DOCTOR=$(./bonbon doctor "$TMPDIR/doctor.json")
+: > "$TMPDIR/doctor-empty.json"
+if echo "$DOCTOR" | grep -q "warn  format  *content is bonjson, but the name says json" && \
+   echo "$DOCTOR" | grep -q -- "-> rename the file with a .boj extension" && \
+   ./bonbon doctor "$TMPDIR/doctor.json" --json | grep -q '"check": "tmp"' && \
+   ! ./bonbon doctor "$TMPDIR/doctor-empty.json" >/dev/null 2>&1; then
+    pass "doctor: probes the input and advises"
+else
+    fail "doctor: probes the input and advises ($DOCTOR)"
+fi
+
+# Test: doctor names the option that lets rejected BONJSON decode
+printf '\xb0\x00\x00\xc0\x7f' > "$TMPDIR/doctor-nan.boj"
+if ./bonbon doctor "$TMPDIR/doctor-nan.boj" | grep -q -- "-> the input decodes with -f allow"; then
+    pass "doctor: suggests the option for a spec violation"
+else
+    fail "doctor: suggests the option for a spec violation"
+fi
+
+# Test: an interrupted conversion leaves neither its output nor a temporary file behind
+mkdir -p "$TMPDIR/interrupt"
+( echo '{"a": 1}'; sleep 2 ) | ./bonbon j2b --stream - "$TMPDIR/interrupt/out.boj" &
+CONVERT_PID=$!
+sleep 0.5
+kill -INT $CONVERT_PID 2>/dev/null
+wait $CONVERT_PID 2>/dev/null || true
+LEFT=$(ls -A "$TMPDIR/interrupt")
+( echo '{"a": 1}'; sleep 2 ) | ./bonbon j2b --stream --keep-temp - "$TMPDIR/interrupt/out.boj" 2>"$TMPDIR/interrupt.err" &
+CONVERT_PID=$!
+sleep 0.5
+kill -TERM $CONVERT_PID 2>/dev/null
+wait $CONVERT_PID 2>/dev/null || true
+if [ -z "$LEFT" ] && grep -q "kept temporary file .*/\.out\.boj\..*\.tmp" "$TMPDIR/interrupt.err" && \
+   ls -A "$TMPDIR/interrupt" | grep -q '^\.out\.boj\..*\.tmp$'; then
+    pass "temp files: removed on interrupt, kept with --keep-temp"
+else
+    fail "temp files: removed on interrupt, kept with --keep-temp ($LEFT)"
+fi
+
+# Test: outputs that are not regular files are written in place, and file modes follow the umask or the old file
+mkdir -p "$TMPDIR/inplace"
+echo '{"a": 1}' > "$TMPDIR/inplace/in.json"
+: > "$TMPDIR/inplace/real.boj"
+ln -s real.boj "$TMPDIR/inplace/link.boj"
+./bonbon j2b "$TMPDIR/inplace/in.json" "$TMPDIR/inplace/link.boj"
+(umask 077; ./bonbon j2b "$TMPDIR/inplace/in.json" "$TMPDIR/inplace/private.boj")
+PRIVATE=$(ls -l "$TMPDIR/inplace/private.boj" | cut -c1-10)
+chmod 640 "$TMPDIR/inplace/private.boj"
+./bonbon j2b "$TMPDIR/inplace/in.json" "$TMPDIR/inplace/private.boj"
+KEPT=$(ls -l "$TMPDIR/inplace/private.boj" | cut -c1-10)
+if [ -L "$TMPDIR/inplace/link.boj" ] && [ -s "$TMPDIR/inplace/real.boj" ] && \
+   [ "$PRIVATE" = "-rw-------" ] && [ "$KEPT" = "-rw-r-----" ] && \
+   ./bonbon j2b "$TMPDIR/inplace/in.json" /dev/null && [ -c /dev/null ]; then
+    pass "output: symlinks and devices written in place, modes kept"
+else
+    fail "output: symlinks and devices written in place, modes kept ($PRIVATE $KEPT)"
+fi
+
+# Test: SIGUSR1 reports the progress of a running conversion
+( printf '{"a": 1}\n{"a": 2}\n'; sleep 2 ) | ./bonbon j2b --stream - "$TMPDIR/progress.boj" 2>"$TMPDIR/progress.err" &
+CONVERT_PID=$!
+sleep 0.5
+kill -USR1 $CONVERT_PID 2>/dev/null
+wait $CONVERT_PID 2>/dev/null || true
+if grep -q "^progress: stdin: offset 18 bytes, 2 documents converted, .* MB/s, [0-9]* bytes heap" "$TMPDIR/progress.err"; then
+    pass "progress: SIGUSR1 reports offset and documents converted"
+else
+    fail "progress: SIGUSR1 reports offset and documents converted ($(cat "$TMPDIR/progress.err"))"
+fi
+
+# Test: diff pairs files across trees by relative path, whatever their format
+mkdir -p "$TMPDIR/diffa/sub" "$TMPDIR/diffb/sub"
+echo '{"x": 1, "y": [1, 2]}' > "$TMPDIR/diffa/one.json"
+./bonbon j2b "$TMPDIR/diffa/one.json" "$TMPDIR/diffb/one.boj"
+echo '{"x": 2}' > "$TMPDIR/diffa/sub/two.json"
+echo '{"x": 3}' > "$TMPDIR/diffb/sub/two.json"
+echo '1' > "$TMPDIR/diffa/three.json"
+DIFF_EXIT=0
+DIFF=$(./bonbon diff "$TMPDIR/diffa" "$TMPDIR/diffb") || DIFF_EXIT=$?
+if [ "$DIFF_EXIT" -eq 1 ] && echo "$DIFF" | grep -q "^same  *one$" && \
+   echo "$DIFF" | grep -q "^different  sub/two: 1 differences, first replace /x$" && \
+   echo "$DIFF" | grep -q "^only in A  three.json$" && \
+   echo "$DIFF" | grep -q "^3 paths: 1 same, 1 different, 1 only in A, 0 only in B, 0 errors$" && \
+   ./bonbon diff "$TMPDIR/diffa/one.json" "$TMPDIR/diffb/one.boj"; then
+    pass "diff: compares directory trees across formats"
+else
+    fail "diff: compares directory trees across formats ($DIFF_EXIT: $DIFF)"
+fi
+
+# Test: merge3 merges both sides' changes and marks conflicts, keeping the format of ours
+echo '{"a": 1, "b": [1, 2], "c": "x", "d": {"e": 1}}' > "$TMPDIR/m3-base.json"
+echo '{"a": 2, "b": [1, 3], "c": "y", "d": {"e": 1}}' | ./bonbon j2b - "$TMPDIR/m3-ours"
+echo '{"a": 1, "b": [4, 2], "c": "z", "f": true}' > "$TMPDIR/m3-theirs.json"
+MERGE_EXIT=0
+./bonbon merge3 "$TMPDIR/m3-base.json" "$TMPDIR/m3-ours" "$TMPDIR/m3-theirs.json" --out "$TMPDIR/m3-ours" 2>"$TMPDIR/m3.err" || MERGE_EXIT=$?
+MERGED=$(./bonbon b2j "$TMPDIR/m3-ours" - | tr -d ' \n')
+if [ "$MERGE_EXIT" -eq 1 ] && grep -q 'conflict at "/c"' "$TMPDIR/m3.err" && \
+   [ "$MERGED" = '{"a":2,"b":[4,3],"c":{"$conflict":{"base":"x","ours":"y","theirs":"z"}},"f":true}' ]; then
+    pass "merge3: three-way merge with conflict markers"
+else
+    fail "merge3: three-way merge with conflict markers ($MERGE_EXIT: $MERGED)"
+fi
+
+# Test: git filters store BONJSON and check out JSON, and textconv diffs BONJSON as JSON
+GITREPO="$TMPDIR/gitrepo"
+mkdir -p "$GITREPO"
+BONBON="$(pwd)/bonbon"
+if (
+    cd "$GITREPO" && git init -q && git config user.email t@example.com && git config user.name t &&
+    git config filter.bonjson.clean "'$BONBON' --git-clean" &&
+    git config filter.bonjson.smudge "'$BONBON' --git-smudge" &&
+    git config diff.bonjson.textconv "'$BONBON' --git-textconv" &&
+    echo '*.boj filter=bonjson diff=bonjson' > .gitattributes &&
+    echo '{"b": 1, "a": [1, 2]}' > data.boj && git add . && git commit -qm one &&
+    git cat-file -p HEAD:data.boj | "$BONBON" b2j - - | grep -q '"a"' &&
+    rm data.boj && git checkout -q data.boj && grep -q '"b": 1' data.boj &&
+    [ -z "$(git status --porcelain)" ] &&
+    echo '{"b": 2, "a": [1, 2]}' > data.boj && git diff | grep -q '^+    "b": 2'
+); then
+    pass "git: clean, smudge, and textconv helpers"
+else
+    fail "git: clean, smudge, and textconv helpers"
+fi
+
+# Test: mergetool writes MERGED in the format its name gives, and an empty BASE means no ancestor
+: > "$TMPDIR/mt-base"
+echo '{"a": 1, "b": 2}' > "$TMPDIR/mt-local"
+echo '{"a": 1, "c": 3}' > "$TMPDIR/mt-remote"
+if ./bonbon mergetool "$TMPDIR/mt-base" "$TMPDIR/mt-local" "$TMPDIR/mt-remote" "$TMPDIR/mt-merged.boj" && \
+   [ "$(./bonbon b2j "$TMPDIR/mt-merged.boj" - | tr -d ' \n')" = '{"a":1,"b":2,"c":3}' ]; then
+    pass "mergetool: merges into MERGED in its format"
+else
+    fail "mergetool: merges into MERGED in its format"
+fi
+
+# Test: --provenance records the source byte range of each document and top-level member
+printf '{"name": "x", "list": [1, 2]}\n{"b": true}\n' | ./bonbon j2b --stream - "$TMPDIR/prov.boj"
+./bonbon b2j --stream "$TMPDIR/prov.boj" "$TMPDIR/prov.json" --provenance "$TMPDIR/prov-sidecar.json"
+PROV=$(tr -d ' \n' < "$TMPDIR/prov-sidecar.json")
+if echo "$PROV" | grep -q '"documents":\[{"offset":0,"size":18,"members":\[{"key":"list","offset":1,"size":9},{"key":"name","offset":10,"size":7}\]},{"offset":18,"size":5,' && \
+   ! ./bonbon j2b "$TMPDIR/prov.json" "$TMPDIR/prov2.boj" --provenance "$TMPDIR/x.json" 2>/dev/null; then
+    pass "provenance: sidecar of source byte ranges"
+else
+    fail "provenance: sidecar of source byte ranges ($PROV)"
+fi
+
+# Test: --width keeps arrays and objects that fit on one line and wraps the rest
+echo '{"name": "sample", "matrix": [[1, 2, 3], [4, 5, 6]], "long": [1000000, 2000000, 3000000, 4000000, 5000000]}' | ./bonbon j2b - "$TMPDIR/width.boj"
+WIDE=$(./bonbon b2j "$TMPDIR/width.boj" - --width 40)
+EXPECTED='{
+    "long": [
+        1000000,
+        2000000,
+        3000000,
+        4000000,
+        5000000
+    ],
+    "matrix": [[1, 2, 3], [4, 5, 6]],
+    "name": "sample"
+}'
+if [ "$WIDE" = "$EXPECTED" ] && ! ./bonbon b2j "$TMPDIR/width.boj" - --width 0 2>/dev/null; then
+    pass "width: width-aware pretty printing"
+else
+    fail "width: width-aware pretty printing"
+fi
+
+# Test: --compact-arrays puts arrays of scalars on one line and keeps objects expanded
+echo '{"matrix": [[1, 2], [3, 4]], "meta": {"a": 1}}' | ./bonbon j2b - "$TMPDIR/compact.boj"
+COMPACT=$(./bonbon b2j "$TMPDIR/compact.boj" - --compact-arrays)
+EXPECTED='{
+    "matrix": [
+        [1, 2],
+        [3, 4]
+    ],
+    "meta": {
+        "a": 1
+    }
+}'
+if [ "$COMPACT" = "$EXPECTED" ]; then
+    pass "compact-arrays: scalar arrays on one line"
+else
+    fail "compact-arrays: scalar arrays on one line"
+fi
+
+# Test: --nfc and --nfd normalize string values and keys
+printf '{"caf\\u0065\\u0301": "e\\u0301"}' > "$TMPDIR/nfd.json"
+./bonbon j2b "$TMPDIR/nfd.json" "$TMPDIR/nfc.boj" --nfc
+./bonbon b2j "$TMPDIR/nfc.boj" "$TMPDIR/nfc.json" --indent 0
+printf '{"\\u00e9": 1, "e\\u0301": 2}' > "$TMPDIR/nfclash.json"
+if [ "$(cat "$TMPDIR/nfc.json")" = "$(printf '{"caf\303\251":"\303\251"}')" ] && \
+   ! ./bonbon j2b "$TMPDIR/nfclash.json" "$TMPDIR/nfclash.boj" --nfc 2>/dev/null; then
+    pass "nfc: Unicode normalization of strings and keys"
+else
+    fail "nfc: Unicode normalization of strings and keys"
+fi
+
+# Test: --keys rewrites the case of every object key
+echo '{"userName": "a", "HTTPServer": {"max-conns": 1, "_id": 2, "list": [{"Zip Code": 3}]}}' > "$TMPDIR/keys.json"
+./bonbon j2b "$TMPDIR/keys.json" "$TMPDIR/keys.boj" --keys snake
+SNAKE=$(./bonbon b2j "$TMPDIR/keys.boj" - --indent 0)
+CAMEL=$(./bonbon j2b "$TMPDIR/keys.json" - --keys camel | ./bonbon b2j - - --indent 0)
+if [ "$SNAKE" = '{"http_server":{"_id":2,"list":[{"zip_code":3}],"max_conns":1},"user_name":"a"}' ] && \
+   [ "$CAMEL" = '{"httpServer":{"_id":2,"list":[{"zipCode":3}],"maxConns":1},"userName":"a"}' ] && \
+   ! echo '{"userName": 1, "user_name": 2}' | ./bonbon j2b - "$TMPDIR/keys2.boj" --keys snake 2>/dev/null; then
+    pass "keys: key case conversion"
+else
+    fail "keys: key case conversion ($SNAKE $CAMEL)"
+fi
+
+# Test: --scale multiplies or divides numbers at a path exactly
+echo '{"ts": 123456789012345, "latency_ms": 1500, "samples": [1, 2], "name": "x"}' > "$TMPDIR/scale.json"
+./bonbon j2b "$TMPDIR/scale.json" "$TMPDIR/scale.boj" --scale '$.ts*1000' --scale '$.latency_ms*0.001' --scale '$.samples/4'
+SCALED=$(./bonbon b2j "$TMPDIR/scale.boj" - --indent 0)
+if [ "$SCALED" = '{"latency_ms":1.5,"name":"x","samples":[0.25,0.5],"ts":123456789012345000}' ] && \
+   ! ./bonbon j2b "$TMPDIR/scale.json" "$TMPDIR/scale2.boj" --scale '$.name*2' 2>/dev/null; then
+    pass "scale: exact numeric unit scaling"
+else
+    fail "scale: exact numeric unit scaling ($SCALED)"
+fi
+
+# Test: --scale keeps every digit of a high-precision decimal
+SCALED=$(echo '{"m":{"$decimal":"1.2345678901234567890123"}}' | ./bonbon j2j - - --decimals tag --scale '$.m*1000' --indent 0)
+if [ "$SCALED" = '{"m":{"$decimal":"1234.5678901234567890123"}}' ]; then
+    pass "scale: high-precision decimals keep every digit"
+else
+    fail "scale: high-precision decimals keep every digit ($SCALED)"
+fi
+
+# Test: agg counts and sums a stream per group
+printf '{"level": "info", "bytes": 100}\n{"level": "error", "bytes": 50}\n{"level": "info", "bytes": 25}\n{"level": "info"}\n' > "$TMPDIR/agg.json"
+./bonbon j2b --stream "$TMPDIR/agg.json" "$TMPDIR/agg.boj"
+AGG=$(./bonbon agg "$TMPDIR/agg.boj" --group-by '$.level' --count --sum '$.bytes' --indent 0)
+./bonbon agg "$TMPDIR/agg.json" --out "$TMPDIR/agg-total.boj"
+if [ "$AGG" = '{"groups":[{"count":1,"group":{"$.level":"error"},"sum":{"$.bytes":50}},{"count":3,"group":{"$.level":"info"},"sum":{"$.bytes":125}}]}' ] && \
+   [ "$(./bonbon b2j "$TMPDIR/agg-total.boj" - --indent 0)" = '{"count":4}' ] && \
+   ! ./bonbon agg "$TMPDIR/agg.boj" --sum '$.level' 2>/dev/null; then
+    pass "agg: grouped count and sum"
+else
+    fail "agg: grouped count and sum ($AGG)"
+fi
+
+# Test: sort orders a stream by a key, stably, spilling runs to temporary files
+printf '{"t": 3, "n": "a"}\n{"t": 1, "n": "b"}\n{"n": "c"}\n{"t": 2, "n": "d"}\n{"t": 1, "n": "e"}\n{"t": 10, "n": "f"}\n' > "$TMPDIR/sort.json"
+./bonbon j2b --stream "$TMPDIR/sort.json" "$TMPDIR/sort.boj"
+SORTED=$(./bonbon sort "$TMPDIR/sort.boj" --by '$.t' --run-size 20 --indent 0 | tr -d '\n')
+INMEM=$(./bonbon sort "$TMPDIR/sort.json" --by t --indent 0 | tr -d '\n')
+EXPECTED='{"n":"c"}{"n":"b","t":1}{"n":"e","t":1}{"n":"d","t":2}{"n":"a","t":3}{"n":"f","t":10}'
+if [ "$SORTED" = "$EXPECTED" ] && [ "$INMEM" = "$EXPECTED" ] && ! ./bonbon sort "$TMPDIR/sort.boj" 2>/dev/null; then
+    pass "sort: external merge sort by key"
+else
+    fail "sort: external merge sort by key ($SORTED)"
+fi
+
+# Test: join combines documents of two streams on a key
+printf '{"id": 1, "msg": "a"}\n{"id": 2, "msg": "b"}\n{"id": 3, "msg": "c"}\n' > "$TMPDIR/join-left.json"
+printf '{"id": 1, "name": "one", "msg": "ignored"}\n{"id": 2, "name": "two"}\n' > "$TMPDIR/join-right.json"
+./bonbon j2b --stream "$TMPDIR/join-left.json" "$TMPDIR/join-left.boj"
+INNER=$(./bonbon join "$TMPDIR/join-left.boj" "$TMPDIR/join-right.json" --on '$.id' --indent 0 | tr -d '\n')
+LEFT=$(./bonbon join "$TMPDIR/join-left.boj" "$TMPDIR/join-right.json" --on '$.id' --type left --indent 0 | tr -d '\n')
+if [ "$INNER" = '{"id":1,"msg":"a","name":"one"}{"id":2,"msg":"b","name":"two"}' ] && \
+   [ "$LEFT" = "$INNER"'{"id":3,"msg":"c"}' ]; then
+    pass "join: inner and left joins on a key"
+else
+    fail "join: inner and left joins on a key ($INNER $LEFT)"
+fi
+
+# Test: --since and --until convert only documents within a time window
+printf '{"timestamp": "2024-05-01T10:00:00Z", "n": 1}\n{"timestamp": "2024-05-02T10:00:00Z", "n": 2}\n{"n": 3}\n{"timestamp": "2024-05-03T00:00:00Z", "n": 4}\n' > "$TMPDIR/window.json"
+./bonbon j2b --stream "$TMPDIR/window.json" "$TMPDIR/window.boj"
+WINDOWED=$(./bonbon b2j --stream --since 2024-05-02 --until 2024-05-03 --indent 0 "$TMPDIR/window.boj" - | tr -d '\n')
+BY_N=$(./bonbon b2j --stream --time-path '$.n' --since 3 --indent 0 "$TMPDIR/window.boj" - | tr -d '\n')
+if [ "$WINDOWED" = '{"n":2,"timestamp":"2024-05-02T10:00:00Z"}' ] && \
+   [ "$BY_N" = '{"n":3}{"n":4,"timestamp":"2024-05-03T00:00:00Z"}' ]; then
+    pass "--since/--until: time window over a stream"
+else
+    fail "--since/--until: time window over a stream ($WINDOWED $BY_N)"
+fi
+
+# Test: --envelope wraps each document with its source, range, time, and checksum
+printf '{"a": 1}\n{"b": 2}\n' > "$TMPDIR/envelope.json"
+./bonbon j2b --stream "$TMPDIR/envelope.json" "$TMPDIR/envelope.boj"
+ENVELOPED=$(./bonbon b2j --stream --envelope --indent 0 "$TMPDIR/envelope.boj" - | sed -n 2p)
+EXPECTED_SUM=$(tail -c +6 "$TMPDIR/envelope.boj" | sha256sum | cut -d' ' -f1)
+if echo "$ENVELOPED" | grep -q '"document":{"b":2}' && \
+   echo "$ENVELOPED" | grep -q '"offset":5,' && \
+   echo "$ENVELOPED" | grep -q "\"sha256\":\"$EXPECTED_SUM\"" && \
+   echo "$ENVELOPED" | grep -q '"converted":"20'; then
+    pass "--envelope: document metadata"
+else
+    fail "--envelope: document metadata ($ENVELOPED)"
+fi
+
+# Test: --schema-types decodes integers the schema declares exactly
+printf '{"type": "object", "properties": {"id": {"type": "integer"}, "items": {"type": "array", "items": {"$ref": "#/$defs/item"}}}, "$defs": {"item": {"properties": {"qty": {"type": "integer"}}}}}' > "$TMPDIR/types-schema.json"
+echo '{"id": 12345678901234567891, "items": [{"qty": 9007199254740993}], "other": 9007199254740993}' > "$TMPDIR/types.json"
+./bonbon --schema-types "$TMPDIR/types-schema.json" j2b "$TMPDIR/types.json" "$TMPDIR/types.boj"
+TYPED=$(./bonbon b2j --indent 0 "$TMPDIR/types.boj" - | tr -d '\n')
+if [ "$TYPED" = '{"id":12345678901234567891,"items":[{"qty":9007199254740993}],"other":9007199254740992}' ] && \
+   ! echo '{"id": 1.5}' | ./bonbon --schema-types "$TMPDIR/types-schema.json" j2b - "$TMPDIR/types-bad.boj" 2>/dev/null; then
+    pass "--schema-types: exact integers from schema hints"
+else
+    fail "--schema-types: exact integers from schema hints ($TYPED)"
+fi
+
+# Test: validate checks BONJSON against a schema on its token stream
+printf '{"type": "object", "required": ["id"], "properties": {"id": {"type": "integer", "minimum": 1}, "tags": {"type": "array", "items": {"type": "string"}}}}' > "$TMPDIR/validate-schema.json"
+printf '{"id": 1, "tags": ["a"]}\n{"id": 0, "tags": ["b", 2]}\n{"tags": []}\n' > "$TMPDIR/validate.json"
+./bonbon j2b --stream "$TMPDIR/validate.json" "$TMPDIR/validate.boj"
+head -1 "$TMPDIR/validate.json" > "$TMPDIR/validate-ok.json"
+./bonbon j2b "$TMPDIR/validate-ok.json" "$TMPDIR/validate-ok.boj"
+VIOLATIONS=$(./bonbon --stream --schema "$TMPDIR/validate-schema.json" validate "$TMPDIR/validate.boj" 2>/dev/null)
+if [ "$VIOLATIONS" = 'document 1: offset 19: $.id: 0 is less than the minimum 1
+document 1: offset 28: $.tags[1]: expected string, got integer
+document 2: offset 31: $: missing required key "id"' ] && \
+   ./bonbon --schema "$TMPDIR/validate-schema.json" validate "$TMPDIR/validate-ok.boj"; then
+    pass "validate: schema violations on the BONJSON token stream"
+else
+    fail "validate: schema violations on the BONJSON token stream ($VIOLATIONS)"
+fi
+
+# Test: inspect --reserved reports a reserved type code with its offset and path
+printf '\xb8\x66\x61\x01\x66\x62\xb7\x01\xcc\x00\xb6\xb6' > "$TMPDIR/reserved.boj"
+RESERVED=$(./bonbon --reserved inspect "$TMPDIR/reserved.boj" 2>/dev/null)
+if [ "$RESERVED" = 'document 0: offset 8: $.b[1]: reserved type code 0xcc' ] && \
+   ./bonbon --reserved inspect "$TMPDIR/validate-ok.boj"; then
+    pass "inspect --reserved: reserved type codes"
+else
+    fail "inspect --reserved: reserved type codes ($RESERVED)"
+fi
+
+# Test: --decimals tag carries high-precision decimals through JSON without loss
+echo '{"price": {"$decimal": "1.2345678901234567890123"}}' > "$TMPDIR/decimal.json"
+./bonbon --decimals tag j2b "$TMPDIR/decimal.json" "$TMPDIR/decimal.boj"
+TAGGED=$(./bonbon --decimals tag --indent 0 b2j "$TMPDIR/decimal.boj" - | tr -d '\n')
+PLAIN=$(./bonbon --indent 0 b2j "$TMPDIR/decimal.boj" - | tr -d '\n')
+if [ "$TAGGED" = '{"price":{"$decimal":"1.2345678901234567890123"}}' ] && \
+   [ "$PLAIN" = '{"price":"1.2345678901234567890123"}' ]; then
+    pass "--decimals tag: lossless decimal round trip"
+else
+    fail "--decimals tag: lossless decimal round trip ($TAGGED $PLAIN)"
+fi
+
+# Test: --uint64 handles integers beyond JavaScript's safe range in JSON output
+echo '{"type": "object", "additionalProperties": {"type": "integer"}}' > "$TMPDIR/uint64-schema.json"
+echo '{"id": 18446744073709551615, "n": 5}' > "$TMPDIR/uint64.json"
+./bonbon --schema-types "$TMPDIR/uint64-schema.json" j2b "$TMPDIR/uint64.json" "$TMPDIR/uint64.boj"
+AS_STRING=$(./bonbon --uint64 string --indent 0 b2j "$TMPDIR/uint64.boj" - | tr -d '\n')
+CLAMPED=$(./bonbon --uint64 clamp --indent 0 b2j "$TMPDIR/uint64.boj" - 2>/dev/null | tr -d '\n')
+if [ "$AS_STRING" = '{"id":"18446744073709551615","n":5}' ] && \
+   [ "$CLAMPED" = '{"id":9007199254740991,"n":5}' ] && \
+   ! ./bonbon --uint64 error b2j "$TMPDIR/uint64.boj" - >/dev/null 2>&1; then
+    pass "--uint64: string, clamp, and error"
+else
+    fail "--uint64: string, clamp, and error ($AS_STRING $CLAMPED)"
+fi
+
+# Test: --keep projects documents onto the listed paths
+echo '{"id": 1, "name": "x", "metrics": {"cpu": 1, "mem": 2}, "items": [{"id": 1, "v": 2}, {"id": 2, "v": 3}]}' > "$TMPDIR/keep.json"
+KEPT=$(./bonbon --keep '$.id,$.metrics.*' --keep '$.items[*].id' --indent 0 j2j "$TMPDIR/keep.json" - | tr -d '\n')
+if [ "$KEPT" = '{"id":1,"items":[{"id":1},{"id":2}],"metrics":{"cpu":1,"mem":2}}' ]; then
+    pass "--keep: path allowlist projection"
+else
+    fail "--keep: path allowlist projection ($KEPT)"
+fi
+
+# Test: --to bontext renders BONJSON as text that --from bontext rebuilds byte for byte
+echo '{"a": [1, 300, -5, 1.5, "x", null, true]}' > "$TMPDIR/bontext.json"
+./bonbon j2b "$TMPDIR/bontext.json" "$TMPDIR/bontext.boj"
+./bonbon --to bontext b2b "$TMPDIR/bontext.boj" "$TMPDIR/bontext.txt"
+sed 's/int16 300/int16 301/' "$TMPDIR/bontext.txt" > "$TMPDIR/bontext-patched.txt"
+./bonbon --from bontext b2b "$TMPDIR/bontext.txt" "$TMPDIR/bontext2.boj"
+PATCHED=$(./bonbon --from bontext --indent 0 b2j "$TMPDIR/bontext-patched.txt" - | tr -d '\n')
+if grep -q '^5 *int16 300$' "$TMPDIR/bontext.txt" && cmp -s "$TMPDIR/bontext.boj" "$TMPDIR/bontext2.boj" && \
+   [ "$PATCHED" = '{"a":[1,301,-5,1.5,"x",null,true]}' ]; then
+    pass "--to/--from bontext: reversible text rendering"
+else
+    fail "--to/--from bontext: reversible text rendering ($PATCHED)"
+fi
+
+# Test: --trace-decode logs each token with its offset, and where decoding stops
+printf '\xb8\x66a\xb7\x01\x02' > "$TMPDIR/trace-truncated.boj"
+./bonbon --trace-decode "$TMPDIR/trace.txt" b2j "$TMPDIR/trace-truncated.boj" - >/dev/null 2>&1
+if grep -q '^3 *push array$' "$TMPDIR/trace.txt" && grep -q '^5 *small 2$' "$TMPDIR/trace.txt" && \
+   grep -q '^6 *error: offset 6: unexpected EOF$' "$TMPDIR/trace.txt"; then
+    pass "--trace-decode: token trace up to the failure"
+else
+    fail "--trace-decode: token trace up to the failure"
+fi
+
+# Test: cross-check compares bonbon's decoding with another decoder's JSON output
+echo '{"a": [1, 300, 1.1]}' > "$TMPDIR/cross.json"
+./bonbon j2b "$TMPDIR/cross.json" "$TMPDIR/cross.boj"
+printf '#!/bin/sh\necho '"'"'{"a": [1, 301, 1.1]}'"'"'\n' > "$TMPDIR/cross-other.sh"
+printf '#!/bin/sh\nexec "%s/bonbon" b2j "$1" -\n' "$PWD" > "$TMPDIR/cross-same.sh"
+chmod +x "$TMPDIR/cross-other.sh" "$TMPDIR/cross-same.sh"
+CROSS=$(./bonbon cross-check --other "$TMPDIR/cross-other.sh" "$TMPDIR/cross.boj" 2>/dev/null)
+if ./bonbon cross-check --other "$TMPDIR/cross-same.sh" "$TMPDIR/cross.boj" && \
+   [ "$CROSS" = "document 0: \$.a[1]: bonbon decodes 300, $TMPDIR/cross-other.sh decodes 301" ]; then
+    pass "cross-check: agreement, and the first divergence"
+else
+    fail "cross-check: agreement, and the first divergence ($CROSS)"
+fi
+
+# Test: --empty-as gives empty input a defined output
+: > "$TMPDIR/empty.json"
+EMPTY_NULL=$(./bonbon --empty-as null --indent 0 j2j "$TMPDIR/empty.json" -)
+EMPTY_OBJECT=$(./bonbon --stream --empty-as empty-object b2j "$TMPDIR/empty.json" -)
+if [ "$EMPTY_NULL" = "null" ] && [ "$EMPTY_OBJECT" = "{}" ] && \
+   ! ./bonbon j2j "$TMPDIR/empty.json" - >/dev/null 2>&1; then
+    pass "--empty-as: null, empty-object, and the default error"
+else
+    fail "--empty-as: null, empty-object, and the default error ($EMPTY_NULL $EMPTY_OBJECT)"
+fi
+
+# Test: convert reads files and stdin in argument order into one output
+echo '{"n": 1}' > "$TMPDIR/convert-a.json"
+echo '{"n": 3}' | ./bonbon j2b - "$TMPDIR/convert-c.boj"
+CONVERTED=$(echo '{"n": 2}' | ./bonbon convert "$TMPDIR/convert-a.json" - "$TMPDIR/convert-c.boj" --wrap-array --indent 0)
+if [ "$CONVERTED" = '[{"n":1},{"n":2},{"n":3}]' ] && \
+   ! echo '{}' | ./bonbon convert - - >/dev/null 2>&1; then
+    pass "convert: mixed files and stdin, in argument order"
+else
+    fail "convert: mixed files and stdin, in argument order ($CONVERTED)"
+fi
+
+# Test: --in-fd and --out-fd use inherited file descriptors, as do /dev/fd/N paths
+echo '{"fd": true}' > "$TMPDIR/fd.json"
+./bonbon j2b --in-fd 3 --out-fd 4 3<"$TMPDIR/fd.json" 4>"$TMPDIR/fd.boj"
+FD_BACK=$(./bonbon --indent 0 b2j /dev/fd/5 - 5<"$TMPDIR/fd.boj")
+if [ "$FD_BACK" = '{"fd":true}' ] && ! ./bonbon j2b --in-fd x "$TMPDIR/fd.boj" 2>/dev/null; then
+    pass "--in-fd, --out-fd, and /dev/fd/N paths"
+else
+    fail "--in-fd, --out-fd, and /dev/fd/N paths ($FD_BACK)"
+fi
+
+# Test: CSV delimiter, quoting, decimal separator, and line endings
+echo '[{"name": "a;b", "price": 1.5}]' > "$TMPDIR/locale.json"
+LOCALE_CSV=$(./bonbon --to csv --csv-delimiter ';' --decimal-separator , --newline crlf j2j "$TMPDIR/locale.json" - | od -An -c | tr -d '\n' | tr -s ' ')
+QUOTED_CSV=$(./bonbon --to csv --csv-quote all --csv-delimiter tab j2j "$TMPDIR/locale.json" -)
+if [ "$LOCALE_CSV" = ' n a m e ; p r i c e \r \n " a ; b " ; 1 , 5 \r \n' ] && \
+   [ "$QUOTED_CSV" = "$(printf '"name"\t"price"\n"a;b"\t"1.5"')" ]; then
+    pass "CSV: --csv-delimiter, --csv-quote, --decimal-separator, --newline"
+else
+    fail "CSV: --csv-delimiter, --csv-quote, --decimal-separator, --newline ($LOCALE_CSV)"
+fi
+
+# Test: --out-template lays out batch and shard output paths
+rm -rf "$TMPDIR/tmpl-in" "$TMPDIR/tmpl-out"
+mkdir -p "$TMPDIR/tmpl-in/sub"
+echo '{"a": 1}' > "$TMPDIR/tmpl-in/sub/x.json"
+printf '{"n": 1}\n{"n": 2}\n{"n": 3}\n' > "$TMPDIR/tmpl-in/s.json"
+./bonbon --out-template '{format}/{dir}/{name}-{input_format}.{format}' j2b "$TMPDIR/tmpl-in/sub" "$TMPDIR/tmpl-out" && \
+./bonbon --stream --split-docs 2 --out-template 'parts/{name}.{shard}.{format}' j2b "$TMPDIR/tmpl-in/s.json" "$TMPDIR/tmpl-out"
+if [ -f "$TMPDIR/tmpl-out/boj/x-json.boj" ] && [ -f "$TMPDIR/tmpl-out/parts/s.00001.boj" ] && \
+   ! ./bonbon --out-template '{nope}' j2b "$TMPDIR/tmpl-in/s.json" "$TMPDIR/tmpl-out" 2>/dev/null; then
+    pass "--out-template: directory and shard layouts"
+else
+    fail "--out-template: directory and shard layouts"
+fi
+
+# Test: directory input skips symlinks unless followed, and never opens FIFOs
+rm -rf "$TMPDIR/walk-in" "$TMPDIR/walk-out"
+mkdir -p "$TMPDIR/walk-in/d"
+echo '{"a": 1}' > "$TMPDIR/walk-in/d/a.json"
+ln -s d/a.json "$TMPDIR/walk-in/alias.json"
+ln -s .. "$TMPDIR/walk-in/d/up"
+mkfifo "$TMPDIR/walk-in/fifo.json"
+WALK_SKIPPED=$(./bonbon j2b "$TMPDIR/walk-in" "$TMPDIR/walk-out" 2>&1)
+WALK_PLAIN=$(ls "$TMPDIR/walk-out")
+./bonbon --follow-symlinks j2b "$TMPDIR/walk-in" "$TMPDIR/walk-out" 2>/dev/null
+if [ "$WALK_PLAIN" = "d" ] && [ -f "$TMPDIR/walk-out/alias.boj" ] && \
+   [ "$WALK_SKIPPED" = "skipped 2 symlinks (not followed without --follow-symlinks), 1 sockets, devices, or FIFOs" ]; then
+    pass "directory input: symlink policy and special files"
+else
+    fail "directory input: symlink policy and special files ($WALK_SKIPPED)"
+fi
+
+# Test: --preserve-mode and --preserve-times copy the input's metadata
+echo '{"a": 1}' > "$TMPDIR/preserve.json"
+chmod 600 "$TMPDIR/preserve.json"
+touch -t 202001020304 "$TMPDIR/preserve.json"
+rm -f "$TMPDIR/preserve.boj"
+./bonbon --preserve-mode --preserve-times j2b "$TMPDIR/preserve.json" "$TMPDIR/preserve.boj"
+if [ "$(ls -l "$TMPDIR/preserve.boj" | cut -c1-10)" = "-rw-------" ] && \
+   [ ! "$TMPDIR/preserve.boj" -nt "$TMPDIR/preserve.json" ] && [ ! "$TMPDIR/preserve.boj" -ot "$TMPDIR/preserve.json" ]; then
+    pass "--preserve-mode and --preserve-times"
+else
+    fail "--preserve-mode and --preserve-times"
+fi
+
+# Test: --expect-sha256 verifies the input, writing nothing on a mismatch
+printf '{"n": 1}\n{"n": 2}\n' > "$TMPDIR/digest.json"
+DIGEST=$(sha256sum "$TMPDIR/digest.json" 2>/dev/null || shasum -a 256 "$TMPDIR/digest.json")
+DIGEST=${DIGEST%% *}
+rm -f "$TMPDIR/digest-ok.boj" "$TMPDIR/digest-bad.boj"
+if ./bonbon --stream --expect-sha256 "$DIGEST" j2b "$TMPDIR/digest.json" "$TMPDIR/digest-ok.boj" && \
+   ! ./bonbon --stream --expect-sha256 "$(printf '%064d' 0)" j2b "$TMPDIR/digest.json" "$TMPDIR/digest-bad.boj" 2>/dev/null && \
+   [ -f "$TMPDIR/digest-ok.boj" ] && [ ! -e "$TMPDIR/digest-bad.boj" ]; then
+    pass "--expect-sha256: match converts, mismatch writes nothing"
+else
+    fail "--expect-sha256: match converts, mismatch writes nothing"
+fi
+FILTERED=$(./bonbon --filter --stream --expect-sha256 "$(printf '%064d' 0)" j2j < "$TMPDIR/digest.json" 2>/dev/null || true)
+if [ -z "$FILTERED" ] && [ -n "$(./bonbon --filter --stream --expect-sha256 "$DIGEST" j2j < "$TMPDIR/digest.json")" ]; then
+    pass "--expect-sha256: checked in --filter mode"
+else
+    fail "--expect-sha256: checked in --filter mode ($FILTERED)"
+fi
+
+# Test: --cache-dir serves a repeated conversion from the cache
+rm -rf "$TMPDIR/cache"
+echo '{"b": 2, "a": 1}' > "$TMPDIR/cached.json"
+FIRST=$(./bonbon --cache-dir "$TMPDIR/cache" --indent 0 j2j "$TMPDIR/cached.json" -)
+for entry in "$TMPDIR"/cache/*/*; do printf '"from cache"' > "$entry"; done
+SECOND=$(./bonbon --cache-dir "$TMPDIR/cache" --indent 0 j2j "$TMPDIR/cached.json" -)
+OTHER=$(./bonbon --cache-dir "$TMPDIR/cache" --indent 0 --keys lower j2j "$TMPDIR/cached.json" -)
+if [ "$FIRST" = '{"a":1,"b":2}' ] && [ "$SECOND" = '"from cache"' ] && [ "$OTHER" = '{"a":1,"b":2}' ]; then
+    pass "--cache-dir: hit for the same conversion, miss for other options"
+else
+    fail "--cache-dir: hit for the same conversion, miss for other options ($FIRST $SECOND $OTHER)"
+fi
+
+# Test: --warnings-as-errors fails on JSON that decoding would change, naming where
+printf '{"id": 12345678901234567891}' > "$TMPDIR/warn-round.json"
+printf '{"a": 1, "a": 2}' > "$TMPDIR/warn-dup.json"
+printf '["\\ud800"]' > "$TMPDIR/warn-surrogate.json"
+WARN_ROUND=$(./bonbon --warnings-as-errors j2b "$TMPDIR/warn-round.json" "$TMPDIR/warn-round.boj" 2>&1 || true)
+if [ ! -e "$TMPDIR/warn-round.boj" ] && echo "$WARN_ROUND" | grep -q '(\$.id): number 12345678901234567891 rounded' \
+    && ! ./bonbon --warnings-as-errors j2b "$TMPDIR/warn-dup.json" "$TMPDIR/warn-dup.boj" 2>/dev/null \
+    && ! ./bonbon --warnings-as-errors j2b "$TMPDIR/warn-surrogate.json" "$TMPDIR/warn-surrogate.boj" 2>/dev/null \
+    && ./bonbon j2b "$TMPDIR/warn-dup.json" "$TMPDIR/warn-dup.boj" 2>/dev/null \
+    && ./bonbon --warnings-as-errors j2b "$TMPDIR/logs.json" "$TMPDIR/warn-ok.boj"; then
+    pass "--warnings-as-errors: fails on lossy JSON only"
+else
+    fail "--warnings-as-errors: fails on lossy JSON only"
+fi
+
+# Test: JSON decoding warnings go to stderr and the summary, and underflow to 0 is one
+printf '[1e-3000000, 0e-3000000]' > "$TMPDIR/warn-underflow.json"
+WARN_UNDERFLOW=$(./bonbon --warnings-as-errors j2j "$TMPDIR/warn-underflow.json" - 2>&1 || true)
+WARN_SUMMARY=$(./bonbon --summary j2b "$TMPDIR/warn-round.json" "$TMPDIR/warn-summary.boj" 2>&1 || true)
+if [ "$WARN_UNDERFLOW" = 'Error: warning treated as error: document 0 at offset 11 ($[0]): number 1e-3000000 rounded to 0' ] && \
+   echo "$WARN_SUMMARY" | grep -q '^warning: document 0 at offset 27 (\$.id): number 12345678901234567891 rounded' && \
+   echo "$WARN_SUMMARY" | grep -q ' warnings=1 status=ok'; then
+    pass "JSON decoding warnings: reported, counted, and underflow detected"
+else
+    fail "JSON decoding warnings: reported, counted, and underflow detected ($WARN_UNDERFLOW / $WARN_SUMMARY)"
+fi
+
+# Test: transforms report the same key collision on every run, whatever Go's map order
+printf '{"fooBar": 1, "foo_bar": 2, "FooBar": 3, "x": {"aB": 1, "a_b": 2}}' > "$TMPDIR/keycase-collide.json"
+KEYCASE_ERRORS=$(for i in 1 2 3 4 5 6 7 8; do ./bonbon --keys snake j2j "$TMPDIR/keycase-collide.json" - 2>&1 || true; done | sort -u)
+if [ "$KEYCASE_ERRORS" = 'Error: cannot convert "FooBar" to "foo_bar" at $: key already exists' ]; then
+    pass "--keys: reports the same collision every run"
+else
+    fail "--keys: reports the same collision every run"
+fi
+
+# Test: --offset-map lists the byte range of every value of BONJSON output
+printf '{"a":[1,"xy"],"b":{"c":null}}\n[true]\n' > "$TMPDIR/offsetmap.json"
+./bonbon --stream --offset-map "$TMPDIR/offsetmap.map.json" j2b "$TMPDIR/offsetmap.json" "$TMPDIR/offsetmap.boj"
+OFFSET_MAP=$(tr -d ' \n' < "$TMPDIR/offsetmap.map.json")
+if echo "$OFFSET_MAP" | grep -q '{"document":0,"path":"\$.a\[1\]","kind":"string","offset":5,"size":3}' \
+    && echo "$OFFSET_MAP" | grep -q '{"document":1,"path":"\$\[0\]","kind":"bool","offset":18,"size":1}' \
+    && ! ./bonbon --offset-map "$TMPDIR/offsetmap-json.map.json" j2j "$TMPDIR/offsetmap.json" "$TMPDIR/offsetmap.out.json" 2>/dev/null; then
+    pass "--offset-map: maps output values to byte ranges"
+else
+    fail "--offset-map: maps output values to byte ranges"
+fi
+
+# Test: detection ranks formats on ambiguity and names foreign signatures
+printf '5\n' > "$TMPDIR/ambiguous"
+printf '\037\213\010\000' > "$TMPDIR/foreign.gz"
+if ./bonbon doctor "$TMPDIR/ambiguous" | grep -q "content is json; also valid as bonjson (by structure)" \
+    && ./bonbon convert "$TMPDIR/foreign.gz" --out "$TMPDIR/foreign.boj" 2>&1 | grep -q "gzip-compressed data, which bonbon does not read"; then
+    pass "detection: ranked ambiguity and foreign signatures"
+else
+    fail "detection: ranked ambiguity and foreign signatures"
+fi
+
+# Test: --detect-budget bounds the trial parse of format detection
+printf '[1, 2, 3, 4, 5, 6, 7, 8, 9, 10]\n]]]' > "$TMPDIR/budget.json"
+if ./bonbon --detect-budget 16 doctor "$TMPDIR/budget.json" | grep -q "content is json, as the name says; also valid as bonjson (by structure of the first 16 bytes)" \
+    && ./bonbon doctor "$TMPDIR/budget.json" | grep -q "content is bonjson, but the name says json" \
+    && ! ./bonbon --detect-budget 0 doctor "$TMPDIR/budget.json" 2>/dev/null; then
+    pass "--detect-budget: bounds detection's trial parse"
+else
+    fail "--detect-budget: bounds detection's trial parse"
+fi
+
+# Test: sizes that overflow an int64 are refused, on the command line and in the config file
+printf -- '--detect-budget 9223372037GB\n' > "$TMPDIR/overflow.config"
+if ! ./bonbon --stream --split-size 9223372036854775807K j2b "$TMPDIR/five.json" "$TMPDIR/overflow.boj" 2>/dev/null \
+    && ! BONBON_CONFIG="$TMPDIR/overflow.config" ./bonbon doctor "$TMPDIR/budget.json" >/dev/null 2>&1 \
+    && ./bonbon --detect-budget 9223372036GB doctor "$TMPDIR/budget.json" >/dev/null; then
+    pass "sizes: overflow refused"
+else
+    fail "sizes: overflow refused"
+fi
+
+# Test: --prefer picks the format of input valid as both, also from the config file
+printf '5' > "$TMPDIR/five.dat"
+./bonbon convert "$TMPDIR/five.dat" --out "$TMPDIR/five-json.json"
+./bonbon --prefer bonjson convert "$TMPDIR/five.dat" --out "$TMPDIR/five-bonjson.json"
+printf '# defaults\n--prefer bonjson\n' > "$TMPDIR/prefer.config"
+PREFER_CONFIG=$(BONBON_CONFIG="$TMPDIR/prefer.config" ./bonbon doctor "$TMPDIR/five.dat" | grep format)
+PREFER_OVERRIDE=$(BONBON_CONFIG="$TMPDIR/prefer.config" ./bonbon --prefer json doctor "$TMPDIR/five.dat" | grep format)
+if [ "$(cat "$TMPDIR/five-json.json")" = "5" ] && [ "$(cat "$TMPDIR/five-bonjson.json")" = "53" ] \
+    && echo "$PREFER_CONFIG" | grep -q "content is bonjson; also valid as json" \
+    && echo "$PREFER_OVERRIDE" | grep -q "content is json; also valid as bonjson"; then
+    pass "--prefer: favors a format, from the command line or the config file"
+else
+    fail "--prefer: favors a format, from the command line or the config file"
+fi
+printf -- '--keep $.a\n' > "$TMPDIR/keep.config"
+if ! BONBON_CONFIG="$TMPDIR/keep.config" ./bonbon j2j "$TMPDIR/five.dat" - >/dev/null 2>&1; then
+    pass "config file: rejects options other than defaults"
+else
+    fail "config file: rejects options other than defaults"
+fi
+
+# Test: --summary prints a key=value line after each conversion
+printf '{"a": [1, 2, 3]}' > "$TMPDIR/summary.json"
+SUMMARY=$(./bonbon --summary j2b "$TMPDIR/summary.json" "$TMPDIR/summary.boj" 2>&1)
+SUMMARY_OUT=$(wc -c < "$TMPDIR/summary.boj" | tr -d ' ')
+if echo "$SUMMARY" | grep -q "^summary: input=$TMPDIR/summary.json input_format=json output_format=bonjson bytes_in=16 bytes_out=$SUMMARY_OUT ratio=[0-9.]* duration_ms=[0-9.]* warnings=0 status=ok$"; then
+    pass "--summary: one key=value line per conversion"
+else
+    fail "--summary: one key=value line per conversion"
+fi
+
+# Test: --summary counts the bytes written from the conversion cache
+mkdir -p "$TMPDIR/summary-cache"
+./bonbon --cache-dir "$TMPDIR/summary-cache" j2b "$TMPDIR/summary.json" "$TMPDIR/summary-cached.boj" 2>/dev/null
+CACHED=$(ls "$TMPDIR/summary-cache")
+SUMMARY=$(./bonbon --summary --cache-dir "$TMPDIR/summary-cache" j2b "$TMPDIR/summary.json" "$TMPDIR/summary-cached.boj" 2>&1)
+if [ "$(ls "$TMPDIR/summary-cache")" = "$CACHED" ] && echo "$SUMMARY" | grep -q " bytes_out=$SUMMARY_OUT "; then
+    pass "--summary: counts output from the cache"
+else
+    fail "--summary: counts output from the cache ($SUMMARY)"
+fi
+
+# Test: validate --report writes JUnit XML and SARIF reports of the violations
+JUNIT=$(./bonbon --stream --schema "$TMPDIR/validate-schema.json" --report junit validate "$TMPDIR/validate.json" 2>/dev/null || true)
+SARIF=$(./bonbon --stream --schema "$TMPDIR/validate-schema.json" --report sarif validate "$TMPDIR/validate.boj" 2>/dev/null || true)
+if echo "$JUNIT" | grep -q '<testsuite name=".*validate.json" tests="3" failures="2">' && \
+   echo "$JUNIT" | grep -q '<failure message="2 schema violations" type="schema">' && \
+   echo "$SARIF" | grep -q '"version": "2.1.0"' && \
+   echo "$SARIF" | grep -q '"byteOffset": 28' && \
+   echo "$SARIF" | grep -q '"fullyQualifiedName": "$.tags\[1\]"' && \
+   ! ./bonbon --stream --schema "$TMPDIR/validate-schema.json" --report junit validate "$TMPDIR/validate.json" >/dev/null 2>&1; then
+    pass "validate --report: JUnit XML and SARIF"
+else
+    fail "validate --report: JUnit XML and SARIF ($JUNIT $SARIF)"
+fi
+
+# Test: --target-profile embedded fails, listing each value beyond its limits
+printf '{"id": 7, "ratio": 2.5, "tags": [["a"]]}\n' > "$TMPDIR/embedded.json"
+EMBEDDED=$(./bonbon --target-profile embedded j2b "$TMPDIR/embedded.json" "$TMPDIR/embedded.boj" 2>&1 || true)
+if [ "$EMBEDDED" = 'document 0: $.ratio: number 2.5 is not an integer of at most 64 bits
+Error: 1 values cannot be represented within the embedded target profile' ] && [ ! -e "$TMPDIR/embedded.boj" ] && \
+   echo '{"id": 7, "tags": [["a"]]}' | ./bonbon --target-profile embedded j2b - "$TMPDIR/embedded.boj"; then
+    pass "--target-profile embedded: values beyond the profile"
+else
+    fail "--target-profile embedded: values beyond the profile ($EMBEDDED)"
+fi
+FILTERED=$(./bonbon --filter --target-profile embedded j2b < "$TMPDIR/embedded.json" 2>/dev/null || true)
+if [ -z "$FILTERED" ] && ! ./bonbon --filter --target-profile embedded j2b < "$TMPDIR/embedded.json" >/dev/null 2>&1; then
+    pass "--target-profile embedded: checked in --filter mode"
+else
+    fail "--target-profile embedded: checked in --filter mode"
+fi
+
+# Test: --encode-* options configure the BONJSON encoder
+NUL_OUT=$(echo '{"a": "x\u0000y"}' | ./bonbon --encode-nul j2b - - | od -An -tx1 | tr -d ' \n')
+if [ "$NUL_OUT" = "b8666168780079b6" ] && \
+   ! echo '{"a": "x\u0000y"}' | ./bonbon j2b - - >/dev/null 2>&1 && \
+   ! echo '{"a": [[1]]}' | ./bonbon --encode-max-depth 2 j2b - - >/dev/null 2>&1 && \
+   echo '{"a": [1]}' | ./bonbon --encode-max-depth 2 j2b - - >/dev/null && \
+   ! ./bonbon --encode-max-depth -1 j2b - - </dev/null >/dev/null 2>&1; then
+    pass "--encode-*: BONJSON encoder options"
+else
+    fail "--encode-*: BONJSON encoder options ($NUL_OUT)"
+fi
+
+# Test: --snapshots keeps a snapshot per change, and history finds the one in effect at a time
+echo '{"b": 1, "a": 2}' > "$TMPDIR/snapcfg.json"
+./bonbon --snapshots "$TMPDIR/snaps" j2b "$TMPDIR/snapcfg.json" "$TMPDIR/snapcfg.boj"
+./bonbon --snapshots "$TMPDIR/snaps" j2b "$TMPDIR/snapcfg.json" "$TMPDIR/snapcfg.boj"
+echo '{"b": 3}' > "$TMPDIR/snapcfg.json"
+./bonbon --snapshots "$TMPDIR/snaps" j2b "$TMPDIR/snapcfg.json" "$TMPDIR/snapcfg.boj"
+SNAPSHOTS=$(./bonbon --snapshots "$TMPDIR/snaps" history "$TMPDIR/snapcfg.json" | wc -l | tr -d ' ')
+FIRST=$(./bonbon --snapshots "$TMPDIR/snaps" history "$TMPDIR/snapcfg.json" | head -1 | cut -d' ' -f1)
+AT_FIRST=$(./bonbon --snapshots "$TMPDIR/snaps" --at "$FIRST" --indent 0 history "$TMPDIR/snapcfg.json" 2>&1 || true)
+LATEST=$(./bonbon --snapshots "$TMPDIR/snaps" --at 2999-01-01 --indent 0 history "$TMPDIR/snapcfg.json" 2>&1 || true)
+if [ "$SNAPSHOTS" = "2" ] && [ "$AT_FIRST" = '{"a":2,"b":1}' ] && [ "$LATEST" = '{"b":3}' ] && \
+   ! ./bonbon --snapshots "$TMPDIR/snaps" --at 2000-01-01 history "$TMPDIR/snapcfg.json" >/dev/null 2>&1; then
+    pass "--snapshots: history of a file's changes"
+else
+    fail "--snapshots: history of a file's changes ($SNAPSHOTS $AT_FIRST $LATEST)"
+fi
+
+# Test: schema compat reports breaking changes by mode
+printf '{"type": "object", "required": ["id"], "properties": {"id": {"type": "integer", "minimum": 1}, "kind": {"enum": ["a", "b"]}}}' > "$TMPDIR/compat-old.json"
+printf '{"type": "object", "required": ["id", "name"], "properties": {"id": {"type": "integer", "minimum": 10}, "name": {"type": "string"}, "kind": {"enum": ["a", "b", "c"]}}}' > "$TMPDIR/compat-new.json"
+BACKWARD=$(./bonbon schema compat "$TMPDIR/compat-old.json" "$TMPDIR/compat-new.json" 2>/dev/null || true)
+FORWARD=$(./bonbon --mode forward schema compat "$TMPDIR/compat-old.json" "$TMPDIR/compat-new.json" 2>/dev/null || true)
+if [ "$BACKWARD" = 'backward: $: new schema requires the key "name"
+backward: $.id: new schema raises the minimum from 1 to 10
+backward: $.name: new schema only accepts string' ] && \
+   [ "$FORWARD" = 'forward: $.kind: old schema rejects the value "c"' ] && \
+   ./bonbon --mode full schema compat "$TMPDIR/compat-old.json" "$TMPDIR/compat-old.json"; then
+    pass "schema compat: breaking changes by mode"
+else
+    fail "schema compat: breaking changes by mode ($BACKWARD / $FORWARD)"
+fi
+
+# Test: --redact-rules removes, masks, and replaces values, with counts per rule
+cat > "$TMPDIR/redact-rules.json" <<'RULES'
+{"rules": [
+    {"name": "ssn", "key": "ssn", "strategy": "remove"},
+    {"name": "email", "path": "$.user.email", "strategy": "mask"},
+    {"name": "phones", "match": "[0-9]{3}-[0-9]{4}", "strategy": "replace", "replacement": "[PHONE]"}
+]}
+RULES
+printf '{"user": {"email": "ann@x.io", "ssn": "123", "note": "call 555-1234"}, "list": [{"ssn": 1}]}\n' > "$TMPDIR/redact.json"
+REDACTED=$(./bonbon --stream --indent 0 --redact-rules "$TMPDIR/redact-rules.json" j2j "$TMPDIR/redact.json" - 2>"$TMPDIR/redact-audit.txt" || true)
+if [ "$REDACTED" = '{"list":[{}],"user":{"email":"********","note":"call [PHONE]"}}' ] && \
+   [ "$(cat "$TMPDIR/redact-audit.txt")" = 'redacted 2 values by rule "ssn" (remove)
+redacted 1 values by rule "email" (mask)
+redacted 1 values by rule "phones" (replace)' ] && \
+   ! ./bonbon --redact-rules "$TMPDIR/redact.json" j2j "$TMPDIR/redact.json" - >/dev/null 2>&1; then
+    pass "--redact-rules: rule-based redaction with audit counts"
+else
+    fail "--redact-rules: rule-based redaction with audit counts ($REDACTED)"
+fi
+
+# Test: --encrypt-paths encrypts selected values and --decrypt restores them exactly
+export BONBON_TEST_KEY=$(printf '0123456789abcdef0123456789abcdef' | base64)
+export BONBON_OTHER_KEY=$(printf 'fedcba9876543210' | base64)
+printf '{"ssn": "123-45-6789", "card": {"n": 4111}, "name": "Ann"}' > "$TMPDIR/encrypt.json"
+./bonbon --encrypt-paths '$.ssn,$.card' --key-env BONBON_TEST_KEY j2b "$TMPDIR/encrypt.json" "$TMPDIR/encrypt.boj"
+ENCRYPTED=$(./bonbon --indent 0 b2j "$TMPDIR/encrypt.boj" - 2>&1 || true)
+DECRYPTED=$(./bonbon --indent 0 --decrypt --key-env BONBON_TEST_KEY b2j "$TMPDIR/encrypt.boj" - 2>&1 || true)
+if echo "$ENCRYPTED" | grep -q '"ssn":{"\$encrypted":"' && ! echo "$ENCRYPTED" | grep -q '123-45' && \
+   [ "$DECRYPTED" = '{"card":{"n":4111},"name":"Ann","ssn":"123-45-6789"}' ] && \
+   ! ./bonbon --decrypt --key-env BONBON_OTHER_KEY b2j "$TMPDIR/encrypt.boj" - >/dev/null 2>&1 && \
+   ! ./bonbon --decrypt b2j "$TMPDIR/encrypt.boj" - >/dev/null 2>&1; then
+    pass "--encrypt-paths/--decrypt: field-level AES-GCM"
+else
+    fail "--encrypt-paths/--decrypt: field-level AES-GCM ($ENCRYPTED / $DECRYPTED)"
+fi
+
+# Test: lint-expr checks transform options and patch files against a sample
+printf '{"user": {"mail": "a@b"}, "price": "12", "qty": [1, 2]}' > "$TMPDIR/lint-sample.json"
+printf '[{"op": "remove", "path": "/legacy"}]' > "$TMPDIR/lint-patch.json"
+LINTED=$(./bonbon lint-expr --against "$TMPDIR/lint-sample.json" --rename '$.user.mail=email' --scale '$.user.email*2' --scale '$.price*100' "$TMPDIR/lint-patch.json" 2>/dev/null || true)
+if [ "$LINTED" = "--scale \$.user.email*2: \$.user.email is a string, not a number or an array of numbers
+--scale \$.price*100: \$.price is a string, not a number or an array of numbers
+$TMPDIR/lint-patch.json: operation 0: key \"legacy\" not found" ] && \
+   ./bonbon lint-expr --against "$TMPDIR/lint-sample.json" --rename '$.user.mail=email' --scale '$.qty*2' --keep '$.user,$.qty' >/dev/null; then
+    pass "lint-expr: dry-run of transform expressions"
+else
+    fail "lint-expr: dry-run of transform expressions ($LINTED)"
+fi
+
+# Test: --assert fails at the first value of the wrong type, with its offset and path
+printf '{"id": 1, "tags": ["a"]}\n{"id": 2, "tags": ["b", 3]}\n' > "$TMPDIR/assert.json"
+ASSERTED=$(./bonbon --stream --assert '$.id:int' --assert '$.tags:array<string>' j2j "$TMPDIR/assert.json" - 2>&1 || true)
+./bonbon --stream j2b "$TMPDIR/assert.json" "$TMPDIR/assert.boj"
+ASSERTED_BONJSON=$(./bonbon --stream --assert '$.name:string' b2j "$TMPDIR/assert.boj" - 2>&1 || true)
+if [ "$ASSERTED" = 'Error: assertion $.tags:array<string> failed: document 1 at offset 49 ($.tags[1]): expected string, found int' ] && \
+   [ "$ASSERTED_BONJSON" = 'Error: assertion $.name:string failed: document 0 at offset 0 ($.name): expected string, found nothing' ] && \
+   ./bonbon --stream --assert '$.id:number' --assert '$.tags:array' j2j "$TMPDIR/assert.json" - >/dev/null; then
+    pass "--assert: per-path type assertions"
+else
+    fail "--assert: per-path type assertions ($ASSERTED / $ASSERTED_BONJSON)"
+fi
+
 # Summary
 echo ""
 echo "Results: $PASS passed, $FAIL failed"
@@ -0,0 +1,166 @@
+// ABOUTME: Time windows over document streams (--since, --until): only documents stamped within them are converted.
+// ABOUTME: Documents outside the window are skipped by the wire scanner, without being decoded.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"slices"
+	"time"
+)
+
+// defaultTimePath is where --since and --until look for a document's
+// timestamp unless --time-path says otherwise.
+var defaultTimePath = path{{key: "timestamp"}}
+
+// timeLayouts are the forms of time --since, --until, and timestamps in
+// documents may take.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// timeBound is a --since or --until bound: a time, or a number to compare
+// numeric timestamps with as they are, whatever their unit.
+type timeBound struct {
+	time   time.Time
+	number *big.Rat
+}
+
+// parseTimeBound parses a --since or --until value: an RFC 3339 time, a
+// date-time without a zone (taken as UTC), a date, or a number.
+func parseTimeBound(s string) (*timeBound, error) {
+	if number, ok := new(big.Rat).SetString(s); ok {
+		return &timeBound{number: number}, nil
+	}
+	if t, ok := parseTimestamp(s); ok {
+		return &timeBound{time: t}, nil
+	}
+	return nil, fmt.Errorf("invalid time %q: expected RFC 3339 (2024-05-01T12:00:00Z), a date (2024-05-01), or a number", s)
+}
+
+func parseTimestamp(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// compare compares the decoded timestamp stamp with b, and reports whether
+// they can be compared. A bound that is a number compares with numeric
+// stamps only. A bound that is a time compares with stamps that are strings
+// in one of timeLayouts, and with numeric stamps as Unix seconds.
+func (b *timeBound) compare(stamp any) (int, bool) {
+	if b.number != nil {
+		n, _, ok := exactNumber(stamp)
+		if !ok {
+			return 0, false
+		}
+		return n.Cmp(b.number), true
+	}
+	if s, ok := stamp.(string); ok {
+		t, ok := parseTimestamp(s)
+		if !ok {
+			return 0, false
+		}
+		return t.Compare(b.time), true
+	}
+	n, _, ok := exactNumber(stamp)
+	if !ok {
+		return 0, false
+	}
+	seconds, _ := n.Float64()
+	whole := math.Floor(seconds)
+	return time.Unix(int64(whole), int64((seconds-whole)*1e9)).Compare(b.time), true
+}
+
+// inTimeWindow reports whether a document whose timestamp is stamp, if found,
+// is within --since (inclusive) and --until (exclusive). A document without
+// a timestamp that compares with the bounds is not.
+func inTimeWindow(stamp any, found bool, opts *options) bool {
+	if !found {
+		return false
+	}
+	if opts.since != nil {
+		if c, ok := opts.since.compare(stamp); !ok || c < 0 {
+			return false
+		}
+	}
+	if opts.until != nil {
+		if c, ok := opts.until.compare(stamp); !ok || c >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// timeFiltered reports whether --since or --until is set.
+func (opts *options) timeFiltered() bool {
+	return opts.since != nil || opts.until != nil
+}
+
+// filterTimeWindow returns a reader of the BONJSON stream r without the
+// documents outside the time window, found by scanning r with a wireScanner,
+// which only captures the scalars it passes over. Record definitions are
+// kept whatever follows them, since later documents may use them. A scan
+// error ends the returned stream with that error, after the documents
+// before it.
+func filterTimeWindow(r io.Reader, opts *options) io.Reader {
+	timePath := opts.timePath
+	if timePath == nil {
+		timePath = defaultTimePath
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		// pending holds the bytes the scanner has read from base on that
+		// have not been passed on or dropped yet.
+		var pending bytes.Buffer
+		base := int64(0)
+		var stamp any
+		found := false
+		var writeErr error
+		scanner := newWireScanner(io.TeeReader(r, &pending), 0, func(v scannedValue) {
+			if v.depth > 0 {
+				if !found && v.raw != nil && slices.Equal(v.path, timePath) {
+					stamp, found = decodeScalar(v.raw, opts)
+				}
+				return
+			}
+			keep := v.kind == kindRecordDef || inTimeWindow(stamp, found, opts)
+			stamp, found = nil, false
+			// Bytes before this value (none, in a well-formed stream) are
+			// passed on with it or dropped with it.
+			n := int(v.offset + v.size - base)
+			chunk := pending.Next(n)
+			base += int64(n)
+			if keep && writeErr == nil {
+				_, writeErr = pw.Write(chunk)
+			}
+		})
+		scanner.captureScalars = true
+		for writeErr == nil {
+			err := scanner.scanDocument()
+			if err == io.EOF {
+				pw.Close()
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// decodeScalar decodes the BONJSON encoding of a scalar value.
+func decodeScalar(raw []byte, opts *options) (any, bool) {
+	var value any
+	if err := newBONJSONDecoder(bytes.NewReader(raw), opts).Decode(&value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
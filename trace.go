@@ -0,0 +1,23 @@
+// ABOUTME: The --trace-decode option: a log of every token of the BONJSON input, for debugging decoder discrepancies.
+// ABOUTME: Each line holds a token's offset, type, and value preview, with containers pushed and popped.
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// traceDecode writes a trace of the BONJSON documents in data from offset
+// start to w: one line per token with its input offset, type, and a preview
+// of its value, and a push and a pop event for each container. Reading
+// stops at the first token that cannot be read, which the last line of the
+// trace reports, so the trace shows how far the input decodes.
+func traceDecode(w io.Writer, data []byte, start int) error {
+	r := &bontextRenderer{data: data, pos: start, trace: true}
+	if err := r.documents(); err != nil {
+		fmt.Fprintf(&r.out, "%-8d error: %v\n", r.pos, err)
+	}
+	_, err := w.Write(r.out.Bytes())
+	return err
+}
@@ -0,0 +1,167 @@
+// ABOUTME: Transformation pipeline applied to decoded documents before encoding.
+// ABOUTME: Each enabled transform runs over each document in a fixed order.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// transformDocuments applies the transforms enabled in opts to every
+// document, in place where possible, and returns the resulting documents.
+// inputPath is the path the documents were read from ("-" for stdin).
+func transformDocuments(docs []any, inputPath string, opts *options) ([]any, error) {
+	dir := transformDir(inputPath)
+	var total transformCounts
+	for i, doc := range docs {
+		transformed, counts, err := transformDocument(doc, dir, opts)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = transformed
+		total.add(counts)
+	}
+	total.report(opts)
+	return docs, nil
+}
+
+// transformCounts counts what the transforms changed, for reporting.
+type transformCounts struct {
+	omittedNulls int64
+	anonymized   int64
+	redacted     []int64 // by redaction rule
+}
+
+func (c *transformCounts) add(other transformCounts) {
+	c.omittedNulls += other.omittedNulls
+	c.anonymized += other.anonymized
+	if c.redacted == nil && other.redacted != nil {
+		c.redacted = make([]int64, len(other.redacted))
+	}
+	for i, n := range other.redacted {
+		c.redacted[i] += n
+	}
+}
+
+// report prints the counts of the enabled transforms to stderr.
+func (c *transformCounts) report(opts *options) {
+	if opts.omitNulls {
+		fmt.Fprintf(os.Stderr, "omitted %d null-valued keys\n", c.omittedNulls)
+	}
+	if len(opts.anonymizeFields) > 0 {
+		fmt.Fprintf(os.Stderr, "anonymized %d values\n", c.anonymized)
+	}
+	if opts.redactRules != nil {
+		printRedactAudit(os.Stderr, opts.redactRules, c.redacted)
+	}
+}
+
+// transformDocument applies the transforms enabled in opts to one document
+// and returns the result along with counts of what was changed.
+// dir is the directory that relative $include paths are resolved against.
+// It is safe to call concurrently for different documents.
+func transformDocument(doc any, dir string, opts *options) (any, transformCounts, error) {
+	var counts transformCounts
+	if opts.decrypt {
+		decrypted, err := decryptFields(doc, nil, opts.fieldKey, opts)
+		if err != nil {
+			return nil, counts, err
+		}
+		doc = decrypted
+	}
+
+	if opts.resolveRefs {
+		resolved, err := resolveIncludes(doc, dir, nil)
+		if err != nil {
+			return nil, counts, err
+		}
+		if doc, err = resolveRefs(resolved, resolved, nil); err != nil {
+			return nil, counts, err
+		}
+	}
+
+	if opts.expandEnv {
+		expanded, err := expandEnv(doc, nil, opts.strictEnv)
+		if err != nil {
+			return nil, counts, fmt.Errorf("expanding environment variables: %w", err)
+		}
+		doc = expanded
+	}
+
+	if opts.decimals == "tag" {
+		untagged, err := untagDecimals(doc, nil)
+		if err != nil {
+			return nil, counts, err
+		}
+		doc = untagged
+	}
+
+	if opts.keep != nil {
+		doc = keepPaths(doc, opts.keep)
+	}
+
+	if opts.normalization != "" {
+		normalized, err := normalizeStrings(doc, nil, normalizationForms[opts.normalization])
+		if err != nil {
+			return nil, counts, fmt.Errorf("normalizing strings: %w", err)
+		}
+		doc = normalized
+	}
+
+	for _, r := range opts.renames {
+		if err := applyRename(doc, r); err != nil {
+			return nil, counts, err
+		}
+	}
+
+	if opts.keyCase != "" {
+		if err := convertKeyCase(doc, nil, opts.keyCase); err != nil {
+			return nil, counts, err
+		}
+	}
+
+	for _, scale := range opts.scales {
+		if err := applyScale(doc, scale); err != nil {
+			return nil, counts, err
+		}
+	}
+
+	if opts.defaults != nil {
+		doc = deepMerge(cloneValue(opts.defaults), doc, opts.nullsAsAbsent)
+	}
+
+	if opts.redactRules != nil {
+		counts.redacted = make([]int64, len(opts.redactRules))
+		doc = redact(doc, opts.redactRules, counts.redacted)
+	}
+
+	if len(opts.anonymizeFields) > 0 {
+		n, err := anonymize(doc, opts.anonymizeFields, opts.anonymizeKey)
+		counts.anonymized = int64(n)
+		if err != nil {
+			return nil, counts, err
+		}
+	}
+
+	if opts.omitNulls {
+		counts.omittedNulls = int64(omitNulls(doc))
+	}
+
+	if opts.encryptPaths != nil {
+		if err := encryptFields(doc, opts.encryptPaths, opts.fieldKey, opts); err != nil {
+			return nil, counts, err
+		}
+	}
+	return doc, counts, nil
+}
+
+// transformDir returns the directory that relative $include paths in
+// documents read from inputPath are resolved against.
+func transformDir(inputPath string) string {
+	if inputPath == "-" {
+		return "."
+	}
+	return filepath.Dir(inputPath)
+}
@@ -0,0 +1,294 @@
+// ABOUTME: The validate command: checks documents against a JSON Schema.
+// ABOUTME: BONJSON input is validated on the wire scanner's token stream, so it is never decoded into a tree.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// schemaValidator checks values against a schema as they are visited, each
+// container after its members, as a wireScanner visits them.
+type schemaValidator struct {
+	root *typeSchema
+	out  io.Writer
+	// members holds, for each depth, the keys and the count of the members
+	// visited since the last container there, so that a container finds its
+	// own members at the depth below it.
+	members    []validatorMembers
+	document   int64
+	offset     int64 // of the value being visited in BONJSON input, or -1
+	violations int64
+	documents  int64
+	// recorded holds the violations for a --report, which are then not
+	// printed.
+	recorded []schemaViolation
+	record   bool
+}
+
+type validatorMembers struct {
+	keys  []string
+	count int
+}
+
+// runValidate validates each document of the input at inputPath, JSON or
+// BONJSON by file extension (stdin is JSON), against the schema, printing
+// each violation to stdout, or a --report of them all at the end. It fails
+// if any document does not validate.
+// BONJSON input is checked on its token stream, decoding only the scalars
+// that the schema constrains, so it can be far larger than memory.
+func runValidate(inputPath string, schema *typeSchema, opts *options) error {
+	var r io.Reader = os.Stdin
+	if inputPath != "-" {
+		f, err := os.Open(inputPath)
+		if err != nil {
+			return fmt.Errorf("reading input file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	progress.begin(inputPath)
+	r = progressReader{r}
+	sv := &schemaValidator{root: schema, out: os.Stdout, offset: -1, record: opts.report != ""}
+
+	if !isBONJSONPath(inputPath) {
+		err := decodeStream(bufio.NewReader(r), true, opts, func(seq int, doc any) bool {
+			sv.document = int64(seq)
+			sv.walk(doc, 0, nil)
+			sv.documents++
+			progress.documents.Add(1)
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+		return sv.result(inputPath, opts)
+	}
+
+	scanner := newWireScanner(r, 0, func(v scannedValue) {
+		if v.kind == kindKey || v.kind == kindRecordDef {
+			return
+		}
+		sv.offset = v.offset
+		sv.visit(v.kind, v.depth, v.path, func() (any, bool) {
+			return decodeScalar(v.raw, opts)
+		})
+	})
+	scanner.captureScalars = true
+	for ; ; sv.document++ {
+		err := scanner.scanDocument()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid BONJSON: document %d: %w", sv.document, err)
+		}
+		sv.documents++
+		progress.documents.Add(1)
+		if !opts.stream {
+			if !scanner.atEOF() {
+				return fmt.Errorf("invalid BONJSON: trailing data at offset %d", scanner.offset)
+			}
+			break
+		}
+	}
+	return sv.result(inputPath, opts)
+}
+
+// result reports the outcome of the validation, writing the --report if
+// one was asked for.
+func (sv *schemaValidator) result(inputPath string, opts *options) error {
+	if sv.record {
+		if err := writeValidateReport(sv.out, opts.report, inputPath, sv.documents, sv.recorded); err != nil {
+			return fmt.Errorf("writing report: %w", err)
+		}
+	}
+	if sv.violations > 0 {
+		return fmt.Errorf("%d schema violations", sv.violations)
+	}
+	return nil
+}
+
+// walk visits the decoded value v, and first its members, in key order.
+func (sv *schemaValidator) walk(v any, depth int, at path) {
+	kind := kindNull
+	switch v := v.(type) {
+	case bool:
+		kind = kindBool
+	case string:
+		kind = kindString
+	case map[string]any:
+		kind = kindObject
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			sv.walk(v[key], depth+1, append(at, pathSegment{key: key}))
+		}
+	case []any:
+		kind = kindArray
+		for i, elem := range v {
+			sv.walk(elem, depth+1, append(at, pathSegment{index: i, isIndex: true}))
+		}
+	case nil:
+	default:
+		kind = kindFloat
+	}
+	sv.visit(kind, depth, at, func() (any, bool) { return v, true })
+}
+
+// visit checks one value of kind at path at, whose members, if it is a
+// container, have already been visited. value decodes a scalar, for the
+// checks that need it.
+func (sv *schemaValidator) visit(kind valueKind, depth int, at path, value func() (any, bool)) {
+	for len(sv.members) <= depth+1 {
+		sv.members = append(sv.members, validatorMembers{})
+	}
+	own := sv.members[depth+1]
+	sv.members[depth+1] = validatorMembers{}
+
+	if depth > 0 {
+		members := &sv.members[depth]
+		members.count++
+		if last := at[len(at)-1]; !last.isIndex {
+			members.keys = append(members.keys, last.key)
+			if parent := sv.schemaAt(at[:len(at)-1]); parent != nil && parent.closed && parent.properties[last.key] == nil {
+				sv.report(at, "unexpected key")
+			}
+		}
+	}
+	s := sv.schemaAt(at)
+	if s == nil {
+		return
+	}
+
+	var decoded any
+	needsValue := s.enum != nil || s.dateTime || s.minimum != nil || s.maximum != nil || s.minLength != nil || s.maxLength != nil ||
+		kind == kindFloat || kind == kindBigNumber
+	if needsValue && kind != kindObject && kind != kindArray && kind != kindTypedArray {
+		var ok bool
+		if decoded, ok = value(); !ok {
+			sv.report(at, "cannot decode %s value", kind)
+			return
+		}
+	}
+
+	if typ := schemaTypeOf(kind, decoded); s.types != nil && !slices.Contains(s.types, typ) &&
+		!(typ == "integer" && slices.Contains(s.types, "number")) {
+		sv.report(at, "expected %s, got %s", strings.Join(s.types, " or "), typ)
+		return
+	}
+	if s.enum != nil && kind != kindObject && kind != kindArray && kind != kindTypedArray {
+		encoded, _ := json.Marshal(decoded)
+		if !slices.ContainsFunc(s.enum, func(e any) bool {
+			option, _ := json.Marshal(e)
+			return string(option) == string(encoded)
+		}) {
+			sv.report(at, "%s is not one of the allowed values", encoded)
+		}
+	}
+	switch kind {
+	case kindInt, kindFloat, kindBigNumber:
+		n, _, ok := exactNumber(decoded)
+		if !ok {
+			break
+		}
+		if s.minimum != nil && n.Cmp(s.minimum) < 0 {
+			sv.report(at, "%s is less than the minimum %s", n.RatString(), s.minimum.RatString())
+		}
+		if s.maximum != nil && n.Cmp(s.maximum) > 0 {
+			sv.report(at, "%s is greater than the maximum %s", n.RatString(), s.maximum.RatString())
+		}
+	case kindString:
+		str, _ := decoded.(string)
+		length := utf8.RuneCountInString(str)
+		if s.minLength != nil && length < *s.minLength {
+			sv.report(at, "string of length %d is shorter than %d", length, *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			sv.report(at, "string of length %d is longer than %d", length, *s.maxLength)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, str); s.dateTime && err != nil {
+			sv.report(at, "%q is not an RFC 3339 date-time", str)
+		}
+	case kindObject:
+		for _, key := range s.required {
+			if !slices.Contains(own.keys, key) {
+				sv.report(at, "missing required key %q", key)
+			}
+		}
+	case kindArray:
+		if s.minItems != nil && own.count < *s.minItems {
+			sv.report(at, "array of %d items has fewer than %d", own.count, *s.minItems)
+		}
+		if s.maxItems != nil && own.count > *s.maxItems {
+			sv.report(at, "array of %d items has more than %d", own.count, *s.maxItems)
+		}
+	}
+}
+
+// schemaAt returns the schema for the value at path at, or nil if nothing
+// constrains it.
+func (sv *schemaValidator) schemaAt(at path) *typeSchema {
+	s := sv.root
+	for _, seg := range at {
+		if s == nil {
+			return nil
+		}
+		if seg.isIndex {
+			s = s.items
+		} else if member := s.properties[seg.key]; member != nil {
+			s = member
+		} else {
+			s = s.additional
+		}
+	}
+	return s
+}
+
+// schemaTypeOf returns the JSON Schema type of a value of kind; a number is
+// an integer if it is whole.
+func schemaTypeOf(kind valueKind, decoded any) string {
+	switch kind {
+	case kindNull:
+		return "null"
+	case kindBool:
+		return "boolean"
+	case kindInt:
+		return "integer"
+	case kindString:
+		return "string"
+	case kindArray, kindTypedArray:
+		return "array"
+	case kindObject:
+		return "object"
+	}
+	if _, whole, ok := exactNumber(decoded); ok && whole {
+		return "integer"
+	}
+	return "number"
+}
+
+// report prints a violation at path at, or records it for a --report.
+func (sv *schemaValidator) report(at path, format string, args ...any) {
+	sv.violations++
+	if sv.record {
+		sv.recorded = append(sv.recorded, schemaViolation{sv.document, sv.offset, fmt.Sprint(at), fmt.Sprintf(format, args...)})
+		return
+	}
+	if sv.offset >= 0 {
+		fmt.Fprintf(sv.out, "document %d: offset %d: %s: %s\n", sv.document, sv.offset, at, fmt.Sprintf(format, args...))
+		return
+	}
+	fmt.Fprintf(sv.out, "document %d: %s: %s\n", sv.document, at, fmt.Sprintf(format, args...))
+}
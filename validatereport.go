@@ -0,0 +1,201 @@
+// ABOUTME: Reports of the validate command for CI (--report): JUnit XML for test dashboards, SARIF for code scanning.
+// ABOUTME: Each document is a test case in JUnit; each schema violation is a SARIF result with its path and offset.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// reportFormats are the formats of --report.
+var reportFormats = []string{"junit", "sarif"}
+
+// schemaViolation is a violation recorded for a report.
+type schemaViolation struct {
+	document int64
+	offset   int64 // in BONJSON input, or -1
+	path     string
+	message  string
+}
+
+func (v schemaViolation) String() string {
+	if v.offset >= 0 {
+		return fmt.Sprintf("offset %d: %s: %s", v.offset, v.path, v.message)
+	}
+	return v.path + ": " + v.message
+}
+
+// writeValidateReport writes the violations found in the documents of the
+// input at inputPath as a report in format.
+func writeValidateReport(w io.Writer, format, inputPath string, documents int64, violations []schemaViolation) error {
+	if format == "junit" {
+		return writeJUnitReport(w, inputPath, documents, violations)
+	}
+	return writeSARIFReport(w, inputPath, violations)
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int64        `xml:"tests,attr"`
+	Failures int64        `xml:"failures,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int64       `xml:"tests,attr"`
+	Failures int64       `xml:"failures,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes a JUnit XML report with a test case for each
+// document, failing with the document's violations, one per line.
+func writeJUnitReport(w io.Writer, inputPath string, documents int64, violations []schemaViolation) error {
+	suite := junitSuite{Name: inputPath, Tests: documents}
+	byDocument := map[int64][]string{}
+	for _, v := range violations {
+		byDocument[v.document] = append(byDocument[v.document], v.String())
+	}
+	for doc := range documents {
+		c := junitCase{Name: fmt.Sprintf("document %d", doc), ClassName: inputPath}
+		if lines := byDocument[doc]; len(lines) > 0 {
+			suite.Failures++
+			c.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d schema violations", len(lines)),
+				Type:    "schema",
+				Text:    strings.Join(lines, "\n"),
+			}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	report := junitSuites{Tests: suite.Tests, Failures: suite.Failures, Suites: []junitSuite{suite}}
+	out, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, out)
+	return err
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string          `json:"ruleId"`
+	Level      string          `json:"level"`
+	Message    sarifMessage    `json:"message"`
+	Locations  []sarifLocation `json:"locations"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	ByteOffset int64 `json:"byteOffset"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// writeSARIFReport writes a SARIF 2.1.0 log with a result for each
+// violation, located in the input by its path and, in BONJSON input, its
+// byte offset.
+func writeSARIFReport(w io.Writer, inputPath string, violations []schemaViolation) error {
+	uri := filepath.ToSlash(inputPath)
+	if inputPath == "-" {
+		uri = "stdin"
+	}
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "bonbon",
+			InformationURI: "https://github.com/kstenerud/bonbon",
+			Rules:          []sarifRule{{ID: "schema", ShortDescription: sarifMessage{Text: "A value does not match the JSON Schema"}}},
+		}},
+		Results: []sarifResult{},
+	}
+	for _, v := range violations {
+		loc := sarifLocation{
+			PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: uri}},
+			LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: v.path, Kind: "member"}},
+		}
+		if v.offset >= 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{ByteOffset: v.offset}
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:     "schema",
+			Level:      "error",
+			Message:    sarifMessage{Text: v.message},
+			Locations:  []sarifLocation{loc},
+			Properties: map[string]any{"document": v.document},
+		})
+	}
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+	out, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
@@ -0,0 +1,101 @@
+// ABOUTME: The directory walk of batch conversion: which entries of an input tree are converted.
+// ABOUTME: Symlinks are followed only with --follow-symlinks, cycles are cut, and sockets, devices, and FIFOs skipped.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// walkSkips counts the entries of a walk that were passed over, by reason.
+type walkSkips struct {
+	symlinks int // not followed
+	broken   int // symlinks to nothing
+	revisits int // directories already walked, through a symlink cycle or a second link
+	special  int // sockets, devices, and FIFOs
+}
+
+// report prints a summary of the skipped entries to stderr, if there are any.
+func (s walkSkips) report() {
+	var parts []string
+	for _, count := range []struct {
+		n    int
+		what string
+	}{
+		{s.symlinks, "symlinks (not followed without --follow-symlinks)"},
+		{s.broken, "broken symlinks"},
+		{s.revisits, "directories already converted (symlink cycles)"},
+		{s.special, "sockets, devices, or FIFOs"},
+	} {
+		if count.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count.n, count.what))
+		}
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %s\n", strings.Join(parts, ", "))
+	}
+}
+
+// walkInputs calls fn, in lexical order, for each regular file under root
+// that want accepts. Symlinks are followed only if follow is true; each
+// directory is walked once, however many links lead to it, so cycles end.
+// Symlinks not followed, and sockets, devices, and FIFOs, are counted in
+// skips if want accepts their name or they lead to a directory.
+func walkInputs(root string, follow bool, want func(filename string) bool, skips *walkSkips, fn func(filename string) error) error {
+	walked := make(map[string]bool)
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if walked[real] {
+			skips.revisits++
+			return nil
+		}
+		walked[real] = true
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			filename := filepath.Join(dir, entry.Name())
+			mode := entry.Type()
+			if mode&fs.ModeSymlink != 0 {
+				info, err := os.Stat(filename)
+				switch {
+				case err != nil:
+					if want(filename) {
+						skips.broken++
+					}
+					continue
+				case !follow:
+					if info.IsDir() || want(filename) {
+						skips.symlinks++
+					}
+					continue
+				}
+				mode = info.Mode().Type()
+			}
+			switch {
+			case mode.IsDir():
+				if err := walk(filename); err != nil {
+					return err
+				}
+			case !want(filename):
+			case mode.IsRegular():
+				if err := fn(filename); err != nil {
+					return err
+				}
+			default:
+				skips.special++
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
@@ -0,0 +1,51 @@
+// ABOUTME: Warnings from JSON decoding, and the --warnings-as-errors option that makes the first one fail instead.
+// ABOUTME: Rounded numbers, dropped duplicate keys, and U+FFFD replacements come from the codec package's warnings.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"bonbon/codec"
+)
+
+// errWarning is the error of a warning under --warnings-as-errors.
+var errWarning = errors.New("warning treated as error")
+
+// decodeJSONEach decodes the whitespace-separated JSON documents read from
+// r, passing each to fn, after applying --schema-types. Each value that
+// decoding changes rather than rejects, such as a number rounded to a
+// float64, is reported with warnf as it is decoded; with
+// --warnings-as-errors, the first instead fails the decoding at the end of
+// its document. Errors from fn are returned as they are; those of
+// --schema-types name the document.
+func decodeJSONEach(r io.Reader, opts *options, fn func(doc any) error) error {
+	var first *codec.Warning
+	decoder := codec.Options{UseNumber: opts.schemaTypes != nil}
+	switch {
+	case opts.warningsAsErrors:
+		decoder.OnWarning = func(w codec.Warning) {
+			if first == nil {
+				first = &w
+			}
+		}
+	case !opts.lossinessReport: // which lists them itself
+		decoder.OnWarning = func(w codec.Warning) { warnf("%s", w) }
+	}
+	document := 0
+	return decoder.UnmarshalJSONEach(r, func(doc any) error {
+		if first != nil {
+			return fmt.Errorf("%w: %s", errWarning, first)
+		}
+		if opts.schemaTypes != nil {
+			var err error
+			if doc, err = applySchemaTypes(doc, opts.schemaTypes, nil); err != nil {
+				return fmt.Errorf("document %d: %w", document, err)
+			}
+		}
+		document++
+		return fn(doc)
+	})
+}
@@ -0,0 +1,33 @@
+// ABOUTME: Input windows: byte ranges of the input that each hold a payload to decode.
+// ABOUTME: Set with -s/--start and --length to extract payloads embedded in container files.
+
+package main
+
+import "fmt"
+
+// inputWindow is a byte range of the input. A negative length extends the
+// window to the end of the input.
+type inputWindow struct {
+	start  int
+	length int
+}
+
+// slice returns the part of data covered by the window.
+func (w inputWindow) slice(data []byte) ([]byte, error) {
+	if w.length < 0 {
+		if w.start > 0 && w.start >= len(data) {
+			return nil, fmt.Errorf("skip value %d exceeds input size %d", w.start, len(data))
+		}
+		return data[w.start:], nil
+	}
+	if w.start+w.length > len(data) {
+		return nil, fmt.Errorf("window at offset %d with length %d exceeds input size %d", w.start, w.length, len(data))
+	}
+	return data[w.start : w.start+w.length], nil
+}
+
+// windowed reports whether the input is read through explicit windows rather
+// than from the skip offset to the end.
+func (opts *options) windowed() bool {
+	return len(opts.windows) > 1 || (len(opts.windows) == 1 && opts.windows[0].length >= 0)
+}